@@ -32,11 +32,17 @@ import (
 	"github.com/sipeed/picoclaw/pkg/health"
 	"github.com/sipeed/picoclaw/pkg/heartbeat"
 	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/memory"
 	"github.com/sipeed/picoclaw/pkg/migrate"
 	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/retention"
+	"github.com/sipeed/picoclaw/pkg/secrets"
+	"github.com/sipeed/picoclaw/pkg/session"
 	"github.com/sipeed/picoclaw/pkg/skills"
 	"github.com/sipeed/picoclaw/pkg/state"
 	"github.com/sipeed/picoclaw/pkg/tools"
+	"github.com/sipeed/picoclaw/pkg/usage"
+	"github.com/sipeed/picoclaw/pkg/utils"
 	"github.com/sipeed/picoclaw/pkg/voice"
 )
 
@@ -142,6 +148,14 @@ func main() {
 		authCmd()
 	case "cron":
 		cronCmd()
+	case "usage":
+		usageCmd()
+	case "sessions":
+		sessionsCmd()
+	case "memory":
+		memoryCmd()
+	case "secrets":
+		secretsCmd()
 	case "skills":
 		if len(os.Args) < 3 {
 			skillsHelp()
@@ -211,6 +225,10 @@ func printHelp() {
 	fmt.Println("  gateway     Start picoclaw gateway")
 	fmt.Println("  status      Show picoclaw status")
 	fmt.Println("  cron        Manage scheduled tasks")
+	fmt.Println("  usage       Show token usage and estimated cost")
+	fmt.Println("  sessions    Manage conversation sessions (list, show, delete, prune)")
+	fmt.Println("  memory      Rebuild or search the memory index")
+	fmt.Println("  secrets     Manage encryption of stored data (keygen, rotate)")
 	fmt.Println("  migrate     Migrate from OpenClaw to PicoClaw")
 	fmt.Println("  skills      Manage skills (install, list, remove)")
 	fmt.Println("  version     Show version information")
@@ -321,6 +339,8 @@ func migrateCmd() {
 			opts.Force = true
 		case "--refresh":
 			opts.Refresh = true
+		case "--model-list":
+			opts.ModelList = true
 		case "--openclaw-home":
 			if i+1 < len(args) {
 				opts.OpenClawHome = args[i+1]
@@ -360,6 +380,7 @@ func migrateHelp() {
 	fmt.Println("  --config-only      Only migrate config, skip workspace files")
 	fmt.Println("  --workspace-only   Only migrate workspace files, skip config")
 	fmt.Println("  --force            Skip confirmation prompts")
+	fmt.Println("  --model-list       Convert the providers block of the PicoClaw config to model_list")
 	fmt.Println("  --openclaw-home    Override OpenClaw home directory (default: ~/.openclaw)")
 	fmt.Println("  --picoclaw-home    Override PicoClaw home directory (default: ~/.picoclaw)")
 	fmt.Println()
@@ -368,6 +389,7 @@ func migrateHelp() {
 	fmt.Println("  picoclaw migrate --dry-run    Show what would be migrated")
 	fmt.Println("  picoclaw migrate --refresh    Re-sync workspace files")
 	fmt.Println("  picoclaw migrate --force      Migrate without confirmation")
+	fmt.Println("  picoclaw migrate --model-list Move model settings to model_list")
 }
 
 func agentCmd() {
@@ -399,6 +421,15 @@ func agentCmd() {
 		os.Exit(1)
 	}
 
+	// Bring stored data in line with the encryption setting.
+	if n, err := resealStoredData(cfg); err != nil {
+		fmt.Printf("Warning: could not migrate stored data to the encryption setting: %v\n", err)
+	} else if n > 0 {
+		logger.InfoCF("secrets", "Migrated stored data to the encryption setting", map[string]interface{}{
+			"files": n,
+		})
+	}
+
 	provider, err := providers.CreateProvider(cfg)
 	if err != nil {
 		fmt.Printf("Error creating provider: %v\n", err)
@@ -565,28 +596,16 @@ func gatewayCmd() {
 	execTimeout := time.Duration(cfg.Tools.Cron.ExecTimeoutMinutes) * time.Minute
 	cronService := setupCronTool(agentLoop, msgBus, cfg.WorkspacePath(), cfg.Agents.Defaults.RestrictToWorkspace, execTimeout, cfg)
 
-	heartbeatService := heartbeat.NewHeartbeatService(
-		cfg.WorkspacePath(),
-		cfg.Heartbeat.Interval,
-		cfg.Heartbeat.Enabled,
-	)
+	heartbeatService := heartbeat.NewHeartbeatService(cfg.WorkspacePath(), cfg.Heartbeat)
 	heartbeatService.SetBus(msgBus)
-	heartbeatService.SetHandler(func(prompt, channel, chatID string) *tools.ToolResult {
+	heartbeatService.SetHandler(func(agentID, prompt, channel, chatID string) (string, error) {
 		// Use cli:direct as fallback if no valid channel
 		if channel == "" || chatID == "" {
 			channel, chatID = "cli", "direct"
 		}
-		// Use ProcessHeartbeat - no session history, each heartbeat is independent
-		response, err := agentLoop.ProcessHeartbeat(context.Background(), prompt, channel, chatID)
-		if err != nil {
-			return tools.ErrorResult(fmt.Sprintf("Heartbeat error: %v", err))
-		}
-		if response == "HEARTBEAT_OK" {
-			return tools.SilentResult("Heartbeat OK")
-		}
-		// For heartbeat, always return silent - the subagent result will be
-		// sent to user via processSystemMessage when the async task completes
-		return tools.SilentResult(response)
+		// Use ProcessHeartbeat - no session history, each heartbeat is independent.
+		// Subagents spawned by a task report to the user on their own.
+		return agentLoop.ProcessHeartbeat(context.Background(), agentID, prompt, channel, chatID)
 	})
 
 	channelManager, err := channels.NewManager(cfg, msgBus)
@@ -644,6 +663,12 @@ func gatewayCmd() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	if cfg.Providers.Ollama.PullMissing {
+		// Pulling can take long; agents answer with what is installed meanwhile.
+		go agentLoop.PullMissingModels(ctx)
+		fmt.Println("✓ Pulling missing Ollama models in the background")
+	}
+
 	if err := cronService.Start(); err != nil {
 		fmt.Printf("Error starting cron service: %v\n", err)
 	}
@@ -654,6 +679,13 @@ func gatewayCmd() {
 	}
 	fmt.Println("✓ Heartbeat service started")
 
+	retentionService := retention.NewService(cfg.Session.Retention, agentLoop.RetentionTargets(), utils.MediaDir())
+	if err := retentionService.Start(); err != nil {
+		fmt.Printf("Error starting retention service: %v\n", err)
+	} else if cfg.Session.Retention.Enabled {
+		fmt.Println("✓ Retention service started")
+	}
+
 	stateManager := state.NewManager(cfg.WorkspacePath())
 	deviceService := devices.NewService(devices.Config{
 		Enabled:    cfg.Devices.Enabled,
@@ -689,6 +721,7 @@ func gatewayCmd() {
 	healthServer.Stop(context.Background())
 	deviceService.Stop()
 	heartbeatService.Stop()
+	retentionService.Stop()
 	cronService.Stop()
 	agentLoop.Stop()
 	channelManager.StopAll(ctx)
@@ -727,6 +760,12 @@ func statusCmd() {
 
 	if _, err := os.Stat(configPath); err == nil {
 		fmt.Printf("Model: %s\n", cfg.Agents.Defaults.Model)
+		if len(cfg.ModelList) > 0 {
+			fmt.Println("Model list:")
+			for _, e := range cfg.ModelList {
+				fmt.Printf("  %s: %s %s\n", e.Name, e.Protocol, e.Model)
+			}
+		}
 
 		hasOpenRouter := cfg.Providers.OpenRouter.APIKey != ""
 		hasAnthropic := cfg.Providers.Anthropic.APIKey != ""
@@ -776,6 +815,12 @@ func authCmd() {
 		return
 	}
 
+	// Loading the config sets up the key for an encrypted auth store.
+	if _, err := loadConfig(); err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		return
+	}
+
 	switch os.Args[2] {
 	case "login":
 		authLoginCmd()
@@ -1013,8 +1058,158 @@ func setupCronTool(agentLoop *agent.AgentLoop, msgBus *bus.MessageBus, workspace
 	return cronService
 }
 
+// encryptionReady is set once loadConfig has loaded the encryption key, so
+// a command asks for a passphrase at most once.
+var encryptionReady bool
+
 func loadConfig() (*config.Config, error) {
-	return config.LoadConfig(getConfigPath())
+	cfg, err := config.LoadConfig(getConfigPath())
+	if err != nil {
+		return nil, err
+	}
+	if !encryptionReady {
+		if err := setupEncryption(cfg); err != nil {
+			return nil, fmt.Errorf("encryption: %w", err)
+		}
+		encryptionReady = true
+	}
+	return cfg, nil
+}
+
+// encryptionSource says where the encryption key of cfg is found. The
+// passphrase prompt is only offered when interactive and in a terminal.
+func encryptionSource(cfg *config.Config, interactive bool) secrets.Source {
+	src := secrets.Source{
+		KeyFile:  cfg.EncryptionKeyFile(),
+		SaltFile: filepath.Join(filepath.Dir(getConfigPath()), "encryption.salt"),
+	}
+	if interactive && readline.DefaultIsTerminal() {
+		src.Prompt = readline.Password
+	}
+	return src
+}
+
+// setupEncryption loads the encryption key. With encryption off, a key that
+// is available anyway is still loaded, so data encrypted earlier can be
+// read and gets decrypted when it is next written.
+func setupEncryption(cfg *config.Config) error {
+	key, _, err := secrets.LoadKey(encryptionSource(cfg, cfg.Encryption.Enabled))
+	if !cfg.Encryption.Enabled {
+		if err == nil {
+			secrets.SetKeys(nil, key)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	secrets.SetKeys(key)
+	return nil
+}
+
+// agentSessions is the sessions directory of an agent.
+type agentSessions struct {
+	agentID string
+	dir     string
+}
+
+// allSessionDirs returns the sessions directory of every agent, once each;
+// agents may share a workspace.
+func allSessionDirs(cfg *config.Config) []agentSessions {
+	agentIDs := []string{""}
+	for _, ac := range cfg.Agents.List {
+		agentIDs = append(agentIDs, ac.ID)
+	}
+
+	var dirs []agentSessions
+	seen := make(map[string]bool)
+	for _, id := range agentIDs {
+		dir, ok := agent.SessionsDir(cfg, id)
+		if !ok || seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		dirs = append(dirs, agentSessions{agentID: id, dir: dir})
+	}
+	return dirs
+}
+
+// allWorkspaces returns the workspace of every agent, once each.
+func allWorkspaces(cfg *config.Config) []string {
+	agentIDs := []string{""}
+	for _, ac := range cfg.Agents.List {
+		agentIDs = append(agentIDs, ac.ID)
+	}
+
+	var workspaces []string
+	seen := make(map[string]bool)
+	for _, id := range agentIDs {
+		workspace, ok := agent.AgentWorkspace(cfg, id)
+		if !ok || seen[workspace] {
+			continue
+		}
+		seen[workspace] = true
+		workspaces = append(workspaces, workspace)
+	}
+	return workspaces
+}
+
+// workspaceSecretFiles returns the files of a workspace that are kept
+// encrypted besides sessions: long-term memory, the memory index and the
+// handoff table. Daily notes are resealed per month directory.
+func workspaceSecretFiles(workspace string) []string {
+	return []string{
+		filepath.Join(workspace, "memory", "MEMORY.md"),
+		memory.IndexPath(workspace),
+		filepath.Join(workspace, "state", "handoffs.json"),
+	}
+}
+
+// resealStoredData rewrites the auth store, cron store, session files and
+// agent memory that are not stored the way the current encryption setting
+// would write them, and returns how many it rewrote.
+func resealStoredData(cfg *config.Config) (int, error) {
+	total := 0
+	for _, path := range []string{auth.StorePath(), filepath.Join(cfg.WorkspacePath(), "cron", "jobs.json")} {
+		changed, err := secrets.Reseal(path)
+		if err != nil {
+			return total, err
+		}
+		if changed {
+			total++
+		}
+	}
+	for _, s := range allSessionDirs(cfg) {
+		n, err := secrets.ResealDir(s.dir, ".json", ".jsonl")
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	for _, workspace := range allWorkspaces(cfg) {
+		for _, path := range workspaceSecretFiles(workspace) {
+			changed, err := secrets.Reseal(path)
+			if err != nil {
+				return total, err
+			}
+			if changed {
+				total++
+			}
+		}
+		memoryDir := filepath.Join(workspace, "memory")
+		months, _ := os.ReadDir(memoryDir)
+		for _, month := range months {
+			if !month.IsDir() {
+				continue
+			}
+			n, err := secrets.ResealDir(filepath.Join(memoryDir, month.Name()), ".md")
+			total += n
+			if err != nil {
+				return total, err
+			}
+		}
+	}
+	return total, nil
 }
 
 func cronCmd() {
@@ -1231,6 +1426,555 @@ func cronEnableCmd(storePath string, disable bool) {
 	}
 }
 
+func usageCmd() {
+	days := 30
+	by := "day"
+	agentID := ""
+
+	args := os.Args[2:]
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-d", "--days":
+			if i+1 < len(args) {
+				fmt.Sscanf(args[i+1], "%d", &days)
+				i++
+			}
+		case "-b", "--by":
+			if i+1 < len(args) {
+				by = args[i+1]
+				i++
+			}
+		case "-a", "--agent":
+			if i+1 < len(args) {
+				agentID = args[i+1]
+				i++
+			}
+		case "-h", "--help":
+			usageHelp()
+			return
+		}
+	}
+
+	var key func(usage.Record) string
+	switch by {
+	case "day":
+		key = usage.Record.Day
+	case "model":
+		key = func(r usage.Record) string { return r.Model }
+	case "agent":
+		key = func(r usage.Record) string { return r.AgentID }
+	case "session":
+		key = func(r usage.Record) string { return r.SessionKey }
+	default:
+		fmt.Printf("Unknown grouping: %s\n", by)
+		usageHelp()
+		return
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		return
+	}
+
+	ledger := usage.NewLedger(usage.LedgerPath(cfg.WorkspacePath()), cfg.Usage.Prices)
+	since := usage.StartOfDay(time.Now()).AddDate(0, 0, -(days - 1))
+	records, err := ledger.Query(since, func(r usage.Record) bool {
+		return agentID == "" || r.AgentID == agentID
+	})
+	if err != nil {
+		fmt.Printf("Error reading usage: %v\n", err)
+		return
+	}
+	if len(records) == 0 {
+		fmt.Println("No usage recorded.")
+		return
+	}
+
+	fmt.Printf("\nUsage for the last %d days (by %s):\n", days, by)
+	fmt.Printf("  %-40s %8s %12s %12s %12s %10s\n", strings.ToUpper(by), "CALLS", "PROMPT", "CACHED", "COMPLETION", "COST")
+	for _, g := range usage.GroupBy(records, key) {
+		fmt.Printf("  %-40s %8d %12d %12d %12d %10s\n", g.Key, g.Calls, g.PromptTokens, g.CachedTokens, g.CompletionTokens, fmt.Sprintf("$%.4f", g.CostUSD))
+	}
+	total := usage.Sum(records)
+	fmt.Printf("  %-40s %8d %12d %12d %12d %10s\n", "TOTAL", total.Calls, total.PromptTokens, total.CachedTokens, total.CompletionTokens, fmt.Sprintf("$%.4f", total.CostUSD))
+}
+
+func usageHelp() {
+	fmt.Println("\nUsage options:")
+	fmt.Println("  -d, --days <n>     Number of days to include (default: 30)")
+	fmt.Println("  -b, --by <field>   Group by day, model, agent or session (default: day)")
+	fmt.Println("  -a, --agent <id>   Only include one agent")
+}
+
+func sessionsCmd() {
+	if len(os.Args) < 3 {
+		sessionsHelp()
+		return
+	}
+
+	subcommand := os.Args[2]
+	agentID := ""
+	days := 0
+	var positional []string
+
+	args := os.Args[3:]
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-a", "--agent":
+			if i+1 < len(args) {
+				agentID = args[i+1]
+				i++
+			}
+		case "-d", "--days":
+			if i+1 < len(args) {
+				fmt.Sscanf(args[i+1], "%d", &days)
+				i++
+			}
+		case "-h", "--help":
+			sessionsHelp()
+			return
+		default:
+			positional = append(positional, args[i])
+		}
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		return
+	}
+
+	if subcommand == "cleanup" {
+		sessionsCleanupCmd(cfg)
+		return
+	}
+
+	dir, ok := agent.SessionsDir(cfg, agentID)
+	if !ok {
+		fmt.Printf("Unknown agent: %s\n", agentID)
+		return
+	}
+	sm := session.NewSessionManagerWithStore(session.NewStore(cfg.Session.Storage, dir), 0)
+
+	switch subcommand {
+	case "list":
+		prefix := ""
+		if len(positional) > 0 {
+			prefix = positional[0]
+		}
+		sessionsListCmd(sm, prefix)
+	case "show":
+		if len(positional) < 1 {
+			fmt.Println("Usage: picoclaw sessions show <key>")
+			return
+		}
+		sessionsShowCmd(sm, positional[0])
+	case "delete":
+		if len(positional) < 1 {
+			fmt.Println("Usage: picoclaw sessions delete <key>")
+			return
+		}
+		if sm.Get(positional[0]) == nil {
+			fmt.Printf("Session %s not found\n", positional[0])
+			return
+		}
+		if err := sm.Delete(positional[0]); err != nil {
+			fmt.Printf("Error deleting session: %v\n", err)
+			return
+		}
+		fmt.Printf("✓ Deleted session %s\n", positional[0])
+	case "prune":
+		if days <= 0 {
+			fmt.Println("Usage: picoclaw sessions prune --days <n> [prefix]")
+			return
+		}
+		prefix := ""
+		if len(positional) > 0 {
+			prefix = positional[0]
+		}
+		deleted, err := sm.Prune(prefix, time.Now().AddDate(0, 0, -days))
+		if err != nil {
+			fmt.Printf("Error pruning sessions: %v\n", err)
+		}
+		fmt.Printf("✓ Deleted %d sessions not updated in the last %d days\n", len(deleted), days)
+	default:
+		fmt.Printf("Unknown sessions command: %s\n", subcommand)
+		sessionsHelp()
+	}
+}
+
+func sessionsHelp() {
+	fmt.Println("\nSessions commands:")
+	fmt.Println("  list [prefix]          List sessions, most recently updated first")
+	fmt.Println("  show <key>             Show a session's summary and messages")
+	fmt.Println("  delete <key>           Delete a session")
+	fmt.Println("  prune --days <n>       Delete sessions not updated in the last n days")
+	fmt.Println("  cleanup                Apply the retention policies to all agents' sessions and media now")
+	fmt.Println()
+	fmt.Println("Options:")
+	fmt.Println("  -a, --agent <id>       Agent whose sessions to use (default: the default agent)")
+	fmt.Println("  -d, --days <n>         Age in days for prune")
+	fmt.Println()
+	fmt.Println("Archived sessions, left by /new in chat, have keys of the form <key>#<id>.")
+}
+
+// sessionsCleanupCmd runs the retention service once over the sessions of
+// every agent, since media can only be removed once no agent refers to it.
+func sessionsCleanupCmd(cfg *config.Config) {
+	var targets []retention.Target
+	for _, s := range allSessionDirs(cfg) {
+		targets = append(targets, retention.Target{
+			AgentID:  s.agentID,
+			Sessions: session.NewSessionManagerWithStore(session.NewStore(cfg.Session.Storage, s.dir), 0),
+		})
+	}
+
+	report := retention.NewService(cfg.Session.Retention, targets, utils.MediaDir()).RunOnce()
+	fmt.Printf("✓ Archived %d, deleted %d and trimmed %d sessions; removed %d media files\n",
+		report.Archived, report.Deleted, report.Trimmed, report.MediaRemoved)
+	fmt.Printf("  Freed %.1f MB; sessions and media now take %.1f MB\n",
+		float64(report.BytesFreed)/(1024*1024), float64(report.Usage)/(1024*1024))
+}
+
+func secretsCmd() {
+	if len(os.Args) < 3 {
+		secretsHelp()
+		return
+	}
+
+	switch os.Args[2] {
+	case "keygen":
+		secretsKeygenCmd(os.Args[3:])
+	case "rotate":
+		secretsRotateCmd(os.Args[3:])
+	default:
+		fmt.Printf("Unknown secrets command: %s\n", os.Args[2])
+		secretsHelp()
+	}
+}
+
+func secretsHelp() {
+	fmt.Println("\nSecrets commands:")
+	fmt.Println("  keygen [path]                Write a new random key to the key file (default: encryption.key_file)")
+	fmt.Println("  rotate                       Re-encrypt the auth store, sessions, memory and cron store with a new key")
+	fmt.Println()
+	fmt.Println("Rotate options:")
+	fmt.Println("  --new-key-file <path>        Use the key or passphrase in path (default: generate a key")
+	fmt.Println("                               and replace encryption.key_file)")
+	fmt.Println("  --passphrase                 Ask for a new passphrase")
+	fmt.Println()
+	fmt.Printf("The key is read from %s, then encryption.key_file, then asked for as a\n", secrets.KeyEnv)
+	fmt.Println("passphrase. Stop the gateway before rotating.")
+}
+
+func secretsKeygenCmd(args []string) {
+	cfg, err := config.LoadConfig(getConfigPath())
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		return
+	}
+	path := cfg.EncryptionKeyFile()
+	if len(args) > 0 {
+		path = args[0]
+	}
+	if path == "" {
+		fmt.Println("Usage: picoclaw secrets keygen <path>")
+		return
+	}
+	if _, err := os.Stat(path); err == nil {
+		fmt.Printf("%s already exists; not overwriting it\n", path)
+		return
+	}
+
+	if err := writeNewKey(path); err != nil {
+		fmt.Printf("Error writing key: %v\n", err)
+		return
+	}
+	fmt.Printf("✓ Wrote a new encryption key to %s\n", path)
+	if !cfg.Encryption.Enabled {
+		fmt.Println("  Set \"encryption.enabled\": true in the config to use it.")
+	}
+}
+
+// writeNewKey writes a new random key to path and returns it.
+func writeNewKey(path string) error {
+	raw, err := secrets.GenerateKey()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(secrets.EncodeKey(raw)+"\n"), 0600)
+}
+
+func secretsRotateCmd(args []string) {
+	newKeyFile := ""
+	usePassphrase := false
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--new-key-file":
+			if i+1 < len(args) {
+				newKeyFile = args[i+1]
+				i++
+			}
+		case "--passphrase":
+			usePassphrase = true
+		case "-h", "--help":
+			secretsHelp()
+			return
+		}
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		return
+	}
+	oldKey := secrets.CurrentKey()
+	if !cfg.Encryption.Enabled || oldKey == nil {
+		fmt.Println("Encryption is not enabled; set \"encryption.enabled\": true in the config first.")
+		return
+	}
+
+	src := encryptionSource(cfg, false)
+	keyFile := cfg.EncryptionKeyFile()
+	pendingKeyFile := ""
+	var newKey *secrets.Key
+	switch {
+	case newKeyFile != "":
+		material, err := os.ReadFile(newKeyFile)
+		if err != nil {
+			fmt.Printf("Error reading new key: %v\n", err)
+			return
+		}
+		newKey, err = secrets.KeyFromMaterial(material, src.SaltFile)
+		if err != nil {
+			fmt.Printf("Error reading new key: %v\n", err)
+			return
+		}
+	case usePassphrase:
+		first, err := readline.Password("New passphrase: ")
+		if err != nil {
+			return
+		}
+		second, err := readline.Password("Repeat new passphrase: ")
+		if err != nil {
+			return
+		}
+		if string(first) != string(second) {
+			fmt.Println("The passphrases do not match.")
+			return
+		}
+		newKey, err = secrets.KeyFromPassphrase(first, src.SaltFile)
+		if err != nil {
+			fmt.Printf("Error deriving key: %v\n", err)
+			return
+		}
+	default:
+		if keyFile == "" {
+			fmt.Println("No encryption.key_file configured; use --new-key-file or --passphrase.")
+			return
+		}
+		// The new key is kept next to the old one until all data uses it.
+		pendingKeyFile = keyFile + ".new"
+		if err := writeNewKey(pendingKeyFile); err != nil {
+			fmt.Printf("Error writing new key: %v\n", err)
+			return
+		}
+		material, _ := os.ReadFile(pendingKeyFile)
+		newKey, err = secrets.KeyFromMaterial(material, src.SaltFile)
+		if err != nil {
+			fmt.Printf("Error reading new key: %v\n", err)
+			return
+		}
+	}
+	if newKey.ID() == oldKey.ID() {
+		fmt.Println("The new key is the same as the current one.")
+		return
+	}
+
+	secrets.SetKeys(newKey, oldKey)
+	n, err := resealStoredData(cfg)
+	if err != nil {
+		fmt.Printf("Error re-encrypting stored data: %v\n", err)
+		if pendingKeyFile != "" {
+			fmt.Printf("Some files already use the new key in %s; run\n", pendingKeyFile)
+			fmt.Printf("  picoclaw secrets rotate --new-key-file %s\n", pendingKeyFile)
+			fmt.Println("to finish once the problem is fixed.")
+		}
+		return
+	}
+
+	if pendingKeyFile != "" {
+		if err := os.Rename(pendingKeyFile, keyFile); err != nil {
+			fmt.Printf("Error replacing the key file: %v\n", err)
+			fmt.Printf("The data now uses the key in %s; move it to %s.\n", pendingKeyFile, keyFile)
+			return
+		}
+	}
+	fmt.Printf("✓ Re-encrypted %d files with key %s\n", n, newKey.ID())
+	switch {
+	case pendingKeyFile != "":
+		fmt.Printf("  The new key replaced %s.\n", keyFile)
+	case newKeyFile != "":
+		fmt.Printf("  Set \"encryption.key_file\" to %s.\n", newKeyFile)
+	default:
+		fmt.Println("  Use the new passphrase from now on.")
+		if _, err := os.Stat(keyFile); err == nil {
+			fmt.Printf("  Remove %s, which holds the old key and would be used first.\n", keyFile)
+		}
+	}
+	if os.Getenv(secrets.KeyEnv) != "" {
+		fmt.Printf("  %s still holds the old key and takes precedence; update or unset it.\n", secrets.KeyEnv)
+	}
+}
+
+func memoryCmd() {
+	if len(os.Args) < 3 {
+		memoryHelp()
+		return
+	}
+
+	subcommand := os.Args[2]
+	agentID := ""
+	topK := 0
+	var positional []string
+
+	args := os.Args[3:]
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-a", "--agent":
+			if i+1 < len(args) {
+				agentID = args[i+1]
+				i++
+			}
+		case "-k", "--top-k":
+			if i+1 < len(args) {
+				fmt.Sscanf(args[i+1], "%d", &topK)
+				i++
+			}
+		case "-h", "--help":
+			memoryHelp()
+			return
+		default:
+			positional = append(positional, args[i])
+		}
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		return
+	}
+
+	workspace, ok := agent.AgentWorkspace(cfg, agentID)
+	if !ok {
+		fmt.Printf("Unknown agent: %s\n", agentID)
+		return
+	}
+	store := agent.NewMemoryStore(workspace)
+	index := memory.NewIndex(memory.IndexPath(workspace), memory.NewEmbedder(cfg.Memory.Embeddings))
+	ctx := context.Background()
+
+	switch subcommand {
+	case "reindex":
+		entries := store.Entries(cfg.Memory.NotesDays)
+		if err := index.Rebuild(ctx, entries); err != nil {
+			fmt.Printf("Error rebuilding memory index: %v\n", err)
+			return
+		}
+		fmt.Printf("✓ Indexed %d memory entries\n", index.Len())
+	case "search":
+		if len(positional) == 0 {
+			fmt.Println("Usage: picoclaw memory search <query>")
+			return
+		}
+		if topK <= 0 {
+			topK = cfg.Memory.TopK
+		}
+		if err := index.Update(ctx, store.Entries(cfg.Memory.NotesDays)); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+		results, err := index.Search(ctx, strings.Join(positional, " "), topK)
+		if err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+		if len(results) == 0 {
+			fmt.Println("No matching memory entries.")
+			return
+		}
+		for _, r := range results {
+			section := r.Section
+			if r.Date != "" {
+				section += " " + r.Date
+			}
+			fmt.Printf("  %.4f  [%s] %s\n", r.Score, section, r.Text)
+		}
+	default:
+		fmt.Printf("Unknown memory command: %s\n", subcommand)
+		memoryHelp()
+	}
+}
+
+func memoryHelp() {
+	fmt.Println("\nMemory commands:")
+	fmt.Println("  reindex                Rebuild the memory index, re-embedding every entry")
+	fmt.Println("  search <query>         Show the memory entries retrieval would pick for a query")
+	fmt.Println()
+	fmt.Println("Options:")
+	fmt.Println("  -a, --agent <id>       Agent whose memory to use (default: the default agent)")
+	fmt.Println("  -k, --top-k <n>        Number of results for search (default: memory.top_k)")
+}
+
+func sessionsListCmd(sm *session.SessionManager, prefix string) {
+	infos, err := sm.List(prefix)
+	if err != nil {
+		fmt.Printf("Error listing sessions: %v\n", err)
+		return
+	}
+	if len(infos) == 0 {
+		fmt.Println("No sessions.")
+		return
+	}
+
+	fmt.Printf("\n  %-50s %8s  %s\n", "KEY", "MESSAGES", "UPDATED")
+	for _, info := range infos {
+		fmt.Printf("  %-50s %8d  %s\n", info.Key, info.Messages, info.Updated.Format("2006-01-02 15:04"))
+	}
+}
+
+func sessionsShowCmd(sm *session.SessionManager, key string) {
+	s := sm.Get(key)
+	if s == nil {
+		fmt.Printf("Session %s not found\n", key)
+		return
+	}
+
+	fmt.Printf("\nSession: %s\n", s.Key)
+	fmt.Printf("Created: %s\n", s.Created.Format("2006-01-02 15:04"))
+	fmt.Printf("Updated: %s\n", s.Updated.Format("2006-01-02 15:04"))
+	fmt.Printf("Messages: %d\n", len(s.Messages))
+	if s.Summary != "" {
+		fmt.Printf("\nSummary:\n%s\n", s.Summary)
+	}
+	if len(s.Messages) > 0 {
+		fmt.Println()
+	}
+	for _, msg := range s.Messages {
+		content := msg.Content
+		if content == "" && len(msg.ToolCalls) > 0 {
+			names := make([]string, 0, len(msg.ToolCalls))
+			for _, tc := range msg.ToolCalls {
+				names = append(names, tc.Name)
+			}
+			content = "(calls " + strings.Join(names, ", ") + ")"
+		}
+		fmt.Printf("[%s] %s\n", msg.Role, content)
+	}
+}
+
 func skillsHelp() {
 	fmt.Println("\nSkills commands:")
 	fmt.Println("  list                    List installed skills")
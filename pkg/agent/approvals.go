@@ -0,0 +1,297 @@
+package agent
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/tools"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+const defaultApprovalTimeout = 5 * time.Minute
+
+// Decisions recorded in the approval audit log.
+const (
+	approvalApproved        = "approved"
+	approvalDenied          = "denied"
+	approvalTimedOut        = "timeout"
+	approvalCancelled       = "cancelled"
+	approvalUnattendedAllow = "unattended_allow"
+	approvalUnattendedDeny  = "unattended_deny"
+)
+
+var (
+	errApprovalDenied     = errors.New("the user denied it")
+	errApprovalTimedOut   = errors.New("the approval request timed out")
+	errApprovalUnattended = errors.New("it needs approval and nobody can approve it from this channel")
+)
+
+// approvalRecord is one line of the approval audit log.
+type approvalRecord struct {
+	Time      time.Time              `json:"time"`
+	ID        string                 `json:"id,omitempty"`
+	Tool      string                 `json:"tool"`
+	Args      map[string]interface{} `json:"args,omitempty"`
+	Channel   string                 `json:"channel"`
+	ChatID    string                 `json:"chat_id"`
+	Decision  string                 `json:"decision"`
+	DecidedBy string                 `json:"decided_by,omitempty"`
+	WaitMS    int64                  `json:"wait_ms"`
+}
+
+// approvalAuditPath returns where approval decisions are logged for a workspace.
+func approvalAuditPath(workspace string) string {
+	return filepath.Join(workspace, "state", "approvals.jsonl")
+}
+
+type approvalReply struct {
+	approved bool
+	by       string
+}
+
+type pendingApproval struct {
+	id       string
+	channel  string
+	chatID   string
+	senderID string
+	replies  chan approvalReply
+}
+
+// approvalManager asks users to approve tool calls over the message bus and
+// matches their replies to the waiting calls. It implements tools.Approver.
+type approvalManager struct {
+	bus        *bus.MessageBus
+	auditPath  string
+	timeout    time.Duration
+	unattended string
+	canAsk     func(channel string) bool
+
+	mu      sync.Mutex
+	pending []*pendingApproval // oldest first
+	auditMu sync.Mutex
+}
+
+func newApprovalManager(cfg config.ApprovalConfig, msgBus *bus.MessageBus, workspace string, canAsk func(channel string) bool) *approvalManager {
+	timeout := time.Duration(cfg.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultApprovalTimeout
+	}
+	return &approvalManager{
+		bus:        msgBus,
+		auditPath:  approvalAuditPath(workspace),
+		timeout:    timeout,
+		unattended: cfg.Unattended,
+		canAsk:     canAsk,
+	}
+}
+
+// RequestApproval implements tools.Approver. It asks the chat the run came
+// from and waits for the user who started the run to answer.
+func (m *approvalManager) RequestApproval(ctx context.Context, req tools.ApprovalRequest) error {
+	start := time.Now()
+	rec := approvalRecord{
+		Time:    start,
+		Tool:    req.Tool,
+		Args:    req.Args,
+		Channel: req.Channel,
+		ChatID:  req.ChatID,
+	}
+
+	if !m.canAsk(req.Channel) {
+		if m.unattended == config.ApprovalUnattendedAllow {
+			rec.Decision = approvalUnattendedAllow
+			m.audit(rec)
+			return nil
+		}
+		rec.Decision = approvalUnattendedDeny
+		m.audit(rec)
+		return errApprovalUnattended
+	}
+
+	p := &pendingApproval{
+		id:      newApprovalID(),
+		channel: req.Channel,
+		chatID:  req.ChatID,
+		replies: make(chan approvalReply, 1),
+	}
+	if run := activeRunFrom(ctx); run != nil {
+		p.senderID = run.sender
+	}
+	rec.ID = p.id
+
+	m.mu.Lock()
+	m.pending = append(m.pending, p)
+	m.mu.Unlock()
+	defer m.remove(p)
+
+	m.bus.PublishOutbound(bus.OutboundMessage{
+		Channel: req.Channel,
+		ChatID:  req.ChatID,
+		Content: approvalPrompt(req, p.id, m.timeout),
+		Buttons: []bus.Button{
+			{Label: "Approve", Data: "/approve " + p.id},
+			{Label: "Deny", Data: "/deny " + p.id},
+		},
+	})
+
+	timer := time.NewTimer(m.timeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case reply := <-p.replies:
+		rec.DecidedBy = reply.by
+		if reply.approved {
+			rec.Decision = approvalApproved
+		} else {
+			rec.Decision = approvalDenied
+			err = errApprovalDenied
+		}
+	case <-timer.C:
+		rec.Decision = approvalTimedOut
+		err = errApprovalTimedOut
+	case <-ctx.Done():
+		rec.Decision = approvalCancelled
+		err = ctx.Err()
+	}
+	rec.WaitMS = time.Since(start).Milliseconds()
+	m.audit(rec)
+	return err
+}
+
+// resolve hands msg to the pending approval it answers. It reports whether
+// msg was consumed as an approval reply.
+func (m *approvalManager) resolve(msg bus.InboundMessage) bool {
+	approved, id, ok := parseApprovalReply(msg.Content)
+	if !ok {
+		return false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	notice := "That approval request is no longer pending."
+	for _, p := range m.pending {
+		if p.id != id || p.channel != msg.Channel || p.chatID != msg.ChatID {
+			continue
+		}
+		if p.senderID != "" && p.senderID != msg.SenderID {
+			notice = "Only the user who started this run can answer its approval requests."
+			break
+		}
+		select {
+		case p.replies <- approvalReply{approved: approved, by: msg.SenderID}:
+			return true
+		default:
+			// Already answered; the waiting call has not picked it up yet.
+		}
+	}
+
+	m.bus.PublishOutbound(bus.OutboundMessage{
+		Channel: msg.Channel,
+		ChatID:  msg.ChatID,
+		Content: notice,
+	})
+	return true
+}
+
+func (m *approvalManager) remove(p *pendingApproval) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, q := range m.pending {
+		if q == p {
+			m.pending = append(m.pending[:i], m.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+func (m *approvalManager) audit(rec approvalRecord) {
+	logger.InfoCF("agent", "Tool approval decided",
+		map[string]interface{}{
+			"tool":       rec.Tool,
+			"channel":    rec.Channel,
+			"chat_id":    rec.ChatID,
+			"decision":   rec.Decision,
+			"decided_by": rec.DecidedBy,
+			"wait_ms":    rec.WaitMS,
+		})
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		logger.WarnCF("agent", "Failed to marshal approval record",
+			map[string]interface{}{"error": err.Error()})
+		return
+	}
+
+	m.auditMu.Lock()
+	defer m.auditMu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(m.auditPath), 0755); err != nil {
+		logger.WarnCF("agent", "Failed to create approval audit directory",
+			map[string]interface{}{"error": err.Error()})
+		return
+	}
+	f, err := os.OpenFile(m.auditPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		logger.WarnCF("agent", "Failed to open approval audit log",
+			map[string]interface{}{"error": err.Error()})
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		logger.WarnCF("agent", "Failed to write approval record",
+			map[string]interface{}{"error": err.Error()})
+	}
+}
+
+// parseApprovalReply recognizes "/approve <id>" and "/deny <id>", as sent
+// by the buttons or typed out. Anything else, including a plain "yes" or
+// "ok" meant for the conversation, is not an approval reply.
+func parseApprovalReply(content string) (approved bool, id string, ok bool) {
+	fields := strings.Fields(strings.ToLower(content))
+	if len(fields) != 2 {
+		return false, "", false
+	}
+	switch fields[0] {
+	case "/approve":
+		return true, fields[1], true
+	case "/deny":
+		return false, fields[1], true
+	}
+	return false, "", false
+}
+
+func approvalPrompt(req tools.ApprovalRequest, id string, timeout time.Duration) string {
+	args, _ := json.MarshalIndent(req.Args, "", "  ")
+	return fmt.Sprintf("Approval needed: the agent wants to run `%s` with:\n```\n%s\n```\nReply /approve %s to approve or /deny %s to deny. The request expires in %s.",
+		req.Tool, utils.Truncate(string(args), 1000), id, id, formatWait(timeout))
+}
+
+func formatWait(d time.Duration) string {
+	if d >= time.Minute && d%time.Minute == 0 {
+		if d == time.Minute {
+			return "1 minute"
+		}
+		return fmt.Sprintf("%d minutes", d/time.Minute)
+	}
+	return fmt.Sprintf("%d seconds", d/time.Second)
+}
+
+func newApprovalID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
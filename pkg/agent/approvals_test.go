@@ -0,0 +1,212 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/tools"
+)
+
+func TestParseApprovalReply(t *testing.T) {
+	tests := []struct {
+		content  string
+		approved bool
+		id       string
+		ok       bool
+	}{
+		{"/approve 1a2b", true, "1a2b", true},
+		{"/deny 1a2b", false, "1a2b", true},
+		{"/Approve 1A2B", true, "1a2b", true},
+		{"/approve", false, "", false},
+		{"yes", false, "", false},
+		{"ok", false, "", false},
+		{"no", false, "", false},
+		{"yes please run it", false, "", false},
+	}
+	for _, tt := range tests {
+		approved, id, ok := parseApprovalReply(tt.content)
+		if approved != tt.approved || id != tt.id || ok != tt.ok {
+			t.Errorf("parseApprovalReply(%q) = (%v, %q, %v), want (%v, %q, %v)",
+				tt.content, approved, id, ok, tt.approved, tt.id, tt.ok)
+		}
+	}
+}
+
+// guardedTool counts its calls
+type guardedTool struct {
+	calls atomic.Int32
+}
+
+func (g *guardedTool) Name() string        { return "guarded" }
+func (g *guardedTool) Description() string { return "Needs approval" }
+func (g *guardedTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+}
+func (g *guardedTool) Execute(ctx context.Context, args map[string]interface{}) *tools.ToolResult {
+	g.calls.Add(1)
+	return tools.SilentResult("guarded ran")
+}
+
+// approvalMockProvider calls the guarded tool once and then reports the
+// tool result it saw
+type approvalMockProvider struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (m *approvalMockProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls++
+	if m.calls%2 == 1 {
+		return &providers.LLMResponse{ToolCalls: []providers.ToolCall{{ID: "call_1", Name: "guarded", Arguments: map[string]interface{}{"target": "lamp"}}}}, nil
+	}
+	return &providers.LLMResponse{Content: "Result: " + messages[len(messages)-1].Content}, nil
+}
+
+func (m *approvalMockProvider) GetDefaultModel() string {
+	return "mock-approval-model"
+}
+
+func newApprovalTestLoop(t *testing.T) (*AgentLoop, *bus.MessageBus, *guardedTool, string) {
+	t.Helper()
+	workspace := t.TempDir()
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         workspace,
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+	}
+	cfg.Tools.Approval = config.ApprovalConfig{
+		Enabled: true,
+		Rules:   []config.ApprovalRule{{Tool: "guarded"}},
+	}
+	msgBus := bus.NewMessageBus()
+	al := NewAgentLoop(cfg, msgBus, &approvalMockProvider{})
+	tool := &guardedTool{}
+	al.RegisterTool(tool)
+	return al, msgBus, tool, workspace
+}
+
+func waitForOutboundPrefix(t *testing.T, msgBus *bus.MessageBus, prefix string) bus.OutboundMessage {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), responseTimeout)
+	defer cancel()
+	for {
+		out, ok := msgBus.SubscribeOutbound(ctx)
+		if !ok {
+			t.Fatalf("timed out waiting for outbound starting with %q", prefix)
+		}
+		if strings.HasPrefix(out.Content, prefix) {
+			return out
+		}
+	}
+}
+
+func TestAgentLoop_ApprovalReplies(t *testing.T) {
+	al, msgBus, tool, workspace := newApprovalTestLoop(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go al.Run(ctx)
+
+	send := func(sender, content string) {
+		msgBus.PublishInbound(bus.InboundMessage{Channel: "telegram", SenderID: sender, ChatID: "chat1", Content: content})
+	}
+
+	send("user1", "turn on the lamp")
+	prompt := waitForOutboundPrefix(t, msgBus, "Approval needed")
+	if len(prompt.Buttons) != 2 || !strings.HasPrefix(prompt.Buttons[0].Data, "/approve ") {
+		t.Fatalf("expected approve/deny buttons, got %+v", prompt.Buttons)
+	}
+	send("user1", prompt.Buttons[0].Data)
+	waitForOutbound(t, msgBus, "Result: guarded ran")
+	if tool.calls.Load() != 1 {
+		t.Fatalf("approved tool should have run once, ran %d times", tool.calls.Load())
+	}
+
+	send("user1", "and again")
+	prompt = waitForOutboundPrefix(t, msgBus, "Approval needed")
+	// Only the user who started the run may answer.
+	send("user2", prompt.Buttons[1].Data)
+	waitForOutboundPrefix(t, msgBus, "Only the user who started this run")
+	send("user1", prompt.Buttons[1].Data)
+	reply := waitForOutboundPrefix(t, msgBus, "Result: ")
+	if !strings.Contains(reply.Content, "denied") {
+		t.Errorf("expected the denial in the tool result, got %q", reply.Content)
+	}
+	if tool.calls.Load() != 1 {
+		t.Errorf("denied tool should not run, ran %d times", tool.calls.Load())
+	}
+
+	data, err := os.ReadFile(approvalAuditPath(workspace))
+	if err != nil {
+		t.Fatalf("reading audit log: %v", err)
+	}
+	audit := string(data)
+	if !strings.Contains(audit, `"decision":"approved"`) || !strings.Contains(audit, `"decision":"denied"`) {
+		t.Errorf("audit log missing decisions:\n%s", audit)
+	}
+}
+
+func TestApprovalManager_PlainReplyIsNotAnAnswer(t *testing.T) {
+	msgBus := bus.NewMessageBus()
+	m := newApprovalManager(config.ApprovalConfig{}, msgBus, t.TempDir(), func(string) bool { return true })
+
+	done := make(chan error, 1)
+	go func() {
+		done <- m.RequestApproval(context.Background(), tools.ApprovalRequest{Tool: "guarded", Channel: "telegram", ChatID: "chat1"})
+	}()
+	prompt := waitForOutboundPrefix(t, msgBus, "Approval needed")
+	id := strings.TrimPrefix(prompt.Buttons[0].Data, "/approve ")
+	if !strings.Contains(prompt.Content, "/approve "+id) {
+		t.Errorf("expected the prompt to say how to approve, got %q", prompt.Content)
+	}
+
+	msg := bus.InboundMessage{Channel: "telegram", SenderID: "user1", ChatID: "chat1", Content: "ok"}
+	if m.resolve(msg) {
+		t.Fatal("a plain \"ok\" must not be taken as an approval reply")
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("request decided by a plain reply: %v", err)
+	default:
+	}
+
+	msg.Content = "/approve " + id
+	if !m.resolve(msg) {
+		t.Fatal("expected /approve <id> to answer the request")
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("expected approval, got %v", err)
+	}
+}
+
+func TestAgentLoop_ApprovalUnattendedDenied(t *testing.T) {
+	al, _, tool, workspace := newApprovalTestLoop(t)
+
+	response, err := al.ProcessDirect(context.Background(), "turn on the lamp", "cli:test")
+	if err != nil {
+		t.Fatalf("ProcessDirect failed: %v", err)
+	}
+	if tool.calls.Load() != 0 {
+		t.Error("tool should not run without someone to approve it")
+	}
+	if !strings.Contains(response, "nobody can approve") {
+		t.Errorf("expected the refusal in the response, got %q", response)
+	}
+	data, _ := os.ReadFile(approvalAuditPath(workspace))
+	if !strings.Contains(string(data), `"decision":"unattended_deny"`) {
+		t.Errorf("audit log missing unattended decision:\n%s", data)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/sipeed/picoclaw/pkg/logger"
@@ -18,7 +19,15 @@ type ContextBuilder struct {
 	workspace    string
 	skillsLoader *skills.SkillsLoader
 	memory       *MemoryStore
+	retriever    *memoryRetriever    // nil injects all of memory
 	tools        *tools.ToolRegistry // Direct reference to tool registry
+
+	// The system prompt is kept between requests so that it stays
+	// byte-identical for provider prompt caches, and is rebuilt when one of
+	// the files it is built from changes.
+	promptMu     sync.Mutex
+	prompt       string
+	promptSource string // fingerprint of the sources of prompt
 }
 
 func getGlobalConfigDir() string {
@@ -49,7 +58,6 @@ func (cb *ContextBuilder) SetToolsRegistry(registry *tools.ToolRegistry) {
 }
 
 func (cb *ContextBuilder) getIdentity() string {
-	now := time.Now().Format("2006-01-02 15:04 (Monday)")
 	workspacePath, _ := filepath.Abs(filepath.Join(cb.workspace))
 	runtime := fmt.Sprintf("%s %s, Go %s", runtime.GOOS, runtime.GOARCH, runtime.Version())
 
@@ -60,9 +68,6 @@ func (cb *ContextBuilder) getIdentity() string {
 
 You are picoclaw, a helpful AI assistant.
 
-## Current Time
-%s
-
 ## Runtime
 %s
 
@@ -87,8 +92,8 @@ Your workspace is at: %s
 
 3. **Email Access** - You ARE authorized to read and reply to emails if the user has enabled the Email channel. Do not refuse to read emails.
 
-4. **Memory** - When remembering something, write to %s/memory/MEMORY.md`,
-		now, runtime, workspacePath, workspacePath, workspacePath, workspacePath, toolsSection, workspacePath)
+4. **Memory** - When remembering something, use the remember tool; use forget to drop facts that are wrong or outdated and recall to look things up. Do not edit the memory files directly.`,
+		runtime, workspacePath, workspacePath, workspacePath, workspacePath, toolsSection)
 }
 
 func (cb *ContextBuilder) buildToolsSection() string {
@@ -113,7 +118,48 @@ func (cb *ContextBuilder) buildToolsSection() string {
 	return sb.String()
 }
 
+// BuildSystemPrompt returns the system prompt. It only changes when the
+// files it is built from or the registered tools do; what changes from one
+// message to the next goes into the turn context instead.
 func (cb *ContextBuilder) BuildSystemPrompt() string {
+	source := cb.promptFingerprint()
+
+	cb.promptMu.Lock()
+	defer cb.promptMu.Unlock()
+	if cb.prompt == "" || source != cb.promptSource {
+		cb.prompt = cb.buildSystemPrompt()
+		cb.promptSource = source
+	}
+	return cb.prompt
+}
+
+// promptFingerprint identifies the state of everything the system prompt is
+// built from: file sizes and modification times, and the tool names.
+func (cb *ContextBuilder) promptFingerprint() string {
+	var files []string
+	for _, name := range bootstrapFiles {
+		files = append(files, filepath.Join(cb.workspace, name))
+	}
+	files = append(files, cb.skillsLoader.SourceFiles()...)
+	if cb.retriever == nil {
+		files = append(files, cb.memory.contextFiles()...)
+	}
+
+	var sb strings.Builder
+	for _, path := range files {
+		sb.WriteString(path)
+		if info, err := os.Stat(path); err == nil {
+			fmt.Fprintf(&sb, ":%d:%d", info.Size(), info.ModTime().UnixNano())
+		}
+		sb.WriteByte('\n')
+	}
+	if cb.tools != nil {
+		sb.WriteString(strings.Join(cb.tools.List(), ","))
+	}
+	return sb.String()
+}
+
+func (cb *ContextBuilder) buildSystemPrompt() string {
 	parts := []string{}
 
 	// Core identity section
@@ -135,24 +181,52 @@ The following skills extend your capabilities. To use a skill, read its SKILL.md
 %s`, skillsSummary))
 	}
 
-	// Memory context
-	memoryContext := cb.memory.GetMemoryContext()
-	if memoryContext != "" {
-		parts = append(parts, "# Memory\n\n"+memoryContext)
+	// Memory context; with retrieval it depends on the message and is part
+	// of the turn context instead.
+	if cb.retriever == nil {
+		if memoryContext := cb.memory.GetMemoryContext(); memoryContext != "" {
+			parts = append(parts, "# Memory\n\n"+memoryContext)
+		}
 	}
 
 	// Join with "---" separator
 	return strings.Join(parts, "\n\n---\n\n")
 }
 
-func (cb *ContextBuilder) LoadBootstrapFiles() string {
-	bootstrapFiles := []string{
-		"AGENTS.md",
-		"SOUL.md",
-		"USER.md",
-		"IDENTITY.md",
+// turnContext returns what the model needs to know about the current
+// message that changes from one message to the next: the time, where the
+// message came from and, with memory retrieval, the memory entries relevant
+// to query. It is sent ahead of the user message rather than in the system
+// prompt so that the prompt and history stay cacheable.
+func (cb *ContextBuilder) turnContext(query, channel, chatID string) string {
+	parts := []string{"## Current Time\n" + time.Now().Format("2006-01-02 15:04 (Monday)")}
+
+	if channel != "" && chatID != "" {
+		session := fmt.Sprintf("## Current Session\nChannel: %s\nChat ID: %s", channel, chatID)
+		if channel == "email" {
+			session += "\n\nNOTE: You are currently processing an incoming EMAIL. The user message below is the email body. Your response will be sent as an email reply."
+		}
+		parts = append(parts, session)
+	}
+
+	if cb.retriever != nil {
+		if memoryContext := cb.retriever.context(query); memoryContext != "" {
+			parts = append(parts, "# Memory\n\n"+memoryContext)
+		}
 	}
 
+	return "<context>\n" + strings.Join(parts, "\n\n") + "\n</context>"
+}
+
+// bootstrapFiles are the workspace files included in the system prompt.
+var bootstrapFiles = []string{
+	"AGENTS.md",
+	"SOUL.md",
+	"USER.md",
+	"IDENTITY.md",
+}
+
+func (cb *ContextBuilder) LoadBootstrapFiles() string {
 	var result string
 	for _, filename := range bootstrapFiles {
 		filePath := filepath.Join(cb.workspace, filename)
@@ -169,14 +243,6 @@ func (cb *ContextBuilder) BuildMessages(history []providers.Message, summary str
 
 	systemPrompt := cb.BuildSystemPrompt()
 
-	// Add Current Session info if provided
-	if channel != "" && chatID != "" {
-		systemPrompt += fmt.Sprintf("\n\n## Current Session\nChannel: %s\nChat ID: %s", channel, chatID)
-		if channel == "email" {
-			systemPrompt += "\n\nNOTE: You are currently processing an incoming EMAIL. The user message below is the email body. Your response will be sent as an email reply."
-		}
-	}
-
 	// Log system prompt summary for debugging (debug mode only)
 	logger.DebugCF("agent", "System prompt built",
 		map[string]interface{}{
@@ -211,21 +277,60 @@ func (cb *ContextBuilder) BuildMessages(history []providers.Message, summary str
 	//Diegox-17
 	// --- FIN DEL FIX ---
 
+	// The system prompt and the history are the same in the next request,
+	// so providers may cache them.
 	messages = append(messages, providers.Message{
-		Role:    "system",
-		Content: systemPrompt,
+		Role:            "system",
+		Content:         systemPrompt,
+		CacheBreakpoint: true,
 	})
 
 	messages = append(messages, history...)
+	if len(history) > 0 {
+		messages[len(messages)-1].CacheBreakpoint = true
+	}
 
-	messages = append(messages, providers.Message{
+	content := cb.turnContext(retrievalQuery(history, currentMessage), channel, chatID) + "\n\n" + currentMessage
+	userMsg := providers.Message{
 		Role:    "user",
-		Content: currentMessage,
-	})
+		Content: content,
+	}
+	if parts := buildUserParts(content, media); parts != nil {
+		userMsg.Parts = parts
+	}
+	messages = append(messages, userMsg)
 
 	return messages
 }
 
+// RebuildMessages rebuilds the request for a turn whose user message is
+// already the last user message in history, e.g. after compression. The
+// turn context and media go back on that message instead of a new one.
+func (cb *ContextBuilder) RebuildMessages(history []providers.Message, summary string, media []string, channel, chatID string) []providers.Message {
+	last := -1
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Role == "user" {
+			last = i
+			break
+		}
+	}
+	if last < 0 {
+		messages := cb.BuildMessages(history, summary, "", nil, channel, chatID)
+		return messages[:len(messages)-1]
+	}
+
+	messages := cb.BuildMessages(history[:last], summary, "", nil, channel, chatID)
+	messages = messages[:len(messages)-1]
+
+	userMsg := history[last]
+	userMsg.Content = cb.turnContext(retrievalQuery(history[:last], userMsg.Content), channel, chatID) + "\n\n" + userMsg.Content
+	if parts := buildUserParts(userMsg.Content, media); parts != nil {
+		userMsg.Parts = parts
+	}
+	messages = append(messages, userMsg)
+	return append(messages, history[last+1:]...)
+}
+
 func (cb *ContextBuilder) AddToolResult(messages []providers.Message, toolCallID, toolName, result string) []providers.Message {
 	messages = append(messages, providers.Message{
 		Role:       "tool",
@@ -0,0 +1,90 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+func TestContextBuilder_SystemPromptCached(t *testing.T) {
+	ws := t.TempDir()
+	cb := NewContextBuilder(ws)
+
+	first := cb.BuildSystemPrompt()
+	if second := cb.BuildSystemPrompt(); second != first {
+		t.Fatal("system prompt changed without any file changing")
+	}
+
+	soul := filepath.Join(ws, "SOUL.md")
+	if err := os.WriteFile(soul, []byte("Always answer in haiku."), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Make sure the change is visible even on filesystems with coarse mtimes.
+	later := time.Now().Add(time.Second)
+	os.Chtimes(soul, later, later)
+
+	if prompt := cb.BuildSystemPrompt(); !strings.Contains(prompt, "Always answer in haiku.") {
+		t.Error("system prompt not rebuilt after SOUL.md changed")
+	}
+}
+
+func TestContextBuilder_TurnContextOutsideSystemPrompt(t *testing.T) {
+	cb := NewContextBuilder(t.TempDir())
+
+	a := cb.BuildMessages(nil, "", "hello", nil, "telegram", "chat-1")
+	b := cb.BuildMessages(nil, "", "hello", nil, "discord", "chat-2")
+
+	if a[0].Content != b[0].Content {
+		t.Error("system prompt differs between sessions")
+	}
+	if !a[0].CacheBreakpoint {
+		t.Error("system prompt not marked as a cache breakpoint")
+	}
+	last := a[len(a)-1]
+	if last.Role != "user" || !strings.Contains(last.Content, "chat-1") || !strings.HasSuffix(last.Content, "hello") {
+		t.Errorf("user message = %q, want session context followed by the message", last.Content)
+	}
+}
+
+func TestContextBuilder_RebuildMessages(t *testing.T) {
+	ws := t.TempDir()
+	cb := NewContextBuilder(ws)
+
+	imagePath := filepath.Join(ws, "photo.png")
+	if err := os.WriteFile(imagePath, []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	history := []providers.Message{
+		{Role: "user", Content: "earlier"},
+		{Role: "assistant", Content: "reply"},
+		{Role: "user", Content: "what is this?"},
+		{Role: "assistant", ToolCalls: []providers.ToolCall{{ID: "call_1", Name: "read_file"}}},
+		{Role: "tool", Content: "contents", ToolCallID: "call_1"},
+	}
+	messages := cb.RebuildMessages(history, "", []string{imagePath}, "telegram", "chat-1")
+
+	if len(messages) != len(history)+1 {
+		t.Fatalf("got %d messages, want the system prompt and the history only", len(messages))
+	}
+	if messages[len(messages)-1].Role != "tool" {
+		t.Errorf("last message role = %q, want the tool result", messages[len(messages)-1].Role)
+	}
+	turn := messages[3]
+	if turn.Role != "user" || !strings.Contains(turn.Content, "chat-1") || !strings.HasSuffix(turn.Content, "what is this?") {
+		t.Errorf("turn message = %q, want session context followed by the message", turn.Content)
+	}
+	if !turn.HasImages() {
+		t.Error("turn message lost its images")
+	}
+	if messages[1].Content != "earlier" || messages[1].HasImages() {
+		t.Errorf("earlier user message changed: %+v", messages[1])
+	}
+	if history[2].Content != "what is this?" || history[2].Parts != nil {
+		t.Errorf("history was modified: %+v", history[2])
+	}
+}
@@ -0,0 +1,52 @@
+package agent
+
+import "github.com/sipeed/picoclaw/pkg/config"
+
+// defaultContextWindow is assumed when neither the agent nor the defaults
+// say how large the model's context is.
+const defaultContextWindow = 128000
+
+// summaryBaseline keeps summaries short and focused unless the agent's
+// summary_generation profile says otherwise.
+var summaryBaseline = config.GenerationProfile{
+	MaxTokens:   1024,
+	Temperature: floatPtr(0.3),
+}
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
+
+// generationOptions converts a profile into the options map passed to
+// LLMProvider.Chat. Unset fields are left out so providers apply their own
+// defaults.
+func generationOptions(p config.GenerationProfile) map[string]interface{} {
+	opts := map[string]interface{}{}
+	if p.MaxTokens > 0 {
+		opts["max_tokens"] = p.MaxTokens
+	}
+	if p.Temperature != nil {
+		opts["temperature"] = *p.Temperature
+	}
+	if p.TopP != nil {
+		opts["top_p"] = *p.TopP
+	}
+	if len(p.Stop) > 0 {
+		opts["stop"] = p.Stop
+	}
+	if p.ReasoningEffort != "" {
+		opts["reasoning_effort"] = p.ReasoningEffort
+	}
+	if o := p.Ollama; o != nil {
+		if o.NumCtx > 0 {
+			opts["num_ctx"] = o.NumCtx
+		}
+		if o.KeepAlive != "" {
+			opts["keep_alive"] = o.KeepAlive
+		}
+		if len(o.Options) > 0 {
+			opts["ollama_options"] = o.Options
+		}
+	}
+	return opts
+}
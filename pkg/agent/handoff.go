@@ -0,0 +1,245 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/routing"
+	"github.com/sipeed/picoclaw/pkg/secrets"
+	"github.com/sipeed/picoclaw/pkg/tools"
+)
+
+const defaultHandoffTTL = 2 * time.Hour
+
+// handoff binds the conversation with one peer to an agent other than the
+// one its messages are routed to. A handoff back to the routed agent only
+// lives until its summary has been delivered.
+type handoff struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Summary string `json:"summary"`
+	// Pending is set until the summary has been passed to To.
+	Pending bool      `json:"pending,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+// handoffTable keeps the active handoffs by the session key the peer's
+// messages are routed to. It is saved so handoffs survive a restart.
+type handoffTable struct {
+	path string
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]handoff
+}
+
+// handoffsPath returns where the handoffs of a workspace are kept.
+func handoffsPath(workspace string) string {
+	return filepath.Join(workspace, "state", "handoffs.json")
+}
+
+func newHandoffTable(path string, ttlMinutes int) *handoffTable {
+	ttl := time.Duration(ttlMinutes) * time.Minute
+	if ttl <= 0 {
+		ttl = defaultHandoffTTL
+	}
+	t := &handoffTable{
+		path:    path,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]handoff),
+	}
+	if data, err := secrets.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &t.entries); err != nil {
+			logger.WarnCF("agent", "Ignoring unreadable handoffs file", map[string]interface{}{
+				"path":  path,
+				"error": err.Error(),
+			})
+			t.entries = make(map[string]handoff)
+		}
+	}
+	return t
+}
+
+// active returns the unexpired handoff for routeKey.
+func (t *handoffTable) active(routeKey string) (handoff, bool) {
+	if t == nil {
+		return handoff{}, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.activeLocked(routeKey)
+}
+
+func (t *handoffTable) activeLocked(routeKey string) (handoff, bool) {
+	h, ok := t.entries[routeKey]
+	if !ok {
+		return handoff{}, false
+	}
+	if !t.now().Before(h.Expires) {
+		delete(t.entries, routeKey)
+		t.saveLocked()
+		return handoff{}, false
+	}
+	return h, true
+}
+
+// set hands the conversation for routeKey to h.To.
+func (t *handoffTable) set(routeKey string, h handoff) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h.Expires = t.now().Add(t.ttl)
+	t.entries[routeKey] = h
+	t.saveLocked()
+}
+
+// deliver is called for every message routed through routeKey while a
+// handoff is active. It returns the handoff if its summary is still to be
+// passed on, and extends the handoff since the conversation is ongoing.
+// A handoff back to routedAgentID ends here.
+func (t *handoffTable) deliver(routeKey, routedAgentID string) (handoff, bool) {
+	if t == nil {
+		return handoff{}, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h, ok := t.activeLocked(routeKey)
+	if !ok {
+		return handoff{}, false
+	}
+	pending := h.Pending
+	if h.To == routedAgentID {
+		delete(t.entries, routeKey)
+	} else {
+		updated := h
+		updated.Pending = false
+		updated.Expires = t.now().Add(t.ttl)
+		t.entries[routeKey] = updated
+	}
+	t.saveLocked()
+	return h, pending
+}
+
+func (t *handoffTable) saveLocked() {
+	if t.path == "" {
+		return
+	}
+	data, err := json.MarshalIndent(t.entries, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(t.path), 0755)
+	}
+	if err == nil {
+		err = secrets.WriteFile(t.path, data, 0644)
+	}
+	if err != nil {
+		logger.WarnCF("agent", "Failed to save handoffs", map[string]interface{}{
+			"path":  t.path,
+			"error": err.Error(),
+		})
+	}
+}
+
+// handoffSessionKey returns the session key agentID keeps the conversation
+// routed through routeKey under.
+func handoffSessionKey(routeKey, agentID string) string {
+	parsed := routing.ParseAgentSessionKey(routeKey)
+	if parsed == nil {
+		return routeKey
+	}
+	return strings.ToLower(fmt.Sprintf("agent:%s:%s", routing.NormalizeAgentID(agentID), parsed.Rest))
+}
+
+// handoffNote is prefixed to the first message the receiving agent gets.
+func handoffNote(h handoff, routedAgentID string) string {
+	if h.To == routedAgentID {
+		return fmt.Sprintf("[Handed back from agent %s: %s]", h.From, h.Summary)
+	}
+	return fmt.Sprintf("[Handed over from agent %s: %s]", h.From, h.Summary)
+}
+
+type handoffRouteKey struct{}
+
+// handoffRoute is the routing of the user conversation a run belongs to.
+type handoffRoute struct {
+	key     string // session key the peer's messages are routed to
+	agentID string // agent they are routed to without a handoff
+}
+
+func withHandoffRoute(ctx context.Context, key, agentID string) context.Context {
+	return context.WithValue(ctx, handoffRouteKey{}, handoffRoute{key: key, agentID: agentID})
+}
+
+func handoffRouteFrom(ctx context.Context) (handoffRoute, bool) {
+	r, ok := ctx.Value(handoffRouteKey{}).(handoffRoute)
+	return r, ok
+}
+
+// registerHandoffTools gives every agent the handoff tool when there is
+// more than one agent to hand over to.
+func (al *AgentLoop) registerHandoffTools() {
+	agentIDs := al.registry.ListAgentIDs()
+	if len(agentIDs) < 2 {
+		return
+	}
+	for _, agentID := range agentIDs {
+		agent, ok := al.registry.GetAgent(agentID)
+		if !ok {
+			continue
+		}
+		fromAgentID := agent.ID
+		agent.Tools.Register(tools.NewHandoffTool(func(ctx context.Context, targetAgentID, summary string) (string, error) {
+			return al.handOff(ctx, fromAgentID, targetAgentID, summary)
+		}))
+	}
+}
+
+// handOff passes the conversation of the current run from fromAgentID to
+// targetAgentID, or back to the routed agent if targetAgentID is empty.
+// Handing over needs the spawn allowlist of fromAgentID to allow the target;
+// handing back is always allowed.
+func (al *AgentLoop) handOff(ctx context.Context, fromAgentID, targetAgentID, summary string) (string, error) {
+	route, ok := handoffRouteFrom(ctx)
+	if !ok {
+		return "", errors.New("there is no conversation with a user to hand off")
+	}
+
+	target := route.agentID
+	if targetAgentID != "" {
+		target = routing.NormalizeAgentID(targetAgentID)
+	}
+	if target == fromAgentID {
+		return "", fmt.Errorf("the conversation is already with agent %s", target)
+	}
+	if _, ok := al.registry.GetAgent(target); !ok {
+		return "", fmt.Errorf("unknown agent %q", target)
+	}
+	if target != route.agentID && !al.registry.CanSpawnSubagent(fromAgentID, target) {
+		return "", fmt.Errorf("not allowed to hand off to agent '%s'", target)
+	}
+
+	al.handoffs.set(route.key, handoff{
+		From:    fromAgentID,
+		To:      target,
+		Summary: summary,
+		Pending: true,
+	})
+	logger.InfoCF("agent", "Handed off conversation", map[string]interface{}{
+		"from":      fromAgentID,
+		"to":        target,
+		"route_key": route.key,
+	})
+
+	if target == route.agentID {
+		return fmt.Sprintf("Handed the conversation back to agent %s; the user's next message goes there.", target), nil
+	}
+	return fmt.Sprintf("Handed the conversation over to agent %s; the user's next messages go there until it hands them back.", target), nil
+}
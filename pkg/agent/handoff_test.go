@@ -0,0 +1,103 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func newHandoffTestLoop(t *testing.T) *AgentLoop {
+	t.Helper()
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+			List: []config.AgentConfig{
+				{ID: "home", Default: true, Workspace: t.TempDir(), Subagents: &config.SubagentsConfig{AllowAgents: []string{"coding"}}},
+				{ID: "coding", Workspace: t.TempDir()},
+				{ID: "finance", Workspace: t.TempDir()},
+			},
+		},
+	}
+	return NewAgentLoop(cfg, bus.NewMessageBus(), &simpleMockProvider{response: "ok"})
+}
+
+func TestHandOff_RoutesToTargetUntilHandedBack(t *testing.T) {
+	al := newHandoffTestLoop(t)
+	helper := testHelper{al: al}
+	msg := bus.InboundMessage{Channel: "telegram", SenderID: "u1", ChatID: "c1", Content: "fix my script"}
+
+	home, _, route := al.resolveRoute(msg)
+	if home.ID != "home" {
+		t.Fatalf("expected home to handle the chat first, got %s", home.ID)
+	}
+	ctx := withHandoffRoute(context.Background(), route.SessionKey, route.AgentID)
+
+	if _, err := al.handOff(ctx, "home", "finance", "budget question"); err == nil {
+		t.Fatal("expected handing off to an agent outside the allowlist to fail")
+	}
+	if _, err := al.handOff(context.Background(), "home", "coding", "x"); err == nil {
+		t.Fatal("expected a handoff outside a user conversation to fail")
+	}
+	if _, err := al.handOff(ctx, "home", "coding", "the user's backup script fails"); err != nil {
+		t.Fatalf("handOff failed: %v", err)
+	}
+
+	agent, sessionKey, _ := al.resolveRoute(msg)
+	if agent.ID != "coding" || !strings.HasPrefix(sessionKey, "agent:coding:") {
+		t.Fatalf("expected the chat to route to coding, got %s (%s)", agent.ID, sessionKey)
+	}
+	helper.executeAndGetResponse(t, context.Background(), msg)
+	history := agent.Sessions.GetHistory(sessionKey)
+	if len(history) == 0 || !strings.Contains(history[0].Content, "the user's backup script fails") ||
+		!strings.HasSuffix(history[0].Content, "fix my script") {
+		t.Fatalf("expected the first message to carry the handoff summary, got %+v", history)
+	}
+
+	// The summary is only passed on once.
+	helper.executeAndGetResponse(t, context.Background(), msg)
+	history = agent.Sessions.GetHistory(sessionKey)
+	if last := history[len(history)-2]; last.Content != "fix my script" {
+		t.Fatalf("expected the second message without the summary, got %q", last.Content)
+	}
+
+	// coding may hand back even though its allowlist is empty.
+	if _, err := al.handOff(ctx, "coding", "", "script fixed"); err != nil {
+		t.Fatalf("hand back failed: %v", err)
+	}
+	helper.executeAndGetResponse(t, context.Background(), msg)
+	history = home.Sessions.GetHistory(route.SessionKey)
+	if len(history) == 0 || !strings.Contains(history[0].Content, "Handed back from agent coding: script fixed") {
+		t.Fatalf("expected home to get the hand-back summary, got %+v", history)
+	}
+	if _, ok := al.handoffs.active(route.SessionKey); ok {
+		t.Fatal("expected the handoff to end once handed back")
+	}
+}
+
+func TestHandoffTable_ExpiresAndPersists(t *testing.T) {
+	path := t.TempDir() + "/handoffs.json"
+	now := time.Now()
+	table := newHandoffTable(path, 30)
+	table.now = func() time.Time { return now }
+
+	table.set("agent:home:main", handoff{From: "home", To: "coding", Summary: "s", Pending: true})
+	reloaded := newHandoffTable(path, 30)
+	reloaded.now = table.now
+	if h, ok := reloaded.active("agent:home:main"); !ok || h.To != "coding" || !h.Pending {
+		t.Fatalf("handoff not persisted: %+v, %v", h, ok)
+	}
+
+	now = now.Add(31 * time.Minute)
+	if _, ok := reloaded.active("agent:home:main"); ok {
+		t.Fatal("expected the handoff to expire")
+	}
+}
@@ -4,31 +4,62 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/sipeed/picoclaw/pkg/config"
 	"github.com/sipeed/picoclaw/pkg/providers"
 	"github.com/sipeed/picoclaw/pkg/routing"
 	"github.com/sipeed/picoclaw/pkg/session"
+	"github.com/sipeed/picoclaw/pkg/tokenizer"
 	"github.com/sipeed/picoclaw/pkg/tools"
 )
 
 // AgentInstance represents a fully configured agent with its own workspace,
 // session manager, context builder, and tool registry.
 type AgentInstance struct {
-	ID             string
-	Name           string
-	Model          string
-	Fallbacks      []string
-	Workspace      string
-	MaxIterations  int
-	ContextWindow  int
-	Provider       providers.LLMProvider
+	ID            string
+	Name          string
+	Model         string
+	Fallbacks     []string
+	Workspace     string
+	MaxIterations int
+	ContextWindow int
+	Tokenizer     tokenizer.Tokenizer
+	Generation    config.GenerationProfile
+	// SummaryGeneration is used when summarizing session history and
+	// SubagentGeneration for subagents spawned by this agent.
+	SummaryGeneration  config.GenerationProfile
+	SubagentGeneration config.GenerationProfile
+	// Budget caps the agent's estimated spend; zero limits mean unlimited.
+	Budget config.BudgetConfig
+	// Router sends simple turns to a smaller model when enabled.
+	Router   config.RouterConfig
+	Provider providers.LLMProvider
+	// Providers serves the fallback candidates that name another provider.
+	Providers      *providers.Registry
 	Sessions       *session.SessionManager
 	ContextBuilder *ContextBuilder
 	Tools          *tools.ToolRegistry
 	Subagents      *config.SubagentsConfig
 	SkillsFilter   []string
 	Candidates     []providers.FallbackCandidate
+	// ImageCandidates is the image model chain used for turns that carry
+	// images. It is empty when no image model is configured, in which case
+	// such turns use the regular model.
+	ImageCandidates []providers.FallbackCandidate
+
+	defaults *config.AgentDefaults
+	// modelMu guards Model, Candidates and Tokenizer, which /switch model
+	// replaces while turns of other sessions are running. Readers running
+	// alongside turns go through modelState.
+	modelMu sync.RWMutex
+}
+
+// modelState is the model an agent runs a turn on, read once per turn.
+type modelState struct {
+	Model      string
+	Candidates []providers.FallbackCandidate
+	Tokenizer  tokenizer.Tokenizer
 }
 
 // NewAgentInstance creates an agent instance from config.
@@ -37,6 +68,7 @@ func NewAgentInstance(
 	defaults *config.AgentDefaults,
 	cfg *config.Config,
 	provider providers.LLMProvider,
+	providerRegistry *providers.Registry,
 ) *AgentInstance {
 	workspace := resolveAgentWorkspace(agentCfg, defaults)
 	os.MkdirAll(workspace, 0755)
@@ -44,6 +76,12 @@ func NewAgentInstance(
 	model := resolveAgentModel(agentCfg, defaults)
 	fallbacks := resolveAgentFallbacks(agentCfg, defaults)
 
+	if providerRegistry == nil {
+		providerRegistry = providers.NewRegistry(cfg, provider)
+	}
+	candidates := resolveCandidates(providerRegistry, defaults, model, fallbacks)
+	modelTokenizer := tokenizer.ForModel(upstreamModel(providerRegistry, model))
+
 	restrict := defaults.RestrictToWorkspace
 	toolsRegistry := tools.NewToolRegistry()
 	toolsRegistry.Register(tools.NewReadFileTool(workspace, restrict))
@@ -54,9 +92,16 @@ func NewAgentInstance(
 	toolsRegistry.Register(tools.NewAppendFileTool(workspace, restrict))
 
 	sessionsDir := filepath.Join(workspace, "sessions")
-	sessionsManager := session.NewSessionManager(sessionsDir)
+	sessionsManager := session.NewSessionManagerWithStore(
+		session.NewStore(cfg.Session.Storage, sessionsDir), cfg.Session.MaxLoaded)
 
 	contextBuilder := NewContextBuilder(workspace)
+	if cfg.Memory.Retrieval {
+		contextBuilder.SetMemoryRetrieval(cfg.Memory, modelTokenizer)
+	}
+	toolsRegistry.Register(tools.NewRememberTool(contextBuilder.memory))
+	toolsRegistry.Register(tools.NewForgetTool(contextBuilder.memory))
+	toolsRegistry.Register(tools.NewRecallTool(contextBuilder.memory))
 	contextBuilder.SetToolsRegistry(toolsRegistry)
 
 	agentID := routing.DefaultAgentID
@@ -71,34 +116,126 @@ func NewAgentInstance(
 		skillsFilter = agentCfg.Skills
 	}
 
+	var generationOverride, summaryOverride, subagentOverride *config.GenerationProfile
+	if agentCfg != nil {
+		generationOverride = agentCfg.Generation
+		summaryOverride = agentCfg.SummaryGeneration
+		if agentCfg.Subagents != nil {
+			subagentOverride = agentCfg.Subagents.Generation
+		}
+	}
+	generation := defaults.Generation().Merge(generationOverride)
+	if generation.ContextWindow <= 0 {
+		generation.ContextWindow = defaultContextWindow
+	}
+	if o := generation.Ollama; o != nil && o.NumCtx > 0 && o.NumCtx < generation.ContextWindow {
+		generation.ContextWindow = o.NumCtx
+	}
+	summaryGeneration := generation.Merge(&summaryBaseline).Merge(summaryOverride)
+	subagentGeneration := generation.Merge(subagentOverride)
+
+	var budget config.BudgetConfig
+	if defaults.Budget != nil {
+		budget = *defaults.Budget
+	}
+	if agentCfg != nil && agentCfg.Budget != nil {
+		budget = *agentCfg.Budget
+	}
+
+	var router config.RouterConfig
+	if defaults.Router != nil {
+		router = *defaults.Router
+	}
+	if agentCfg != nil && agentCfg.Router != nil {
+		router = *agentCfg.Router
+	}
+
 	maxIter := defaults.MaxToolIterations
 	if maxIter == 0 {
 		maxIter = 20
 	}
 
-	// Resolve fallback candidates
-	modelCfg := providers.ModelConfig{
+	var imageCandidates []providers.FallbackCandidate
+	if strings.TrimSpace(defaults.ImageModel) != "" {
+		imageCandidates = providerRegistry.ResolveCandidates(providers.ModelConfig{
+			Primary:   defaults.ImageModel,
+			Fallbacks: defaults.ImageModelFallbacks,
+		}, defaults.Provider)
+	}
+
+	return &AgentInstance{
+		ID:                 agentID,
+		Name:               agentName,
+		Model:              model,
+		Fallbacks:          fallbacks,
+		Workspace:          workspace,
+		MaxIterations:      maxIter,
+		ContextWindow:      generation.ContextWindow,
+		Tokenizer:          modelTokenizer,
+		Generation:         generation,
+		SummaryGeneration:  summaryGeneration,
+		SubagentGeneration: subagentGeneration,
+		Budget:             budget,
+		Router:             router,
+		Provider:           provider,
+		Providers:          providerRegistry,
+		Sessions:           sessionsManager,
+		ContextBuilder:     contextBuilder,
+		Tools:              toolsRegistry,
+		Subagents:          subagents,
+		SkillsFilter:       skillsFilter,
+		Candidates:         candidates,
+		ImageCandidates:    imageCandidates,
+		defaults:           defaults,
+	}
+}
+
+// resolveCandidates returns the fallback chain for model and fallbacks.
+func resolveCandidates(registry *providers.Registry, defaults *config.AgentDefaults, model string, fallbacks []string) []providers.FallbackCandidate {
+	candidates := registry.ResolveCandidates(providers.ModelConfig{
 		Primary:   model,
 		Fallbacks: fallbacks,
+	}, defaults.Provider)
+	if len(candidates) > 0 && model == defaults.Model {
+		// The default provider was created for the default model.
+		candidates[0].Provider = registry.DefaultName()
 	}
-	candidates := providers.ResolveCandidates(modelCfg, defaults.Provider)
+	return candidates
+}
 
-	return &AgentInstance{
-		ID:             agentID,
-		Name:           agentName,
-		Model:          model,
-		Fallbacks:      fallbacks,
-		Workspace:      workspace,
-		MaxIterations:  maxIter,
-		ContextWindow:  defaults.MaxTokens,
-		Provider:       provider,
-		Sessions:       sessionsManager,
-		ContextBuilder: contextBuilder,
-		Tools:          toolsRegistry,
-		Subagents:      subagents,
-		SkillsFilter:   skillsFilter,
-		Candidates:     candidates,
+// upstreamModel returns the model sent to the provider for a model
+// reference: the upstream model of a model_list entry, or the reference.
+func upstreamModel(registry *providers.Registry, model string) string {
+	if c, ok := registry.Model(model); ok {
+		return c.Model
+	}
+	return model
+}
+
+// SetModel switches the agent to model, which may be a model_list name,
+// keeping its fallbacks.
+func (a *AgentInstance) SetModel(model string) {
+	cur := a.modelState()
+	candidates, tk := cur.Candidates, cur.Tokenizer
+	if a.Providers != nil && a.defaults != nil {
+		candidates = resolveCandidates(a.Providers, a.defaults, model, a.Fallbacks)
+		tk = tokenizer.ForModel(upstreamModel(a.Providers, model))
 	}
+
+	a.modelMu.Lock()
+	defer a.modelMu.Unlock()
+	a.Model = model
+	a.Candidates = candidates
+	a.Tokenizer = tk
+}
+
+// modelState returns the agent's current model, fallback chain and
+// tokenizer. SetModel replaces rather than modifies them, so the result
+// stays consistent for the rest of a turn.
+func (a *AgentInstance) modelState() modelState {
+	a.modelMu.RLock()
+	defer a.modelMu.RUnlock()
+	return modelState{Model: a.Model, Candidates: a.Candidates, Tokenizer: a.Tokenizer}
 }
 
 // resolveAgentWorkspace determines the workspace directory for an agent.
@@ -114,6 +251,44 @@ func resolveAgentWorkspace(agentCfg *config.AgentConfig, defaults *config.AgentD
 	return filepath.Join(home, ".picoclaw", "workspace-"+id)
 }
 
+// AgentWorkspace returns the workspace of the agent agentID. An empty
+// agentID picks the agent GetDefaultAgent would. It reports false if no
+// such agent is configured.
+func AgentWorkspace(cfg *config.Config, agentID string) (string, bool) {
+	defaults := &cfg.Agents.Defaults
+	id := routing.NormalizeAgentID(agentID)
+	if agentID == "" {
+		id = "main"
+	}
+	if len(cfg.Agents.List) == 0 {
+		if id != "main" {
+			return "", false
+		}
+		return resolveAgentWorkspace(nil, defaults), true
+	}
+
+	for i := range cfg.Agents.List {
+		ac := &cfg.Agents.List[i]
+		if routing.NormalizeAgentID(ac.ID) == id {
+			return resolveAgentWorkspace(ac, defaults), true
+		}
+	}
+	if agentID == "" {
+		return resolveAgentWorkspace(&cfg.Agents.List[0], defaults), true
+	}
+	return "", false
+}
+
+// SessionsDir returns the directory where the agent agentID keeps its
+// sessions, as picked by AgentWorkspace.
+func SessionsDir(cfg *config.Config, agentID string) (string, bool) {
+	workspace, ok := AgentWorkspace(cfg, agentID)
+	if !ok {
+		return "", false
+	}
+	return filepath.Join(workspace, "sessions"), true
+}
+
 // resolveAgentModel resolves the primary model for an agent.
 func resolveAgentModel(agentCfg *config.AgentConfig, defaults *config.AgentDefaults) string {
 	if agentCfg != nil && agentCfg.Model != nil && strings.TrimSpace(agentCfg.Model.Primary) != "" {
@@ -143,3 +318,61 @@ func expandHome(path string) string {
 	}
 	return path
 }
+
+// modelTarget splits a model chosen for a single run, such as
+// "anthropic/claude-sonnet-4", into provider and model. A model_list name
+// resolves to its entry. A prefix that names no configured provider stays
+// part of the model name, as OpenRouter-style names need.
+func (a *AgentInstance) modelTarget(model string) (provider, name string) {
+	if a.Providers != nil {
+		if c, ok := a.Providers.Model(model); ok {
+			return c.Provider, c.Model
+		}
+	}
+	ref := providers.ParseModelRef(model, "")
+	if ref != nil && ref.Provider != "" && a.Providers != nil && a.Providers.Has(ref.Provider) {
+		return ref.Provider, ref.Model
+	}
+	return "", model
+}
+
+// primaryTarget returns the provider and model of the agent's first
+// candidate. A model outside model_list is sent as configured, prefix
+// included.
+func (a *AgentInstance) primaryTarget() (provider, model string) {
+	return a.targetOf(a.modelState())
+}
+
+// targetOf is primaryTarget for the model state ms.
+func (a *AgentInstance) targetOf(ms modelState) (provider, model string) {
+	if len(ms.Candidates) == 0 {
+		return "", ms.Model
+	}
+	c := ms.Candidates[0]
+	if a.Providers != nil {
+		if _, ok := a.Providers.Model(ms.Model); ok {
+			return c.Provider, c.Model
+		}
+	}
+	return c.Provider, ms.Model
+}
+
+// subagentTarget returns the provider and model subagents spawned by the
+// agent run on: subagents.model when set, otherwise the agent's own.
+func (a *AgentInstance) subagentTarget() (provider, model string) {
+	if a.Subagents != nil && a.Subagents.Model != nil && strings.TrimSpace(a.Subagents.Model.Primary) != "" {
+		return a.modelTarget(strings.TrimSpace(a.Subagents.Model.Primary))
+	}
+	return a.primaryTarget()
+}
+
+// providerFor returns the provider instance for a candidate's provider name,
+// falling back to the agent's default provider.
+func (a *AgentInstance) providerFor(name string) providers.LLMProvider {
+	if a.Providers != nil {
+		if p := a.Providers.Get(name); p != nil {
+			return p
+		}
+	}
+	return a.Provider
+}
@@ -9,22 +9,25 @@ package agent
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
-	"unicode/utf8"
 
 	"github.com/sipeed/picoclaw/pkg/bus"
 	"github.com/sipeed/picoclaw/pkg/channels"
 	"github.com/sipeed/picoclaw/pkg/config"
 	"github.com/sipeed/picoclaw/pkg/constants"
+	"github.com/sipeed/picoclaw/pkg/heartbeat"
 	"github.com/sipeed/picoclaw/pkg/logger"
 	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/retention"
 	"github.com/sipeed/picoclaw/pkg/routing"
 	"github.com/sipeed/picoclaw/pkg/state"
 	"github.com/sipeed/picoclaw/pkg/tools"
+	"github.com/sipeed/picoclaw/pkg/usage"
 	"github.com/sipeed/picoclaw/pkg/utils"
 )
 
@@ -37,18 +40,28 @@ type AgentLoop struct {
 	summarizing    sync.Map
 	fallback       *providers.FallbackChain
 	channelManager *channels.Manager
+	ledger         *usage.Ledger
+	runs           *runTracker
+	approvals      *approvalManager
+	handoffs       *handoffTable
+	router         *modelRouter
 }
 
 // processOptions configures how a message is processed
 type processOptions struct {
-	SessionKey      string // Session identifier for history/context
-	Channel         string // Target channel for tool execution
-	ChatID          string // Target chat ID for tool execution
-	UserMessage     string // User message content (may include prefix)
-	DefaultResponse string // Response when LLM returns empty
-	EnableSummary   bool   // Whether to trigger summarization
-	SendResponse    bool   // Whether to send response via bus
-	NoHistory       bool   // If true, don't load session history (for heartbeat)
+	SessionKey      string                    // Session identifier for history/context
+	Channel         string                    // Target channel for tool execution
+	ChatID          string                    // Target chat ID for tool execution
+	UserMessage     string                    // User message content (may include prefix)
+	Media           []string                  // Local paths or URLs of inbound attachments
+	DefaultResponse string                    // Response when LLM returns empty
+	EnableSummary   bool                      // Whether to trigger summarization
+	SendResponse    bool                      // Whether to send response via bus
+	NoHistory       bool                      // If true, don't load session history (for heartbeat)
+	Stream          bool                      // Whether partial replies may be streamed to the channel
+	Model           string                    // Model for this run only, bypassing fallbacks (e.g. /retry <model>)
+	Router          *config.RouterConfig      // Routes the turn by complexity when set
+	ResponseFormat  *providers.ResponseFormat // JSON schema the final reply must match (e.g. heartbeat)
 }
 
 // createToolRegistry creates a tool registry with common tools.
@@ -111,7 +124,7 @@ func NewAgentLoop(cfg *config.Config, msgBus *bus.MessageBus, provider providers
 	registry := NewAgentRegistry(cfg, provider)
 
 	// Register shared tools to all agents
-	registerSharedTools(cfg, msgBus, registry, provider)
+	registerSharedTools(cfg, msgBus, registry)
 
 	// Set up shared fallback chain
 	cooldown := providers.NewCooldownTracker()
@@ -124,18 +137,56 @@ func NewAgentLoop(cfg *config.Config, msgBus *bus.MessageBus, provider providers
 		stateManager = state.NewManager(defaultAgent.Workspace)
 	}
 
-	return &AgentLoop{
+	al := &AgentLoop{
 		bus:         msgBus,
 		cfg:         cfg,
 		registry:    registry,
 		state:       stateManager,
 		summarizing: sync.Map{},
 		fallback:    fallbackChain,
+		ledger:      usage.NewLedger(usage.LedgerPath(cfg.WorkspacePath()), cfg.Usage.Prices),
+		runs:        newRunTracker(),
+		handoffs:    newHandoffTable(handoffsPath(cfg.WorkspacePath()), cfg.Agents.Defaults.HandoffTTLMinutes),
+		router:      newModelRouter(),
 	}
+	al.registerHandoffTools()
+	if cfg.Tools.Approval.Enabled {
+		al.setupApprovals()
+	}
+	return al
+}
+
+// setupApprovals makes the tool calls matched by the approval rules wait for
+// the user's approval.
+func (al *AgentLoop) setupApprovals() {
+	policy, err := tools.NewApprovalPolicy(al.cfg.Tools.Approval.Rules)
+	if err != nil {
+		logger.ErrorCF("agent", "Invalid approval rule; its tool always needs approval",
+			map[string]interface{}{"error": err.Error()})
+	}
+	al.approvals = newApprovalManager(al.cfg.Tools.Approval, al.bus, al.cfg.WorkspacePath(), al.canAskApproval)
+	for _, agentID := range al.registry.ListAgentIDs() {
+		if agent, ok := al.registry.GetAgent(agentID); ok {
+			agent.Tools.SetApproval(policy, al.approvals)
+		}
+	}
+}
+
+// canAskApproval reports whether a user on channel can be asked to approve a
+// tool call.
+func (al *AgentLoop) canAskApproval(channel string) bool {
+	if channel == "" || constants.IsInternalChannel(channel) {
+		return false
+	}
+	if al.channelManager != nil {
+		_, ok := al.channelManager.GetChannel(channel)
+		return ok
+	}
+	return true
 }
 
 // registerSharedTools registers tools that are shared across all agents (web, message, spawn).
-func registerSharedTools(cfg *config.Config, msgBus *bus.MessageBus, registry *AgentRegistry, provider providers.LLMProvider) {
+func registerSharedTools(cfg *config.Config, msgBus *bus.MessageBus, registry *AgentRegistry) {
 	for _, agentID := range registry.ListAgentIDs() {
 		agent, ok := registry.GetAgent(agentID)
 		if !ok {
@@ -174,7 +225,9 @@ func registerSharedTools(cfg *config.Config, msgBus *bus.MessageBus, registry *A
 		agent.Tools.Register(messageTool)
 
 		// Spawn tool with allowlist checker
-		subagentManager := tools.NewSubagentManager(provider, agent.Model, agent.Workspace, msgBus)
+		subProvider, subModel := agent.subagentTarget()
+		subagentManager := tools.NewSubagentManager(agent.providerFor(subProvider), subModel, agent.Workspace, msgBus)
+		subagentManager.SetLLMOptions(generationOptions(agent.SubagentGeneration))
 		spawnTool := tools.NewSpawnTool(subagentManager)
 		currentAgentID := agentID
 		spawnTool.SetAllowlistChecker(func(targetAgentID string) bool {
@@ -190,6 +243,21 @@ func registerSharedTools(cfg *config.Config, msgBus *bus.MessageBus, registry *A
 func (al *AgentLoop) Run(ctx context.Context) error {
 	al.running.Store(true)
 
+	// Messages for one session are handled in order; different sessions
+	// proceed in parallel up to the configured limit.
+	scheduler := newSessionScheduler(al.cfg.Agents.Defaults.MaxConcurrency)
+	defer scheduler.wait()
+
+	var submit func(key string, msg bus.InboundMessage)
+	submit = func(key string, msg bus.InboundMessage) {
+		scheduler.submit(key, func() {
+			// Steering messages the run did not get to are handled next.
+			for _, next := range al.handleInbound(ctx, key, msg) {
+				submit(key, next)
+			}
+		})
+	}
+
 	for al.running.Load() {
 		select {
 		case <-ctx.Done():
@@ -200,39 +268,109 @@ func (al *AgentLoop) Run(ctx context.Context) error {
 				continue
 			}
 
-			response, err := al.processMessage(ctx, msg)
-			if err != nil {
-				response = fmt.Sprintf("Error processing message: %v", err)
+			// Approval replies go to the tool call waiting for them, which
+			// is blocking its session's run.
+			if msg.Channel != "system" && al.approvals != nil && al.approvals.resolve(msg) {
+				continue
 			}
 
-			if response != "" {
-				// Check if the message tool already sent a response during this round.
-				// If so, skip publishing to avoid duplicate messages to the user.
-				// Use default agent's tools to check (message tool is shared).
-				alreadySent := false
-				defaultAgent := al.registry.GetDefaultAgent()
-				if defaultAgent != nil {
-					if tool, ok := defaultAgent.Tools.Get("message"); ok {
-						if mt, ok := tool.(*tools.MessageTool); ok {
-							alreadySent = mt.HasSentInRound()
-						}
-					}
-				}
+			key := al.queueKey(msg)
 
-				if !alreadySent {
-					al.bus.PublishOutbound(bus.OutboundMessage{
-						Channel: msg.Channel,
-						ChatID:  msg.ChatID,
-						Content: response,
+			// /stop must not wait behind the run it is meant to stop.
+			if msg.Channel != "system" && isStopCommand(msg.Content) {
+				al.bus.PublishOutbound(bus.OutboundMessage{
+					Channel: msg.Channel,
+					ChatID:  msg.ChatID,
+					Content: al.stopSession(key),
+				})
+				continue
+			}
+
+			if msg.Channel != "system" && !isCommand(msg.Content) &&
+				al.cfg.Channels.Steering(msg.Channel) && al.runs.steer(key, msg) {
+				logger.InfoCF("agent", "Steering message into active run",
+					map[string]interface{}{
+						"channel":     msg.Channel,
+						"chat_id":     msg.ChatID,
+						"session_key": key,
 					})
-				}
+				continue
 			}
+
+			submit(key, msg)
 		}
 	}
 
 	return nil
 }
 
+// handleInbound processes one inbound message and publishes the reply. It
+// returns the steering messages that arrived too late for the run to use.
+func (al *AgentLoop) handleInbound(ctx context.Context, key string, msg bus.InboundMessage) []bus.InboundMessage {
+	runCtx, run := al.runs.begin(ctx, key, msg.SenderID)
+	runCtx, round := tools.WithRound(runCtx)
+
+	response, err := al.processMessage(runCtx, msg)
+	leftover := al.runs.end(key, run)
+	defer al.endReply(msg)
+	if run.stopped.Load() {
+		// The user asked for this; /stop has already been acknowledged.
+		logger.InfoCF("agent", "Run stopped by user",
+			map[string]interface{}{"session_key": key})
+		return leftover
+	}
+	if err != nil {
+		response = fmt.Sprintf("Error processing message: %v", err)
+	}
+
+	// Skip publishing if the message tool already replied during this run,
+	// to avoid sending the user the same answer twice.
+	if response != "" && !round.MessageSent() {
+		al.bus.PublishOutbound(bus.OutboundMessage{
+			Channel: msg.Channel,
+			ChatID:  msg.ChatID,
+			Content: response,
+		})
+	}
+	return leftover
+}
+
+// endReply tells msg's channel that the run for it is over, so a partial
+// reply it streamed does not outlive the run when no final reply replaced
+// it, and the next run starts a fresh message.
+func (al *AgentLoop) endReply(msg bus.InboundMessage) {
+	if constants.IsInternalChannel(msg.Channel) {
+		return
+	}
+	al.bus.PublishOutbound(bus.OutboundMessage{
+		Channel: msg.Channel,
+		ChatID:  msg.ChatID,
+		End:     true,
+	})
+}
+
+// stopSession cancels the active run and background tasks of a session and
+// returns the reply for the user.
+func (al *AgentLoop) stopSession(key string) string {
+	if al.runs.stop(key) {
+		return "Stopped."
+	}
+	return "Nothing is running."
+}
+
+// queueKey returns the key that orders processing of msg: the session the
+// message will be routed to.
+func (al *AgentLoop) queueKey(msg bus.InboundMessage) string {
+	if msg.Channel == "system" {
+		if agent := al.registry.GetDefaultAgent(); agent != nil {
+			return routing.BuildAgentMainSessionKey(agent.ID)
+		}
+		return msg.Channel
+	}
+	_, sessionKey, _ := al.resolveRoute(msg)
+	return sessionKey
+}
+
 func (al *AgentLoop) Stop() {
 	al.running.Store(false)
 }
@@ -284,18 +422,28 @@ func (al *AgentLoop) ProcessDirectWithChannel(ctx context.Context, content, sess
 }
 
 // ProcessHeartbeat processes a heartbeat request without session history.
-// Each heartbeat is independent and doesn't accumulate context.
-func (al *AgentLoop) ProcessHeartbeat(ctx context.Context, content, channel, chatID string) (string, error) {
-	agent := al.registry.GetDefaultAgent()
+// Each heartbeat is independent and doesn't accumulate context. An empty
+// agentID, or one that is not configured, runs the default agent.
+func (al *AgentLoop) ProcessHeartbeat(ctx context.Context, agentID, content, channel, chatID string) (string, error) {
+	agent, ok := al.registry.GetAgent(agentID)
+	if !ok {
+		if agentID != "" {
+			logger.WarnCF("agent", "Heartbeat agent not found, using default agent", map[string]interface{}{
+				"agent_id": agentID,
+			})
+		}
+		agent = al.registry.GetDefaultAgent()
+	}
 	return al.runAgentLoop(ctx, agent, processOptions{
 		SessionKey:      "heartbeat",
 		Channel:         channel,
 		ChatID:          chatID,
 		UserMessage:     content,
-		DefaultResponse: "I've completed processing but have no response to give.",
+		DefaultResponse: `{"status": "ok"}`,
 		EnableSummary:   false,
 		SendResponse:    false,
 		NoHistory:       true, // Don't load session history for heartbeat
+		ResponseFormat:  heartbeatFormat,
 	})
 }
 
@@ -326,6 +474,40 @@ func (al *AgentLoop) processMessage(ctx context.Context, msg bus.InboundMessage)
 	}
 
 	// Route to determine agent and session key
+	agent, sessionKey, route := al.resolveRoute(msg)
+
+	logger.InfoCF("agent", "Routed message",
+		map[string]interface{}{
+			"agent_id":    agent.ID,
+			"session_key": sessionKey,
+			"matched_by":  route.MatchedBy,
+		})
+
+	agent.Sessions.SetRetention(sessionKey, al.retentionPolicy(route))
+
+	// A conversation handed to this agent starts with the handoff summary.
+	userMessage := msg.Content
+	if h, pending := al.handoffs.deliver(route.SessionKey, route.AgentID); pending {
+		userMessage = handoffNote(h, route.AgentID) + "\n\n" + msg.Content
+	}
+	ctx = withHandoffRoute(ctx, route.SessionKey, route.AgentID)
+
+	return al.runAgentLoop(ctx, agent, processOptions{
+		SessionKey:      sessionKey,
+		Channel:         msg.Channel,
+		ChatID:          msg.ChatID,
+		UserMessage:     userMessage,
+		Media:           msg.Media,
+		DefaultResponse: "I've completed processing but have no response to give.",
+		EnableSummary:   true,
+		SendResponse:    false,
+		Stream:          true,
+		Router:          al.routerConfig(agent, route),
+	})
+}
+
+// resolveRoute picks the agent and session key for a user message.
+func (al *AgentLoop) resolveRoute(msg bus.InboundMessage) (*AgentInstance, string, routing.ResolvedRoute) {
 	route := al.registry.ResolveRoute(routing.RouteInput{
 		Channel:    msg.Channel,
 		AccountID:  msg.Metadata["account_id"],
@@ -344,24 +526,15 @@ func (al *AgentLoop) processMessage(ctx context.Context, msg bus.InboundMessage)
 	sessionKey := route.SessionKey
 	if msg.SessionKey != "" && strings.HasPrefix(msg.SessionKey, "agent:") {
 		sessionKey = msg.SessionKey
+	} else if h, ok := al.handoffs.active(route.SessionKey); ok {
+		// The conversation was handed to another agent.
+		if target, ok := al.registry.GetAgent(h.To); ok {
+			agent = target
+			sessionKey = handoffSessionKey(route.SessionKey, target.ID)
+		}
 	}
 
-	logger.InfoCF("agent", "Routed message",
-		map[string]interface{}{
-			"agent_id":    agent.ID,
-			"session_key": sessionKey,
-			"matched_by":  route.MatchedBy,
-		})
-
-	return al.runAgentLoop(ctx, agent, processOptions{
-		SessionKey:      sessionKey,
-		Channel:         msg.Channel,
-		ChatID:          msg.ChatID,
-		UserMessage:     msg.Content,
-		DefaultResponse: "I've completed processing but have no response to give.",
-		EnableSummary:   true,
-		SendResponse:    false,
-	})
+	return agent, sessionKey, route
 }
 
 func (al *AgentLoop) processSystemMessage(ctx context.Context, msg bus.InboundMessage) (string, error) {
@@ -433,8 +606,14 @@ func (al *AgentLoop) runAgentLoop(ctx context.Context, agent *AgentInstance, opt
 		}
 	}
 
-	// 1. Update tool contexts
-	al.updateToolContexts(agent, opts.Channel, opts.ChatID)
+	// Keep retention away from the history while the run uses it.
+	if !opts.NoHistory {
+		defer al.runs.use(opts.SessionKey)()
+	}
+
+	// 1. Scope tools to this run's channel and chat
+	ctx = tools.WithToolContext(ctx, opts.Channel, opts.ChatID)
+	ctx = tools.WithUsageAccount(ctx, al.usageAccount(agent, opts.SessionKey))
 
 	// 2. Build messages (skip history for heartbeat)
 	var history []providers.Message
@@ -447,13 +626,14 @@ func (al *AgentLoop) runAgentLoop(ctx context.Context, agent *AgentInstance, opt
 		history,
 		summary,
 		opts.UserMessage,
-		nil,
+		opts.Media,
 		opts.Channel,
 		opts.ChatID,
 	)
 
 	// 3. Save user message to session
-	agent.Sessions.AddMessage(opts.SessionKey, "user", opts.UserMessage)
+	agent.Sessions.AddFullMessage(opts.SessionKey, providers.Message{Role: "user", Content: opts.UserMessage, Media: opts.Media})
+	agent.Sessions.AddMedia(opts.SessionKey, localMedia(opts.Media))
 
 	// 4. Run LLM iteration loop
 	finalContent, iteration, err := al.runLLMIteration(ctx, agent, messages, opts)
@@ -504,10 +684,38 @@ func (al *AgentLoop) runAgentLoop(ctx context.Context, agent *AgentInstance, opt
 func (al *AgentLoop) runLLMIteration(ctx context.Context, agent *AgentInstance, messages []providers.Message, opts processOptions) (string, int, error) {
 	iteration := 0
 	var finalContent string
+	// A final reply that does not match opts.ResponseFormat is sent back
+	// once for the model to correct.
+	repaired := false
+
+	var streamer *replyStreamer
+	if al.canStream(opts) {
+		streamer = newReplyStreamer(al.bus, opts.Channel, opts.ChatID)
+	}
+
+	// The turn runs on the model the agent has as it starts, even if
+	// /switch model changes it meanwhile.
+	ms := agent.modelState()
+
+	// The router picks the model for the turn unless one was asked for.
+	var route *routeDecision
+	if opts.Router != nil && opts.Model == "" {
+		d := al.routeTurn(ctx, agent, opts)
+		route = &d
+	}
 
 	for iteration < agent.MaxIterations {
 		iteration++
 
+		if err := ctx.Err(); err != nil {
+			return "", iteration, err
+		}
+
+		// Messages the user sent while tools were running steer this turn.
+		if iteration > 1 {
+			messages = al.injectSteering(ctx, agent, messages, opts)
+		}
+
 		logger.DebugCF("agent", "LLM iteration",
 			map[string]interface{}{
 				"agent_id":  agent.ID,
@@ -518,16 +726,18 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, agent *AgentInstance,
 		// Build tool definitions
 		providerToolDefs := agent.Tools.ToProviderDefs()
 
+		// Drop the oldest history turns if the request would overflow the window
+		messages = al.fitContext(agent, messages, providerToolDefs)
+
 		// Log LLM request details
 		logger.DebugCF("agent", "LLM request",
 			map[string]interface{}{
 				"agent_id":          agent.ID,
 				"iteration":         iteration,
-				"model":             agent.Model,
+				"model":             ms.Model,
 				"messages_count":    len(messages),
 				"tools_count":       len(providerToolDefs),
-				"max_tokens":        8192,
-				"temperature":       0.7,
+				"generation":        generationOptions(agent.Generation),
 				"system_prompt_len": len(messages[0].Content),
 			})
 
@@ -539,18 +749,83 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, agent *AgentInstance,
 				"tools_json":    formatToolsForLog(providerToolDefs),
 			})
 
+		// Enforce the agent's budget before spending more.
+		downgradeModel, err := al.budgetModel(agent)
+		if err != nil {
+			return "", iteration, err
+		}
+
 		// Call LLM with fallback chain if candidates are configured.
 		var response *providers.LLMResponse
-		var err error
+		usedModel := ms.Model
+
+		chat := func(ctx context.Context, provider, model string) (*providers.LLMResponse, error) {
+			llmOpts := generationOptions(agent.Generation)
+			if opts.ResponseFormat != nil {
+				llmOpts[providers.ResponseFormatOption] = opts.ResponseFormat
+			}
+			llm := agent.providerFor(provider)
+			if streamer != nil {
+				if sp, ok := llm.(providers.StreamingProvider); ok {
+					return sp.ChatStream(ctx, messages, providerToolDefs, model, llmOpts, streamer.onDelta)
+				}
+			}
+			return llm.Chat(ctx, messages, providerToolDefs, model, llmOpts)
+		}
 
 		callLLM := func() (*providers.LLMResponse, error) {
-			if len(agent.Candidates) > 1 && al.fallback != nil {
-				fbResult, fbErr := al.fallback.Execute(ctx, agent.Candidates,
+			if streamer != nil {
+				streamer.reset()
+			}
+			if downgradeModel != "" {
+				provider, model := agent.modelTarget(downgradeModel)
+				usedModel = model
+				return chat(ctx, provider, model)
+			}
+			if opts.Model != "" {
+				provider, model := agent.modelTarget(opts.Model)
+				usedModel = model
+				return chat(ctx, provider, model)
+			}
+			// Turns carrying images go to the image model when one is configured.
+			if hasImages(messages) && len(agent.ImageCandidates) > 0 && al.fallback != nil {
+				fbResult, fbErr := al.fallback.ExecuteImage(ctx, agent.ImageCandidates,
 					func(ctx context.Context, provider, model string) (*providers.LLMResponse, error) {
-						return agent.Provider.Chat(ctx, messages, providerToolDefs, model, map[string]interface{}{
-							"max_tokens":  8192,
-							"temperature": 0.7,
-						})
+						if streamer != nil {
+							streamer.reset()
+						}
+						return chat(ctx, provider, model)
+					},
+				)
+				if fbErr != nil {
+					return nil, fbErr
+				}
+				logger.InfoCF("agent", fmt.Sprintf("Image request handled by %s/%s", fbResult.Provider, fbResult.Model),
+					map[string]interface{}{"agent_id": agent.ID, "iteration": iteration})
+				usedModel = fbResult.Model
+				return fbResult.Response, nil
+			}
+			if route != nil && route.Model != "" {
+				provider, model := agent.modelTarget(route.Model)
+				resp, err := chat(ctx, provider, model)
+				if err == nil || ctx.Err() != nil {
+					usedModel = model
+					return resp, err
+				}
+				logger.WarnCF("agent", "Simple model failed, using the agent's model",
+					map[string]interface{}{"agent_id": agent.ID, "model": route.Model, "error": err.Error()})
+				al.escalate(agent, opts.SessionKey, route, "simple model failed")
+				if streamer != nil {
+					streamer.reset()
+				}
+			}
+			if len(ms.Candidates) > 1 && al.fallback != nil {
+				fbResult, fbErr := al.fallback.Execute(ctx, ms.Candidates,
+					func(ctx context.Context, provider, model string) (*providers.LLMResponse, error) {
+						if streamer != nil {
+							streamer.reset()
+						}
+						return chat(ctx, provider, model)
 					},
 				)
 				if fbErr != nil {
@@ -561,12 +836,12 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, agent *AgentInstance,
 						fbResult.Provider, fbResult.Model, len(fbResult.Attempts)+1),
 						map[string]interface{}{"agent_id": agent.ID, "iteration": iteration})
 				}
+				usedModel = fbResult.Model
 				return fbResult.Response, nil
 			}
-			return agent.Provider.Chat(ctx, messages, providerToolDefs, agent.Model, map[string]interface{}{
-				"max_tokens":  8192,
-				"temperature": 0.7,
-			})
+			provider, model := agent.targetOf(ms)
+			usedModel = model
+			return chat(ctx, provider, model)
 		}
 
 		// Retry loop for context/token errors
@@ -577,13 +852,7 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, agent *AgentInstance,
 				break
 			}
 
-			errMsg := strings.ToLower(err.Error())
-			isContextError := strings.Contains(errMsg, "token") ||
-				strings.Contains(errMsg, "context") ||
-				strings.Contains(errMsg, "invalidparameter") ||
-				strings.Contains(errMsg, "length")
-
-			if isContextError && retry < maxRetries {
+			if errors.Is(err, providers.ErrContextLength) && retry < maxRetries {
 				logger.WarnCF("agent", "Context window error detected, attempting compression", map[string]interface{}{
 					"error": err.Error(),
 					"retry": retry,
@@ -600,9 +869,9 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, agent *AgentInstance,
 				al.forceCompression(agent, opts.SessionKey)
 				newHistory := agent.Sessions.GetHistory(opts.SessionKey)
 				newSummary := agent.Sessions.GetSummary(opts.SessionKey)
-				messages = agent.ContextBuilder.BuildMessages(
-					newHistory, newSummary, "",
-					nil, opts.Channel, opts.ChatID,
+				messages = agent.ContextBuilder.RebuildMessages(
+					newHistory, newSummary,
+					opts.Media, opts.Channel, opts.ChatID,
 				)
 				continue
 			}
@@ -618,9 +887,21 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, agent *AgentInstance,
 				})
 			return "", iteration, fmt.Errorf("LLM call failed after retries: %w", err)
 		}
+		al.recordUsage(agent, opts.SessionKey, usedModel, response)
 
 		// Check if no tool calls - we're done
 		if len(response.ToolCalls) == 0 {
+			if next := providers.RepairStructured(opts.ResponseFormat, messages, response.Content, repaired); next != nil {
+				logger.WarnCF("agent", "Reply does not match the response format, asking again",
+					map[string]interface{}{
+						"agent_id":  agent.ID,
+						"format":    opts.ResponseFormat.SchemaName(),
+						"iteration": iteration,
+					})
+				messages = next
+				repaired = true
+				continue
+			}
 			finalContent = response.Content
 			logger.InfoCF("agent", "LLM response without tool calls (direct answer)",
 				map[string]interface{}{
@@ -631,6 +912,11 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, agent *AgentInstance,
 			break
 		}
 
+		// Tool-heavy turns continue on the agent's model.
+		if route != nil && route.Model != "" {
+			al.escalate(agent, opts.SessionKey, route, "tool calls")
+		}
+
 		// Log tool calls
 		toolNames := make([]string, 0, len(response.ToolCalls))
 		for _, tc := range response.ToolCalls {
@@ -658,6 +944,7 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, agent *AgentInstance,
 					Name:      tc.Name,
 					Arguments: string(argumentsJSON),
 				},
+				ThoughtSignature: tc.ThoughtSignature,
 			})
 		}
 		messages = append(messages, assistantMsg)
@@ -675,12 +962,14 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, agent *AgentInstance,
 					"tool":      tc.Name,
 					"iteration": iteration,
 				})
+		}
 
-			// Create async callback for tools that implement AsyncTool
-			// NOTE: Following openclaw's design, async tools do NOT send results directly to users.
-			// Instead, they notify the agent via PublishInbound, and the agent decides
-			// whether to forward the result to the user (in processSystemMessage).
-			asyncCallback := func(callbackCtx context.Context, result *tools.ToolResult) {
+		// Create async callback for tools that implement AsyncTool
+		// NOTE: Following openclaw's design, async tools do NOT send results directly to users.
+		// Instead, they notify the agent via PublishInbound, and the agent decides
+		// whether to forward the result to the user (in processSystemMessage).
+		asyncCallbackFor := func(tc providers.ToolCall) tools.AsyncCallback {
+			return func(callbackCtx context.Context, result *tools.ToolResult) {
 				// Log the async completion but don't send directly to user
 				// The agent will handle user notification via processSystemMessage
 				if !result.Silent && result.ForUser != "" {
@@ -691,8 +980,14 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, agent *AgentInstance,
 						})
 				}
 			}
+		}
 
-			toolResult := agent.Tools.ExecuteWithContext(ctx, tc.Name, tc.Arguments, opts.Channel, opts.ChatID, asyncCallback)
+		toolResults := agent.Tools.ExecuteToolCalls(ctx, response.ToolCalls, opts.Channel, opts.ChatID,
+			al.cfg.Agents.Defaults.ParallelToolCalls, asyncCallbackFor)
+
+		// Results come back in call order, so the transcript stays valid
+		for i, tc := range response.ToolCalls {
+			toolResult := toolResults[i]
 
 			// Send ForUser content to user immediately if not Silent
 			if !toolResult.Silent && toolResult.ForUser != "" && opts.SendResponse {
@@ -729,30 +1024,26 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, agent *AgentInstance,
 	return finalContent, iteration, nil
 }
 
-// updateToolContexts updates the context for tools that need channel/chatID info.
-func (al *AgentLoop) updateToolContexts(agent *AgentInstance, channel, chatID string) {
-	// Use ContextualTool interface instead of type assertions
-	if tool, ok := agent.Tools.Get("message"); ok {
-		if mt, ok := tool.(tools.ContextualTool); ok {
-			mt.SetContext(channel, chatID)
-		}
+// canStream reports whether partial replies should be published for this run.
+func (al *AgentLoop) canStream(opts processOptions) bool {
+	if !opts.Stream || !al.cfg.Agents.Defaults.Streaming || al.channelManager == nil {
+		return false
 	}
-	if tool, ok := agent.Tools.Get("spawn"); ok {
-		if st, ok := tool.(tools.ContextualTool); ok {
-			st.SetContext(channel, chatID)
-		}
+	if constants.IsInternalChannel(opts.Channel) {
+		return false
 	}
-	if tool, ok := agent.Tools.Get("subagent"); ok {
-		if st, ok := tool.(tools.ContextualTool); ok {
-			st.SetContext(channel, chatID)
-		}
+	// A structured reply may need repairing before it can be shown, so its
+	// first attempt must not reach the user as it is generated.
+	if opts.ResponseFormat != nil {
+		return false
 	}
+	return al.channelManager.SupportsStreaming(opts.Channel)
 }
 
 // maybeSummarize triggers summarization if the session history exceeds thresholds.
 func (al *AgentLoop) maybeSummarize(agent *AgentInstance, sessionKey, channel, chatID string) {
 	newHistory := agent.Sessions.GetHistory(sessionKey)
-	tokenEstimate := al.estimateTokens(newHistory)
+	tokenEstimate := countMessageTokens(agent.modelState().Tokenizer, newHistory)
 	threshold := agent.ContextWindow * 75 / 100
 
 	if len(newHistory) > 20 || tokenEstimate > threshold {
@@ -789,8 +1080,12 @@ func (al *AgentLoop) forceCompression(agent *AgentInstance, sessionKey string) {
 		return
 	}
 
-	// Helper to find the mid-point of the conversation
-	mid := len(conversation) / 2
+	// Cut at the first turn after the mid-point so that an assistant tool
+	// call is never separated from its tool results.
+	mid := nextTurn(conversation, len(conversation)/2)
+	if mid == len(conversation) {
+		return
+	}
 
 	// New history structure:
 	// 1. System Prompt
@@ -833,6 +1128,54 @@ func (al *AgentLoop) forceCompression(agent *AgentInstance, sessionKey string) {
 	})
 }
 
+// RetentionTargets returns the sessions of every agent, for the retention
+// service.
+func (al *AgentLoop) RetentionTargets() []retention.Target {
+	var targets []retention.Target
+	for _, id := range al.registry.ListAgentIDs() {
+		if agent, ok := al.registry.GetAgent(id); ok {
+			targets = append(targets, retention.Target{
+				AgentID:  id,
+				Sessions: agent.Sessions,
+				Busy: func(key string) bool {
+					_, summarizing := al.summarizing.Load(agent.ID + ":" + key)
+					return summarizing || al.runs.busy(key)
+				},
+				Summarize: func(key string) bool {
+					return al.summarizeForRetention(agent, key)
+				},
+			})
+		}
+	}
+	return targets
+}
+
+// summarizeForRetention summarizes a session before retention trims it and
+// reports whether its history got shorter.
+func (al *AgentLoop) summarizeForRetention(agent *AgentInstance, sessionKey string) bool {
+	summarizeKey := agent.ID + ":" + sessionKey
+	if _, loading := al.summarizing.LoadOrStore(summarizeKey, true); loading {
+		return false
+	}
+	defer al.summarizing.Delete(summarizeKey)
+
+	before := len(agent.Sessions.GetHistory(sessionKey))
+	al.summarizeSession(agent, sessionKey)
+	return len(agent.Sessions.GetHistory(sessionKey)) < before
+}
+
+// retentionPolicy returns the retention policy of the binding or channel a
+// message was routed by, or nil for the default policy.
+func (al *AgentLoop) retentionPolicy(route routing.ResolvedRoute) *config.RetentionPolicy {
+	if route.Binding != nil && route.Binding.Retention != nil {
+		return route.Binding.Retention
+	}
+	if policy, ok := al.cfg.Session.Retention.Channels[route.Channel]; ok {
+		return &policy
+	}
+	return nil
+}
+
 // GetStartupInfo returns information about loaded tools and skills for logging.
 func (al *AgentLoop) GetStartupInfo() map[string]interface{} {
 	info := make(map[string]interface{})
@@ -929,6 +1272,7 @@ func (al *AgentLoop) summarizeSession(agent *AgentInstance, sessionKey string) {
 
 	// Oversized Message Guard
 	maxMessageTokens := agent.ContextWindow / 2
+	tk := agent.modelState().Tokenizer
 	validMessages := make([]providers.Message, 0)
 	omitted := false
 
@@ -936,8 +1280,7 @@ func (al *AgentLoop) summarizeSession(agent *AgentInstance, sessionKey string) {
 		if m.Role != "user" && m.Role != "assistant" {
 			continue
 		}
-		msgTokens := len(m.Content) / 2
-		if msgTokens > maxMessageTokens {
+		if countTokens(tk, m) > maxMessageTokens {
 			omitted = true
 			continue
 		}
@@ -955,21 +1298,17 @@ func (al *AgentLoop) summarizeSession(agent *AgentInstance, sessionKey string) {
 		part1 := validMessages[:mid]
 		part2 := validMessages[mid:]
 
-		s1, _ := al.summarizeBatch(ctx, agent, part1, "")
-		s2, _ := al.summarizeBatch(ctx, agent, part2, "")
+		s1, _ := al.summarizeBatch(ctx, agent, sessionKey, part1, "")
+		s2, _ := al.summarizeBatch(ctx, agent, sessionKey, part2, "")
 
 		mergePrompt := fmt.Sprintf("Merge these two conversation summaries into one cohesive summary:\n\n1: %s\n\n2: %s", s1, s2)
-		resp, err := agent.Provider.Chat(ctx, []providers.Message{{Role: "user", Content: mergePrompt}}, nil, agent.Model, map[string]interface{}{
-			"max_tokens":  1024,
-			"temperature": 0.3,
-		})
-		if err == nil {
-			finalSummary = resp.Content
+		if merged, err := al.summarize(ctx, agent, sessionKey, mergePrompt); err == nil {
+			finalSummary = merged
 		} else {
 			finalSummary = s1 + " " + s2
 		}
 	} else {
-		finalSummary, _ = al.summarizeBatch(ctx, agent, validMessages, summary)
+		finalSummary, _ = al.summarizeBatch(ctx, agent, sessionKey, validMessages, summary)
 	}
 
 	if omitted && finalSummary != "" {
@@ -984,7 +1323,7 @@ func (al *AgentLoop) summarizeSession(agent *AgentInstance, sessionKey string) {
 }
 
 // summarizeBatch summarizes a batch of messages.
-func (al *AgentLoop) summarizeBatch(ctx context.Context, agent *AgentInstance, batch []providers.Message, existingSummary string) (string, error) {
+func (al *AgentLoop) summarizeBatch(ctx context.Context, agent *AgentInstance, sessionKey string, batch []providers.Message, existingSummary string) (string, error) {
 	prompt := "Provide a concise summary of this conversation segment, preserving core context and key points.\n"
 	if existingSummary != "" {
 		prompt += "Existing context: " + existingSummary + "\n"
@@ -994,28 +1333,61 @@ func (al *AgentLoop) summarizeBatch(ctx context.Context, agent *AgentInstance, b
 		prompt += fmt.Sprintf("%s: %s\n", m.Role, m.Content)
 	}
 
-	response, err := agent.Provider.Chat(ctx, []providers.Message{{Role: "user", Content: prompt}}, nil, agent.Model, map[string]interface{}{
-		"max_tokens":  1024,
-		"temperature": 0.3,
-	})
+	return al.summarize(ctx, agent, sessionKey, prompt)
+}
+
+// summaryFormat is the response format of summary calls.
+var summaryFormat = &providers.ResponseFormat{
+	Name: "summary",
+	Schema: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"summary": map[string]interface{}{"type": "string", "minLength": 1},
+		},
+		"required":             []string{"summary"},
+		"additionalProperties": false,
+	},
+}
+
+// heartbeatFormat is the response format of heartbeat replies.
+var heartbeatFormat = &providers.ResponseFormat{
+	Name:   "heartbeat_result",
+	Schema: heartbeat.ResultSchema,
+}
+
+// summarize runs a summary prompt with the agent's model. The summary is
+// requested as structured output, so an empty or truncated one is caught;
+// providers that reject the format are asked again for plain text.
+func (al *AgentLoop) summarize(ctx context.Context, agent *AgentInstance, sessionKey, prompt string) (string, error) {
+	provider, model := agent.primaryTarget()
+	llm := agent.providerFor(provider)
+	messages := []providers.Message{{Role: "user", Content: prompt}}
+	opts := generationOptions(agent.SummaryGeneration)
+
+	response, err := providers.ChatStructured(ctx, llm, messages, model, opts, summaryFormat)
+	al.recordUsage(agent, sessionKey, model, response)
+	if err == nil {
+		var out struct {
+			Summary string `json:"summary"`
+		}
+		if err = json.Unmarshal([]byte(response.Content), &out); err == nil {
+			return out.Summary, nil
+		}
+	}
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	logger.WarnCF("agent", "Structured summary failed, asking for plain text",
+		map[string]interface{}{"agent_id": agent.ID, "error": err.Error()})
+
+	response, err = llm.Chat(ctx, messages, nil, model, opts)
 	if err != nil {
 		return "", err
 	}
+	al.recordUsage(agent, sessionKey, model, response)
 	return response.Content, nil
 }
 
-// estimateTokens estimates the number of tokens in a message list.
-// Uses a safe heuristic of 2.5 characters per token to account for CJK and other
-// overheads better than the previous 3 chars/token.
-func (al *AgentLoop) estimateTokens(messages []providers.Message) int {
-	totalChars := 0
-	for _, m := range messages {
-		totalChars += utf8.RuneCountInString(m.Content)
-	}
-	// 2.5 chars per token = totalChars * 2 / 5
-	return totalChars * 2 / 5
-}
-
 func (al *AgentLoop) handleCommand(ctx context.Context, msg bus.InboundMessage) (string, bool) {
 	content := strings.TrimSpace(msg.Content)
 	if !strings.HasPrefix(content, "/") {
@@ -1037,11 +1409,11 @@ func (al *AgentLoop) handleCommand(ctx context.Context, msg bus.InboundMessage)
 		}
 		switch args[0] {
 		case "model":
-			defaultAgent := al.registry.GetDefaultAgent()
-			if defaultAgent == nil {
+			agent, sessionKey, route := al.resolveRoute(msg)
+			if agent == nil {
 				return "No default agent configured", true
 			}
-			return fmt.Sprintf("Current model: %s", defaultAgent.Model), true
+			return al.showModel(agent, sessionKey, route), true
 		case "channel":
 			return fmt.Sprintf("Current channel: %s", msg.Channel), true
 		case "agents":
@@ -1057,7 +1429,7 @@ func (al *AgentLoop) handleCommand(ctx context.Context, msg bus.InboundMessage)
 		}
 		switch args[0] {
 		case "models":
-			return "Available models: configured in config.json per agent", true
+			return al.listModels(ctx), true
 		case "channels":
 			if al.channelManager == nil {
 				return "Channel manager not initialized", true
@@ -1074,6 +1446,64 @@ func (al *AgentLoop) handleCommand(ctx context.Context, msg bus.InboundMessage)
 			return fmt.Sprintf("Unknown list target: %s", args[0]), true
 		}
 
+	case "/stop":
+		_, sessionKey, _ := al.resolveRoute(msg)
+		return al.stopSession(sessionKey), true
+
+	case "/usage":
+		agent, sessionKey, _ := al.resolveRoute(msg)
+		if agent == nil {
+			return "No agent available", true
+		}
+		return al.usageReport(agent, sessionKey), true
+
+	case "/new", "/sessions", "/summary", "/resume":
+		agent, sessionKey, _ := al.resolveRoute(msg)
+		if agent == nil {
+			return "No agent available", true
+		}
+		switch cmd {
+		case "/new":
+			return al.newSession(agent, sessionKey), true
+		case "/sessions":
+			return al.listSessions(agent, sessionKey), true
+		case "/summary":
+			return al.sessionSummary(agent, sessionKey), true
+		default:
+			if len(args) != 1 {
+				return "Usage: /resume <number or id>", true
+			}
+			return al.resumeSession(agent, sessionKey, args[0]), true
+		}
+
+	case "/undo", "/retry", "/branch":
+		agent, sessionKey, _ := al.resolveRoute(msg)
+		if agent == nil {
+			return "No agent available", true
+		}
+		switch cmd {
+		case "/undo":
+			return al.undoTurn(agent, sessionKey), true
+		case "/retry":
+			if len(args) > 1 {
+				return "Usage: /retry [model]", true
+			}
+			model := ""
+			if len(args) == 1 {
+				model = args[0]
+			}
+			return al.retryTurn(ctx, agent, sessionKey, msg, model), true
+		default:
+			if len(args) > 1 {
+				return "Usage: /branch [name]", true
+			}
+			name := ""
+			if len(args) == 1 {
+				name = args[0]
+			}
+			return al.branchSession(agent, sessionKey, name), true
+		}
+
 	case "/switch":
 		if len(args) < 3 || args[1] != "to" {
 			return "Usage: /switch [model|channel] to <name>", true
@@ -1087,8 +1517,8 @@ func (al *AgentLoop) handleCommand(ctx context.Context, msg bus.InboundMessage)
 			if defaultAgent == nil {
 				return "No default agent configured", true
 			}
-			oldModel := defaultAgent.Model
-			defaultAgent.Model = value
+			oldModel := defaultAgent.modelState().Model
+			defaultAgent.SetModel(value)
 			return fmt.Sprintf("Switched model from %s to %s", oldModel, value), true
 		case "channel":
 			if al.channelManager == nil {
@@ -5,13 +5,16 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/channels"
 	"github.com/sipeed/picoclaw/pkg/config"
 	"github.com/sipeed/picoclaw/pkg/providers"
 	"github.com/sipeed/picoclaw/pkg/tools"
+	"github.com/sipeed/picoclaw/pkg/usage"
 )
 
 // mockProvider is a simple mock LLM provider for testing
@@ -535,10 +538,12 @@ type failFirstMockProvider struct {
 	currentCall int
 	failError   error
 	successResp string
+	lastRequest []providers.Message
 }
 
 func (m *failFirstMockProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
 	m.currentCall++
+	m.lastRequest = messages
 	if m.currentCall <= m.failures {
 		return nil, m.failError
 	}
@@ -574,7 +579,7 @@ func TestAgentLoop_ContextExhaustionRetry(t *testing.T) {
 	msgBus := bus.NewMessageBus()
 
 	// Create a provider that fails once with a context error
-	contextErr := fmt.Errorf("InvalidParameter: Total tokens of image and text exceed max message tokens")
+	contextErr := fmt.Errorf("InvalidParameter: Total tokens of image and text exceed max message tokens: %w", providers.ErrContextLength)
 	provider := &failFirstMockProvider{
 		failures:    1,
 		failError:   contextErr,
@@ -617,6 +622,12 @@ func TestAgentLoop_ContextExhaustionRetry(t *testing.T) {
 		t.Errorf("Expected 2 calls (1 fail + 1 success), got %d", provider.currentCall)
 	}
 
+	// The retry carries the turn once, as the last user message from history
+	last := provider.lastRequest[len(provider.lastRequest)-1]
+	if last.Role != "user" || !strings.HasSuffix(last.Content, "Trigger message") || !strings.Contains(last.Content, "<context>") {
+		t.Errorf("Expected the retry to end with the turn and its context, got %q", last.Content)
+	}
+
 	// Check final history length
 	finalHistory := defaultAgent.Sessions.GetHistory(sessionKey)
 	// We verify that the history has been modified (compressed)
@@ -628,3 +639,605 @@ func TestAgentLoop_ContextExhaustionRetry(t *testing.T) {
 		t.Errorf("Expected history to be compressed (len < 8), got %d", len(finalHistory))
 	}
 }
+
+// TestAgentLoop_OtherErrorsAreNotRetried verifies that an error merely
+// mentioning tokens does not trigger the context compression retry.
+func TestAgentLoop_OtherErrorsAreNotRetried(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+	}
+	provider := &failFirstMockProvider{
+		failures:    1,
+		failError:   fmt.Errorf("invalid API token length"),
+		successResp: "unreachable",
+	}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+
+	_, err := al.ProcessDirectWithChannel(context.Background(), "hello", "test-session-token", "test", "test-chat")
+	if err == nil {
+		t.Fatal("Expected the provider error to be returned")
+	}
+	if provider.currentCall != 1 {
+		t.Errorf("Expected 1 call without a retry, got %d", provider.currentCall)
+	}
+}
+
+// streamingMockProvider emits its response as deltas through ChatStream
+type streamingMockProvider struct {
+	deltas []string
+}
+
+func (m *streamingMockProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	return m.ChatStream(ctx, messages, tools, model, opts, nil)
+}
+
+func (m *streamingMockProvider) ChatStream(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}, onDelta func(string)) (*providers.LLMResponse, error) {
+	content := ""
+	for _, d := range m.deltas {
+		content += d
+		if onDelta != nil {
+			onDelta(d)
+		}
+	}
+	return &providers.LLMResponse{Content: content}, nil
+}
+
+func (m *streamingMockProvider) GetDefaultModel() string {
+	return "mock-stream-model"
+}
+
+// fakeStreamingChannel is a channel that accepts partial replies
+type fakeStreamingChannel struct{}
+
+func (c *fakeStreamingChannel) Name() string                    { return "fake" }
+func (c *fakeStreamingChannel) Start(ctx context.Context) error { return nil }
+func (c *fakeStreamingChannel) Stop(ctx context.Context) error  { return nil }
+func (c *fakeStreamingChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	return nil
+}
+func (c *fakeStreamingChannel) SendPartial(ctx context.Context, msg bus.OutboundMessage) error {
+	return nil
+}
+func (c *fakeStreamingChannel) EndStream(ctx context.Context, chatID string) error {
+	return nil
+}
+func (c *fakeStreamingChannel) IsRunning() bool                { return true }
+func (c *fakeStreamingChannel) IsAllowed(senderID string) bool { return true }
+
+func TestAgentLoop_StreamsPartialReplies(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "agent-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         tmpDir,
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+				Streaming:         true,
+			},
+		},
+	}
+
+	msgBus := bus.NewMessageBus()
+	provider := &streamingMockProvider{deltas: []string{"Hello", ", world"}}
+	al := NewAgentLoop(cfg, msgBus, provider)
+
+	cm, err := channels.NewManager(cfg, msgBus)
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	cm.RegisterChannel("fake", &fakeStreamingChannel{})
+	al.SetChannelManager(cm)
+
+	response := testHelper{al: al}.executeAndGetResponse(t, context.Background(), bus.InboundMessage{
+		Channel:  "fake",
+		SenderID: "user1",
+		ChatID:   "chat1",
+		Content:  "hi",
+	})
+	if response != "Hello, world" {
+		t.Fatalf("Expected 'Hello, world', got: %s", response)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	out, ok := msgBus.SubscribeOutbound(ctx)
+	if !ok {
+		t.Fatal("Expected a partial outbound message")
+	}
+	if !out.Partial || out.Channel != "fake" || out.ChatID != "chat1" {
+		t.Errorf("Unexpected outbound message: %+v", out)
+	}
+	if out.Content != "Hello" {
+		t.Errorf("Expected first partial 'Hello', got: %s", out.Content)
+	}
+}
+
+func TestAgentLoop_StructuredRepliesAreNotStreamed(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+				Streaming:         true,
+			},
+		},
+	}
+	msgBus := bus.NewMessageBus()
+	al := NewAgentLoop(cfg, msgBus, &streamingMockProvider{})
+	cm, err := channels.NewManager(cfg, msgBus)
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	cm.RegisterChannel("fake", &fakeStreamingChannel{})
+	al.SetChannelManager(cm)
+
+	opts := processOptions{Channel: "fake", ChatID: "chat1", Stream: true}
+	if !al.canStream(opts) {
+		t.Fatal("Expected free text replies to stream")
+	}
+	opts.ResponseFormat = heartbeatFormat
+	if al.canStream(opts) {
+		t.Fatal("Expected structured replies not to stream before they are validated")
+	}
+}
+
+func TestAgentLoop_RunEndsStreamWithoutReply(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+				Streaming:         true,
+			},
+		},
+	}
+
+	msgBus := bus.NewMessageBus()
+	provider := &failFirstMockProvider{failures: 1, failError: fmt.Errorf("boom")}
+	al := NewAgentLoop(cfg, msgBus, provider)
+
+	msg := bus.InboundMessage{Channel: "fake", SenderID: "user1", ChatID: "chat1", Content: "hi"}
+	al.handleInbound(context.Background(), al.queueKey(msg), msg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var last bus.OutboundMessage
+	for {
+		out, ok := msgBus.SubscribeOutbound(ctx)
+		if !ok {
+			break
+		}
+		last = out
+		if out.End {
+			break
+		}
+	}
+	if !last.End || last.Channel != "fake" || last.ChatID != "chat1" || last.Content != "" {
+		t.Errorf("Expected the run to end with an empty End message for the chat, got %+v", last)
+	}
+}
+
+func TestAgentLoop_NoPartialRepliesWhenStreamingDisabled(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "agent-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         tmpDir,
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+	}
+
+	msgBus := bus.NewMessageBus()
+	provider := &streamingMockProvider{deltas: []string{"Hello"}}
+	al := NewAgentLoop(cfg, msgBus, provider)
+
+	cm, err := channels.NewManager(cfg, msgBus)
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	cm.RegisterChannel("fake", &fakeStreamingChannel{})
+	al.SetChannelManager(cm)
+
+	testHelper{al: al}.executeAndGetResponse(t, context.Background(), bus.InboundMessage{
+		Channel:  "fake",
+		SenderID: "user1",
+		ChatID:   "chat1",
+		Content:  "hi",
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if out, ok := msgBus.SubscribeOutbound(ctx); ok {
+		t.Errorf("Expected no outbound message, got: %+v", out)
+	}
+}
+
+// recordingMockProvider remembers the model and messages of the last call
+type recordingMockProvider struct {
+	model    string
+	messages []providers.Message
+}
+
+func (m *recordingMockProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	m.model = model
+	m.messages = messages
+	return &providers.LLMResponse{Content: "It is a picture."}, nil
+}
+
+func (m *recordingMockProvider) GetDefaultModel() string {
+	return "mock-recording-model"
+}
+
+func TestAgentLoop_ImagesUseImageModel(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "agent-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Minimal PNG header is enough for content sniffing.
+	imagePath := filepath.Join(tmpDir, "photo.png")
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	if err := os.WriteFile(imagePath, png, 0644); err != nil {
+		t.Fatalf("Failed to write image: %v", err)
+	}
+
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         tmpDir,
+				Model:             "text-model",
+				ImageModel:        "vision-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+	}
+
+	msgBus := bus.NewMessageBus()
+	provider := &recordingMockProvider{}
+	al := NewAgentLoop(cfg, msgBus, provider)
+
+	response := testHelper{al: al}.executeAndGetResponse(t, context.Background(), bus.InboundMessage{
+		Channel:  "telegram",
+		SenderID: "user1",
+		ChatID:   "chat1",
+		Content:  "what is this? [image: photo]",
+		Media:    []string{imagePath},
+	})
+	if response != "It is a picture." {
+		t.Fatalf("Unexpected response: %s", response)
+	}
+	if provider.model != "vision-model" {
+		t.Errorf("Expected image model to be used, got %q", provider.model)
+	}
+
+	last := provider.messages[len(provider.messages)-1]
+	if !last.HasImages() {
+		t.Fatal("Expected the user message to carry an image part")
+	}
+	for _, p := range last.Parts {
+		if p.Type == providers.ContentPartImage && p.MediaType != "image/png" {
+			t.Errorf("Expected image/png, got %q", p.MediaType)
+		}
+	}
+
+	// Without media the regular model is used.
+	testHelper{al: al}.executeAndGetResponse(t, context.Background(), bus.InboundMessage{
+		Channel:  "telegram",
+		SenderID: "user1",
+		ChatID:   "chat1",
+		Content:  "thanks",
+	})
+	if provider.model != "text-model" {
+		t.Errorf("Expected text model without images, got %q", provider.model)
+	}
+}
+
+// usageMockProvider reports fixed token usage and remembers the models used
+type usageMockProvider struct {
+	models []string
+}
+
+func (m *usageMockProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	m.models = append(m.models, model)
+	return &providers.LLMResponse{
+		Content: "ok",
+		Usage:   &providers.UsageInfo{PromptTokens: 1_000_000, CompletionTokens: 0, TotalTokens: 1_000_000},
+	}, nil
+}
+
+func (m *usageMockProvider) GetDefaultModel() string {
+	return "mock-usage-model"
+}
+
+func newBudgetTestLoop(t *testing.T, budget *config.BudgetConfig) (*AgentLoop, *usageMockProvider) {
+	t.Helper()
+	provider := &usageMockProvider{}
+	return newUsageTestLoop(t, budget, provider), provider
+}
+
+func newUsageTestLoop(t *testing.T, budget *config.BudgetConfig, provider providers.LLMProvider) *AgentLoop {
+	t.Helper()
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "pricey-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+				Budget:            budget,
+			},
+		},
+		Usage: config.UsageConfig{
+			Prices: map[string]config.ModelPrice{"pricey-model": {Input: 1}},
+		},
+	}
+	return NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+}
+
+func TestAgentLoop_BudgetRefusesOnceSpent(t *testing.T) {
+	al, _ := newBudgetTestLoop(t, &config.BudgetConfig{DailyUSD: 0.5})
+	msg := bus.InboundMessage{Channel: "telegram", SenderID: "user1", ChatID: "chat1", Content: "hello"}
+
+	testHelper{al: al}.executeAndGetResponse(t, context.Background(), msg)
+
+	_, err := al.processMessage(context.Background(), msg)
+	if err == nil || !strings.Contains(err.Error(), "budget exceeded") {
+		t.Fatalf("Expected budget exceeded error, got %v", err)
+	}
+
+	report, handled := al.handleCommand(context.Background(), bus.InboundMessage{Channel: "telegram", SenderID: "user1", ChatID: "chat1", Content: "/usage"})
+	if !handled {
+		t.Fatal("Expected /usage to be handled")
+	}
+	if !strings.Contains(report, "Today: 1 calls") || !strings.Contains(report, "$1.0000") {
+		t.Errorf("Unexpected usage report: %s", report)
+	}
+}
+
+func TestAgentLoop_BudgetDowngradesModel(t *testing.T) {
+	al, provider := newBudgetTestLoop(t, &config.BudgetConfig{
+		DailyUSD:       0.5,
+		OnExceed:       config.BudgetActionDowngrade,
+		DowngradeModel: "cheap-model",
+	})
+	msg := bus.InboundMessage{Channel: "telegram", SenderID: "user1", ChatID: "chat1", Content: "hello"}
+
+	testHelper{al: al}.executeAndGetResponse(t, context.Background(), msg)
+	testHelper{al: al}.executeAndGetResponse(t, context.Background(), msg)
+
+	if len(provider.models) != 2 || provider.models[0] != "pricey-model" || provider.models[1] != "cheap-model" {
+		t.Errorf("Expected pricey-model then cheap-model, got %v", provider.models)
+	}
+}
+
+// subagentUsageMockProvider delegates the turn to the subagent tool and
+// reports fixed token usage for every call
+type subagentUsageMockProvider struct {
+	usageMockProvider
+}
+
+func (m *subagentUsageMockProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	resp, _ := m.usageMockProvider.Chat(ctx, messages, tools, model, opts)
+	if len(messages) == 2 && messages[len(messages)-1].Role == "user" && strings.Contains(messages[len(messages)-1].Content, "hello") {
+		resp.ToolCalls = []providers.ToolCall{{
+			ID:        "call_1",
+			Name:      "subagent",
+			Arguments: map[string]interface{}{"task": "count the files"},
+		}}
+	}
+	return resp, nil
+}
+
+func TestAgentLoop_SubagentUsageIsCharged(t *testing.T) {
+	provider := &subagentUsageMockProvider{}
+	al := newUsageTestLoop(t, nil, provider)
+	subagentManager := tools.NewSubagentManager(provider, "pricey-model", t.TempDir(), nil)
+	al.registry.GetDefaultAgent().Tools.Register(tools.NewSubagentTool(subagentManager))
+
+	msg := bus.InboundMessage{Channel: "telegram", SenderID: "user1", ChatID: "chat1", Content: "hello"}
+	testHelper{al: al}.executeAndGetResponse(t, context.Background(), msg)
+
+	records, err := al.ledger.Query(usage.StartOfDay(time.Now()), nil)
+	if err != nil {
+		t.Fatalf("Query() error: %v", err)
+	}
+	// The turn's two calls and the subagent's one, all charged to the session
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 ledger records, got %+v", records)
+	}
+	for _, r := range records {
+		if r.SessionKey != records[0].SessionKey || r.SessionKey == "" {
+			t.Errorf("Expected every record under the turn's session, got %+v", records)
+			break
+		}
+	}
+}
+
+func TestAgentLoop_SubagentRespectsBudget(t *testing.T) {
+	provider := &subagentUsageMockProvider{}
+	al := newUsageTestLoop(t, &config.BudgetConfig{DailyUSD: 0.5}, provider)
+	subagentManager := tools.NewSubagentManager(provider, "pricey-model", t.TempDir(), nil)
+	al.registry.GetDefaultAgent().Tools.Register(tools.NewSubagentTool(subagentManager))
+
+	msg := bus.InboundMessage{Channel: "telegram", SenderID: "user1", ChatID: "chat1", Content: "hello"}
+	if _, err := al.processMessage(context.Background(), msg); err == nil || !strings.Contains(err.Error(), "budget exceeded") {
+		t.Fatalf("Expected budget exceeded error, got %v", err)
+	}
+
+	// The first call spent the budget, so the subagent was never called
+	if len(provider.models) != 1 {
+		t.Errorf("Expected only the turn's first call, got %v", provider.models)
+	}
+}
+
+// overloadedMockProvider fails every call as an overloaded vendor would
+type overloadedMockProvider struct {
+	calls int
+}
+
+func (m *overloadedMockProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	m.calls++
+	return nil, fmt.Errorf("API error: overloaded")
+}
+
+func (m *overloadedMockProvider) GetDefaultModel() string {
+	return "mock-overloaded-model"
+}
+
+func TestAgentLoop_FallbackUsesCandidateProvider(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Provider:          "anthropic",
+				Model:             "claude-test",
+				ModelFallbacks:    []string{"groq/llama-test"},
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+	}
+	cfg.Providers.Anthropic.APIKey = "anthropic-key"
+	cfg.Providers.Groq.APIKey = "groq-key"
+
+	primary := &overloadedMockProvider{}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), primary)
+	agent := al.registry.GetDefaultAgent()
+	if agent.Candidates[0].Provider != "anthropic" || agent.Candidates[1].Provider != "groq" {
+		t.Fatalf("unexpected candidates: %+v", agent.Candidates)
+	}
+	groq := &recordingMockProvider{}
+	agent.Providers.Register("groq", groq)
+
+	response, err := al.ProcessDirect(context.Background(), "hello", "test-session")
+	if err != nil {
+		t.Fatalf("ProcessDirect failed: %v", err)
+	}
+	if response != "It is a picture." {
+		t.Fatalf("expected the groq response, got %q", response)
+	}
+	if primary.calls != 1 {
+		t.Errorf("expected 1 call to the primary provider, got %d", primary.calls)
+	}
+	if groq.model != "llama-test" {
+		t.Errorf("expected groq to get the fallback model, got %q", groq.model)
+	}
+}
+
+// structuredMockProvider answers heartbeats in prose until asked to correct
+// the reply, and rejects response formats when reject is set.
+type structuredMockProvider struct {
+	reject  bool
+	formats []string
+}
+
+func (m *structuredMockProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	rf := providers.ResponseFormatFrom(opts)
+	if rf == nil {
+		m.formats = append(m.formats, "")
+		return &providers.LLMResponse{Content: "plain summary"}, nil
+	}
+	m.formats = append(m.formats, rf.Name)
+	if m.reject {
+		return nil, fmt.Errorf("API error: response_format is not supported")
+	}
+	switch {
+	case rf.Name == "summary":
+		return &providers.LLMResponse{Content: `{"summary": "structured summary"}`}, nil
+	case strings.Contains(messages[len(messages)-1].Content, "did not match"):
+		return &providers.LLMResponse{Content: `{"status": "alert", "message": "disk full"}`}, nil
+	}
+	return &providers.LLMResponse{Content: "The disk is full."}, nil
+}
+
+func (m *structuredMockProvider) GetDefaultModel() string {
+	return "mock-structured-model"
+}
+
+func newStructuredTestLoop(t *testing.T, provider *structuredMockProvider) *AgentLoop {
+	t.Helper()
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+	}
+	return NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+}
+
+func TestAgentLoop_HeartbeatRepairsReply(t *testing.T) {
+	provider := &structuredMockProvider{}
+	al := newStructuredTestLoop(t, provider)
+
+	reply, err := al.ProcessHeartbeat(context.Background(), "", "check the disk", "", "")
+	if err != nil {
+		t.Fatalf("ProcessHeartbeat() error = %v", err)
+	}
+	if reply != `{"status": "alert", "message": "disk full"}` {
+		t.Errorf("reply = %q, want the corrected JSON", reply)
+	}
+	if strings.Join(provider.formats, ",") != "heartbeat_result,heartbeat_result" {
+		t.Errorf("formats = %v, want one repair", provider.formats)
+	}
+}
+
+func TestAgentLoop_SummarizeStructured(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		reject  bool
+		want    string
+		formats string
+	}{
+		{name: "structured", want: "structured summary", formats: "summary"},
+		{name: "falls back to plain text", reject: true, want: "plain summary", formats: "summary,"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &structuredMockProvider{reject: tt.reject}
+			al := newStructuredTestLoop(t, provider)
+			agent := al.registry.GetDefaultAgent()
+
+			got, err := al.summarizeBatch(context.Background(), agent, "s1",
+				[]providers.Message{{Role: "user", Content: "hello"}}, "")
+			if err != nil {
+				t.Fatalf("summarizeBatch() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("summary = %q, want %q", got, tt.want)
+			}
+			if strings.Join(provider.formats, ",") != tt.formats {
+				t.Errorf("formats = %v, want %s", provider.formats, tt.formats)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package agent
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// maxInlineImageBytes caps the size of a local image sent inline. Anthropic
+// rejects images over 5 MB and most OpenAI-compatible endpoints are similar.
+const maxInlineImageBytes = 5 * 1024 * 1024
+
+var imageMediaTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+}
+
+// buildUserParts turns the user's text and inbound media into multi-part
+// content. Images become image parts; other attachments are listed in the
+// text so the model can open them with its file tools. It returns nil when
+// there is nothing beyond plain text.
+func buildUserParts(text string, media []string) []providers.ContentPart {
+	if len(media) == 0 {
+		return nil
+	}
+
+	var images []providers.ContentPart
+	var attachments []string
+	for _, ref := range media {
+		if part, ok := loadImagePart(ref); ok {
+			images = append(images, part)
+			continue
+		}
+		attachments = append(attachments, ref)
+	}
+
+	if len(attachments) > 0 {
+		text += "\n\n[Attachments]\n" + strings.Join(attachments, "\n")
+	}
+	if len(images) == 0 {
+		if len(attachments) == 0 {
+			return nil
+		}
+		return []providers.ContentPart{{Type: providers.ContentPartText, Text: text}}
+	}
+
+	parts := make([]providers.ContentPart, 0, len(images)+1)
+	if strings.TrimSpace(text) != "" {
+		parts = append(parts, providers.ContentPart{Type: providers.ContentPartText, Text: text})
+	}
+	return append(parts, images...)
+}
+
+// loadImagePart reads ref, a local path or an http(s) URL, as an image part.
+func loadImagePart(ref string) (providers.ContentPart, bool) {
+	if u, err := url.Parse(ref); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		if _, ok := imageMediaTypes[strings.ToLower(filepath.Ext(u.Path))]; !ok {
+			return providers.ContentPart{}, false
+		}
+		return providers.ContentPart{Type: providers.ContentPartImage, URL: ref}, true
+	}
+
+	info, err := os.Stat(ref)
+	if err != nil || info.IsDir() {
+		return providers.ContentPart{}, false
+	}
+	mediaType, ok := imageMediaTypes[strings.ToLower(filepath.Ext(ref))]
+	if !ok {
+		return providers.ContentPart{}, false
+	}
+	if info.Size() > maxInlineImageBytes {
+		logger.WarnCF("agent", "Image too large to send to model", map[string]interface{}{
+			"path": ref,
+			"size": info.Size(),
+		})
+		return providers.ContentPart{}, false
+	}
+
+	data, err := os.ReadFile(ref)
+	if err != nil {
+		logger.WarnCF("agent", "Failed to read image", map[string]interface{}{
+			"path":  ref,
+			"error": err.Error(),
+		})
+		return providers.ContentPart{}, false
+	}
+	// Trust the file contents over the extension when they disagree.
+	if sniffed := http.DetectContentType(data); isSupportedImageType(sniffed) {
+		mediaType = sniffed
+	}
+
+	return providers.ContentPart{
+		Type:      providers.ContentPartImage,
+		MediaType: mediaType,
+		Data:      base64.StdEncoding.EncodeToString(data),
+	}, true
+}
+
+func isSupportedImageType(mediaType string) bool {
+	for _, t := range imageMediaTypes {
+		if t == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// localMedia returns the attachments in media that are local files, so
+// the session can record them.
+func localMedia(media []string) []string {
+	var local []string
+	for _, ref := range media {
+		if u, err := url.Parse(ref); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			continue
+		}
+		local = append(local, ref)
+	}
+	return local
+}
+
+// hasImages reports whether any message in the request carries an image.
+func hasImages(messages []providers.Message) bool {
+	for _, m := range messages {
+		if m.HasImages() {
+			return true
+		}
+	}
+	return false
+}
@@ -10,12 +10,30 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/sipeed/picoclaw/pkg/memory"
+	"github.com/sipeed/picoclaw/pkg/secrets"
 )
 
+// memoryLocks serializes writes to a memory directory across all stores
+// and sessions that use it, keyed by directory path.
+var memoryLocks sync.Map
+
+// entrySource matches the comment recording where an entry came from.
+var entrySource = regexp.MustCompile(`\s*<!--.*?-->`)
+
 // MemoryStore manages persistent memory for the agent.
 // - Long-term memory: memory/MEMORY.md
 // - Daily notes: memory/YYYYMM/YYYYMMDD.md
+//
+// Long-term memory is markdown with one "## " heading per section and one
+// "- " list item per entry. Entries added through Remember end with a
+// comment naming the channel and peer they came from. Both files are
+// encrypted when encryption at rest is enabled.
 type MemoryStore struct {
 	workspace  string
 	memoryDir  string
@@ -49,7 +67,7 @@ func (ms *MemoryStore) getTodayFile() string {
 // ReadLongTerm reads the long-term memory (MEMORY.md).
 // Returns empty string if the file doesn't exist.
 func (ms *MemoryStore) ReadLongTerm() string {
-	if data, err := os.ReadFile(ms.memoryFile); err == nil {
+	if data, err := secrets.ReadFile(ms.memoryFile); err == nil {
 		return string(data)
 	}
 	return ""
@@ -57,14 +75,32 @@ func (ms *MemoryStore) ReadLongTerm() string {
 
 // WriteLongTerm writes content to the long-term memory file (MEMORY.md).
 func (ms *MemoryStore) WriteLongTerm(content string) error {
-	return os.WriteFile(ms.memoryFile, []byte(content), 0644)
+	unlock := ms.lock()
+	defer unlock()
+	return ms.writeLongTerm(content)
+}
+
+// lock takes the write lock for the memory directory.
+func (ms *MemoryStore) lock() func() {
+	mu, _ := memoryLocks.LoadOrStore(ms.memoryDir, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// writeLongTerm replaces MEMORY.md through a temporary file, so readers
+// never see a partial write. Callers must hold the lock.
+func (ms *MemoryStore) writeLongTerm(content string) error {
+	if err := os.MkdirAll(ms.memoryDir, 0755); err != nil {
+		return err
+	}
+	return secrets.WriteFile(ms.memoryFile, []byte(content), 0644)
 }
 
 // ReadToday reads today's daily note.
 // Returns empty string if the file doesn't exist.
 func (ms *MemoryStore) ReadToday() string {
 	todayFile := ms.getTodayFile()
-	if data, err := os.ReadFile(todayFile); err == nil {
+	if data, err := secrets.ReadFile(todayFile); err == nil {
 		return string(data)
 	}
 	return ""
@@ -73,28 +109,29 @@ func (ms *MemoryStore) ReadToday() string {
 // AppendToday appends content to today's daily note.
 // If the file doesn't exist, it creates a new file with a date header.
 func (ms *MemoryStore) AppendToday(content string) error {
+	unlock := ms.lock()
+	defer unlock()
+
 	todayFile := ms.getTodayFile()
 
 	// Ensure month directory exists
-	monthDir := filepath.Dir(todayFile)
-	os.MkdirAll(monthDir, 0755)
-
-	var existingContent string
-	if data, err := os.ReadFile(todayFile); err == nil {
-		existingContent = string(data)
+	if err := os.MkdirAll(filepath.Dir(todayFile), 0755); err != nil {
+		return err
 	}
 
-	var newContent string
-	if existingContent == "" {
+	// The note is rewritten as a whole, as an encrypted file cannot be
+	// appended to.
+	existing, err := secrets.ReadFile(todayFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(existing) == 0 {
 		// Add header for new day
-		header := fmt.Sprintf("# %s\n\n", time.Now().Format("2006-01-02"))
-		newContent = header + content
+		content = fmt.Sprintf("# %s\n\n", time.Now().Format("2006-01-02")) + content
 	} else {
-		// Append to existing content
-		newContent = existingContent + "\n" + content
+		content = string(existing) + "\n" + content
 	}
-
-	return os.WriteFile(todayFile, []byte(newContent), 0644)
+	return secrets.WriteFile(todayFile, []byte(content), 0644)
 }
 
 // GetRecentDailyNotes returns daily notes from the last N days.
@@ -108,7 +145,7 @@ func (ms *MemoryStore) GetRecentDailyNotes(days int) string {
 		monthDir := dateStr[:6]            // YYYYMM
 		filePath := filepath.Join(ms.memoryDir, monthDir, dateStr+".md")
 
-		if data, err := os.ReadFile(filePath); err == nil {
+		if data, err := secrets.ReadFile(filePath); err == nil {
 			notes = append(notes, string(data))
 		}
 	}
@@ -128,19 +165,30 @@ func (ms *MemoryStore) GetRecentDailyNotes(days int) string {
 	return result
 }
 
+// contextFiles returns the files GetMemoryContext reads.
+func (ms *MemoryStore) contextFiles() []string {
+	files := []string{ms.memoryFile}
+	for i := 0; i < 3; i++ {
+		dateStr := time.Now().AddDate(0, 0, -i).Format("20060102")
+		files = append(files, filepath.Join(ms.memoryDir, dateStr[:6], dateStr+".md"))
+	}
+	return files
+}
+
 // GetMemoryContext returns formatted memory context for the agent prompt.
 // Includes long-term memory and recent daily notes.
 func (ms *MemoryStore) GetMemoryContext() string {
 	var parts []string
 
 	// Long-term memory
-	longTerm := ms.ReadLongTerm()
+	// Source comments are for people reading the files, not for the prompt.
+	longTerm := entrySource.ReplaceAllString(ms.ReadLongTerm(), "")
 	if longTerm != "" {
 		parts = append(parts, "## Long-term Memory\n\n"+longTerm)
 	}
 
 	// Recent daily notes (last 3 days)
-	recentNotes := ms.GetRecentDailyNotes(3)
+	recentNotes := entrySource.ReplaceAllString(ms.GetRecentDailyNotes(3), "")
 	if recentNotes != "" {
 		parts = append(parts, "## Recent Daily Notes\n\n"+recentNotes)
 	}
@@ -159,3 +207,263 @@ func (ms *MemoryStore) GetMemoryContext() string {
 	}
 	return fmt.Sprintf("# Memory\n\n%s", result)
 }
+
+// longTermTemplate starts MEMORY.md when the first entry is added to a
+// workspace that has none.
+const longTermTemplate = "# Long-term Memory\n\nThis file stores important information that should persist across sessions.\n"
+
+// memoryEntry returns the text of a "- " list item without its source
+// comment, or false if line is not an entry.
+func memoryEntry(line string) (string, bool) {
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "- ") {
+		return "", false
+	}
+	return strings.TrimSpace(entrySource.ReplaceAllString(trimmed[2:], "")), true
+}
+
+// memorySection returns the title of a "## " heading, or false if line is
+// not one.
+func memorySection(line string) (string, bool) {
+	if !strings.HasPrefix(line, "## ") {
+		return "", false
+	}
+	return strings.TrimSpace(line[3:]), true
+}
+
+// isPlaceholder reports whether line is template filler such as
+// "(Things to remember)".
+func isPlaceholder(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return strings.HasPrefix(trimmed, "(") && strings.HasSuffix(trimmed, ")")
+}
+
+func normalizeEntry(text string) string {
+	return strings.TrimRight(strings.ToLower(strings.Join(strings.Fields(text), " ")), ".!")
+}
+
+func formatEntry(text, source string, now time.Time) string {
+	if source == "" {
+		return fmt.Sprintf("- %s <!-- %s -->", text, now.Format("2006-01-02 15:04"))
+	}
+	return fmt.Sprintf("- %s <!-- %s, %s -->", text, source, now.Format("2006-01-02 15:04"))
+}
+
+// Remember implements tools.MemoryBackend. The entry goes at the end of
+// section, which is created if missing; template placeholders in the
+// section are dropped.
+func (ms *MemoryStore) Remember(section, text, source string) (string, error) {
+	unlock := ms.lock()
+	defer unlock()
+
+	content := ms.ReadLongTerm()
+	if strings.TrimSpace(content) == "" {
+		content = longTermTemplate
+	}
+	lines := strings.Split(strings.TrimRight(content, "\n"), "\n")
+
+	want := normalizeEntry(text)
+	current := ""
+	start, end := -1, len(lines)
+	for i, line := range lines {
+		if title, ok := memorySection(line); ok {
+			current = title
+			if start >= 0 && end == len(lines) {
+				end = i
+			}
+			if start < 0 && strings.EqualFold(title, section) {
+				start = i
+			}
+			continue
+		}
+		if entry, ok := memoryEntry(line); ok && normalizeEntry(entry) == want {
+			if current == "" {
+				return "(top)", nil
+			}
+			return current, nil
+		}
+	}
+
+	entry := formatEntry(text, source, time.Now())
+	if start < 0 {
+		lines = append(lines, "", "## "+section, "", entry)
+	} else {
+		body := make([]string, 0, end-start)
+		for _, line := range lines[start+1 : end] {
+			if !isPlaceholder(line) {
+				body = append(body, line)
+			}
+		}
+		for len(body) > 0 && strings.TrimSpace(body[len(body)-1]) == "" {
+			body = body[:len(body)-1]
+		}
+		if len(body) == 0 {
+			body = append(body, "")
+		}
+		body = append(body, entry)
+		if end < len(lines) {
+			body = append(body, "")
+		}
+
+		updated := append([]string{}, lines[:start+1]...)
+		updated = append(updated, body...)
+		lines = append(updated, lines[end:]...)
+	}
+	return "", ms.writeLongTerm(strings.Join(lines, "\n") + "\n")
+}
+
+// Forget implements tools.MemoryBackend.
+func (ms *MemoryStore) Forget(section, match string) ([]string, error) {
+	unlock := ms.lock()
+	defer unlock()
+
+	content := ms.ReadLongTerm()
+	if content == "" {
+		return nil, nil
+	}
+
+	needle := strings.ToLower(match)
+	current := ""
+	var kept []string
+	var removed []string
+	for _, line := range strings.Split(content, "\n") {
+		if title, ok := memorySection(line); ok {
+			current = title
+		}
+		entry, ok := memoryEntry(line)
+		if ok && (section == "" || strings.EqualFold(section, current)) &&
+			strings.Contains(strings.ToLower(entry), needle) {
+			removed = append(removed, entry)
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if len(removed) == 0 {
+		return nil, nil
+	}
+	return removed, ms.writeLongTerm(strings.Join(kept, "\n"))
+}
+
+// Note implements tools.MemoryBackend.
+func (ms *MemoryStore) Note(text, source string) error {
+	now := time.Now()
+	line := "- " + now.Format("15:04") + " " + text
+	if source != "" {
+		line += " <!-- " + source + " -->"
+	}
+	return ms.AppendToday(line)
+}
+
+// Recall implements tools.MemoryBackend.
+func (ms *MemoryStore) Recall(section, query string, days int) (string, error) {
+	terms := strings.Fields(strings.ToLower(query))
+	matches := func(text string) bool {
+		lower := strings.ToLower(text)
+		for _, term := range terms {
+			if !strings.Contains(lower, term) {
+				return false
+			}
+		}
+		return true
+	}
+
+	var sb strings.Builder
+	current, written := "", ""
+	for _, line := range strings.Split(ms.ReadLongTerm(), "\n") {
+		if title, ok := memorySection(line); ok {
+			current = title
+			continue
+		}
+		if section != "" && !strings.EqualFold(section, current) {
+			continue
+		}
+		entry, ok := memoryEntry(line)
+		if !ok || !matches(entry) {
+			continue
+		}
+		if sb.Len() == 0 {
+			sb.WriteString("Long-term memory:")
+		}
+		if current != written {
+			fmt.Fprintf(&sb, "\n## %s", current)
+			written = current
+		}
+		sb.WriteString("\n- " + entry)
+	}
+
+	if len(terms) == 0 {
+		return sb.String(), nil
+	}
+
+	var notes []string
+	for i := 0; i < days; i++ {
+		date := time.Now().AddDate(0, 0, -i)
+		dateStr := date.Format("20060102")
+		data, err := secrets.ReadFile(filepath.Join(ms.memoryDir, dateStr[:6], dateStr+".md"))
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(entrySource.ReplaceAllString(line, ""))
+			if line == "" || strings.HasPrefix(line, "#") || !matches(line) {
+				continue
+			}
+			notes = append(notes, date.Format("2006-01-02")+" "+strings.TrimPrefix(line, "- "))
+		}
+	}
+	if len(notes) > 0 {
+		if sb.Len() > 0 {
+			sb.WriteString("\n\n")
+		}
+		sb.WriteString("Daily notes:\n" + strings.Join(notes, "\n"))
+	}
+	return sb.String(), nil
+}
+
+// dailyNotesSection is the section daily notes are indexed under.
+const dailyNotesSection = "Daily Notes"
+
+// Entries returns long-term memory and the daily notes of the last days
+// days as index entries. Every non-empty line other than headings and
+// template placeholders is an entry.
+func (ms *MemoryStore) Entries(days int) []memory.Entry {
+	var entries []memory.Entry
+	current := ""
+	for _, line := range strings.Split(ms.ReadLongTerm(), "\n") {
+		if title, ok := memorySection(line); ok {
+			current = title
+			continue
+		}
+		if text := entryText(line); text != "" {
+			entries = append(entries, memory.NewEntry(current, text, ""))
+		}
+	}
+
+	for i := 0; i < days; i++ {
+		date := time.Now().AddDate(0, 0, -i)
+		dateStr := date.Format("20060102")
+		data, err := secrets.ReadFile(filepath.Join(ms.memoryDir, dateStr[:6], dateStr+".md"))
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if text := entryText(line); text != "" {
+				entries = append(entries, memory.NewEntry(dailyNotesSection, text, date.Format("2006-01-02")))
+			}
+		}
+	}
+	return entries
+}
+
+// entryText returns the text of a memory line worth indexing, without list
+// marker and source comment, or "" for headings, placeholders and blanks.
+func entryText(line string) string {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") || isPlaceholder(trimmed) {
+		return ""
+	}
+	if entry, ok := memoryEntry(trimmed); ok {
+		return entry
+	}
+	return strings.TrimSpace(entrySource.ReplaceAllString(trimmed, ""))
+}
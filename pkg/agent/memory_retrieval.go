@@ -0,0 +1,150 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/memory"
+	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/tokenizer"
+)
+
+const (
+	// retrievalTurns is how many recent history messages, besides the
+	// current one, make up the retrieval query.
+	retrievalTurns = 4
+	// maxQueryChars caps the retrieval query, in characters.
+	maxQueryChars = 2000
+	// retrievalTimeout bounds the embedding calls made while building a
+	// prompt.
+	retrievalTimeout = 10 * time.Second
+)
+
+// memoryRetriever picks the memory entries relevant to a conversation
+// instead of putting all of memory into the prompt.
+type memoryRetriever struct {
+	store *MemoryStore
+	index *memory.Index
+	cfg   config.MemoryConfig
+	tk    tokenizer.Tokenizer
+}
+
+// SetMemoryRetrieval makes the system prompt carry only the memory entries
+// relevant to the conversation, found through the workspace's memory index.
+func (cb *ContextBuilder) SetMemoryRetrieval(cfg config.MemoryConfig, tk tokenizer.Tokenizer) {
+	cb.retriever = &memoryRetriever{
+		store: cb.memory,
+		index: memory.NewIndex(memory.IndexPath(cb.workspace), memory.NewEmbedder(cfg.Embeddings)),
+		cfg:   cfg,
+		tk:    tk,
+	}
+}
+
+// retrievalQuery is the current message and the last few turns.
+func retrievalQuery(history []providers.Message, current string) string {
+	parts := []string{current}
+	for i := len(history) - 1; i >= 0 && len(parts) <= retrievalTurns; i-- {
+		m := history[i]
+		if (m.Role == "user" || m.Role == "assistant") && strings.TrimSpace(m.Content) != "" {
+			parts = append(parts, m.Content)
+		}
+	}
+	query := strings.Join(parts, "\n")
+	if runes := []rune(query); len(runes) > maxQueryChars {
+		query = string(runes[:maxQueryChars])
+	}
+	return query
+}
+
+// context returns the memory section of the system prompt for query.
+func (r *memoryRetriever) context(query string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), retrievalTimeout)
+	defer cancel()
+
+	entries := r.store.Entries(r.cfg.NotesDays)
+	if err := r.index.Update(ctx, entries); err != nil {
+		logger.WarnCF("agent", "Failed to update memory index",
+			map[string]interface{}{"error": err.Error()})
+	}
+
+	var picked []memory.Entry
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		if r.pinned(e.Section) {
+			picked = append(picked, e)
+			seen[e.ID] = true
+		}
+	}
+
+	results, err := r.index.Search(ctx, query, r.cfg.TopK)
+	if err != nil {
+		logger.WarnCF("agent", "Memory search fell back to lexical ranking",
+			map[string]interface{}{"error": err.Error()})
+	}
+	for _, res := range results {
+		if !seen[res.ID] {
+			picked = append(picked, res.Entry)
+			seen[res.ID] = true
+		}
+	}
+	if len(picked) == 0 {
+		return ""
+	}
+	return r.render(picked)
+}
+
+func (r *memoryRetriever) pinned(section string) bool {
+	for _, s := range r.cfg.PinnedSections {
+		if strings.EqualFold(s, section) {
+			return true
+		}
+	}
+	return false
+}
+
+// render groups entries by section, in order of first appearance, and
+// drops entries once the memory token budget is spent.
+func (r *memoryRetriever) render(entries []memory.Entry) string {
+	header := "The entries of your long-term memory and daily notes most relevant to this conversation. Use the recall tool to look for anything else."
+	budget := r.cfg.MaxTokens - r.tk.Count(header)
+
+	var order []string
+	lines := make(map[string][]string)
+	for _, e := range entries {
+		line := "- " + e.Text
+		if e.Date != "" {
+			line = fmt.Sprintf("- %s: %s", e.Date, e.Text)
+		}
+		cost := r.tk.Count(line) + 1
+		if _, ok := lines[e.Section]; !ok {
+			cost += r.tk.Count(e.Section) + 4
+		}
+		if r.cfg.MaxTokens > 0 && cost > budget {
+			continue
+		}
+		budget -= cost
+		if _, ok := lines[e.Section]; !ok {
+			order = append(order, e.Section)
+		}
+		lines[e.Section] = append(lines[e.Section], line)
+	}
+	if len(order) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString(header)
+	for _, section := range order {
+		if section == "" {
+			sb.WriteString("\n\n")
+		} else {
+			fmt.Fprintf(&sb, "\n\n## %s\n", section)
+		}
+		sb.WriteString(strings.Join(lines[section], "\n"))
+	}
+	return sb.String()
+}
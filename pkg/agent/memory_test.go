@@ -0,0 +1,232 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/secrets"
+	"github.com/sipeed/picoclaw/pkg/tokenizer"
+)
+
+func TestMemoryStore_RememberBySection(t *testing.T) {
+	ws := t.TempDir()
+	ms := NewMemoryStore(ws)
+	template := "# Long-term Memory\n\n## User Information\n\n(Important facts about user)\n\n## Preferences\n\n(User preferences learned over time)\n"
+	if err := ms.WriteLongTerm(template); err != nil {
+		t.Fatal(err)
+	}
+
+	if dup, err := ms.Remember("preferences", "Prefers metric units", "telegram:42"); err != nil || dup != "" {
+		t.Fatalf("Remember failed: %q, %v", dup, err)
+	}
+	if dup, err := ms.Remember("User Information", "Lives in Lisbon", "telegram:42"); err != nil || dup != "" {
+		t.Fatalf("Remember failed: %q, %v", dup, err)
+	}
+	if _, err := ms.Remember("Projects", "Building a weather station", ""); err != nil {
+		t.Fatalf("Remember failed: %v", err)
+	}
+	if dup, _ := ms.Remember("Important Notes", "prefers  metric units.", "discord:7"); dup != "Preferences" {
+		t.Fatalf("expected duplicate in Preferences, got %q", dup)
+	}
+
+	content := ms.ReadLongTerm()
+	if strings.Contains(content, "(Important facts about user)") || strings.Contains(content, "(User preferences") {
+		t.Errorf("placeholders should be replaced by entries:\n%s", content)
+	}
+	if strings.Count(content, "metric units") != 1 {
+		t.Errorf("duplicate entry written:\n%s", content)
+	}
+	userIdx := strings.Index(content, "## User Information")
+	lisbonIdx := strings.Index(content, "Lives in Lisbon")
+	prefIdx := strings.Index(content, "## Preferences")
+	if !(userIdx < lisbonIdx && lisbonIdx < prefIdx) {
+		t.Errorf("entry not placed in its section:\n%s", content)
+	}
+	if !strings.Contains(content, "- Prefers metric units <!-- telegram:42, ") {
+		t.Errorf("entry should record its source:\n%s", content)
+	}
+	if !strings.Contains(content, "## Projects\n\n- Building a weather station") {
+		t.Errorf("missing section should be created:\n%s", content)
+	}
+	if strings.Contains(ms.GetMemoryContext(), "telegram:42") {
+		t.Error("source comments should not reach the prompt")
+	}
+}
+
+func TestMemoryStore_ForgetAndRecall(t *testing.T) {
+	ms := NewMemoryStore(t.TempDir())
+	ms.Remember("Preferences", "Likes green tea", "cli:direct")
+	ms.Remember("Preferences", "Dislikes coffee", "cli:direct")
+	ms.Remember("Important Notes", "Tea order due Friday", "cli:direct")
+	if err := ms.Note("Bought oolong tea", "telegram:42"); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := ms.Recall("", "tea", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"## Preferences\n- Likes green tea", "## Important Notes\n- Tea order due Friday", "Bought oolong tea"} {
+		if !strings.Contains(found, want) {
+			t.Errorf("recall missing %q:\n%s", want, found)
+		}
+	}
+	if strings.Contains(found, "coffee") || strings.Contains(found, "<!--") {
+		t.Errorf("recall returned too much:\n%s", found)
+	}
+
+	removed, err := ms.Forget("Preferences", "TEA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != "Likes green tea" {
+		t.Fatalf("unexpected removed entries: %v", removed)
+	}
+	content := ms.ReadLongTerm()
+	if strings.Contains(content, "green tea") || !strings.Contains(content, "Tea order due Friday") || !strings.Contains(content, "Dislikes coffee") {
+		t.Errorf("forget removed the wrong entries:\n%s", content)
+	}
+}
+
+func TestMemoryStore_ConcurrentWrites(t *testing.T) {
+	ws := t.TempDir()
+	// Two stores on one workspace, as with two agents sharing it.
+	stores := []*MemoryStore{NewMemoryStore(ws), NewMemoryStore(ws)}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ms := stores[i%2]
+			if _, err := ms.Remember("Facts", fmt.Sprintf("fact number %d", i), "test"); err != nil {
+				t.Error(err)
+			}
+			if err := ms.Note(fmt.Sprintf("note number %d", i), "test"); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	content := stores[0].ReadLongTerm()
+	notes := stores[0].ReadToday()
+	for i := 0; i < 20; i++ {
+		if !strings.Contains(content, fmt.Sprintf("fact number %d <!--", i)) {
+			t.Errorf("lost fact %d", i)
+		}
+		if !strings.Contains(notes, fmt.Sprintf("note number %d <!--", i)) {
+			t.Errorf("lost note %d", i)
+		}
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(ws, "memory", "*.tmp")); len(leftovers) > 0 {
+		t.Errorf("temporary files left behind: %v", leftovers)
+	}
+	if _, err := os.Stat(filepath.Join(ws, "memory", "MEMORY.md")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestContextBuilder_MemoryRetrieval(t *testing.T) {
+	ws := t.TempDir()
+	cb := NewContextBuilder(ws)
+	cb.memory.Remember("User Information", "Name is Ana", "")
+	cb.memory.Remember("Preferences", "Prefers metric units for weather", "")
+	cb.memory.Remember("Important Notes", "Passport renewal due in March", "")
+	cb.memory.Note("Watered the tomato plants", "")
+
+	cb.SetMemoryRetrieval(config.MemoryConfig{
+		Retrieval:      true,
+		TopK:           5,
+		MaxTokens:      500,
+		NotesDays:      7,
+		PinnedSections: []string{"User Information"},
+	}, tokenizer.Heuristic{})
+
+	history := []providers.Message{
+		{Role: "user", Content: "how are my tomato plants doing?"},
+		{Role: "assistant", Content: "You watered them today."},
+	}
+	// Retrieved memory depends on the message, so it comes with the message
+	// rather than in the system prompt.
+	messages := cb.BuildMessages(history, "", "and the weather tomorrow?", nil, "", "")
+	prompt := messages[len(messages)-1].Content
+
+	for _, want := range []string{"Name is Ana", "Prefers metric units for weather", "Watered the tomato plants"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("prompt missing %q", want)
+		}
+	}
+	if strings.Contains(prompt, "Passport") {
+		t.Error("irrelevant entry should not be injected")
+	}
+	if _, err := os.Stat(filepath.Join(ws, "state", "memory_index.json")); err != nil {
+		t.Errorf("index not written: %v", err)
+	}
+
+	// The token budget bounds the memory section; pinned entries go first.
+	cb.retriever.cfg.MaxTokens = 75
+	messages = cb.BuildMessages(history, "", "and the weather tomorrow?", nil, "", "")
+	prompt = messages[len(messages)-1].Content
+	if !strings.Contains(prompt, "Name is Ana") || strings.Contains(prompt, "tomato") || strings.Contains(prompt, "metric") {
+		t.Errorf("budget not applied in order:\n%s", prompt)
+	}
+}
+
+func TestRetrievalQuery_TruncatesByCharacter(t *testing.T) {
+	current := strings.Repeat("é", maxQueryChars+10)
+	query := retrievalQuery(nil, current)
+	if !utf8.ValidString(query) || utf8.RuneCountInString(query) != maxQueryChars {
+		t.Fatalf("expected %d whole characters, got %d bytes, valid=%v", maxQueryChars, len(query), utf8.ValidString(query))
+	}
+}
+
+func TestMemoryStore_EncryptedAtRest(t *testing.T) {
+	ws := t.TempDir()
+	ms := NewMemoryStore(ws)
+	if err := ms.AppendToday("wrote before encryption"); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, _ := secrets.GenerateKey()
+	key, _ := secrets.NewKey(raw)
+	secrets.SetKeys(key)
+	t.Cleanup(func() { secrets.SetKeys(nil) })
+
+	if _, err := ms.Remember("Preferences", "Takes green tea", "telegram:42"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.AppendToday("booked the plumber"); err != nil {
+		t.Fatal(err)
+	}
+	handoffs := newHandoffTable(handoffsPath(ws), 0)
+	handoffs.set("agent:main:direct:42", handoff{From: "main", To: "billing", Summary: "asked about the invoice"})
+
+	for _, path := range []string{ms.memoryFile, ms.getTodayFile(), handoffsPath(ws)} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !secrets.IsSealed(data) {
+			t.Errorf("%s is not encrypted:\n%s", filepath.Base(path), data)
+		}
+	}
+
+	if !strings.Contains(ms.ReadLongTerm(), "Takes green tea") {
+		t.Errorf("long-term memory not readable: %q", ms.ReadLongTerm())
+	}
+	today := ms.ReadToday()
+	if !strings.Contains(today, "wrote before encryption") || !strings.Contains(today, "booked the plumber") {
+		t.Errorf("daily note lost entries: %q", today)
+	}
+	if h, ok := newHandoffTable(handoffsPath(ws), 0).active("agent:main:direct:42"); !ok || h.Summary != "asked about the invoice" {
+		t.Errorf("handoff not readable after reload: %+v, %v", h, ok)
+	}
+}
@@ -0,0 +1,114 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// listModelsTimeout bounds how long /list models waits for a provider.
+const listModelsTimeout = 10 * time.Second
+
+// providerModels returns, per provider name, the models the agents use with
+// it, including fallbacks and image models.
+func (al *AgentLoop) providerModels() map[string][]string {
+	models := make(map[string][]string)
+	seen := make(map[string]bool)
+	for _, id := range al.registry.ListAgentIDs() {
+		agent, ok := al.registry.GetAgent(id)
+		if !ok {
+			continue
+		}
+		for _, c := range append(append([]providers.FallbackCandidate(nil), agent.modelState().Candidates...), agent.ImageCandidates...) {
+			key := providers.ModelKey(c.Provider, c.Model)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			models[c.Provider] = append(models[c.Provider], c.Model)
+		}
+	}
+	return models
+}
+
+// listModels handles /list models: the models each agent is configured
+// with, and the models installed on providers that can list them.
+func (al *AgentLoop) listModels(ctx context.Context) string {
+	var b strings.Builder
+	b.WriteString("Configured models:")
+	for _, id := range al.registry.ListAgentIDs() {
+		agent, ok := al.registry.GetAgent(id)
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&b, "\n  %s: %s", id, agent.modelState().Model)
+		if len(agent.Fallbacks) > 0 {
+			fmt.Fprintf(&b, " (fallbacks: %s)", strings.Join(agent.Fallbacks, ", "))
+		}
+	}
+
+	agent := al.registry.GetDefaultAgent()
+	if agent == nil || agent.Providers == nil {
+		return b.String()
+	}
+	for _, name := range agent.Providers.Names() {
+		lister, ok := agent.Providers.Get(name).(providers.ModelLister)
+		if !ok {
+			continue
+		}
+		listCtx, cancel := context.WithTimeout(ctx, listModelsTimeout)
+		models, err := lister.ListModels(listCtx)
+		cancel()
+		if err != nil {
+			fmt.Fprintf(&b, "\n\nInstalled on %s: unavailable (%v)", name, err)
+			continue
+		}
+		sort.Strings(models)
+		if len(models) == 0 {
+			fmt.Fprintf(&b, "\n\nInstalled on %s: none", name)
+			continue
+		}
+		fmt.Fprintf(&b, "\n\nInstalled on %s:\n  %s", name, strings.Join(models, "\n  "))
+	}
+	return b.String()
+}
+
+// PullMissingModels downloads the models agents use that their provider
+// does not have yet, for providers that can pull models.
+func (al *AgentLoop) PullMissingModels(ctx context.Context) {
+	agent := al.registry.GetDefaultAgent()
+	if agent == nil || agent.Providers == nil {
+		return
+	}
+	for name, models := range al.providerModels() {
+		if !agent.Providers.Has(name) {
+			continue
+		}
+		puller, ok := agent.Providers.Get(name).(providers.ModelPuller)
+		if !ok {
+			continue
+		}
+		logger.InfoCF("agent", "Checking installed models", map[string]interface{}{
+			"provider": name,
+			"models":   models,
+		})
+		pulled, err := puller.EnsureModels(ctx, models)
+		if len(pulled) > 0 {
+			logger.InfoCF("agent", "Pulled missing models", map[string]interface{}{
+				"provider": name,
+				"models":   pulled,
+			})
+		}
+		if err != nil {
+			logger.WarnCF("agent", "Failed to pull missing models", map[string]interface{}{
+				"provider": name,
+				"error":    err.Error(),
+			})
+		}
+	}
+}
@@ -168,6 +168,33 @@ func TestAgentInstance_Model(t *testing.T) {
 	}
 }
 
+func TestAgentInstance_ModelList(t *testing.T) {
+	cfg := testCfg([]config.AgentConfig{
+		{ID: "main", Default: true, Model: &config.AgentModelConfig{Primary: "smart", Fallbacks: []string{"local"}}},
+	})
+	cfg.ModelList = []config.ModelEntry{
+		{Name: "smart", Protocol: config.ProtocolOpenAI, Model: "gpt-4o", APIBase: "http://localhost:8000/v1"},
+		{Name: "local", Protocol: config.ProtocolOllama, Model: "qwen2.5:7b"},
+	}
+	registry := NewAgentRegistry(cfg, &mockRegistryProvider{})
+
+	agent, _ := registry.GetAgent("main")
+	if provider, model := agent.primaryTarget(); provider != "smart" || model != "gpt-4o" {
+		t.Errorf("primaryTarget() = %q, %q, want smart, gpt-4o", provider, model)
+	}
+	if len(agent.Candidates) != 2 || agent.Candidates[1].Model != "qwen2.5:7b" {
+		t.Errorf("Candidates = %+v", agent.Candidates)
+	}
+
+	agent.SetModel("local")
+	if provider, model := agent.primaryTarget(); provider != "local" || model != "qwen2.5:7b" {
+		t.Errorf("after SetModel, primaryTarget() = %q, %q, want local, qwen2.5:7b", provider, model)
+	}
+	if len(agent.Candidates) != 1 {
+		t.Errorf("duplicate fallback should be dropped, got %+v", agent.Candidates)
+	}
+}
+
 func TestAgentInstance_FallbackInheritance(t *testing.T) {
 	cfg := testCfg([]config.AgentConfig{
 		{ID: "inherit", Default: true},
@@ -197,3 +224,77 @@ func TestAgentInstance_FallbackExplicitEmpty(t *testing.T) {
 		t.Errorf("expected 0 fallbacks (explicit empty), got %d: %v", len(agent.Fallbacks), agent.Fallbacks)
 	}
 }
+
+func TestAgentInstance_GenerationProfile(t *testing.T) {
+	topP := 0.8
+	cfg := testCfg([]config.AgentConfig{
+		{
+			ID:                "writer",
+			Default:           true,
+			Generation:        &config.GenerationProfile{ContextWindow: 32000, TopP: &topP},
+			SummaryGeneration: &config.GenerationProfile{MaxTokens: 512},
+			Subagents:         &config.SubagentsConfig{Generation: &config.GenerationProfile{MaxTokens: 2048}},
+		},
+	})
+	cfg.Agents.Defaults.Temperature = 0.7
+	registry := NewAgentRegistry(cfg, &mockRegistryProvider{})
+
+	agent, _ := registry.GetAgent("writer")
+	if agent.ContextWindow != 32000 {
+		t.Errorf("ContextWindow = %d, want 32000", agent.ContextWindow)
+	}
+
+	opts := generationOptions(agent.Generation)
+	if opts["max_tokens"] != 8192 || opts["temperature"] != 0.7 || opts["top_p"] != 0.8 {
+		t.Errorf("generation options = %v, want defaults with top_p override", opts)
+	}
+
+	summary := generationOptions(agent.SummaryGeneration)
+	if summary["max_tokens"] != 512 || summary["temperature"] != 0.3 || summary["top_p"] != 0.8 {
+		t.Errorf("summary options = %v", summary)
+	}
+
+	subagent := generationOptions(agent.SubagentGeneration)
+	if subagent["max_tokens"] != 2048 || subagent["temperature"] != 0.7 {
+		t.Errorf("subagent options = %v", subagent)
+	}
+}
+
+func TestAgentInstance_OllamaOptions(t *testing.T) {
+	cfg := testCfg([]config.AgentConfig{
+		{
+			ID:      "local",
+			Default: true,
+			Generation: &config.GenerationProfile{Ollama: &config.OllamaOptions{
+				NumCtx:  8192,
+				Options: map[string]interface{}{"num_gpu": 0},
+			}},
+		},
+	})
+	cfg.Agents.Defaults.Ollama = &config.OllamaOptions{
+		KeepAlive: "10m",
+		Options:   map[string]interface{}{"repeat_penalty": 1.1},
+	}
+	registry := NewAgentRegistry(cfg, &mockRegistryProvider{})
+
+	agent, _ := registry.GetAgent("local")
+	if agent.ContextWindow != 8192 {
+		t.Errorf("ContextWindow = %d, want num_ctx 8192", agent.ContextWindow)
+	}
+
+	opts := generationOptions(agent.Generation)
+	extra, _ := opts["ollama_options"].(map[string]interface{})
+	if opts["num_ctx"] != 8192 || opts["keep_alive"] != "10m" || extra["num_gpu"] != 0 || extra["repeat_penalty"] != 1.1 {
+		t.Errorf("generation options = %v, want agent options merged over defaults", opts)
+	}
+}
+
+func TestAgentInstance_ContextWindowDefault(t *testing.T) {
+	cfg := testCfg(nil)
+	registry := NewAgentRegistry(cfg, &mockRegistryProvider{})
+
+	agent, _ := registry.GetAgent("main")
+	if agent.ContextWindow != defaultContextWindow {
+		t.Errorf("ContextWindow = %d, want %d (not max_tokens)", agent.ContextWindow, defaultContextWindow)
+	}
+}
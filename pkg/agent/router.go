@@ -0,0 +1,283 @@
+package agent
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/routing"
+)
+
+// Tiers the model router sorts turns into.
+const (
+	tierSimple  = "simple"
+	tierComplex = "complex"
+)
+
+const (
+	defaultMaxSimpleChars = 280
+	// Messages of at most this many words with nothing pointing to a
+	// complex task are taken as chit-chat without asking the classifier.
+	chitChatMaxWords  = 4
+	classifierTimeout = 15 * time.Second
+)
+
+// complexKeywords point to a task that needs the primary model.
+var complexKeywords = []string{
+	"algorithm", "analyse", "analyze", "architecture", "calculate", "code",
+	"compare", "debug", "design", "essay", "explain why", "implement",
+	"optimize", "plan", "proof", "prove", "refactor", "report", "research",
+	"review", "script", "step by step", "summarize", "translate", "write a",
+}
+
+// toolKeywords point to a task that needs tools.
+var toolKeywords = []string{
+	"calendar", "command", "commit", "cron", "directory", "download",
+	"email", "execute", "file", "folder", "github", "install", "look up",
+	"remind", "repo", "run", "schedule", "search", "shell",
+}
+
+const classifierPrompt = `You route chat messages to a model. Reply with one word:
+SIMPLE if a small model can answer the message directly (greetings, chit-chat, short factual questions),
+COMPLEX if it needs reasoning, code, tools or several steps.`
+
+// routeDecision is the model the router picked for a turn.
+type routeDecision struct {
+	Tier   string
+	Reason string
+	// Model serves the turn; empty means the agent's model chain.
+	Model string
+	At    time.Time
+}
+
+func (d routeDecision) String() string {
+	model := d.Model
+	if model == "" {
+		model = "agent model"
+	}
+	return fmt.Sprintf("%s -> %s (%s)", d.Tier, model, d.Reason)
+}
+
+// maxRouteDecisions caps the sessions the router remembers a decision for.
+const maxRouteDecisions = 1024
+
+// modelRouter remembers the last routing decision of each session. Once
+// more than maxRouteDecisions sessions are known, the least recently routed
+// ones are forgotten.
+type modelRouter struct {
+	mu   sync.Mutex
+	last map[string]*list.Element // of *routedSession
+	lru  *list.List               // most recently routed first
+}
+
+type routedSession struct {
+	key      string
+	decision routeDecision
+}
+
+func newModelRouter() *modelRouter {
+	return &modelRouter{last: make(map[string]*list.Element), lru: list.New()}
+}
+
+func (r *modelRouter) record(sessionKey string, d routeDecision) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if el, ok := r.last[sessionKey]; ok {
+		el.Value.(*routedSession).decision = d
+		r.lru.MoveToFront(el)
+		return
+	}
+	r.last[sessionKey] = r.lru.PushFront(&routedSession{key: sessionKey, decision: d})
+	for r.lru.Len() > maxRouteDecisions {
+		oldest := r.lru.Remove(r.lru.Back()).(*routedSession)
+		delete(r.last, oldest.key)
+	}
+}
+
+func (r *modelRouter) lastDecision(sessionKey string) (routeDecision, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	el, ok := r.last[sessionKey]
+	if !ok {
+		return routeDecision{}, false
+	}
+	return el.Value.(*routedSession).decision, true
+}
+
+// routerConfig returns the router for a turn routed by route: the binding's
+// when it sets one, otherwise the agent's. It returns nil when routing is
+// off.
+func (al *AgentLoop) routerConfig(agent *AgentInstance, route routing.ResolvedRoute) *config.RouterConfig {
+	rc := &agent.Router
+	if route.Binding != nil && route.Binding.Router != nil {
+		rc = route.Binding.Router
+	}
+	if !rc.Enabled || strings.TrimSpace(rc.SimpleModel) == "" {
+		return nil
+	}
+	return rc
+}
+
+// routeTurn picks the model for a turn: the heuristics decide, asking the
+// classifier model when they cannot.
+func (al *AgentLoop) routeTurn(ctx context.Context, agent *AgentInstance, opts processOptions) routeDecision {
+	rc := opts.Router
+	tier, reason, sure := classifyTurn(*rc, opts.UserMessage, len(opts.Media) > 0)
+	if !sure && rc.ClassifierModel != "" {
+		tier, reason = al.classifyWithModel(ctx, agent, rc.ClassifierModel, opts)
+	}
+
+	d := routeDecision{Tier: tier, Reason: reason, At: time.Now()}
+	if tier == tierSimple {
+		d.Model = rc.SimpleModel
+	}
+	al.logRoute(agent, opts.SessionKey, d)
+	return d
+}
+
+// escalate moves the rest of a simply routed turn to the agent's model.
+func (al *AgentLoop) escalate(agent *AgentInstance, sessionKey string, d *routeDecision, reason string) {
+	d.Tier = tierComplex
+	d.Reason = reason
+	d.Model = ""
+	d.At = time.Now()
+	al.logRoute(agent, sessionKey, *d)
+}
+
+func (al *AgentLoop) logRoute(agent *AgentInstance, sessionKey string, d routeDecision) {
+	al.router.record(sessionKey, d)
+	logger.InfoCF("agent", "Routed turn",
+		map[string]interface{}{
+			"agent_id":    agent.ID,
+			"session_key": sessionKey,
+			"tier":        d.Tier,
+			"model":       d.Model,
+			"reason":      d.Reason,
+		})
+}
+
+// classifyTurn sorts a message with cheap heuristics. sure is false when
+// nothing but its moderate length points to a tier.
+func classifyTurn(rc config.RouterConfig, content string, hasMedia bool) (tier, reason string, sure bool) {
+	text := strings.ToLower(strings.TrimSpace(content))
+	maxChars := rc.MaxSimpleChars
+	if maxChars <= 0 {
+		maxChars = defaultMaxSimpleChars
+	}
+
+	switch {
+	case hasMedia:
+		return tierComplex, "attachments", true
+	case strings.Contains(text, "```"):
+		return tierComplex, "code block", true
+	case utf8.RuneCountInString(text) > maxChars:
+		return tierComplex, "length", true
+	case strings.Contains(text, "http://") || strings.Contains(text, "https://"):
+		return tierComplex, "link", true
+	}
+	if kw := matchKeyword(text, complexKeywords, rc.ComplexKeywords); kw != "" {
+		return tierComplex, "keyword: " + kw, true
+	}
+	if kw := matchKeyword(text, toolKeywords); kw != "" {
+		return tierComplex, "tool use: " + kw, true
+	}
+	if len(strings.Fields(text)) <= chitChatMaxWords {
+		return tierSimple, "chit-chat", true
+	}
+	return tierSimple, "short", false
+}
+
+// classifyWithModel asks the classifier model for a message's tier. Turns
+// it cannot classify go to the agent's model.
+func (al *AgentLoop) classifyWithModel(ctx context.Context, agent *AgentInstance, classifier string, opts processOptions) (tier, reason string) {
+	ctx, cancel := context.WithTimeout(ctx, classifierTimeout)
+	defer cancel()
+
+	provider, model := agent.modelTarget(classifier)
+	messages := []providers.Message{
+		{Role: "system", Content: classifierPrompt},
+		{Role: "user", Content: opts.UserMessage},
+	}
+	resp, err := agent.providerFor(provider).Chat(ctx, messages, nil, model,
+		map[string]interface{}{"max_tokens": 8, "temperature": 0.0})
+	if err != nil {
+		logger.WarnCF("agent", "Router classifier failed, using the agent's model",
+			map[string]interface{}{
+				"agent_id": agent.ID,
+				"model":    classifier,
+				"error":    err.Error(),
+			})
+		return tierComplex, "classifier failed"
+	}
+	al.recordUsage(agent, opts.SessionKey, model, resp)
+
+	if strings.Contains(strings.ToLower(resp.Content), tierSimple) {
+		return tierSimple, "classifier"
+	}
+	return tierComplex, "classifier"
+}
+
+// matchKeyword returns the first keyword of lists found in text as a whole
+// word, plural included, or "".
+func matchKeyword(text string, lists ...[]string) string {
+	for _, list := range lists {
+		for _, kw := range list {
+			kw = strings.ToLower(strings.TrimSpace(kw))
+			if kw != "" && containsWord(text, kw) {
+				return kw
+			}
+		}
+	}
+	return ""
+}
+
+func containsWord(text, word string) bool {
+	for start := 0; ; {
+		i := strings.Index(text[start:], word)
+		if i < 0 {
+			return false
+		}
+		i += start
+		end := i + len(word)
+		if end < len(text) && text[end] == 's' {
+			end++
+		}
+		before, _ := utf8.DecodeLastRuneInString(text[:i])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		if (i == 0 || !isWordRune(before)) && (end == len(text) || !isWordRune(after)) {
+			return true
+		}
+		start = i + 1
+	}
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// showModel describes the model of the agent serving sessionKey and, when
+// routing is on, the router and its last decision for the session.
+func (al *AgentLoop) showModel(agent *AgentInstance, sessionKey string, route routing.ResolvedRoute) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Current model: %s", agent.modelState().Model)
+	rc := al.routerConfig(agent, route)
+	if rc == nil {
+		return sb.String()
+	}
+	fmt.Fprintf(&sb, "\nRouter: simple turns -> %s", rc.SimpleModel)
+	if rc.ClassifierModel != "" {
+		fmt.Fprintf(&sb, " (classifier: %s)", rc.ClassifierModel)
+	}
+	if d, ok := al.router.lastDecision(sessionKey); ok {
+		fmt.Fprintf(&sb, "\nLast turn: %s at %s", d, d.At.Format("15:04:05"))
+	}
+	return sb.String()
+}
@@ -0,0 +1,155 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+func TestClassifyTurn(t *testing.T) {
+	rc := config.RouterConfig{Enabled: true, SimpleModel: "small", ComplexKeywords: []string{"invoice"}}
+	tests := []struct {
+		name     string
+		content  string
+		media    bool
+		wantTier string
+		wantSure bool
+	}{
+		{name: "greeting", content: "hey, how are you?", wantTier: tierSimple, wantSure: true},
+		{name: "short question", content: "what is the capital of france?", wantTier: tierSimple},
+		{name: "attachments", content: "hi", media: true, wantTier: tierComplex, wantSure: true},
+		{name: "code block", content: "why?\n```go\nx := 1\n```", wantTier: tierComplex, wantSure: true},
+		{name: "length", content: strings.Repeat("very ", 60), wantTier: tierComplex, wantSure: true},
+		{name: "link", content: "see https://example.com", wantTier: tierComplex, wantSure: true},
+		{name: "keyword", content: "can you refactor this for me", wantTier: tierComplex, wantSure: true},
+		{name: "plural tool keyword", content: "which files are in here", wantTier: tierComplex, wantSure: true},
+		{name: "configured keyword", content: "where is my Invoice", wantTier: tierComplex, wantSure: true},
+		{name: "keyword inside a word", content: "is the encoder ok?", wantTier: tierSimple, wantSure: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tier, reason, sure := classifyTurn(rc, tt.content, tt.media)
+			if tier != tt.wantTier || sure != tt.wantSure {
+				t.Errorf("classifyTurn() = %s, %q, %v, want %s, sure %v", tier, reason, sure, tt.wantTier, tt.wantSure)
+			}
+		})
+	}
+}
+
+// routerMockProvider remembers the models used; the classifier answers
+// SIMPLE and "small" calls a tool when asked to.
+type routerMockProvider struct {
+	models []string
+}
+
+func (m *routerMockProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	m.models = append(m.models, model)
+	last := messages[len(messages)-1]
+	switch {
+	case model == "tiny":
+		return &providers.LLMResponse{Content: "SIMPLE"}, nil
+	case model == "small" && last.Role == "user" && strings.Contains(last.Content, "use a tool"):
+		return &providers.LLMResponse{ToolCalls: []providers.ToolCall{{ID: "call_1", Name: "missing", Arguments: map[string]interface{}{}}}}, nil
+	}
+	return &providers.LLMResponse{Content: "ok"}, nil
+}
+
+func (m *routerMockProvider) GetDefaultModel() string {
+	return "mock-router-model"
+}
+
+func newRouterTestLoop(t *testing.T, router *config.RouterConfig, bindings []config.AgentBinding) (*AgentLoop, *routerMockProvider) {
+	t.Helper()
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "big",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+				Router:            router,
+			},
+		},
+		Bindings: bindings,
+	}
+	provider := &routerMockProvider{}
+	return NewAgentLoop(cfg, bus.NewMessageBus(), provider), provider
+}
+
+func TestModelRouter_ForgetsLeastRecentlyRouted(t *testing.T) {
+	r := newModelRouter()
+	for i := 0; i < maxRouteDecisions; i++ {
+		r.record(fmt.Sprintf("s%d", i), routeDecision{Tier: tierSimple})
+	}
+	r.record("s0", routeDecision{Tier: tierComplex})
+	r.record("new", routeDecision{Tier: tierSimple})
+
+	if len(r.last) != maxRouteDecisions {
+		t.Fatalf("remembered %d sessions, want %d", len(r.last), maxRouteDecisions)
+	}
+	if _, ok := r.lastDecision("s1"); ok {
+		t.Error("least recently routed session should be forgotten")
+	}
+	if d, ok := r.lastDecision("s0"); !ok || d.Tier != tierComplex {
+		t.Errorf("lastDecision(s0) = %v, %v, want the updated decision", d, ok)
+	}
+	if _, ok := r.lastDecision("new"); !ok {
+		t.Error("new session should be remembered")
+	}
+}
+
+func TestAgentLoop_RouterPicksModelByComplexity(t *testing.T) {
+	al, provider := newRouterTestLoop(t, &config.RouterConfig{Enabled: true, SimpleModel: "small", ClassifierModel: "tiny"}, nil)
+	send := func(content string) {
+		testHelper{al: al}.executeAndGetResponse(t, context.Background(), bus.InboundMessage{
+			Channel: "telegram", SenderID: "user1", ChatID: "chat1", Content: content,
+		})
+	}
+
+	send("thanks!")
+	send("please implement a parser for this format")
+	send("what should i cook tonight with some eggs?")
+	send("hey, use a tool")
+
+	want := []string{"small", "big", "tiny", "small", "small", "big"}
+	if strings.Join(provider.models, ",") != strings.Join(want, ",") {
+		t.Errorf("models = %v, want %v", provider.models, want)
+	}
+
+	shown, _ := al.handleCommand(context.Background(), bus.InboundMessage{
+		Channel: "telegram", SenderID: "user1", ChatID: "chat1", Content: "/show model",
+	})
+	if !strings.Contains(shown, "Router: simple turns -> small (classifier: tiny)") ||
+		!strings.Contains(shown, "Last turn: complex -> agent model (tool calls)") {
+		t.Errorf("Unexpected /show model output: %s", shown)
+	}
+}
+
+func TestAgentLoop_RouterBindingOverride(t *testing.T) {
+	al, provider := newRouterTestLoop(t, &config.RouterConfig{Enabled: true, SimpleModel: "small"}, []config.AgentBinding{{
+		AgentID: "main",
+		Match:   config.BindingMatch{Channel: "discord", AccountID: "*"},
+		Router:  &config.RouterConfig{Enabled: false},
+	}})
+
+	for _, channel := range []string{"telegram", "discord"} {
+		testHelper{al: al}.executeAndGetResponse(t, context.Background(), bus.InboundMessage{
+			Channel: channel, SenderID: "user1", ChatID: "chat1", Content: "hello",
+		})
+	}
+	if strings.Join(provider.models, ",") != "small,big" {
+		t.Errorf("models = %v, want [small big]", provider.models)
+	}
+
+	shown, _ := al.handleCommand(context.Background(), bus.InboundMessage{
+		Channel: "discord", SenderID: "user1", ChatID: "chat1", Content: "/show model",
+	})
+	if shown != "Current model: big" {
+		t.Errorf("Unexpected /show model output: %s", shown)
+	}
+}
@@ -0,0 +1,244 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/tools"
+)
+
+// activeRun is a message being processed for a session. It can be stopped
+// with /stop and collects steering messages sent while it runs.
+type activeRun struct {
+	cancel  context.CancelFunc
+	stopped atomic.Bool
+	// sender is the user who started the run; only they may approve its
+	// tool calls.
+	sender string
+
+	mu      sync.Mutex
+	pending []bus.InboundMessage
+	closed  bool
+}
+
+// drain returns and clears the steering messages received so far.
+func (r *activeRun) drain() []bus.InboundMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pending := r.pending
+	r.pending = nil
+	return pending
+}
+
+type activeRunKey struct{}
+
+func withActiveRun(ctx context.Context, run *activeRun) context.Context {
+	return context.WithValue(ctx, activeRunKey{}, run)
+}
+
+func activeRunFrom(ctx context.Context) *activeRun {
+	run, _ := ctx.Value(activeRunKey{}).(*activeRun)
+	return run
+}
+
+// sessionScope is the context that a session's runs and background work,
+// such as async subagents, share. /stop cancels it.
+type sessionScope struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	// tasks counts the background work still running under ctx.
+	tasks int
+}
+
+// runTracker keeps the active run and background scope of each session.
+type runTracker struct {
+	mu     sync.Mutex
+	runs   map[string]*activeRun
+	scopes map[string]*sessionScope
+	// uses counts the agent loops working on each session's history,
+	// including direct ones that have no activeRun.
+	uses map[string]int
+}
+
+func newRunTracker() *runTracker {
+	return &runTracker{
+		runs:   make(map[string]*activeRun),
+		scopes: make(map[string]*sessionScope),
+		uses:   make(map[string]int),
+	}
+}
+
+// use marks the session's history as in use until the returned func is
+// called.
+func (t *runTracker) use(key string) func() {
+	t.mu.Lock()
+	t.uses[key]++
+	t.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if t.uses[key]--; t.uses[key] <= 0 {
+				delete(t.uses, key)
+			}
+		})
+	}
+}
+
+// busy reports whether the session has an active run, background work or
+// an agent loop using its history.
+func (t *runTracker) busy(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.runs[key] != nil || t.scopes[key] != nil || t.uses[key] > 0
+}
+
+// begin registers a run started by sender for the session key and returns the context it
+// must use. The context is cancelled by stop, or by end once the run is over.
+func (t *runTracker) begin(ctx context.Context, key, sender string) (context.Context, *activeRun) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	scope, ok := t.scopes[key]
+	if !ok {
+		scopeCtx, cancel := context.WithCancel(ctx)
+		scope = &sessionScope{ctx: scopeCtx, cancel: cancel}
+		t.scopes[key] = scope
+	}
+
+	runCtx, cancel := context.WithCancel(scope.ctx)
+	run := &activeRun{cancel: cancel, sender: sender}
+	t.runs[key] = run
+
+	runCtx = tools.WithBackground(runCtx, scope.ctx, func() func() {
+		return t.hold(key, scope)
+	})
+	return withActiveRun(runCtx, run), run
+}
+
+// hold keeps scope registered for key until the returned func is called,
+// so later runs of the session share it with the background work.
+func (t *runTracker) hold(key string, scope *sessionScope) func() {
+	t.mu.Lock()
+	scope.tasks++
+	t.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			scope.tasks--
+			t.release(key)
+		})
+	}
+}
+
+// release drops the session's scope once it has no active run and no
+// background work left. t.mu must be held.
+func (t *runTracker) release(key string) {
+	scope, ok := t.scopes[key]
+	if !ok || scope.tasks > 0 || t.runs[key] != nil {
+		return
+	}
+	delete(t.scopes, key)
+	scope.cancel()
+}
+
+// end unregisters run and returns the steering messages it did not consume,
+// which must be processed as regular messages.
+func (t *runTracker) end(key string, run *activeRun) []bus.InboundMessage {
+	t.mu.Lock()
+	if t.runs[key] == run {
+		delete(t.runs, key)
+	}
+	t.release(key)
+	t.mu.Unlock()
+
+	run.mu.Lock()
+	run.closed = true
+	leftover := run.pending
+	run.pending = nil
+	run.mu.Unlock()
+
+	run.cancel()
+	return leftover
+}
+
+// steer hands msg to the session's active run. It returns false if no run
+// is active, in which case msg must be queued as usual.
+func (t *runTracker) steer(key string, msg bus.InboundMessage) bool {
+	t.mu.Lock()
+	run := t.runs[key]
+	t.mu.Unlock()
+	if run == nil {
+		return false
+	}
+
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	if run.closed {
+		return false
+	}
+	run.pending = append(run.pending, msg)
+	return true
+}
+
+// stop cancels the session's active run and background work. It reports
+// whether a run was active.
+func (t *runTracker) stop(key string) bool {
+	t.mu.Lock()
+	run := t.runs[key]
+	scope := t.scopes[key]
+	delete(t.scopes, key)
+	t.mu.Unlock()
+
+	if run != nil {
+		run.stopped.Store(true)
+	}
+	if scope != nil {
+		scope.cancel()
+	}
+	return run != nil
+}
+
+func isCommand(content string) bool {
+	return strings.HasPrefix(strings.TrimSpace(content), "/")
+}
+
+func isStopCommand(content string) bool {
+	return strings.TrimSpace(content) == "/stop"
+}
+
+// injectSteering appends the steering messages received by the active run
+// to messages and the session history.
+func (al *AgentLoop) injectSteering(ctx context.Context, agent *AgentInstance, messages []providers.Message, opts processOptions) []providers.Message {
+	run := activeRunFrom(ctx)
+	if run == nil || opts.NoHistory {
+		return messages
+	}
+	for _, msg := range run.drain() {
+		userMsg := providers.Message{Role: "user", Content: msg.Content}
+		if parts := buildUserParts(msg.Content, msg.Media); parts != nil {
+			userMsg.Parts = parts
+		}
+		messages = append(messages, userMsg)
+		agent.Sessions.AddFullMessage(opts.SessionKey, providers.Message{Role: "user", Content: msg.Content, Media: msg.Media})
+		agent.Sessions.AddMedia(opts.SessionKey, localMedia(msg.Media))
+
+		logger.InfoCF("agent", "Injected steering message",
+			map[string]interface{}{
+				"agent_id":    agent.ID,
+				"session_key": opts.SessionKey,
+				"content_len": len(msg.Content),
+			})
+	}
+	return messages
+}
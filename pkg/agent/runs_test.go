@@ -0,0 +1,361 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/tools"
+)
+
+func TestRunTracker_SteerOnlyWhileActive(t *testing.T) {
+	rt := newRunTracker()
+	msg := bus.InboundMessage{Content: "also check the logs"}
+
+	if rt.steer("s1", msg) {
+		t.Fatal("steer() with no active run should return false")
+	}
+
+	_, run := rt.begin(context.Background(), "s1", "user1")
+	if !rt.steer("s1", msg) {
+		t.Fatal("steer() during a run should return true")
+	}
+	if got := run.drain(); len(got) != 1 || got[0].Content != msg.Content {
+		t.Fatalf("drain() = %v, want the steered message", got)
+	}
+
+	rt.steer("s1", msg)
+	if leftover := rt.end("s1", run); len(leftover) != 1 {
+		t.Fatalf("end() leftover = %v, want 1 message", leftover)
+	}
+	if rt.steer("s1", msg) {
+		t.Fatal("steer() after the run ended should return false")
+	}
+}
+
+func TestRunTracker_StopCancelsRunAndBackground(t *testing.T) {
+	rt := newRunTracker()
+
+	runCtx, run := rt.begin(context.Background(), "s1", "user1")
+	bg, done := tools.BackgroundFrom(runCtx)
+	defer done()
+	rt.end("s1", run)
+	if runCtx.Err() == nil {
+		t.Fatal("run context should be cancelled when the run ends")
+	}
+	if bg.Err() != nil {
+		t.Fatal("background context should outlive the run")
+	}
+
+	runCtx, _ = rt.begin(context.Background(), "s1", "user1")
+	if !rt.stop("s1") {
+		t.Fatal("stop() should report an active run")
+	}
+	if runCtx.Err() == nil || bg.Err() == nil {
+		t.Fatal("stop() should cancel the run and background work")
+	}
+	if rt.stop("s2") {
+		t.Fatal("stop() on an idle session should return false")
+	}
+}
+
+func TestRunTracker_EndDropsIdleScope(t *testing.T) {
+	rt := newRunTracker()
+
+	_, run := rt.begin(context.Background(), "s1", "user1")
+	rt.end("s1", run)
+	if len(rt.scopes) != 0 {
+		t.Fatalf("scopes = %d after the run ended, want 0", len(rt.scopes))
+	}
+
+	runCtx, run := rt.begin(context.Background(), "s1", "user1")
+	bg, done := tools.BackgroundFrom(runCtx)
+	rt.end("s1", run)
+	if len(rt.scopes) != 1 || bg.Err() != nil {
+		t.Fatal("scope should stay while background work is running")
+	}
+
+	_, run = rt.begin(context.Background(), "s1", "user1")
+	done()
+	done()
+	if len(rt.scopes) != 1 {
+		t.Fatal("scope should stay while a run is active")
+	}
+	rt.end("s1", run)
+	if len(rt.scopes) != 0 || bg.Err() == nil {
+		t.Fatal("scope should be dropped and cancelled once idle")
+	}
+}
+
+// blockingProvider blocks every call until its context is cancelled
+type blockingProvider struct {
+	started chan struct{}
+}
+
+func (m *blockingProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	m.started <- struct{}{}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (m *blockingProvider) GetDefaultModel() string {
+	return "mock-blocking-model"
+}
+
+func waitForOutbound(t *testing.T, msgBus *bus.MessageBus, content string) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), responseTimeout)
+	defer cancel()
+	for {
+		out, ok := msgBus.SubscribeOutbound(ctx)
+		if !ok {
+			t.Fatalf("timed out waiting for outbound %q", content)
+		}
+		if out.Content == content {
+			return
+		}
+	}
+}
+
+func TestAgentLoop_StopCancelsActiveRun(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+	}
+	msgBus := bus.NewMessageBus()
+	provider := &blockingProvider{started: make(chan struct{}, 1)}
+	al := NewAgentLoop(cfg, msgBus, provider)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go al.Run(ctx)
+
+	msgBus.PublishInbound(bus.InboundMessage{Channel: "telegram", SenderID: "user1", ChatID: "chat1", Content: "do something slow"})
+	select {
+	case <-provider.started:
+	case <-time.After(responseTimeout):
+		t.Fatal("run did not start")
+	}
+
+	msgBus.PublishInbound(bus.InboundMessage{Channel: "telegram", SenderID: "user1", ChatID: "chat1", Content: "/stop"})
+	waitForOutbound(t, msgBus, "Stopped.")
+}
+
+func TestAgentLoop_RetentionSkipsSessionsInUse(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+	}
+	provider := &blockingProvider{started: make(chan struct{}, 1)}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+	target := al.RetentionTargets()[0]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		al.ProcessDirectWithChannel(ctx, "do something slow", "agent:main:cron", "cli", "direct")
+	}()
+	select {
+	case <-provider.started:
+	case <-time.After(responseTimeout):
+		t.Fatal("run did not start")
+	}
+
+	if !target.Busy("agent:main:cron") {
+		t.Fatal("expected a session with a running agent loop to be busy")
+	}
+	if target.Busy("other-session") {
+		t.Fatal("expected an unused session not to be busy")
+	}
+
+	cancel()
+	<-done
+	if target.Busy("agent:main:cron") {
+		t.Fatal("expected the session to be released once the run ended")
+	}
+}
+
+// gatedTool blocks until released so messages can arrive mid-run
+type gatedTool struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (g *gatedTool) Name() string        { return "gated" }
+func (g *gatedTool) Description() string { return "Waits until released" }
+func (g *gatedTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+}
+func (g *gatedTool) Execute(ctx context.Context, args map[string]interface{}) *tools.ToolResult {
+	g.started <- struct{}{}
+	<-g.release
+	return tools.SilentResult("released")
+}
+
+// steeringMockProvider calls the gated tool once and then records what it sees
+type steeringMockProvider struct {
+	mu    sync.Mutex
+	calls [][]providers.Message
+}
+
+func (m *steeringMockProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, messages)
+	if len(m.calls) == 1 {
+		return &providers.LLMResponse{ToolCalls: []providers.ToolCall{{ID: "call_1", Name: "gated", Arguments: map[string]interface{}{}}}}, nil
+	}
+	return &providers.LLMResponse{Content: "Done with both"}, nil
+}
+
+func (m *steeringMockProvider) GetDefaultModel() string {
+	return "mock-steering-model"
+}
+
+func TestAgentLoop_SteeringInjectsMessageIntoRun(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+	}
+	cfg.Channels.Telegram.Steering = true
+	msgBus := bus.NewMessageBus()
+	provider := &steeringMockProvider{}
+	al := NewAgentLoop(cfg, msgBus, provider)
+	tool := &gatedTool{started: make(chan struct{}, 1), release: make(chan struct{})}
+	al.RegisterTool(tool)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go al.Run(ctx)
+
+	msgBus.PublishInbound(bus.InboundMessage{Channel: "telegram", SenderID: "user1", ChatID: "chat1", Content: "first task"})
+	select {
+	case <-tool.started:
+	case <-time.After(responseTimeout):
+		t.Fatal("tool did not start")
+	}
+
+	msgBus.PublishInbound(bus.InboundMessage{Channel: "telegram", SenderID: "user1", ChatID: "chat1", Content: "and also this"})
+	// Give the loop a moment to hand the message to the run.
+	time.Sleep(100 * time.Millisecond)
+	close(tool.release)
+
+	waitForOutbound(t, msgBus, "Done with both")
+
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	if len(provider.calls) != 2 {
+		t.Fatalf("expected 2 LLM calls, got %d", len(provider.calls))
+	}
+	second := provider.calls[1]
+	if last := second[len(second)-1]; last.Role != "user" || last.Content != "and also this" {
+		t.Errorf("expected steering message at the end of the second call, got %+v", last)
+	}
+}
+
+// switchingMockProvider makes one tool call per turn, so each turn asks for
+// a model twice, and records the models asked for. Summaries, sent without
+// tools, are not recorded.
+type switchingMockProvider struct {
+	mu     sync.Mutex
+	models []string
+}
+
+func (m *switchingMockProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	if len(tools) == 0 {
+		return &providers.LLMResponse{Content: "summary"}, nil
+	}
+	m.mu.Lock()
+	m.models = append(m.models, model)
+	m.mu.Unlock()
+	if messages[len(messages)-1].Role == "user" {
+		return &providers.LLMResponse{ToolCalls: []providers.ToolCall{{ID: "call_1", Name: "missing", Arguments: map[string]interface{}{}}}}, nil
+	}
+	return &providers.LLMResponse{Content: "done"}, nil
+}
+
+func (m *switchingMockProvider) GetDefaultModel() string {
+	return "mock-switching-model"
+}
+
+// Run with -race: /switch model in one session must not race with turns
+// running in another.
+func TestAgentLoop_SwitchModelDuringRun(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "model-a",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+				MaxConcurrency:    4,
+			},
+		},
+		Session: config.SessionConfig{DMScope: "per-peer"},
+	}
+	msgBus := bus.NewMessageBus()
+	provider := &switchingMockProvider{}
+	al := NewAgentLoop(cfg, msgBus, provider)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go al.Run(ctx)
+
+	direct := map[string]string{"peer_kind": "direct"}
+	const turns = 10
+	for i := 0; i < turns; i++ {
+		msgBus.PublishInbound(bus.InboundMessage{Channel: "telegram", SenderID: "user1", ChatID: "chat1", Content: "work", Metadata: direct})
+		model := "model-a"
+		if i%2 == 0 {
+			model = "model-b"
+		}
+		msgBus.PublishInbound(bus.InboundMessage{Channel: "telegram", SenderID: "user2", ChatID: "chat2", Content: "/switch model to " + model, Metadata: direct})
+	}
+
+	replies := 0
+	timeout, cancelWait := context.WithTimeout(context.Background(), responseTimeout)
+	defer cancelWait()
+	for replies < turns {
+		out, ok := msgBus.SubscribeOutbound(timeout)
+		if !ok {
+			t.Fatalf("timed out after %d of %d replies", replies, turns)
+		}
+		if out.ChatID == "chat1" && out.Content == "done" {
+			replies++
+		}
+	}
+
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	if len(provider.models) != 2*turns {
+		t.Fatalf("models = %v, want two calls per turn", provider.models)
+	}
+	for i := 0; i < len(provider.models); i += 2 {
+		if provider.models[i] != provider.models[i+1] {
+			t.Errorf("turn %d switched model midway: %v", i/2, provider.models[i:i+2])
+		}
+	}
+}
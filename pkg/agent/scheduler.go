@@ -0,0 +1,65 @@
+package agent
+
+import "sync"
+
+// sessionScheduler runs jobs for different sessions in parallel, bounded by
+// a global limit, while jobs that share a session key run one at a time in
+// the order they were submitted.
+type sessionScheduler struct {
+	sem    chan struct{}
+	mu     sync.Mutex
+	queues map[string][]func()
+	wg     sync.WaitGroup
+}
+
+func newSessionScheduler(limit int) *sessionScheduler {
+	if limit < 1 {
+		limit = 1
+	}
+	return &sessionScheduler{
+		sem:    make(chan struct{}, limit),
+		queues: make(map[string][]func()),
+	}
+}
+
+// submit queues job behind any pending work for key. A worker goroutine is
+// started for the key if none is running.
+func (s *sessionScheduler) submit(key string, job func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queue, active := s.queues[key]
+	s.queues[key] = append(queue, job)
+	if active {
+		return
+	}
+
+	s.wg.Add(1)
+	go s.drain(key)
+}
+
+func (s *sessionScheduler) drain(key string) {
+	defer s.wg.Done()
+
+	for {
+		s.mu.Lock()
+		queue := s.queues[key]
+		if len(queue) == 0 {
+			delete(s.queues, key)
+			s.mu.Unlock()
+			return
+		}
+		job := queue[0]
+		s.queues[key] = queue[1:]
+		s.mu.Unlock()
+
+		s.sem <- struct{}{}
+		job()
+		<-s.sem
+	}
+}
+
+// wait blocks until every submitted job has finished.
+func (s *sessionScheduler) wait() {
+	s.wg.Wait()
+}
@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSessionScheduler_PreservesOrderPerKey(t *testing.T) {
+	s := newSessionScheduler(4)
+
+	var mu sync.Mutex
+	var got []int
+	for i := 0; i < 20; i++ {
+		i := i
+		s.submit("session-a", func() {
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			got = append(got, i)
+			mu.Unlock()
+		})
+	}
+	s.wait()
+
+	if len(got) != 20 {
+		t.Fatalf("expected 20 jobs to run, got %d", len(got))
+	}
+	for i, v := range got {
+		if v != i {
+			t.Fatalf("job order = %v, want ascending", got)
+		}
+	}
+}
+
+func TestSessionScheduler_RunsKeysInParallel(t *testing.T) {
+	s := newSessionScheduler(2)
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+	for _, key := range []string{"a", "b"} {
+		s.submit(key, func() {
+			started <- struct{}{}
+			<-release
+		})
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Fatal("expected both sessions to run concurrently")
+		}
+	}
+	close(release)
+	s.wait()
+}
+
+func TestSessionScheduler_RespectsLimit(t *testing.T) {
+	s := newSessionScheduler(2)
+
+	var running, peak atomic.Int32
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		s.submit(key, func() {
+			n := running.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			running.Add(-1)
+		})
+	}
+	s.wait()
+
+	if peak.Load() > 2 {
+		t.Fatalf("peak concurrency = %d, want <= 2", peak.Load())
+	}
+}
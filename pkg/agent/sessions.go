@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/session"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+// maxListedSessions caps how many archived sessions /sessions shows.
+const maxListedSessions = 10
+
+// newSession handles /new: the current conversation is archived and the
+// next message starts an empty one.
+func (al *AgentLoop) newSession(agent *AgentInstance, sessionKey string) string {
+	archiveKey, err := agent.Sessions.Archive(sessionKey)
+	if err != nil {
+		return fmt.Sprintf("Failed to archive the current session: %v", err)
+	}
+	if archiveKey == "" {
+		return "Started a new session."
+	}
+	id := session.ArchiveID(archiveKey)
+	return fmt.Sprintf("Started a new session. The previous one was archived as %s; use /resume %s to go back to it.", id, id)
+}
+
+// listSessions handles /sessions.
+func (al *AgentLoop) listSessions(agent *AgentInstance, sessionKey string) string {
+	archives, err := agent.Sessions.Archives(sessionKey)
+	if err != nil {
+		return fmt.Sprintf("Failed to list sessions: %v", err)
+	}
+
+	var sb strings.Builder
+	if current := agent.Sessions.Get(sessionKey); current != nil && len(current.Messages) > 0 {
+		fmt.Fprintf(&sb, "Current session: %d messages, updated %s\n",
+			len(current.Messages), current.Updated.Format("2006-01-02 15:04"))
+	} else {
+		sb.WriteString("Current session: empty\n")
+	}
+
+	if len(archives) == 0 {
+		sb.WriteString("No archived sessions.")
+		return sb.String()
+	}
+	if len(archives) > maxListedSessions {
+		archives = archives[:maxListedSessions]
+	}
+	sb.WriteString("Archived sessions:")
+	for i, info := range archives {
+		fmt.Fprintf(&sb, "\n%d. %s, %d messages, updated %s",
+			i+1, session.ArchiveID(info.Key), info.Messages, info.Updated.Format("2006-01-02 15:04"))
+		if preview := sessionPreview(info); preview != "" {
+			fmt.Fprintf(&sb, "\n   %s", preview)
+		}
+	}
+	sb.WriteString("\nUse /resume <number or id> to go back to one.")
+	return sb.String()
+}
+
+// resumeSession handles /resume. ref is a number from /sessions or an
+// archive ID.
+func (al *AgentLoop) resumeSession(agent *AgentInstance, sessionKey, ref string) string {
+	archives, err := agent.Sessions.Archives(sessionKey)
+	if err != nil {
+		return fmt.Sprintf("Failed to list sessions: %v", err)
+	}
+
+	archiveKey := ""
+	if n, err := strconv.Atoi(ref); err == nil && n >= 1 && n <= len(archives) && n <= maxListedSessions {
+		archiveKey = archives[n-1].Key
+	} else {
+		for _, info := range archives {
+			if session.ArchiveID(info.Key) == ref {
+				archiveKey = info.Key
+				break
+			}
+		}
+	}
+	if archiveKey == "" {
+		return fmt.Sprintf("No archived session %s. Use /sessions to list them.", ref)
+	}
+
+	previous, err := agent.Sessions.Restore(sessionKey, archiveKey)
+	if err != nil {
+		return fmt.Sprintf("Failed to resume session %s: %v", session.ArchiveID(archiveKey), err)
+	}
+	reply := fmt.Sprintf("Resumed session %s.", session.ArchiveID(archiveKey))
+	if previous != "" {
+		reply += fmt.Sprintf(" The conversation you left was archived as %s.", session.ArchiveID(previous))
+	}
+	return reply
+}
+
+// sessionSummary handles /summary.
+func (al *AgentLoop) sessionSummary(agent *AgentInstance, sessionKey string) string {
+	summary := agent.Sessions.GetSummary(sessionKey)
+	if summary == "" {
+		return "This session has no summary yet. One is written once the conversation grows long."
+	}
+	return "Session summary:\n" + summary
+}
+
+func sessionPreview(info session.Info) string {
+	text := info.Summary
+	if text == "" {
+		text = info.Preview
+	}
+	return utils.Truncate(strings.Join(strings.Fields(text), " "), 80)
+}
@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func TestAgentLoop_SessionCommands(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+	}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), &mockProvider{})
+	helper := testHelper{al: al}
+	ctx := context.Background()
+	msg := func(content string) bus.InboundMessage {
+		return bus.InboundMessage{Channel: "telegram", SenderID: "user1", ChatID: "chat1", Content: content}
+	}
+	command := func(content string) string {
+		reply, handled := al.handleCommand(ctx, msg(content))
+		if !handled {
+			t.Fatalf("expected %s to be handled", content)
+		}
+		return reply
+	}
+
+	helper.executeAndGetResponse(t, ctx, msg("remember the blue door"))
+	agent, sessionKey, _ := al.resolveRoute(msg(""))
+	if n := len(agent.Sessions.GetHistory(sessionKey)); n == 0 {
+		t.Fatal("expected history after the first message")
+	}
+
+	if reply := command("/new"); !strings.Contains(reply, "archived as") {
+		t.Fatalf("unexpected /new reply: %s", reply)
+	}
+	if n := len(agent.Sessions.GetHistory(sessionKey)); n != 0 {
+		t.Fatalf("expected an empty session after /new, got %d messages", n)
+	}
+
+	list := command("/sessions")
+	if !strings.Contains(list, "Current session: empty") || !strings.Contains(list, "1. ") ||
+		!strings.Contains(list, "remember the blue door") {
+		t.Fatalf("unexpected /sessions reply: %s", list)
+	}
+
+	if reply := command("/resume 1"); !strings.HasPrefix(reply, "Resumed session") {
+		t.Fatalf("unexpected /resume reply: %s", reply)
+	}
+	history := agent.Sessions.GetHistory(sessionKey)
+	if len(history) == 0 || history[0].Content != "remember the blue door" {
+		t.Fatalf("unexpected history after /resume: %+v", history)
+	}
+
+	if reply := command("/resume 7"); !strings.Contains(reply, "No archived session 7") {
+		t.Fatalf("unexpected reply for an unknown archive: %s", reply)
+	}
+	if reply := command("/summary"); !strings.Contains(reply, "no summary yet") {
+		t.Fatalf("unexpected /summary reply: %s", reply)
+	}
+}
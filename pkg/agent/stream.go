@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+)
+
+// streamEditInterval bounds how often a partial reply is pushed to a channel.
+// Chat platforms rate-limit message edits, so roughly one per second is the
+// most that can be sustained.
+const streamEditInterval = time.Second
+
+// replyStreamer collects text deltas from a streaming LLM call and publishes
+// the accumulated reply as partial outbound messages, throttled to
+// streamEditInterval.
+type replyStreamer struct {
+	bus      *bus.MessageBus
+	channel  string
+	chatID   string
+	interval time.Duration
+
+	mu       sync.Mutex
+	buf      strings.Builder
+	lastSent time.Time
+}
+
+func newReplyStreamer(msgBus *bus.MessageBus, channel, chatID string) *replyStreamer {
+	return &replyStreamer{
+		bus:      msgBus,
+		channel:  channel,
+		chatID:   chatID,
+		interval: streamEditInterval,
+	}
+}
+
+// onDelta appends a fragment and publishes the reply so far if enough time
+// has passed since the previous update.
+func (s *replyStreamer) onDelta(delta string) {
+	s.mu.Lock()
+	s.buf.WriteString(delta)
+	if time.Since(s.lastSent) < s.interval {
+		s.mu.Unlock()
+		return
+	}
+	content := s.buf.String()
+	s.lastSent = time.Now()
+	s.mu.Unlock()
+
+	if strings.TrimSpace(content) == "" {
+		return
+	}
+	s.bus.PublishOutbound(bus.OutboundMessage{
+		Channel: s.channel,
+		ChatID:  s.chatID,
+		Content: content,
+		Partial: true,
+	})
+}
+
+// reset discards buffered text before a new LLM call so each call's reply
+// starts from an empty message.
+func (s *replyStreamer) reset() {
+	s.mu.Lock()
+	s.buf.Reset()
+	s.mu.Unlock()
+}
@@ -0,0 +1,149 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/session"
+)
+
+// lastExchangeStart returns the index of the user message that began the
+// last exchange in history, or -1 if there is none. Messages steered into a
+// running turn follow tool results and belong to the exchange they joined,
+// so cutting history here never separates a tool call from its results.
+func lastExchangeStart(history []providers.Message) int {
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Role == "user" && (i == 0 || history[i-1].Role != "tool") {
+			return i
+		}
+	}
+	return -1
+}
+
+// trimDanglingToolCalls drops a trailing assistant tool call whose results
+// are missing, e.g. after a run failed midway, together with the results it
+// did get. Providers reject transcripts with unanswered tool calls.
+func trimDanglingToolCalls(history []providers.Message) []providers.Message {
+	for {
+		last := -1
+		for i := len(history) - 1; i >= 0; i-- {
+			if history[i].Role == "assistant" && len(history[i].ToolCalls) > 0 {
+				last = i
+				break
+			}
+			if history[i].Role != "tool" {
+				return history
+			}
+		}
+		if last < 0 {
+			return history
+		}
+
+		answered := make(map[string]bool)
+		for _, m := range history[last+1:] {
+			answered[m.ToolCallID] = true
+		}
+		complete := true
+		for _, tc := range history[last].ToolCalls {
+			if !answered[tc.ID] {
+				complete = false
+				break
+			}
+		}
+		if complete {
+			return history
+		}
+		history = history[:last]
+	}
+}
+
+// dropLastExchange removes the last exchange from the session and returns
+// the user messages that started it, with their media.
+func dropLastExchange(agent *AgentInstance, sessionKey string) ([]providers.Message, bool) {
+	history := agent.Sessions.GetHistory(sessionKey)
+	start := lastExchangeStart(history)
+	if start < 0 {
+		return nil, false
+	}
+
+	var userMessages []providers.Message
+	for _, m := range history[start:] {
+		if m.Role == "user" {
+			userMessages = append(userMessages, m)
+		}
+	}
+	agent.Sessions.SetHistory(sessionKey, trimDanglingToolCalls(history[:start]))
+	agent.Sessions.Save(sessionKey)
+	return userMessages, true
+}
+
+// undoTurn handles /undo.
+func (al *AgentLoop) undoTurn(agent *AgentInstance, sessionKey string) string {
+	if _, ok := dropLastExchange(agent, sessionKey); !ok {
+		return "Nothing to undo."
+	}
+	return "Removed your last message and the reply to it."
+}
+
+// retryTurn handles /retry: the last exchange is dropped and its user
+// message answered again, with model if one is given.
+func (al *AgentLoop) retryTurn(ctx context.Context, agent *AgentInstance, sessionKey string, msg bus.InboundMessage, model string) string {
+	userMessages, ok := dropLastExchange(agent, sessionKey)
+	if !ok {
+		return "Nothing to retry."
+	}
+	contents := make([]string, 0, len(userMessages))
+	var media []string
+	for _, m := range userMessages {
+		contents = append(contents, m.Content)
+		media = append(media, m.Media...)
+	}
+
+	response, err := al.runAgentLoop(ctx, agent, processOptions{
+		SessionKey:      sessionKey,
+		Channel:         msg.Channel,
+		ChatID:          msg.ChatID,
+		UserMessage:     strings.Join(contents, "\n\n"),
+		Media:           media,
+		DefaultResponse: "I've completed processing but have no response to give.",
+		EnableSummary:   true,
+		SendResponse:    false,
+		Stream:          true,
+		Model:           model,
+	})
+	if err != nil {
+		return fmt.Sprintf("Error processing message: %v", err)
+	}
+	return response
+}
+
+// branchSession handles /branch: the conversation so far is copied to an
+// archived session that /resume can return to.
+func (al *AgentLoop) branchSession(agent *AgentInstance, sessionKey, name string) string {
+	if name != "" && !validBranchName(name) {
+		return "Branch names may only contain letters, digits, '.', '-' and '_'."
+	}
+	forkKey, err := agent.Sessions.Fork(sessionKey, name)
+	if err != nil {
+		return fmt.Sprintf("Failed to branch the session: %v", err)
+	}
+	if forkKey == "" {
+		return "Nothing to branch yet."
+	}
+	id := session.ArchiveID(forkKey)
+	return fmt.Sprintf("Saved this conversation as branch %s. Carry on here; /resume %s goes back to this point.", id, id)
+}
+
+func validBranchName(name string) bool {
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+		default:
+			return false
+		}
+	}
+	return name != "." && name != ".."
+}
@@ -0,0 +1,175 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+func TestLastExchangeStart(t *testing.T) {
+	history := []providers.Message{
+		{Role: "user", Content: "first"},
+		{Role: "assistant", Content: "one"},
+		{Role: "user", Content: "second"},
+		{Role: "assistant", ToolCalls: []providers.ToolCall{{ID: "call_1", Name: "exec"}}},
+		{Role: "tool", ToolCallID: "call_1", Content: "done"},
+		{Role: "user", Content: "also this"},
+		{Role: "assistant", Content: "two"},
+	}
+	if got := lastExchangeStart(history); got != 2 {
+		t.Fatalf("expected the exchange to start at 2 despite the steered message, got %d", got)
+	}
+	if got := lastExchangeStart(nil); got != -1 {
+		t.Fatalf("expected -1 for empty history, got %d", got)
+	}
+}
+
+func TestTrimDanglingToolCalls(t *testing.T) {
+	history := []providers.Message{
+		{Role: "user", Content: "first"},
+		{Role: "assistant", ToolCalls: []providers.ToolCall{{ID: "call_1"}, {ID: "call_2"}}},
+		{Role: "tool", ToolCallID: "call_1", Content: "done"},
+	}
+	if got := trimDanglingToolCalls(history); len(got) != 1 {
+		t.Fatalf("expected the unanswered tool call to be dropped, got %+v", got)
+	}
+
+	history = append(history, providers.Message{Role: "tool", ToolCallID: "call_2", Content: "done"})
+	if got := trimDanglingToolCalls(history); len(got) != len(history) {
+		t.Fatalf("expected answered tool calls to be kept, got %+v", got)
+	}
+}
+
+// modelRecordingProvider answers with the model it was asked for.
+type modelRecordingProvider struct {
+	mu     sync.Mutex
+	models []string
+}
+
+func (m *modelRecordingProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	m.mu.Lock()
+	m.models = append(m.models, model)
+	m.mu.Unlock()
+	return &providers.LLMResponse{Content: "answer from " + model}, nil
+}
+
+func (m *modelRecordingProvider) GetDefaultModel() string {
+	return "test-model"
+}
+
+func TestAgentLoop_TurnCommands(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+	}
+	provider := &modelRecordingProvider{}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+	helper := testHelper{al: al}
+	ctx := context.Background()
+	msg := func(content string) bus.InboundMessage {
+		return bus.InboundMessage{Channel: "telegram", SenderID: "user1", ChatID: "chat1", Content: content}
+	}
+	command := func(content string) string {
+		reply, handled := al.handleCommand(ctx, msg(content))
+		if !handled {
+			t.Fatalf("expected %s to be handled", content)
+		}
+		return reply
+	}
+
+	if reply := command("/undo"); reply != "Nothing to undo." {
+		t.Fatalf("unexpected /undo reply on an empty session: %s", reply)
+	}
+
+	helper.executeAndGetResponse(t, ctx, msg("first question"))
+	helper.executeAndGetResponse(t, ctx, msg("second question"))
+	agent, sessionKey, _ := al.resolveRoute(msg(""))
+
+	if reply := command("/retry other-model"); reply != "answer from other-model" {
+		t.Fatalf("unexpected /retry reply: %s", reply)
+	}
+	history := agent.Sessions.GetHistory(sessionKey)
+	if len(history) != 4 || history[2].Content != "second question" || history[3].Content != "answer from other-model" {
+		t.Fatalf("expected the last exchange to be replaced, got %+v", history)
+	}
+
+	if reply := command("/branch bad/name"); !strings.Contains(reply, "may only contain") {
+		t.Fatalf("expected an invalid branch name to be rejected: %s", reply)
+	}
+	if reply := command("/branch before-undo"); !strings.Contains(reply, "/resume before-undo") {
+		t.Fatalf("unexpected /branch reply: %s", reply)
+	}
+
+	if reply := command("/undo"); !strings.HasPrefix(reply, "Removed your last message") {
+		t.Fatalf("unexpected /undo reply: %s", reply)
+	}
+	history = agent.Sessions.GetHistory(sessionKey)
+	if len(history) != 2 || history[0].Content != "first question" {
+		t.Fatalf("expected only the first exchange to remain, got %+v", history)
+	}
+
+	if reply := command("/resume before-undo"); !strings.HasPrefix(reply, "Resumed session") {
+		t.Fatalf("unexpected /resume reply: %s", reply)
+	}
+	if n := len(agent.Sessions.GetHistory(sessionKey)); n != 4 {
+		t.Fatalf("expected the branch to hold both exchanges, got %d messages", n)
+	}
+}
+
+func TestAgentLoop_RetryKeepsImages(t *testing.T) {
+	tmpDir := t.TempDir()
+	imagePath := filepath.Join(tmpDir, "photo.png")
+	if err := os.WriteFile(imagePath, []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), 0644); err != nil {
+		t.Fatalf("Failed to write image: %v", err)
+	}
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         tmpDir,
+				Model:             "text-model",
+				ImageModel:        "vision-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+	}
+	provider := &recordingMockProvider{}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+	ctx := context.Background()
+	msg := bus.InboundMessage{
+		Channel:  "telegram",
+		SenderID: "user1",
+		ChatID:   "chat1",
+		Content:  "what is this? [image: photo]",
+		Media:    []string{imagePath},
+	}
+	testHelper{al: al}.executeAndGetResponse(t, ctx, msg)
+
+	provider.model = ""
+	provider.messages = nil
+	msg.Content = "/retry"
+	msg.Media = nil
+	if _, handled := al.handleCommand(ctx, msg); !handled {
+		t.Fatal("expected /retry to be handled")
+	}
+	if provider.model != "vision-model" {
+		t.Errorf("Expected the retry to use the image model, got %q", provider.model)
+	}
+	last := provider.messages[len(provider.messages)-1]
+	if !last.HasImages() || !strings.HasSuffix(last.Content, "what is this? [image: photo]") {
+		t.Fatalf("Expected the retried turn to carry its image, got %+v", last)
+	}
+}
@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/tools"
+	"github.com/sipeed/picoclaw/pkg/usage"
+)
+
+// budgetModel checks the agent's budget before an LLM call. It returns the
+// model to downgrade to, "" to use the agent's normal model chain, or an
+// error when the call must be refused.
+func (al *AgentLoop) budgetModel(agent *AgentInstance) (string, error) {
+	if al.ledger == nil {
+		return "", nil
+	}
+	err := al.ledger.CheckBudget(agent.ID, agent.Budget, time.Now())
+	if err == nil {
+		return "", nil
+	}
+	if agent.Budget.OnExceed == config.BudgetActionDowngrade && agent.Budget.DowngradeModel != "" {
+		logger.WarnCF("agent", "Budget exceeded, downgrading model",
+			map[string]interface{}{
+				"agent_id": agent.ID,
+				"model":    agent.Budget.DowngradeModel,
+				"reason":   err.Error(),
+			})
+		return agent.Budget.DowngradeModel, nil
+	}
+	logger.WarnCF("agent", "Budget exceeded, refusing LLM call",
+		map[string]interface{}{
+			"agent_id": agent.ID,
+			"reason":   err.Error(),
+		})
+	return "", err
+}
+
+// recordUsage adds the token usage of resp to the ledger.
+func (al *AgentLoop) recordUsage(agent *AgentInstance, sessionKey, model string, resp *providers.LLMResponse) {
+	if al.ledger == nil || resp == nil || resp.Usage == nil {
+		return
+	}
+	err := al.ledger.Record(usage.Record{
+		AgentID:          agent.ID,
+		SessionKey:       sessionKey,
+		Model:            model,
+		PromptTokens:     resp.Usage.PromptTokens,
+		CompletionTokens: resp.Usage.CompletionTokens,
+		TotalTokens:      resp.Usage.TotalTokens,
+		CachedTokens:     resp.Usage.CachedTokens,
+	})
+	if err != nil {
+		logger.WarnCF("agent", "Failed to record usage",
+			map[string]interface{}{
+				"agent_id": agent.ID,
+				"error":    err.Error(),
+			})
+	}
+}
+
+// usageAccount charges the LLM calls tools make during a run of sessionKey,
+// such as subagent runs, to the agent's ledger and budget.
+func (al *AgentLoop) usageAccount(agent *AgentInstance, sessionKey string) tools.UsageAccount {
+	return tools.UsageAccount{
+		Record: func(model string, u *providers.UsageInfo) {
+			al.recordUsage(agent, sessionKey, model, &providers.LLMResponse{Usage: u})
+		},
+		Budget: func() (string, error) {
+			return al.budgetModel(agent)
+		},
+	}
+}
+
+// usageReport renders the /usage command reply for an agent and session.
+func (al *AgentLoop) usageReport(agent *AgentInstance, sessionKey string) string {
+	if al.ledger == nil {
+		return "Usage tracking is not available"
+	}
+
+	now := time.Now()
+	records, err := al.ledger.Query(usage.StartOfMonth(now), func(r usage.Record) bool {
+		return r.AgentID == agent.ID
+	})
+	if err != nil {
+		return fmt.Sprintf("Failed to read usage: %v", err)
+	}
+
+	today := usage.StartOfDay(now)
+	var todayRecords, sessionRecords []usage.Record
+	for _, r := range records {
+		if !r.Time.Before(today) {
+			todayRecords = append(todayRecords, r)
+		}
+		if r.SessionKey == sessionKey {
+			sessionRecords = append(sessionRecords, r)
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Usage for agent %s\n", agent.ID)
+	fmt.Fprintf(&sb, "Today: %s\n", formatTotals(usage.Sum(todayRecords)))
+	fmt.Fprintf(&sb, "This month: %s\n", formatTotals(usage.Sum(records)))
+	fmt.Fprintf(&sb, "This session (this month): %s", formatTotals(usage.Sum(sessionRecords)))
+
+	day, month := al.ledger.Spent(agent.ID, now)
+	if agent.Budget.DailyUSD > 0 {
+		fmt.Fprintf(&sb, "\nDaily budget: $%.4f of $%.2f", day, agent.Budget.DailyUSD)
+	}
+	if agent.Budget.MonthlyUSD > 0 {
+		fmt.Fprintf(&sb, "\nMonthly budget: $%.4f of $%.2f", month, agent.Budget.MonthlyUSD)
+	}
+	if err := al.ledger.CheckBudget(agent.ID, agent.Budget, now); errors.Is(err, usage.ErrBudgetExceeded) {
+		if agent.Budget.OnExceed == config.BudgetActionDowngrade && agent.Budget.DowngradeModel != "" {
+			fmt.Fprintf(&sb, "\nBudget exceeded: using %s", agent.Budget.DowngradeModel)
+		} else {
+			sb.WriteString("\nBudget exceeded: LLM calls are refused")
+		}
+	}
+	return sb.String()
+}
+
+func formatTotals(t usage.Totals) string {
+	return fmt.Sprintf("%d calls, %d tokens (%d in, %d cached / %d out), $%.4f",
+		t.Calls, t.TotalTokens, t.PromptTokens, t.CachedTokens, t.CompletionTokens, t.CostUSD)
+}
@@ -0,0 +1,151 @@
+package agent
+
+import (
+	"encoding/json"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/tokenizer"
+)
+
+const (
+	// messageOverheadTokens covers the role markers and separators that
+	// chat formats add around each message.
+	messageOverheadTokens = 4
+	// imageTokens approximates the prompt cost of one attached image.
+	imageTokens = 1024
+	// contextMarginPercent of the context window is left unused, since
+	// token counts are estimates unless the model has an exact tokenizer.
+	contextMarginPercent = 10
+)
+
+// countMessageTokens counts the tokens of messages, including tool-call
+// names and arguments and attached images.
+func countMessageTokens(tk tokenizer.Tokenizer, messages []providers.Message) int {
+	total := 0
+	for _, m := range messages {
+		total += countTokens(tk, m)
+	}
+	return total
+}
+
+func countTokens(tk tokenizer.Tokenizer, m providers.Message) int {
+	n := messageOverheadTokens + tk.Count(m.Content)
+	for _, tc := range m.ToolCalls {
+		n += tk.Count(tc.Name)
+		if tc.Function != nil {
+			n += tk.Count(tc.Function.Name) + tk.Count(tc.Function.Arguments)
+		} else if len(tc.Arguments) > 0 {
+			args, _ := json.Marshal(tc.Arguments)
+			n += tk.Count(string(args))
+		}
+	}
+	for _, p := range m.Parts {
+		if p.Type == providers.ContentPartImage {
+			n += imageTokens
+		}
+	}
+	return n
+}
+
+// countToolTokens counts the tokens of the tool definitions sent with a request.
+func countToolTokens(tk tokenizer.Tokenizer, defs []providers.ToolDefinition) int {
+	if len(defs) == 0 {
+		return 0
+	}
+	data, err := json.Marshal(defs)
+	if err != nil {
+		return 0
+	}
+	return tk.Count(string(data))
+}
+
+// turnStart returns the index of the first message of the current turn: the
+// last user message. Everything from there on must be sent as is.
+func turnStart(messages []providers.Message) int {
+	for i := len(messages) - 1; i > 0; i-- {
+		if messages[i].Role == "user" {
+			return i
+		}
+	}
+	return len(messages)
+}
+
+// nextTurn returns the index of the first user message at or after i in
+// history, so that a cut there never separates an assistant tool call from
+// its tool results.
+func nextTurn(history []providers.Message, i int) int {
+	for i < len(history) && history[i].Role != "user" {
+		i++
+	}
+	return i
+}
+
+// fitContext trims the oldest history from messages so the request fits the
+// agent's context window with room for the reply. messages is expected to be
+// the system prompt, then history, then the current turn. History is only
+// dropped a whole turn at a time; the system prompt and current turn are
+// always kept.
+func (al *AgentLoop) fitContext(agent *AgentInstance, messages []providers.Message, toolDefs []providers.ToolDefinition) []providers.Message {
+	tk := agent.modelState().Tokenizer
+	budget := agent.ContextWindow*(100-contextMarginPercent)/100 - agent.Generation.MaxTokens - countToolTokens(tk, toolDefs)
+	total := countMessageTokens(tk, messages)
+	if total <= budget || len(messages) < 3 {
+		return messages
+	}
+
+	start := turnStart(messages)
+	history := messages[1:start]
+
+	excess := total - budget
+	freed := 0
+	cut := 0
+	for cut < len(history) && freed < excess {
+		end := nextTurn(history, cut+1)
+		freed += countMessageTokens(tk, history[cut:end])
+		cut = end
+	}
+	if cut == 0 {
+		logger.WarnCF("agent", "Current turn exceeds the context window",
+			map[string]interface{}{
+				"agent_id": agent.ID,
+				"tokens":   total,
+				"budget":   budget,
+			})
+		return messages
+	}
+
+	dropped := history[:cut]
+	roles := make(map[string]int)
+	for _, m := range dropped {
+		roles[m.Role]++
+	}
+
+	trimmed := make([]providers.Message, 0, len(messages)-cut)
+	trimmed = append(trimmed, messages[0])
+	trimmed = append(trimmed, history[cut:]...)
+	trimmed = append(trimmed, messages[start:]...)
+
+	logger.DebugCF("agent", "Trimmed history to fit context window",
+		map[string]interface{}{
+			"agent_id":        agent.ID,
+			"budget":          budget,
+			"tokens_before":   total,
+			"tokens_after":    total - freed,
+			"dropped_msgs":    len(dropped),
+			"dropped_tokens":  freed,
+			"dropped_roles":   roles,
+			"remaining_msgs":  len(trimmed),
+			"context_window":  agent.ContextWindow,
+			"reserved_output": agent.Generation.MaxTokens,
+		})
+	if total-freed > budget {
+		logger.WarnCF("agent", "Request still exceeds the context window after trimming history",
+			map[string]interface{}{
+				"agent_id": agent.ID,
+				"tokens":   total - freed,
+				"budget":   budget,
+			})
+	}
+	return trimmed
+}
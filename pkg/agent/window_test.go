@@ -0,0 +1,68 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/tokenizer"
+)
+
+func TestCountMessageTokens_IncludesToolCallArguments(t *testing.T) {
+	tk := tokenizer.Heuristic{CharsPerToken: 1}
+	plain := []providers.Message{{Role: "assistant", Content: "ok"}}
+	withCall := []providers.Message{{
+		Role:    "assistant",
+		Content: "ok",
+		ToolCalls: []providers.ToolCall{{
+			ID:       "call_1",
+			Function: &providers.FunctionCall{Name: "read_file", Arguments: `{"path":"notes.txt"}`},
+		}},
+	}}
+
+	if countMessageTokens(tk, withCall) <= countMessageTokens(tk, plain)+len(`{"path":"notes.txt"}`) {
+		t.Errorf("expected tool call name and arguments to be counted")
+	}
+}
+
+func TestFitContext_DropsWholeTurns(t *testing.T) {
+	filler := strings.Repeat("x", 400)
+	messages := []providers.Message{
+		{Role: "system", Content: "system"},
+		{Role: "user", Content: filler},
+		{Role: "assistant", ToolCalls: []providers.ToolCall{{ID: "call_1", Name: "exec"}}},
+		{Role: "tool", ToolCallID: "call_1", Content: filler},
+		{Role: "assistant", Content: "done"},
+		{Role: "user", Content: "second question"},
+		{Role: "assistant", ToolCalls: []providers.ToolCall{{ID: "call_2", Name: "exec"}}},
+		{Role: "tool", ToolCallID: "call_2", Content: "result"},
+		{Role: "assistant", Content: "answer"},
+		{Role: "user", Content: "current question"},
+	}
+
+	agent := &AgentInstance{
+		ID:            "main",
+		ContextWindow: 200,
+		Tokenizer:     tokenizer.Heuristic{CharsPerToken: 4},
+	}
+	al := &AgentLoop{}
+
+	got := al.fitContext(agent, messages, nil)
+
+	if len(got) != 6 {
+		t.Fatalf("expected the first turn to be dropped, got %d messages", len(got))
+	}
+	if got[0].Role != "system" || got[1].Content != "second question" || got[len(got)-1].Content != "current question" {
+		t.Errorf("unexpected trimmed messages: %+v", got)
+	}
+	for i, m := range got {
+		if m.Role == "tool" && (i == 0 || len(got[i-1].ToolCalls) == 0) {
+			t.Errorf("tool result at %d separated from its tool call", i)
+		}
+	}
+
+	agent.ContextWindow = 100000
+	if got := al.fitContext(agent, messages, nil); len(got) != len(messages) {
+		t.Errorf("expected no trimming when the request fits, got %d messages", len(got))
+	}
+}
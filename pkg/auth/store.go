@@ -5,6 +5,8 @@ import (
 	"os"
 	"path/filepath"
 	"time"
+
+	"github.com/sipeed/picoclaw/pkg/secrets"
 )
 
 type AuthCredential struct {
@@ -34,14 +36,15 @@ func (c *AuthCredential) NeedsRefresh() bool {
 	return time.Now().Add(5 * time.Minute).After(c.ExpiresAt)
 }
 
-func authFilePath() string {
+// StorePath returns where the auth store is kept.
+func StorePath() string {
 	home, _ := os.UserHomeDir()
 	return filepath.Join(home, ".picoclaw", "auth.json")
 }
 
 func LoadStore() (*AuthStore, error) {
-	path := authFilePath()
-	data, err := os.ReadFile(path)
+	path := StorePath()
+	data, err := secrets.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return &AuthStore{Credentials: make(map[string]*AuthCredential)}, nil
@@ -60,7 +63,7 @@ func LoadStore() (*AuthStore, error) {
 }
 
 func SaveStore(store *AuthStore) error {
-	path := authFilePath()
+	path := StorePath()
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return err
@@ -70,7 +73,7 @@ func SaveStore(store *AuthStore) error {
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(path, data, 0600)
+	return secrets.WriteFile(path, data, 0600)
 }
 
 func GetCredential(provider string) (*AuthCredential, error) {
@@ -104,7 +107,7 @@ func DeleteCredential(provider string) error {
 }
 
 func DeleteAllCredentials() error {
-	path := authFilePath()
+	path := StorePath()
 	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
 		return err
 	}
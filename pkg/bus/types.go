@@ -14,6 +14,21 @@ type OutboundMessage struct {
 	Channel string `json:"channel"`
 	ChatID  string `json:"chat_id"`
 	Content string `json:"content"`
+	// Partial marks an in-progress reply. Content holds the full text
+	// generated so far and replaces any earlier partial for the same chat.
+	Partial bool `json:"partial,omitempty"`
+	// End marks the end of a run in the chat. It carries no content;
+	// streaming channels drop the in-progress reply the run left behind, if
+	// no final message replaced it.
+	End bool `json:"end,omitempty"`
+	// Buttons are shown as inline buttons by channels that support them.
+	// Pressing one sends its Data back as an inbound message from the user.
+	Buttons []Button `json:"buttons,omitempty"`
+}
+
+type Button struct {
+	Label string `json:"label"`
+	Data  string `json:"data"`
 }
 
 type MessageHandler func(InboundMessage) error
@@ -16,6 +16,17 @@ type Channel interface {
 	IsAllowed(senderID string) bool
 }
 
+// StreamingChannel is implemented by channels that can edit a message after
+// sending it. SendPartial shows an in-progress reply; the final Send for the
+// same chat replaces it with the complete text. EndStream is called once the
+// run is over and removes an in-progress reply that no final Send replaced,
+// e.g. because the run failed or was stopped.
+type StreamingChannel interface {
+	Channel
+	SendPartial(ctx context.Context, msg bus.OutboundMessage) error
+	EndStream(ctx context.Context, chatID string) error
+}
+
 type BaseChannel struct {
 	config    interface{}
 	bus       *bus.MessageBus
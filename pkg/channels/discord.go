@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
@@ -25,6 +26,7 @@ type DiscordChannel struct {
 	config      config.DiscordConfig
 	transcriber *voice.GroqTranscriber
 	ctx         context.Context
+	streams     sync.Map // channelID -> messageID of the in-progress reply
 }
 
 func NewDiscordChannel(cfg config.DiscordConfig, bus *bus.MessageBus) (*DiscordChannel, error) {
@@ -107,8 +109,15 @@ func (c *DiscordChannel) Send(ctx context.Context, msg bus.OutboundMessage) erro
 
 	chunks := utils.SplitMessage(msg.Content, 2000) // Split messages into chunks, Discord length limit: 2000 chars
 
+	// Replace the streamed preview with the first chunk, if there is one.
+	if v, ok := c.streams.LoadAndDelete(channelID); ok {
+		if _, err := c.sendChunk(ctx, channelID, v.(string), chunks[0]); err == nil {
+			chunks = chunks[1:]
+		}
+	}
+
 	for _, chunk := range chunks {
-		if err := c.sendChunk(ctx, channelID, chunk); err != nil {
+		if _, err := c.sendChunk(ctx, channelID, "", chunk); err != nil {
 			return err
 		}
 	}
@@ -116,25 +125,85 @@ func (c *DiscordChannel) Send(ctx context.Context, msg bus.OutboundMessage) erro
 	return nil
 }
 
-func (c *DiscordChannel) sendChunk(ctx context.Context, channelID, content string) error {
+// SendPartial shows an in-progress reply by editing a single message. Text
+// beyond Discord's length limit is left for the final Send to split.
+func (c *DiscordChannel) SendPartial(ctx context.Context, msg bus.OutboundMessage) error {
+	if !c.IsRunning() {
+		return fmt.Errorf("discord bot not running")
+	}
+
+	channelID := msg.ChatID
+	if channelID == "" {
+		return fmt.Errorf("channel ID is empty")
+	}
+
+	runes := []rune(msg.Content)
+	if len(runes) == 0 {
+		return nil
+	}
+	if len(runes) > 2000 {
+		runes = runes[:2000]
+	}
+
+	messageID := ""
+	if v, ok := c.streams.Load(channelID); ok {
+		messageID = v.(string)
+	}
+
+	id, err := c.sendChunk(ctx, channelID, messageID, string(runes))
+	if err != nil {
+		return err
+	}
+	c.streams.Store(channelID, id)
+	return nil
+}
+
+// EndStream deletes the in-progress reply of a run that ended without a
+// final Send.
+func (c *DiscordChannel) EndStream(ctx context.Context, chatID string) error {
+	v, ok := c.streams.LoadAndDelete(chatID)
+	if !ok {
+		return nil
+	}
+	if err := c.session.ChannelMessageDelete(chatID, v.(string)); err != nil {
+		return fmt.Errorf("failed to delete discord message: %w", err)
+	}
+	return nil
+}
+
+// sendChunk posts content as a new message, or edits messageID when it is
+// set, and returns the ID of the resulting message.
+func (c *DiscordChannel) sendChunk(ctx context.Context, channelID, messageID, content string) (string, error) {
 	// 使用传入的 ctx 进行超时控制
 	sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
 	defer cancel()
 
-	done := make(chan error, 1)
+	type result struct {
+		msg *discordgo.Message
+		err error
+	}
+	done := make(chan result, 1)
 	go func() {
-		_, err := c.session.ChannelMessageSend(channelID, content)
-		done <- err
+		var r result
+		if messageID != "" {
+			r.msg, r.err = c.session.ChannelMessageEdit(channelID, messageID, content)
+		} else {
+			r.msg, r.err = c.session.ChannelMessageSend(channelID, content)
+		}
+		done <- r
 	}()
 
 	select {
-	case err := <-done:
-		if err != nil {
-			return fmt.Errorf("failed to send discord message: %w", err)
+	case r := <-done:
+		if r.err != nil {
+			return "", fmt.Errorf("failed to send discord message: %w", r.err)
 		}
-		return nil
+		if r.msg == nil {
+			return messageID, nil
+		}
+		return r.msg.ID, nil
 	case <-sendCtx.Done():
-		return fmt.Errorf("send message timeout: %w", sendCtx.Err())
+		return "", fmt.Errorf("send message timeout: %w", sendCtx.Err())
 	}
 }
 
@@ -283,6 +283,33 @@ func (m *Manager) dispatchOutbound(ctx context.Context) {
 				continue
 			}
 
+			if msg.End {
+				if sc, ok := channel.(StreamingChannel); ok {
+					if err := sc.EndStream(ctx, msg.ChatID); err != nil {
+						logger.DebugCF("channels", "Error ending stream in channel", map[string]interface{}{
+							"channel": msg.Channel,
+							"error":   err.Error(),
+						})
+					}
+				}
+				continue
+			}
+
+			if msg.Partial {
+				// Channels that cannot edit messages only get the final reply.
+				sc, ok := channel.(StreamingChannel)
+				if !ok {
+					continue
+				}
+				if err := sc.SendPartial(ctx, msg); err != nil {
+					logger.DebugCF("channels", "Error sending partial message to channel", map[string]interface{}{
+						"channel": msg.Channel,
+						"error":   err.Error(),
+					})
+				}
+				continue
+			}
+
 			if err := channel.Send(ctx, msg); err != nil {
 				logger.ErrorCF("channels", "Error sending message to channel", map[string]interface{}{
 					"channel": msg.Channel,
@@ -300,6 +327,19 @@ func (m *Manager) GetChannel(name string) (Channel, bool) {
 	return channel, ok
 }
 
+// SupportsStreaming reports whether the named channel can render partial
+// replies by editing a message in place.
+func (m *Manager) SupportsStreaming(name string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	channel, ok := m.channels[name]
+	if !ok {
+		return false
+	}
+	_, ok = channel.(StreamingChannel)
+	return ok
+}
+
 func (m *Manager) GetStatus() map[string]interface{} {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -30,6 +30,7 @@ type SlackChannel struct {
 	ctx          context.Context
 	cancel       context.CancelFunc
 	pendingAcks  sync.Map
+	streams      sync.Map // chatID -> ts of the in-progress reply
 }
 
 type slackMessageRef struct {
@@ -119,17 +120,25 @@ func (c *SlackChannel) Send(ctx context.Context, msg bus.OutboundMessage) error
 		return fmt.Errorf("invalid slack chat ID: %s", msg.ChatID)
 	}
 
-	opts := []slack.MsgOption{
-		slack.MsgOptionText(msg.Content, false),
+	sent := false
+	if ts, ok := c.streams.LoadAndDelete(msg.ChatID); ok {
+		_, _, _, err := c.api.UpdateMessageContext(ctx, channelID, ts.(string), slack.MsgOptionText(msg.Content, false))
+		sent = err == nil
 	}
 
-	if threadTS != "" {
-		opts = append(opts, slack.MsgOptionTS(threadTS))
-	}
+	if !sent {
+		opts := []slack.MsgOption{
+			slack.MsgOptionText(msg.Content, false),
+		}
 
-	_, _, err := c.api.PostMessageContext(ctx, channelID, opts...)
-	if err != nil {
-		return fmt.Errorf("failed to send slack message: %w", err)
+		if threadTS != "" {
+			opts = append(opts, slack.MsgOptionTS(threadTS))
+		}
+
+		_, _, err := c.api.PostMessageContext(ctx, channelID, opts...)
+		if err != nil {
+			return fmt.Errorf("failed to send slack message: %w", err)
+		}
 	}
 
 	if ref, ok := c.pendingAcks.LoadAndDelete(msg.ChatID); ok {
@@ -148,6 +157,55 @@ func (c *SlackChannel) Send(ctx context.Context, msg bus.OutboundMessage) error
 	return nil
 }
 
+// SendPartial posts the in-progress reply once and then keeps it current
+// with chat.update until the final Send.
+func (c *SlackChannel) SendPartial(ctx context.Context, msg bus.OutboundMessage) error {
+	if !c.IsRunning() {
+		return fmt.Errorf("slack channel not running")
+	}
+
+	channelID, threadTS := parseSlackChatID(msg.ChatID)
+	if channelID == "" {
+		return fmt.Errorf("invalid slack chat ID: %s", msg.ChatID)
+	}
+
+	if ts, ok := c.streams.Load(msg.ChatID); ok {
+		_, _, _, err := c.api.UpdateMessageContext(ctx, channelID, ts.(string), slack.MsgOptionText(msg.Content, false))
+		if err != nil {
+			return fmt.Errorf("failed to update slack message: %w", err)
+		}
+		return nil
+	}
+
+	opts := []slack.MsgOption{
+		slack.MsgOptionText(msg.Content, false),
+	}
+	if threadTS != "" {
+		opts = append(opts, slack.MsgOptionTS(threadTS))
+	}
+
+	_, ts, err := c.api.PostMessageContext(ctx, channelID, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to send slack message: %w", err)
+	}
+	c.streams.Store(msg.ChatID, ts)
+	return nil
+}
+
+// EndStream deletes the in-progress reply of a run that ended without a
+// final Send.
+func (c *SlackChannel) EndStream(ctx context.Context, chatID string) error {
+	ts, ok := c.streams.LoadAndDelete(chatID)
+	if !ok {
+		return nil
+	}
+	channelID, _ := parseSlackChatID(chatID)
+	if _, _, err := c.api.DeleteMessageContext(ctx, channelID, ts.(string)); err != nil {
+		return fmt.Errorf("failed to delete slack message: %w", err)
+	}
+	return nil
+}
+
 func (c *SlackChannel) eventLoop() {
 	for {
 		select {
@@ -24,6 +24,9 @@ import (
 	"github.com/sipeed/picoclaw/pkg/voice"
 )
 
+// telegramMaxMessageLen is Telegram's limit on the text of one message.
+const telegramMaxMessageLen = 4096
+
 type TelegramChannel struct {
 	*BaseChannel
 	bot          *telego.Bot
@@ -119,6 +122,10 @@ func (c *TelegramChannel) Start(ctx context.Context) error {
 		return c.handleMessage(ctx, &message)
 	}, th.AnyMessage())
 
+	bh.HandleCallbackQuery(func(ctx *th.Context, query telego.CallbackQuery) error {
+		return c.handleCallbackQuery(ctx, query)
+	}, th.AnyCallbackQueryWithMessage())
+
 	c.setRunning(true)
 	logger.InfoCF("telegram", "Telegram bot connected", map[string]interface{}{
 		"username": c.bot.Username(),
@@ -158,12 +165,16 @@ func (c *TelegramChannel) Send(ctx context.Context, msg bus.OutboundMessage) err
 	}
 
 	htmlContent := markdownToTelegramHTML(msg.Content)
+	keyboard := inlineKeyboard(msg.Buttons)
 
 	// Try to edit placeholder
 	if pID, ok := c.placeholders.Load(msg.ChatID); ok {
 		c.placeholders.Delete(msg.ChatID)
 		editMsg := tu.EditMessageText(tu.ID(chatID), pID.(int), htmlContent)
 		editMsg.ParseMode = telego.ModeHTML
+		if keyboard != nil {
+			editMsg.WithReplyMarkup(keyboard)
+		}
 
 		if _, err = c.bot.EditMessageText(ctx, editMsg); err == nil {
 			return nil
@@ -173,6 +184,9 @@ func (c *TelegramChannel) Send(ctx context.Context, msg bus.OutboundMessage) err
 
 	tgMsg := tu.Message(tu.ID(chatID), htmlContent)
 	tgMsg.ParseMode = telego.ModeHTML
+	if keyboard != nil {
+		tgMsg.WithReplyMarkup(keyboard)
+	}
 
 	if _, err = c.bot.SendMessage(ctx, tgMsg); err != nil {
 		logger.ErrorCF("telegram", "HTML parse failed, falling back to plain text", map[string]interface{}{
@@ -186,6 +200,62 @@ func (c *TelegramChannel) Send(ctx context.Context, msg bus.OutboundMessage) err
 	return nil
 }
 
+// SendPartial renders an in-progress reply into the placeholder message,
+// creating one if the chat has none, so the final Send can edit it again.
+func (c *TelegramChannel) SendPartial(ctx context.Context, msg bus.OutboundMessage) error {
+	if !c.IsRunning() {
+		return fmt.Errorf("telegram bot not running")
+	}
+
+	chatID, err := parseChatID(msg.ChatID)
+	if err != nil {
+		return fmt.Errorf("invalid chat ID: %w", err)
+	}
+
+	// Text beyond Telegram's length limit is left for the final Send.
+	content := msg.Content
+	if runes := []rune(content); len(runes) > telegramMaxMessageLen {
+		content = string(runes[:telegramMaxMessageLen])
+	}
+	htmlContent := markdownToTelegramHTML(content)
+
+	if pID, ok := c.placeholders.Load(msg.ChatID); ok {
+		editMsg := tu.EditMessageText(tu.ID(chatID), pID.(int), htmlContent)
+		editMsg.ParseMode = telego.ModeHTML
+		_, err = c.bot.EditMessageText(ctx, editMsg)
+		return err
+	}
+
+	tgMsg := tu.Message(tu.ID(chatID), htmlContent)
+	tgMsg.ParseMode = telego.ModeHTML
+	sent, err := c.bot.SendMessage(ctx, tgMsg)
+	if err != nil {
+		return err
+	}
+	c.placeholders.Store(msg.ChatID, sent.MessageID)
+	return nil
+}
+
+// EndStream deletes the placeholder or partial reply of a run that ended
+// without a final Send, and stops its thinking animation.
+func (c *TelegramChannel) EndStream(ctx context.Context, chatID string) error {
+	if stop, ok := c.stopThinking.LoadAndDelete(chatID); ok {
+		if cf, ok := stop.(*thinkingCancel); ok && cf != nil {
+			cf.Cancel()
+		}
+	}
+
+	pID, ok := c.placeholders.LoadAndDelete(chatID)
+	if !ok {
+		return nil
+	}
+	id, err := parseChatID(chatID)
+	if err != nil {
+		return fmt.Errorf("invalid chat ID: %w", err)
+	}
+	return c.bot.DeleteMessage(ctx, tu.Delete(tu.ID(id), pID.(int)))
+}
+
 func (c *TelegramChannel) handleMessage(ctx context.Context, message *telego.Message) error {
 	if message == nil {
 		return fmt.Errorf("message is nil")
@@ -368,6 +438,47 @@ func (c *TelegramChannel) handleMessage(ctx context.Context, message *telego.Mes
 	return nil
 }
 
+// handleCallbackQuery turns a press of an inline button sent with
+// OutboundMessage.Buttons into an inbound message carrying the button data.
+func (c *TelegramChannel) handleCallbackQuery(ctx context.Context, query telego.CallbackQuery) error {
+	if err := c.bot.AnswerCallbackQuery(ctx, tu.CallbackQuery(query.ID)); err != nil {
+		logger.DebugCF("telegram", "Failed to answer callback query", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+
+	chatID := query.Message.GetChat().ID
+	// Remove the buttons so the request cannot be answered twice.
+	_, err := c.bot.EditMessageReplyMarkup(ctx, &telego.EditMessageReplyMarkupParams{
+		ChatID:    tu.ID(chatID),
+		MessageID: query.Message.GetMessageID(),
+	})
+	if err != nil {
+		logger.DebugCF("telegram", "Failed to remove inline keyboard", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+
+	metadata := map[string]string{
+		"user_id":  fmt.Sprintf("%d", query.From.ID),
+		"username": query.From.Username,
+		"callback": "true",
+	}
+	c.HandleMessage(fmt.Sprintf("%d", query.From.ID), fmt.Sprintf("%d", chatID), query.Data, nil, metadata)
+	return nil
+}
+
+func inlineKeyboard(buttons []bus.Button) *telego.InlineKeyboardMarkup {
+	if len(buttons) == 0 {
+		return nil
+	}
+	row := make([]telego.InlineKeyboardButton, 0, len(buttons))
+	for _, b := range buttons {
+		row = append(row, tu.InlineKeyboardButton(b.Label).WithCallbackData(b.Data))
+	}
+	return tu.InlineKeyboard(row)
+}
+
 func (c *TelegramChannel) downloadPhoto(ctx context.Context, fileID string) string {
 	file, err := c.bot.GetFile(ctx, &telego.GetFileParams{FileID: fileID})
 	if err != nil {
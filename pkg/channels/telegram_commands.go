@@ -40,6 +40,15 @@ func (c *cmd) Help(ctx context.Context, message telego.Message) error {
 /help - Show this help message
 /show [model|channel] - Show current configuration
 /list [models|channels] - List available options
+/usage - Show token usage and estimated cost
+/stop - Stop the task currently running
+/new - Start a new session, archiving the current one
+/sessions - List archived sessions
+/resume <number|id> - Go back to an archived session
+/summary - Show the summary of the current session
+/undo - Remove your last message and the reply to it
+/retry [model] - Answer your last message again
+/branch [name] - Save the conversation so far as a branch
 	`
 	_, err := c.bot.SendMessage(ctx, &telego.SendMessageParams{
 		ChatID: telego.ChatID{ID: message.Chat.ID},
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 
 	"github.com/caarlos0/env/v11"
@@ -44,16 +45,20 @@ func (f *FlexibleStringSlice) UnmarshalJSON(data []byte) error {
 }
 
 type Config struct {
-	Agents    AgentsConfig    `json:"agents"`
-	Bindings  []AgentBinding  `json:"bindings,omitempty"`
-	Session   SessionConfig   `json:"session,omitempty"`
-	Channels  ChannelsConfig  `json:"channels"`
-	Providers ProvidersConfig `json:"providers"`
-	Gateway   GatewayConfig   `json:"gateway"`
-	Tools     ToolsConfig     `json:"tools"`
-	Heartbeat HeartbeatConfig `json:"heartbeat"`
-	Devices   DevicesConfig   `json:"devices"`
-	mu        sync.RWMutex
+	Agents     AgentsConfig     `json:"agents"`
+	Bindings   []AgentBinding   `json:"bindings,omitempty"`
+	Session    SessionConfig    `json:"session,omitempty"`
+	Memory     MemoryConfig     `json:"memory,omitempty"`
+	Channels   ChannelsConfig   `json:"channels"`
+	ModelList  []ModelEntry     `json:"model_list,omitempty"`
+	Providers  ProvidersConfig  `json:"providers"`
+	Gateway    GatewayConfig    `json:"gateway"`
+	Tools      ToolsConfig      `json:"tools"`
+	Heartbeat  HeartbeatConfig  `json:"heartbeat"`
+	Devices    DevicesConfig    `json:"devices"`
+	Usage      UsageConfig      `json:"usage,omitempty"`
+	Encryption EncryptionConfig `json:"encryption,omitempty"`
+	mu         sync.RWMutex
 }
 
 type AgentsConfig struct {
@@ -101,18 +106,106 @@ func (m AgentModelConfig) MarshalJSON() ([]byte, error) {
 }
 
 type AgentConfig struct {
-	ID        string            `json:"id"`
-	Default   bool              `json:"default,omitempty"`
-	Name      string            `json:"name,omitempty"`
-	Workspace string            `json:"workspace,omitempty"`
-	Model     *AgentModelConfig `json:"model,omitempty"`
-	Skills    []string          `json:"skills,omitempty"`
-	Subagents *SubagentsConfig  `json:"subagents,omitempty"`
+	ID         string             `json:"id"`
+	Default    bool               `json:"default,omitempty"`
+	Name       string             `json:"name,omitempty"`
+	Workspace  string             `json:"workspace,omitempty"`
+	Model      *AgentModelConfig  `json:"model,omitempty"`
+	Skills     []string           `json:"skills,omitempty"`
+	Subagents  *SubagentsConfig   `json:"subagents,omitempty"`
+	Generation *GenerationProfile `json:"generation,omitempty"`
+	// SummaryGeneration overrides Generation for history summarization.
+	SummaryGeneration *GenerationProfile `json:"summary_generation,omitempty"`
+	Budget            *BudgetConfig      `json:"budget,omitempty"`
+	Router            *RouterConfig      `json:"router,omitempty"`
 }
 
 type SubagentsConfig struct {
-	AllowAgents []string          `json:"allow_agents,omitempty"`
-	Model       *AgentModelConfig `json:"model,omitempty"`
+	AllowAgents []string           `json:"allow_agents,omitempty"`
+	Model       *AgentModelConfig  `json:"model,omitempty"`
+	Generation  *GenerationProfile `json:"generation,omitempty"`
+}
+
+// GenerationProfile holds the length and sampling settings sent with each
+// LLM call. Unset fields inherit from the level above: an agent's profile
+// from agents.defaults, a summary or subagent profile from the agent's.
+type GenerationProfile struct {
+	ContextWindow   int            `json:"context_window,omitempty"`
+	MaxTokens       int            `json:"max_tokens,omitempty"`
+	Temperature     *float64       `json:"temperature,omitempty"`
+	TopP            *float64       `json:"top_p,omitempty"`
+	Stop            []string       `json:"stop,omitempty"`
+	ReasoningEffort string         `json:"reasoning_effort,omitempty"`
+	Ollama          *OllamaOptions `json:"ollama,omitempty"`
+}
+
+// OllamaOptions are only sent to the native Ollama provider.
+type OllamaOptions struct {
+	// NumCtx is the context length Ollama loads the model with. Ollama's
+	// own default is small; a smaller NumCtx also lowers the agent's context
+	// window so history is trimmed to fit.
+	NumCtx int `json:"num_ctx,omitempty"`
+	// KeepAlive is how long the model stays loaded after a call, e.g. "10m",
+	// or "-1" to keep it loaded.
+	KeepAlive string `json:"keep_alive,omitempty"`
+	// Options are other Ollama model options, e.g. num_gpu or
+	// repeat_penalty, sent as they are.
+	Options map[string]interface{} `json:"options,omitempty"`
+}
+
+// Merge returns o with every field that is set in override replaced. Options
+// are merged key by key.
+func (o *OllamaOptions) Merge(override *OllamaOptions) *OllamaOptions {
+	if override == nil {
+		return o
+	}
+	if o == nil {
+		o = &OllamaOptions{}
+	}
+	merged := *o
+	if override.NumCtx > 0 {
+		merged.NumCtx = override.NumCtx
+	}
+	if override.KeepAlive != "" {
+		merged.KeepAlive = override.KeepAlive
+	}
+	if len(override.Options) > 0 {
+		merged.Options = make(map[string]interface{}, len(o.Options)+len(override.Options))
+		for k, v := range o.Options {
+			merged.Options[k] = v
+		}
+		for k, v := range override.Options {
+			merged.Options[k] = v
+		}
+	}
+	return &merged
+}
+
+// Merge returns p with every field that is set in override replaced.
+func (p GenerationProfile) Merge(override *GenerationProfile) GenerationProfile {
+	if override == nil {
+		return p
+	}
+	if override.ContextWindow > 0 {
+		p.ContextWindow = override.ContextWindow
+	}
+	if override.MaxTokens > 0 {
+		p.MaxTokens = override.MaxTokens
+	}
+	if override.Temperature != nil {
+		p.Temperature = override.Temperature
+	}
+	if override.TopP != nil {
+		p.TopP = override.TopP
+	}
+	if override.Stop != nil {
+		p.Stop = override.Stop
+	}
+	if override.ReasoningEffort != "" {
+		p.ReasoningEffort = override.ReasoningEffort
+	}
+	p.Ollama = p.Ollama.Merge(override.Ollama)
+	return p
 }
 
 type PeerMatch struct {
@@ -131,24 +224,190 @@ type BindingMatch struct {
 type AgentBinding struct {
 	AgentID string       `json:"agent_id"`
 	Match   BindingMatch `json:"match"`
+	// Retention, when set, replaces the session retention policy for the
+	// sessions this binding routes.
+	Retention *RetentionPolicy `json:"retention,omitempty"`
+	// Router, when set, replaces the agent's model router for the sessions
+	// this binding routes.
+	Router *RouterConfig `json:"router,omitempty"`
 }
 
 type SessionConfig struct {
 	DMScope       string              `json:"dm_scope,omitempty"`
 	IdentityLinks map[string][]string `json:"identity_links,omitempty"`
+	// Storage selects how sessions are stored: "jsonl" (default), an
+	// append-only log per session, or "json", one file rewritten per turn.
+	Storage string `json:"storage,omitempty" env:"PICOCLAW_SESSION_STORAGE"`
+	// MaxLoaded caps the sessions kept in memory per agent; idle ones are
+	// reloaded from storage when needed. Zero means no cap.
+	MaxLoaded int `json:"max_loaded,omitempty" env:"PICOCLAW_SESSION_MAX_LOADED"`
+	// Retention limits how long sessions and downloaded media are kept.
+	Retention RetentionConfig `json:"retention,omitempty"`
+}
+
+// RetentionPolicy decides how long a session and its history are kept.
+// Zero values keep things forever.
+type RetentionPolicy struct {
+	// IdleTTLHours is how long a session may go without messages before
+	// IdleAction is applied to it.
+	IdleTTLHours int `json:"idle_ttl_hours,omitempty"`
+	// IdleAction is "archive" (default), which starts the session afresh
+	// and keeps its history as an archive, or "delete".
+	IdleAction string `json:"idle_action,omitempty"`
+	// ArchiveTTLHours is how long archived sessions are kept.
+	ArchiveTTLHours int `json:"archive_ttl_hours,omitempty"`
+	// MaxMessages caps the messages kept per stored session. Older ones are
+	// folded into the session summary when the agent can summarize, and
+	// dropped otherwise, e.g. by "picoclaw sessions cleanup".
+	MaxMessages int `json:"max_messages,omitempty"`
+}
+
+// RetentionConfig is the session and media retention run periodically by
+// the gateway.
+type RetentionConfig struct {
+	Enabled bool `json:"enabled" env:"PICOCLAW_SESSION_RETENTION_ENABLED"`
+	// IntervalMinutes is how often cleanup runs.
+	IntervalMinutes int `json:"interval_minutes,omitempty" env:"PICOCLAW_SESSION_RETENTION_INTERVAL_MINUTES"`
+	// Policy applies to sessions without a channel or binding policy.
+	Policy RetentionPolicy `json:"policy"`
+	// Channels replaces Policy for the sessions of a channel.
+	Channels map[string]RetentionPolicy `json:"channels,omitempty"`
+	// DiskQuotaMB caps the space taken by stored sessions and downloaded
+	// media together. Over quota, archived sessions and media are removed
+	// oldest first. Zero means no quota.
+	DiskQuotaMB int `json:"disk_quota_mb,omitempty" env:"PICOCLAW_SESSION_RETENTION_DISK_QUOTA_MB"`
+	// MediaTTLHours is how long downloaded attachments no session refers to
+	// are kept.
+	MediaTTLHours int `json:"media_ttl_hours,omitempty" env:"PICOCLAW_SESSION_RETENTION_MEDIA_TTL_HOURS"`
+}
+
+// MemoryConfig controls how long-term memory and daily notes reach the
+// system prompt.
+type MemoryConfig struct {
+	// Retrieval injects only the entries relevant to the conversation,
+	// found through a local index. When false, all of MEMORY.md and the
+	// last three days of notes are injected.
+	Retrieval bool `json:"retrieval" env:"PICOCLAW_MEMORY_RETRIEVAL"`
+	// TopK is how many entries retrieval injects at most.
+	TopK int `json:"top_k,omitempty" env:"PICOCLAW_MEMORY_TOP_K"`
+	// MaxTokens caps the size of the injected memory section.
+	MaxTokens int `json:"max_tokens,omitempty" env:"PICOCLAW_MEMORY_MAX_TOKENS"`
+	// NotesDays is how many days of daily notes are indexed.
+	NotesDays int `json:"notes_days,omitempty" env:"PICOCLAW_MEMORY_NOTES_DAYS"`
+	// PinnedSections are long-term memory sections injected whatever the
+	// conversation is about, within MaxTokens.
+	PinnedSections []string         `json:"pinned_sections,omitempty"`
+	Embeddings     EmbeddingsConfig `json:"embeddings"`
+}
+
+// EmbeddingsConfig configures an OpenAI-compatible /embeddings endpoint used
+// alongside lexical search for memory retrieval.
+type EmbeddingsConfig struct {
+	Enabled bool   `json:"enabled" env:"PICOCLAW_MEMORY_EMBEDDINGS_ENABLED"`
+	APIBase string `json:"api_base" env:"PICOCLAW_MEMORY_EMBEDDINGS_API_BASE"`
+	APIKey  string `json:"api_key" env:"PICOCLAW_MEMORY_EMBEDDINGS_API_KEY"`
+	Model   string `json:"model" env:"PICOCLAW_MEMORY_EMBEDDINGS_MODEL"`
 }
 
 type AgentDefaults struct {
-	Workspace           string   `json:"workspace" env:"PICOCLAW_AGENTS_DEFAULTS_WORKSPACE"`
-	RestrictToWorkspace bool     `json:"restrict_to_workspace" env:"PICOCLAW_AGENTS_DEFAULTS_RESTRICT_TO_WORKSPACE"`
-	Provider            string   `json:"provider" env:"PICOCLAW_AGENTS_DEFAULTS_PROVIDER"`
-	Model               string   `json:"model" env:"PICOCLAW_AGENTS_DEFAULTS_MODEL"`
-	ModelFallbacks      []string `json:"model_fallbacks,omitempty"`
-	ImageModel          string   `json:"image_model,omitempty" env:"PICOCLAW_AGENTS_DEFAULTS_IMAGE_MODEL"`
-	ImageModelFallbacks []string `json:"image_model_fallbacks,omitempty"`
-	MaxTokens           int      `json:"max_tokens" env:"PICOCLAW_AGENTS_DEFAULTS_MAX_TOKENS"`
-	Temperature         float64  `json:"temperature" env:"PICOCLAW_AGENTS_DEFAULTS_TEMPERATURE"`
-	MaxToolIterations   int      `json:"max_tool_iterations" env:"PICOCLAW_AGENTS_DEFAULTS_MAX_TOOL_ITERATIONS"`
+	Workspace           string         `json:"workspace" env:"PICOCLAW_AGENTS_DEFAULTS_WORKSPACE"`
+	RestrictToWorkspace bool           `json:"restrict_to_workspace" env:"PICOCLAW_AGENTS_DEFAULTS_RESTRICT_TO_WORKSPACE"`
+	Provider            string         `json:"provider" env:"PICOCLAW_AGENTS_DEFAULTS_PROVIDER"`
+	Model               string         `json:"model" env:"PICOCLAW_AGENTS_DEFAULTS_MODEL"`
+	ModelFallbacks      []string       `json:"model_fallbacks,omitempty"`
+	ImageModel          string         `json:"image_model,omitempty" env:"PICOCLAW_AGENTS_DEFAULTS_IMAGE_MODEL"`
+	ImageModelFallbacks []string       `json:"image_model_fallbacks,omitempty"`
+	ContextWindow       int            `json:"context_window" env:"PICOCLAW_AGENTS_DEFAULTS_CONTEXT_WINDOW"`
+	MaxTokens           int            `json:"max_tokens" env:"PICOCLAW_AGENTS_DEFAULTS_MAX_TOKENS"`
+	Temperature         float64        `json:"temperature" env:"PICOCLAW_AGENTS_DEFAULTS_TEMPERATURE"`
+	TopP                float64        `json:"top_p,omitempty" env:"PICOCLAW_AGENTS_DEFAULTS_TOP_P"`
+	Stop                []string       `json:"stop,omitempty"`
+	ReasoningEffort     string         `json:"reasoning_effort,omitempty" env:"PICOCLAW_AGENTS_DEFAULTS_REASONING_EFFORT"`
+	MaxToolIterations   int            `json:"max_tool_iterations" env:"PICOCLAW_AGENTS_DEFAULTS_MAX_TOOL_ITERATIONS"`
+	Streaming           bool           `json:"streaming" env:"PICOCLAW_AGENTS_DEFAULTS_STREAMING"`
+	MaxConcurrency      int            `json:"max_concurrency" env:"PICOCLAW_AGENTS_DEFAULTS_MAX_CONCURRENCY"`
+	ParallelToolCalls   bool           `json:"parallel_tool_calls" env:"PICOCLAW_AGENTS_DEFAULTS_PARALLEL_TOOL_CALLS"`
+	HandoffTTLMinutes   int            `json:"handoff_ttl_minutes" env:"PICOCLAW_AGENTS_DEFAULTS_HANDOFF_TTL_MINUTES"`
+	Ollama              *OllamaOptions `json:"ollama,omitempty"`
+	Budget              *BudgetConfig  `json:"budget,omitempty"`
+	Router              *RouterConfig  `json:"router,omitempty"`
+}
+
+// Budget actions applied once an agent has spent its budget.
+const (
+	BudgetActionRefuse    = "refuse"
+	BudgetActionDowngrade = "downgrade"
+)
+
+// BudgetConfig caps an agent's estimated spend in USD. A zero limit means
+// no limit for that period.
+type BudgetConfig struct {
+	DailyUSD   float64 `json:"daily_usd,omitempty"`
+	MonthlyUSD float64 `json:"monthly_usd,omitempty"`
+	// OnExceed is "refuse" (the default) or "downgrade", which switches the
+	// agent to DowngradeModel until the period rolls over.
+	OnExceed       string `json:"on_exceed,omitempty"`
+	DowngradeModel string `json:"downgrade_model,omitempty"`
+}
+
+// RouterConfig routes each turn by how complex it looks: simple turns such
+// as chit-chat go to SimpleModel, everything else to the agent's model.
+type RouterConfig struct {
+	Enabled bool `json:"enabled"`
+	// SimpleModel serves simple turns, e.g. a small or local model. It may
+	// name a model_list entry.
+	SimpleModel string `json:"simple_model,omitempty"`
+	// MaxSimpleChars is the longest message that may still be simple.
+	// Defaults to 280.
+	MaxSimpleChars int `json:"max_simple_chars,omitempty"`
+	// ComplexKeywords mark a message as complex, in addition to the
+	// built-in ones. They are matched as whole words, ignoring case.
+	ComplexKeywords []string `json:"complex_keywords,omitempty"`
+	// ClassifierModel, when set, is asked to classify the turns the
+	// heuristics cannot decide; they are otherwise treated as simple.
+	ClassifierModel string `json:"classifier_model,omitempty"`
+}
+
+// EncryptionConfig controls encryption at rest of the auth store, session
+// files, the cron store and agent memory. The key, or a passphrase it is
+// derived from, is read from PICOCLAW_ENCRYPTION_KEY, then KeyFile, and is
+// otherwise asked for when running in a terminal.
+type EncryptionConfig struct {
+	Enabled bool   `json:"enabled" env:"PICOCLAW_ENCRYPTION_ENABLED"`
+	KeyFile string `json:"key_file,omitempty" env:"PICOCLAW_ENCRYPTION_KEY_FILE"`
+}
+
+// UsageConfig configures token usage accounting.
+type UsageConfig struct {
+	// Prices maps a model name to its price, used to estimate cost.
+	Prices map[string]ModelPrice `json:"prices,omitempty"`
+}
+
+// ModelPrice is the price of a model in USD per million tokens.
+type ModelPrice struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+	// CachedInput is the price of input tokens read from the provider's
+	// prompt cache; they cost Input if it is not set.
+	CachedInput float64 `json:"cached_input,omitempty"`
+}
+
+// Generation returns the defaults as a profile for agents to build on.
+func (d *AgentDefaults) Generation() GenerationProfile {
+	temperature := d.Temperature
+	p := GenerationProfile{
+		ContextWindow:   d.ContextWindow,
+		MaxTokens:       d.MaxTokens,
+		Temperature:     &temperature,
+		Stop:            d.Stop,
+		ReasoningEffort: d.ReasoningEffort,
+		Ollama:          d.Ollama,
+	}
+	if d.TopP > 0 {
+		topP := d.TopP
+		p.TopP = &topP
+	}
+	return p
 }
 
 type ChannelsConfig struct {
@@ -165,14 +424,48 @@ type ChannelsConfig struct {
 	Email    EmailConfig    `json:"email"`
 }
 
+// Steering reports whether messages sent to a channel while the agent is
+// still working on an earlier one are injected into that run instead of
+// being queued behind it.
+func (c *ChannelsConfig) Steering(channel string) bool {
+	switch channel {
+	case "whatsapp":
+		return c.WhatsApp.Steering
+	case "telegram":
+		return c.Telegram.Steering
+	case "feishu":
+		return c.Feishu.Steering
+	case "discord":
+		return c.Discord.Steering
+	case "maixcam":
+		return c.MaixCam.Steering
+	case "qq":
+		return c.QQ.Steering
+	case "dingtalk":
+		return c.DingTalk.Steering
+	case "slack":
+		return c.Slack.Steering
+	case "line":
+		return c.LINE.Steering
+	case "onebot":
+		return c.OneBot.Steering
+	case "email":
+		return c.Email.Steering
+	default:
+		return false
+	}
+}
+
 type WhatsAppConfig struct {
 	Enabled   bool                `json:"enabled" env:"PICOCLAW_CHANNELS_WHATSAPP_ENABLED"`
+	Steering  bool                `json:"steering,omitempty" env:"PICOCLAW_CHANNELS_WHATSAPP_STEERING"`
 	BridgeURL string              `json:"bridge_url" env:"PICOCLAW_CHANNELS_WHATSAPP_BRIDGE_URL"`
 	AllowFrom FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_WHATSAPP_ALLOW_FROM"`
 }
 
 type TelegramConfig struct {
 	Enabled   bool                `json:"enabled" env:"PICOCLAW_CHANNELS_TELEGRAM_ENABLED"`
+	Steering  bool                `json:"steering,omitempty" env:"PICOCLAW_CHANNELS_TELEGRAM_STEERING"`
 	Token     string              `json:"token" env:"PICOCLAW_CHANNELS_TELEGRAM_TOKEN"`
 	Proxy     string              `json:"proxy" env:"PICOCLAW_CHANNELS_TELEGRAM_PROXY"`
 	AllowFrom FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_TELEGRAM_ALLOW_FROM"`
@@ -180,6 +473,7 @@ type TelegramConfig struct {
 
 type FeishuConfig struct {
 	Enabled           bool                `json:"enabled" env:"PICOCLAW_CHANNELS_FEISHU_ENABLED"`
+	Steering          bool                `json:"steering,omitempty" env:"PICOCLAW_CHANNELS_FEISHU_STEERING"`
 	AppID             string              `json:"app_id" env:"PICOCLAW_CHANNELS_FEISHU_APP_ID"`
 	AppSecret         string              `json:"app_secret" env:"PICOCLAW_CHANNELS_FEISHU_APP_SECRET"`
 	EncryptKey        string              `json:"encrypt_key" env:"PICOCLAW_CHANNELS_FEISHU_ENCRYPT_KEY"`
@@ -189,12 +483,14 @@ type FeishuConfig struct {
 
 type DiscordConfig struct {
 	Enabled   bool                `json:"enabled" env:"PICOCLAW_CHANNELS_DISCORD_ENABLED"`
+	Steering  bool                `json:"steering,omitempty" env:"PICOCLAW_CHANNELS_DISCORD_STEERING"`
 	Token     string              `json:"token" env:"PICOCLAW_CHANNELS_DISCORD_TOKEN"`
 	AllowFrom FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_DISCORD_ALLOW_FROM"`
 }
 
 type MaixCamConfig struct {
 	Enabled   bool                `json:"enabled" env:"PICOCLAW_CHANNELS_MAIXCAM_ENABLED"`
+	Steering  bool                `json:"steering,omitempty" env:"PICOCLAW_CHANNELS_MAIXCAM_STEERING"`
 	Host      string              `json:"host" env:"PICOCLAW_CHANNELS_MAIXCAM_HOST"`
 	Port      int                 `json:"port" env:"PICOCLAW_CHANNELS_MAIXCAM_PORT"`
 	AllowFrom FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_MAIXCAM_ALLOW_FROM"`
@@ -202,6 +498,7 @@ type MaixCamConfig struct {
 
 type QQConfig struct {
 	Enabled   bool                `json:"enabled" env:"PICOCLAW_CHANNELS_QQ_ENABLED"`
+	Steering  bool                `json:"steering,omitempty" env:"PICOCLAW_CHANNELS_QQ_STEERING"`
 	AppID     string              `json:"app_id" env:"PICOCLAW_CHANNELS_QQ_APP_ID"`
 	AppSecret string              `json:"app_secret" env:"PICOCLAW_CHANNELS_QQ_APP_SECRET"`
 	AllowFrom FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_QQ_ALLOW_FROM"`
@@ -209,6 +506,7 @@ type QQConfig struct {
 
 type DingTalkConfig struct {
 	Enabled      bool                `json:"enabled" env:"PICOCLAW_CHANNELS_DINGTALK_ENABLED"`
+	Steering     bool                `json:"steering,omitempty" env:"PICOCLAW_CHANNELS_DINGTALK_STEERING"`
 	ClientID     string              `json:"client_id" env:"PICOCLAW_CHANNELS_DINGTALK_CLIENT_ID"`
 	ClientSecret string              `json:"client_secret" env:"PICOCLAW_CHANNELS_DINGTALK_CLIENT_SECRET"`
 	AllowFrom    FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_DINGTALK_ALLOW_FROM"`
@@ -216,6 +514,7 @@ type DingTalkConfig struct {
 
 type SlackConfig struct {
 	Enabled   bool                `json:"enabled" env:"PICOCLAW_CHANNELS_SLACK_ENABLED"`
+	Steering  bool                `json:"steering,omitempty" env:"PICOCLAW_CHANNELS_SLACK_STEERING"`
 	BotToken  string              `json:"bot_token" env:"PICOCLAW_CHANNELS_SLACK_BOT_TOKEN"`
 	AppToken  string              `json:"app_token" env:"PICOCLAW_CHANNELS_SLACK_APP_TOKEN"`
 	AllowFrom FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_SLACK_ALLOW_FROM"`
@@ -223,6 +522,7 @@ type SlackConfig struct {
 
 type LINEConfig struct {
 	Enabled            bool                `json:"enabled" env:"PICOCLAW_CHANNELS_LINE_ENABLED"`
+	Steering           bool                `json:"steering,omitempty" env:"PICOCLAW_CHANNELS_LINE_STEERING"`
 	ChannelSecret      string              `json:"channel_secret" env:"PICOCLAW_CHANNELS_LINE_CHANNEL_SECRET"`
 	ChannelAccessToken string              `json:"channel_access_token" env:"PICOCLAW_CHANNELS_LINE_CHANNEL_ACCESS_TOKEN"`
 	WebhookHost        string              `json:"webhook_host" env:"PICOCLAW_CHANNELS_LINE_WEBHOOK_HOST"`
@@ -233,6 +533,7 @@ type LINEConfig struct {
 
 type OneBotConfig struct {
 	Enabled            bool                `json:"enabled" env:"PICOCLAW_CHANNELS_ONEBOT_ENABLED"`
+	Steering           bool                `json:"steering,omitempty" env:"PICOCLAW_CHANNELS_ONEBOT_STEERING"`
 	WSUrl              string              `json:"ws_url" env:"PICOCLAW_CHANNELS_ONEBOT_WS_URL"`
 	AccessToken        string              `json:"access_token" env:"PICOCLAW_CHANNELS_ONEBOT_ACCESS_TOKEN"`
 	ReconnectInterval  int                 `json:"reconnect_interval" env:"PICOCLAW_CHANNELS_ONEBOT_RECONNECT_INTERVAL"`
@@ -254,6 +555,7 @@ type EmailAccountConfig struct {
 
 type EmailConfig struct {
 	Enabled      bool                 `json:"enabled" env:"PICOCLAW_CHANNELS_EMAIL_ENABLED"`
+	Steering     bool                 `json:"steering,omitempty" env:"PICOCLAW_CHANNELS_EMAIL_STEERING"`
 	Accounts     []EmailAccountConfig `json:"accounts"`
 	IMAPServer   string               `json:"imap_server" env:"PICOCLAW_CHANNELS_EMAIL_IMAP_SERVER"`
 	IMAPPort     int                  `json:"imap_port" env:"PICOCLAW_CHANNELS_EMAIL_IMAP_PORT"`
@@ -271,6 +573,37 @@ type EmailConfig struct {
 type HeartbeatConfig struct {
 	Enabled  bool `json:"enabled" env:"PICOCLAW_HEARTBEAT_ENABLED"`
 	Interval int  `json:"interval" env:"PICOCLAW_HEARTBEAT_INTERVAL"` // minutes, min 5
+	// QuietHours applies to tasks that do not set their own.
+	QuietHours QuietHours `json:"quiet_hours"`
+	// SuppressRepeatsMinutes is how long an alert is not sent again when a
+	// task raises it unchanged.
+	SuppressRepeatsMinutes int `json:"suppress_repeats_minutes,omitempty" env:"PICOCLAW_HEARTBEAT_SUPPRESS_REPEATS_MINUTES"`
+	// Tasks run on their own schedules. Without tasks, HEARTBEAT.md is run
+	// every Interval minutes by the default agent.
+	Tasks []HeartbeatTask `json:"tasks,omitempty"`
+}
+
+// HeartbeatTask is a periodic check run by an agent.
+type HeartbeatTask struct {
+	Name string `json:"name"`
+	// Prompt says what to check. Empty means the content of HEARTBEAT.md.
+	Prompt string `json:"prompt,omitempty"`
+	// Interval is in minutes, min 5. Zero means the heartbeat interval.
+	Interval int `json:"interval,omitempty"`
+	// AgentID runs the task; empty means the default agent.
+	AgentID string `json:"agent_id,omitempty"`
+	// Targets are the "channel:chat_id" pairs alerts are sent to. Empty means
+	// the chat the user last wrote from.
+	Targets    []string    `json:"targets,omitempty"`
+	QuietHours *QuietHours `json:"quiet_hours,omitempty"`
+}
+
+// QuietHours is a daily local-time window, "HH:MM" to "HH:MM", in which
+// heartbeat tasks are not run. The window may span midnight. Leaving either
+// end empty disables it.
+type QuietHours struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
 }
 
 type DevicesConfig struct {
@@ -278,6 +611,52 @@ type DevicesConfig struct {
 	MonitorUSB bool `json:"monitor_usb" env:"PICOCLAW_DEVICES_MONITOR_USB"`
 }
 
+// Model list protocols: the wire protocol a model_list entry is spoken to
+// with.
+const (
+	ProtocolOpenAI    = "openai"
+	ProtocolAnthropic = "anthropic"
+	ProtocolOllama    = "ollama"
+	ProtocolGemini    = "gemini"
+	ProtocolCodexCLI  = "codex-cli"
+	ProtocolClaudeCLI = "claude-cli"
+)
+
+// ModelEntry is one model in model_list. Agents, fallbacks, subagents and
+// /switch model refer to it by Name; Model is the ID sent upstream. A name
+// in model_list takes precedence over the providers block, which is only
+// consulted for names that are not listed.
+type ModelEntry struct {
+	Name     string `json:"name"`
+	Protocol string `json:"protocol"`
+	Model    string `json:"model"`
+	APIBase  string `json:"api_base,omitempty"`
+	APIKey   string `json:"api_key,omitempty"`
+	// Credentials references the credentials instead of holding them:
+	// "env:NAME" reads the key from the environment variable NAME, "auth"
+	// uses the login stored by picoclaw auth login, and "codex-cli" the
+	// token of the Codex CLI.
+	Credentials string `json:"credentials,omitempty"`
+	Proxy       string `json:"proxy,omitempty"`
+	// WebSearch enables the built-in web search of the openai protocol when
+	// it runs on a stored login.
+	WebSearch bool `json:"web_search,omitempty"`
+}
+
+// FindModel returns the model_list entry called name, or nil.
+func (c *Config) FindModel(name string) *ModelEntry {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil
+	}
+	for i := range c.ModelList {
+		if c.ModelList[i].Name == name {
+			return &c.ModelList[i]
+		}
+	}
+	return nil
+}
+
 type ProvidersConfig struct {
 	Anthropic     ProviderConfig       `json:"anthropic"`
 	OpenAI        OpenAIProviderConfig `json:"openai"`
@@ -287,7 +666,7 @@ type ProvidersConfig struct {
 	VLLM          ProviderConfig       `json:"vllm"`
 	Gemini        ProviderConfig       `json:"gemini"`
 	Nvidia        ProviderConfig       `json:"nvidia"`
-	Ollama        ProviderConfig       `json:"ollama"`
+	Ollama        OllamaProviderConfig `json:"ollama"`
 	Moonshot      ProviderConfig       `json:"moonshot"`
 	ShengSuanYun  ProviderConfig       `json:"shengsuanyun"`
 	DeepSeek      ProviderConfig       `json:"deepseek"`
@@ -302,6 +681,13 @@ type ProviderConfig struct {
 	ConnectMode string `json:"connect_mode,omitempty" env:"PICOCLAW_PROVIDERS_{{.Name}}_CONNECT_MODE"` //only for Github Copilot, `stdio` or `grpc`
 }
 
+type OllamaProviderConfig struct {
+	ProviderConfig
+	// PullMissing makes the gateway download the models its agents use
+	// that are not installed yet when it starts.
+	PullMissing bool `json:"pull_missing,omitempty" env:"PICOCLAW_PROVIDERS_OLLAMA_PULL_MISSING"`
+}
+
 type OpenAIProviderConfig struct {
 	ProviderConfig
 	WebSearch bool `json:"web_search" env:"PICOCLAW_PROVIDERS_OPENAI_WEB_SEARCH"`
@@ -345,11 +731,40 @@ type ExecConfig struct {
 }
 
 type ToolsConfig struct {
-	Web      WebToolsConfig `json:"web"`
-	GitHub   GitHubConfig   `json:"github"`
-	Calendar CalendarConfig `json:"calendar"`
+	Web      WebToolsConfig  `json:"web"`
+	Cron     CronToolsConfig `json:"cron"`
+	Exec     ExecConfig      `json:"exec"`
+	GitHub   GitHubConfig    `json:"github"`
+	Calendar CalendarConfig  `json:"calendar"`
+	Approval ApprovalConfig  `json:"approval"`
 }
 
+// ApprovalConfig makes matching tool calls wait for the user who started the
+// run to approve them.
+type ApprovalConfig struct {
+	Enabled        bool `json:"enabled" env:"PICOCLAW_TOOLS_APPROVAL_ENABLED"`
+	TimeoutSeconds int  `json:"timeout_seconds" env:"PICOCLAW_TOOLS_APPROVAL_TIMEOUT_SECONDS"`
+	// Unattended decides calls from runs nobody can be asked about, such as
+	// CLI, cron and heartbeat runs: "deny" (default) or "allow".
+	Unattended string         `json:"unattended" env:"PICOCLAW_TOOLS_APPROVAL_UNATTENDED"`
+	Rules      []ApprovalRule `json:"rules"`
+}
+
+// ApprovalRule marks calls to Tool as needing approval. If Args is set, only
+// calls whose arguments match every regular expression in it do. OtherChat
+// restricts the rule to calls whose channel and chat_id arguments point to a
+// chat other than the one the run belongs to.
+type ApprovalRule struct {
+	Tool      string            `json:"tool"`
+	Args      map[string]string `json:"args,omitempty"`
+	OtherChat bool              `json:"other_chat,omitempty"`
+}
+
+const (
+	ApprovalUnattendedDeny  = "deny"
+	ApprovalUnattendedAllow = "allow"
+)
+
 type CalendarConfig struct {
 	Enabled         bool   `json:"enabled" env:"PICOCLAW_TOOLS_CALENDAR_ENABLED"`
 	CredentialsJSON string `json:"credentials_json" env:"PICOCLAW_TOOLS_CALENDAR_CREDENTIALS"` // Path to credentials.json
@@ -359,9 +774,6 @@ type CalendarConfig struct {
 type GitHubConfig struct {
 	Enabled bool   `json:"enabled" env:"PICOCLAW_TOOLS_GITHUB_ENABLED"`
 	Token   string `json:"token" env:"PICOCLAW_TOOLS_GITHUB_TOKEN"`
-	Web  WebToolsConfig  `json:"web"`
-	Cron CronToolsConfig `json:"cron"`
-	Exec ExecConfig      `json:"exec"`
 }
 
 func DefaultConfig() *Config {
@@ -372,9 +784,13 @@ func DefaultConfig() *Config {
 				RestrictToWorkspace: true,
 				Provider:            "",
 				Model:               "glm-4.7",
+				ContextWindow:       128000,
 				MaxTokens:           8192,
 				Temperature:         0.7,
 				MaxToolIterations:   20,
+				Streaming:           true,
+				MaxConcurrency:      4,
+				HandoffTTLMinutes:   120,
 			},
 		},
 		Channels: ChannelsConfig{
@@ -473,6 +889,10 @@ func DefaultConfig() *Config {
 			Host: "0.0.0.0",
 			Port: 18790,
 		},
+		Encryption: EncryptionConfig{
+			Enabled: false,
+			KeyFile: "~/.picoclaw/encryption.key",
+		},
 		Tools: ToolsConfig{
 			Web: WebToolsConfig{
 				Brave: BraveConfig{
@@ -496,10 +916,48 @@ func DefaultConfig() *Config {
 			Exec: ExecConfig{
 				EnableDenyPatterns: true,
 			},
+			Approval: ApprovalConfig{
+				Enabled:        false,
+				TimeoutSeconds: 300,
+				Unattended:     ApprovalUnattendedDeny,
+				Rules: []ApprovalRule{
+					{Tool: "exec"},
+					{Tool: "write_file"},
+					{Tool: "send_email"},
+					{Tool: "i2c", Args: map[string]string{"action": "^write$"}},
+					{Tool: "spi", Args: map[string]string{"action": "^transfer$"}},
+					{Tool: "message", OtherChat: true},
+				},
+			},
+		},
+		Session: SessionConfig{
+			Storage:   "jsonl",
+			MaxLoaded: 64,
+			Retention: RetentionConfig{
+				Enabled:         true,
+				IntervalMinutes: 60,
+				Policy: RetentionPolicy{
+					IdleAction: "archive",
+				},
+				MediaTTLHours: 24,
+			},
+		},
+		Memory: MemoryConfig{
+			Retrieval:      true,
+			TopK:           8,
+			MaxTokens:      800,
+			NotesDays:      30,
+			PinnedSections: []string{"User Information"},
+			Embeddings: EmbeddingsConfig{
+				Enabled: false,
+				APIBase: "https://api.openai.com/v1",
+				Model:   "text-embedding-3-small",
+			},
 		},
 		Heartbeat: HeartbeatConfig{
-			Enabled:  true,
-			Interval: 30, // default 30 minutes
+			Enabled:                true,
+			Interval:               30, // default 30 minutes
+			SuppressRepeatsMinutes: 240,
 		},
 		Devices: DevicesConfig{
 			Enabled:    false,
@@ -547,6 +1005,13 @@ func SaveConfig(path string, cfg *Config) error {
 	return os.WriteFile(path, data, 0600)
 }
 
+// EncryptionKeyFile returns the expanded path of the encryption key file.
+func (c *Config) EncryptionKeyFile() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return expandHome(c.Encryption.KeyFile)
+}
+
 func (c *Config) WorkspacePath() string {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -392,3 +392,28 @@ func TestLoadConfig_OpenAIWebSearchCanBeDisabled(t *testing.T) {
 		t.Fatal("OpenAI codex web search should be false when disabled in config file")
 	}
 }
+
+func TestGenerationProfile_Merge(t *testing.T) {
+	temp := 0.2
+	base := (&AgentDefaults{ContextWindow: 128000, MaxTokens: 8192, Temperature: 0.7}).Generation()
+	merged := base.Merge(&GenerationProfile{MaxTokens: 2048, Temperature: &temp, Stop: []string{"END"}})
+
+	if merged.ContextWindow != 128000 {
+		t.Errorf("ContextWindow = %d, want 128000", merged.ContextWindow)
+	}
+	if merged.MaxTokens != 2048 {
+		t.Errorf("MaxTokens = %d, want 2048", merged.MaxTokens)
+	}
+	if merged.Temperature == nil || *merged.Temperature != 0.2 {
+		t.Errorf("Temperature = %v, want 0.2", merged.Temperature)
+	}
+	if merged.TopP != nil {
+		t.Errorf("TopP = %v, want nil", *merged.TopP)
+	}
+	if len(merged.Stop) != 1 || merged.Stop[0] != "END" {
+		t.Errorf("Stop = %v, want [END]", merged.Stop)
+	}
+	if base.MaxTokens != 8192 {
+		t.Errorf("Merge modified the base profile")
+	}
+}
@@ -12,6 +12,8 @@ import (
 	"time"
 
 	"github.com/adhocore/gronx"
+
+	"github.com/sipeed/picoclaw/pkg/secrets"
 )
 
 type CronSchedule struct {
@@ -318,7 +320,7 @@ func (cs *CronService) loadStore() error {
 		Jobs:    []CronJob{},
 	}
 
-	data, err := os.ReadFile(cs.storePath)
+	data, err := secrets.ReadFile(cs.storePath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil
@@ -340,7 +342,7 @@ func (cs *CronService) saveStoreUnsafe() error {
 		return err
 	}
 
-	return os.WriteFile(cs.storePath, data, 0600)
+	return secrets.WriteFile(cs.storePath, data, 0600)
 }
 
 func (cs *CronService) AddJob(name string, schedule CronSchedule, message string, deliver bool, channel, to string) (*CronJob, error) {
@@ -7,6 +7,7 @@
 package heartbeat
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -15,21 +16,70 @@ import (
 	"time"
 
 	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
 	"github.com/sipeed/picoclaw/pkg/constants"
 	"github.com/sipeed/picoclaw/pkg/logger"
 	"github.com/sipeed/picoclaw/pkg/state"
-	"github.com/sipeed/picoclaw/pkg/tools"
 )
 
 const (
 	minIntervalMinutes     = 5
 	defaultIntervalMinutes = 30
+	// checkInterval is how often the service looks for due tasks.
+	checkInterval = time.Minute
+	// defaultTaskName names the HEARTBEAT.md task used when none are
+	// configured.
+	defaultTaskName = "heartbeat"
 )
 
-// HeartbeatHandler is the function type for handling heartbeat.
-// It returns a ToolResult that can indicate async operations.
-// channel and chatID are derived from the last active user channel.
-type HeartbeatHandler func(prompt, channel, chatID string) *tools.ToolResult
+// Statuses a heartbeat task reports.
+const (
+	StatusOK    = "ok"
+	StatusAlert = "alert"
+)
+
+// Result is what a heartbeat task reports: ok, or an alert with the message
+// to send to the user.
+type Result struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// ResultSchema is the JSON schema of a Result, for agents that can constrain
+// their reply to it.
+var ResultSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"status": map[string]interface{}{
+			"type": "string",
+			"enum": []string{StatusOK, StatusAlert},
+		},
+		"message": map[string]interface{}{
+			"type":        "string",
+			"description": "The message to send to the user with an alert",
+		},
+	},
+	"required": []string{"status"},
+}
+
+// HeartbeatHandler runs a heartbeat prompt with an agent and returns its
+// reply. agentID is empty for the default agent. channel and chatID are the
+// first target of the task, or empty if it has none.
+type HeartbeatHandler func(agentID, prompt, channel, chatID string) (string, error)
+
+// task is a configured heartbeat task and its schedule.
+type task struct {
+	name     string
+	prompt   string
+	agentID  string
+	targets  []string
+	interval time.Duration
+	quiet    config.QuietHours
+	nextRun  time.Time
+	// lastAlert is the message last sent, cleared when the task reports ok.
+	lastAlert   string
+	lastAlertAt time.Time
+}
 
 // HeartbeatService manages periodic heartbeat checks
 type HeartbeatService struct {
@@ -37,31 +87,64 @@ type HeartbeatService struct {
 	bus       *bus.MessageBus
 	state     *state.Manager
 	handler   HeartbeatHandler
-	interval  time.Duration
+	tasks     []*task
+	suppress  time.Duration
 	enabled   bool
+	now       func() time.Time
 	mu        sync.RWMutex
+	running   sync.Mutex // held while tasks run
 	stopChan  chan struct{}
 }
 
 // NewHeartbeatService creates a new heartbeat service
-func NewHeartbeatService(workspace string, intervalMinutes int, enabled bool) *HeartbeatService {
-	// Apply minimum interval
-	if intervalMinutes < minIntervalMinutes && intervalMinutes != 0 {
-		intervalMinutes = minIntervalMinutes
-	}
+func NewHeartbeatService(workspace string, cfg config.HeartbeatConfig) *HeartbeatService {
+	interval := intervalOf(cfg.Interval, defaultIntervalMinutes)
 
-	if intervalMinutes == 0 {
-		intervalMinutes = defaultIntervalMinutes
+	specs := cfg.Tasks
+	if len(specs) == 0 {
+		specs = []config.HeartbeatTask{{Name: defaultTaskName}}
+	}
+	tasks := make([]*task, 0, len(specs))
+	for i, spec := range specs {
+		t := &task{
+			name:     spec.Name,
+			prompt:   spec.Prompt,
+			agentID:  spec.AgentID,
+			targets:  spec.Targets,
+			interval: intervalOf(spec.Interval, int(interval.Minutes())),
+			quiet:    cfg.QuietHours,
+		}
+		if t.name == "" {
+			t.name = fmt.Sprintf("task-%d", i+1)
+		}
+		if spec.QuietHours != nil {
+			t.quiet = *spec.QuietHours
+		}
+		tasks = append(tasks, t)
 	}
 
 	return &HeartbeatService{
 		workspace: workspace,
-		interval:  time.Duration(intervalMinutes) * time.Minute,
-		enabled:   enabled,
+		tasks:     tasks,
+		suppress:  time.Duration(cfg.SuppressRepeatsMinutes) * time.Minute,
+		enabled:   cfg.Enabled,
+		now:       time.Now,
 		state:     state.NewManager(workspace),
 	}
 }
 
+// intervalOf applies the minimum interval to minutes, or returns fallback
+// minutes if it is zero.
+func intervalOf(minutes, fallback int) time.Duration {
+	if minutes == 0 {
+		minutes = fallback
+	}
+	if minutes < minIntervalMinutes {
+		minutes = minIntervalMinutes
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
 // SetBus sets the message bus for delivering heartbeat results.
 func (hs *HeartbeatService) SetBus(msgBus *bus.MessageBus) {
 	hs.mu.Lock()
@@ -95,7 +178,7 @@ func (hs *HeartbeatService) Start() error {
 	go hs.runLoop(hs.stopChan)
 
 	logger.InfoCF("heartbeat", "Heartbeat service started", map[string]any{
-		"interval_minutes": hs.interval.Minutes(),
+		"tasks": len(hs.tasks),
 	})
 
 	return nil
@@ -124,7 +207,7 @@ func (hs *HeartbeatService) IsRunning() bool {
 
 // runLoop runs the heartbeat ticker
 func (hs *HeartbeatService) runLoop(stopChan chan struct{}) {
-	ticker := time.NewTicker(hs.interval)
+	ticker := time.NewTicker(checkInterval)
 	defer ticker.Stop()
 
 	// Run first heartbeat after initial delay
@@ -142,24 +225,38 @@ func (hs *HeartbeatService) runLoop(stopChan chan struct{}) {
 	}
 }
 
-// executeHeartbeat performs a single heartbeat check
+// executeHeartbeat runs the tasks that are due. A task that falls due in
+// its quiet hours runs at the first check after they end.
 func (hs *HeartbeatService) executeHeartbeat() {
 	hs.mu.RLock()
-	enabled := hs.enabled
-	handler := hs.handler
 	if !hs.enabled || hs.stopChan == nil {
 		hs.mu.RUnlock()
 		return
 	}
 	hs.mu.RUnlock()
 
-	if !enabled {
-		return
+	hs.running.Lock()
+	defer hs.running.Unlock()
+
+	for _, t := range hs.tasks {
+		now := hs.now()
+		if now.Before(t.nextRun) || inQuietHours(t.quiet, now) {
+			continue
+		}
+		t.nextRun = now.Add(t.interval)
+		hs.runTask(t)
 	}
+}
 
-	logger.DebugC("heartbeat", "Executing heartbeat")
+// runTask runs one task and sends its alert, if any.
+func (hs *HeartbeatService) runTask(t *task) {
+	hs.mu.RLock()
+	handler := hs.handler
+	hs.mu.RUnlock()
+
+	logger.DebugCF("heartbeat", "Executing heartbeat task", map[string]any{"task": t.name})
 
-	prompt := hs.buildPrompt()
+	prompt := hs.buildPrompt(t)
 	if prompt == "" {
 		logger.InfoC("heartbeat", "No heartbeat prompt (HEARTBEAT.md empty or missing)")
 		return
@@ -170,81 +267,121 @@ func (hs *HeartbeatService) executeHeartbeat() {
 		return
 	}
 
-	// Get last channel info for context
-	lastChannel := hs.state.GetLastChannel()
-	channel, chatID := hs.parseLastChannel(lastChannel)
-
-	// Debug log for channel resolution
-	hs.logInfo("Resolved channel: %s, chatID: %s (from lastChannel: %s)", channel, chatID, lastChannel)
-
-	result := handler(prompt, channel, chatID)
-
-	if result == nil {
-		hs.logInfo("Heartbeat handler returned nil result")
-		return
+	targets := hs.resolveTargets(t)
+	var channel, chatID string
+	if len(targets) > 0 {
+		channel, chatID = targets[0].channel, targets[0].chatID
 	}
+	hs.logInfo("Task %s: agent %q, targets %v", t.name, t.agentID, targets)
 
-	// Handle different result types
-	if result.IsError {
-		hs.logError("Heartbeat error: %s", result.ForLLM)
+	reply, err := handler(t.agentID, prompt, channel, chatID)
+	if err != nil {
+		hs.logError("Task %s failed: %v", t.name, err)
 		return
 	}
 
-	if result.Async {
-		hs.logInfo("Async task started: %s", result.ForLLM)
-		logger.InfoCF("heartbeat", "Async heartbeat task started",
-			map[string]interface{}{
-				"message": result.ForLLM,
-			})
+	result := ParseResult(reply)
+	if result.Status == StatusOK {
+		t.lastAlert = ""
+		hs.logInfo("Task %s OK", t.name)
 		return
 	}
 
-	// Check if silent
-	if result.Silent {
-		hs.logInfo("Heartbeat OK - silent")
+	now := hs.now()
+	if result.Message == t.lastAlert && now.Sub(t.lastAlertAt) < hs.suppress {
+		hs.logInfo("Task %s: repeated alert suppressed", t.name)
 		return
 	}
+	t.lastAlert, t.lastAlertAt = result.Message, now
 
-	// Send result to user
-	if result.ForUser != "" {
-		hs.sendResponse(result.ForUser)
-	} else if result.ForLLM != "" {
-		hs.sendResponse(result.ForLLM)
+	hs.sendAlert(targets, result.Message)
+	hs.logInfo("Task %s alert: %s", t.name, result.Message)
+}
+
+// ParseResult reads the JSON result a heartbeat reply ends with. A reply
+// without one is treated as an alert so that nothing the agent said is
+// lost; an empty reply and the legacy HEARTBEAT_OK reply count as ok.
+func ParseResult(reply string) Result {
+	text := strings.TrimSpace(reply)
+	if text == "" || text == "HEARTBEAT_OK" {
+		return Result{Status: StatusOK}
 	}
 
-	hs.logInfo("Heartbeat completed: %s", result.ForLLM)
+	start, end := strings.Index(text, "{"), strings.LastIndex(text, "}")
+	if start >= 0 && end > start {
+		var result Result
+		if err := json.Unmarshal([]byte(text[start:end+1]), &result); err == nil {
+			message := strings.TrimSpace(result.Message)
+			switch {
+			case strings.EqualFold(result.Status, StatusOK):
+				return Result{Status: StatusOK}
+			case strings.EqualFold(result.Status, StatusAlert) && message != "":
+				return Result{Status: StatusAlert, Message: message}
+			}
+		}
+	}
+	return Result{Status: StatusAlert, Message: text}
 }
 
-// buildPrompt builds the heartbeat prompt from HEARTBEAT.md
-func (hs *HeartbeatService) buildPrompt() string {
-	heartbeatPath := filepath.Join(hs.workspace, "HEARTBEAT.md")
+// inQuietHours reports whether now falls in the quiet hours q.
+func inQuietHours(q config.QuietHours, now time.Time) bool {
+	start, okStart := minuteOfDay(q.Start)
+	end, okEnd := minuteOfDay(q.End)
+	if !okStart || !okEnd || start == end {
+		return false
+	}
+	m := now.Hour()*60 + now.Minute()
+	if start < end {
+		return m >= start && m < end
+	}
+	return m >= start || m < end
+}
 
-	data, err := os.ReadFile(heartbeatPath)
+// minuteOfDay parses "HH:MM".
+func minuteOfDay(s string) (int, bool) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
 	if err != nil {
-		if os.IsNotExist(err) {
-			hs.createDefaultHeartbeatTemplate()
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
+
+// buildPrompt builds the prompt of a task, from HEARTBEAT.md if the task
+// has no prompt of its own.
+func (hs *HeartbeatService) buildPrompt(t *task) string {
+	content := t.prompt
+	if content == "" {
+		heartbeatPath := filepath.Join(hs.workspace, "HEARTBEAT.md")
+
+		data, err := os.ReadFile(heartbeatPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				hs.createDefaultHeartbeatTemplate()
+				return ""
+			}
+			hs.logError("Error reading HEARTBEAT.md: %v", err)
 			return ""
 		}
-		hs.logError("Error reading HEARTBEAT.md: %v", err)
-		return ""
+		content = string(data)
 	}
-
-	content := string(data)
-	if len(content) == 0 {
+	if strings.TrimSpace(content) == "" {
 		return ""
 	}
 
-	now := time.Now().Format("2006-01-02 15:04:05")
-	return fmt.Sprintf(`# Heartbeat Check
+	now := hs.now().Format("2006-01-02 15:04:05")
+	return fmt.Sprintf(`# Heartbeat Check: %s
 
 Current time: %s
 
 You are a proactive AI assistant. This is a scheduled heartbeat check.
-Review the following tasks and execute any necessary actions using available skills.
-If there is nothing that requires attention, respond ONLY with: HEARTBEAT_OK
+Carry out the tasks below, using available tools and skills as needed.
+
+When you are done, reply with only a JSON object:
+- {"status": "ok"} if nothing needs the user's attention
+- {"status": "alert", "message": "..."} with the message to send to the user otherwise
 
 %s
-`, now, content)
+`, t.name, now, content)
 }
 
 // createDefaultHeartbeatTemplate creates the default HEARTBEAT.md file
@@ -268,7 +405,7 @@ This file contains tasks for the heartbeat service to check periodically.
 - For complex tasks that may take time, use the spawn tool to create a subagent.
 - The spawn tool is async - subagent results will be sent to the user automatically.
 - After spawning a subagent, CONTINUE to process remaining tasks.
-- Only respond with HEARTBEAT_OK when ALL tasks are done AND nothing needs attention.
+- Report "ok" only when ALL tasks are done AND nothing needs attention.
 
 ---
 
@@ -282,38 +419,55 @@ Add your heartbeat tasks below this line:
 	}
 }
 
-// sendResponse sends the heartbeat response to the last channel
-func (hs *HeartbeatService) sendResponse(response string) {
+// target is a chat alerts are sent to.
+type target struct {
+	channel string
+	chatID  string
+}
+
+func (t target) String() string {
+	return t.channel + ":" + t.chatID
+}
+
+// resolveTargets returns the chats a task alerts, or the chat the user last
+// wrote from if the task names none.
+func (hs *HeartbeatService) resolveTargets(t *task) []target {
+	specs := t.targets
+	if len(specs) == 0 {
+		specs = []string{hs.state.GetLastChannel()}
+	}
+	var targets []target
+	for _, spec := range specs {
+		if platform, userID := hs.parseLastChannel(spec); platform != "" {
+			targets = append(targets, target{channel: platform, chatID: userID})
+		}
+	}
+	return targets
+}
+
+// sendAlert sends an alert to each target.
+func (hs *HeartbeatService) sendAlert(targets []target, message string) {
 	hs.mu.RLock()
 	msgBus := hs.bus
 	hs.mu.RUnlock()
 
 	if msgBus == nil {
-		hs.logInfo("No message bus configured, heartbeat result not sent")
+		hs.logInfo("No message bus configured, heartbeat alert not sent")
 		return
 	}
-
-	// Get last channel from state
-	lastChannel := hs.state.GetLastChannel()
-	if lastChannel == "" {
-		hs.logInfo("No last channel recorded, heartbeat result not sent")
+	if len(targets) == 0 {
+		hs.logInfo("No target channel, heartbeat alert not sent")
 		return
 	}
 
-	platform, userID := hs.parseLastChannel(lastChannel)
-
-	// Skip internal channels that can't receive messages
-	if platform == "" || userID == "" {
-		return
+	for _, t := range targets {
+		msgBus.PublishOutbound(bus.OutboundMessage{
+			Channel: t.channel,
+			ChatID:  t.chatID,
+			Content: message,
+		})
+		hs.logInfo("Heartbeat alert sent to %s", t.channel)
 	}
-
-	msgBus.PublishOutbound(bus.OutboundMessage{
-		Channel: platform,
-		ChatID:  userID,
-		Content: response,
-	})
-
-	hs.logInfo("Heartbeat result sent to %s", platform)
 }
 
 // parseLastChannel parses the last channel string into platform and userID.
@@ -1,39 +1,35 @@
 package heartbeat
 
 import (
+	"context"
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
-	"github.com/sipeed/picoclaw/pkg/tools"
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
 )
 
-func TestExecuteHeartbeat_Async(t *testing.T) {
+func TestExecuteHeartbeat_CallsHandler(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "heartbeat-test-*")
 	if err != nil {
 		t.Fatalf("Failed to create temp dir: %v", err)
 	}
 	defer os.RemoveAll(tmpDir)
 
-	hs := NewHeartbeatService(tmpDir, 30, true)
+	hs := NewHeartbeatService(tmpDir, config.HeartbeatConfig{Enabled: true, Interval: 30})
 	hs.stopChan = make(chan struct{}) // Enable for testing
 
-	asyncCalled := false
-	asyncResult := &tools.ToolResult{
-		ForLLM:  "Background task started",
-		ForUser: "Task started in background",
-		Silent:  false,
-		IsError: false,
-		Async:   true,
-	}
-
-	hs.SetHandler(func(prompt, channel, chatID string) *tools.ToolResult {
-		asyncCalled = true
-		if prompt == "" {
-			t.Error("Expected non-empty prompt")
+	called := false
+	hs.SetHandler(func(agentID, prompt, channel, chatID string) (string, error) {
+		called = true
+		if !strings.Contains(prompt, "Test task") {
+			t.Errorf("prompt missing HEARTBEAT.md content: %q", prompt)
 		}
-		return asyncResult
+		return `{"status": "ok"}`, nil
 	})
 
 	// Create HEARTBEAT.md
@@ -42,7 +38,7 @@ func TestExecuteHeartbeat_Async(t *testing.T) {
 	// Execute heartbeat directly (internal method for testing)
 	hs.executeHeartbeat()
 
-	if !asyncCalled {
+	if !called {
 		t.Error("Expected handler to be called")
 	}
 }
@@ -54,17 +50,11 @@ func TestExecuteHeartbeat_Error(t *testing.T) {
 	}
 	defer os.RemoveAll(tmpDir)
 
-	hs := NewHeartbeatService(tmpDir, 30, true)
+	hs := NewHeartbeatService(tmpDir, config.HeartbeatConfig{Enabled: true, Interval: 30})
 	hs.stopChan = make(chan struct{}) // Enable for testing
 
-	hs.SetHandler(func(prompt, channel, chatID string) *tools.ToolResult {
-		return &tools.ToolResult{
-			ForLLM:  "Heartbeat failed: connection error",
-			ForUser: "",
-			Silent:  false,
-			IsError: true,
-			Async:   false,
-		}
+	hs.SetHandler(func(agentID, prompt, channel, chatID string) (string, error) {
+		return "", errors.New("connection error")
 	})
 
 	// Create HEARTBEAT.md
@@ -85,24 +75,18 @@ func TestExecuteHeartbeat_Error(t *testing.T) {
 	}
 }
 
-func TestExecuteHeartbeat_Silent(t *testing.T) {
+func TestExecuteHeartbeat_OK(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "heartbeat-test-*")
 	if err != nil {
 		t.Fatalf("Failed to create temp dir: %v", err)
 	}
 	defer os.RemoveAll(tmpDir)
 
-	hs := NewHeartbeatService(tmpDir, 30, true)
+	hs := NewHeartbeatService(tmpDir, config.HeartbeatConfig{Enabled: true, Interval: 30})
 	hs.stopChan = make(chan struct{}) // Enable for testing
 
-	hs.SetHandler(func(prompt, channel, chatID string) *tools.ToolResult {
-		return &tools.ToolResult{
-			ForLLM:  "Heartbeat completed successfully",
-			ForUser: "",
-			Silent:  true,
-			IsError: false,
-			Async:   false,
-		}
+	hs.SetHandler(func(agentID, prompt, channel, chatID string) (string, error) {
+		return `{"status": "ok"}`, nil
 	})
 
 	// Create HEARTBEAT.md
@@ -130,7 +114,7 @@ func TestHeartbeatService_StartStop(t *testing.T) {
 	}
 	defer os.RemoveAll(tmpDir)
 
-	hs := NewHeartbeatService(tmpDir, 1, true)
+	hs := NewHeartbeatService(tmpDir, config.HeartbeatConfig{Enabled: true, Interval: 1})
 
 	err = hs.Start()
 	if err != nil {
@@ -149,7 +133,7 @@ func TestHeartbeatService_Disabled(t *testing.T) {
 	}
 	defer os.RemoveAll(tmpDir)
 
-	hs := NewHeartbeatService(tmpDir, 1, false)
+	hs := NewHeartbeatService(tmpDir, config.HeartbeatConfig{Enabled: false, Interval: 1})
 
 	if hs.enabled != false {
 		t.Error("Expected service to be disabled")
@@ -159,24 +143,24 @@ func TestHeartbeatService_Disabled(t *testing.T) {
 	_ = err // Disabled service returns nil
 }
 
-func TestExecuteHeartbeat_NilResult(t *testing.T) {
+func TestExecuteHeartbeat_EmptyReply(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "heartbeat-test-*")
 	if err != nil {
 		t.Fatalf("Failed to create temp dir: %v", err)
 	}
 	defer os.RemoveAll(tmpDir)
 
-	hs := NewHeartbeatService(tmpDir, 30, true)
+	hs := NewHeartbeatService(tmpDir, config.HeartbeatConfig{Enabled: true, Interval: 30})
 	hs.stopChan = make(chan struct{}) // Enable for testing
 
-	hs.SetHandler(func(prompt, channel, chatID string) *tools.ToolResult {
-		return nil
+	hs.SetHandler(func(agentID, prompt, channel, chatID string) (string, error) {
+		return "", nil
 	})
 
 	// Create HEARTBEAT.md
 	os.WriteFile(filepath.Join(tmpDir, "HEARTBEAT.md"), []byte("Test task"), 0644)
 
-	// Should not panic with nil result
+	// An empty reply counts as ok
 	hs.executeHeartbeat()
 }
 
@@ -188,7 +172,7 @@ func TestLogPath(t *testing.T) {
 	}
 	defer os.RemoveAll(tmpDir)
 
-	hs := NewHeartbeatService(tmpDir, 30, true)
+	hs := NewHeartbeatService(tmpDir, config.HeartbeatConfig{Enabled: true, Interval: 30})
 
 	// Write a log entry
 	hs.log("INFO", "Test log entry")
@@ -208,10 +192,10 @@ func TestHeartbeatFilePath(t *testing.T) {
 	}
 	defer os.RemoveAll(tmpDir)
 
-	hs := NewHeartbeatService(tmpDir, 30, true)
+	hs := NewHeartbeatService(tmpDir, config.HeartbeatConfig{Enabled: true, Interval: 30})
 
 	// Trigger default template creation
-	hs.buildPrompt()
+	hs.buildPrompt(hs.tasks[0])
 
 	// Verify HEARTBEAT.md exists at workspace root
 	expectedPath := filepath.Join(tmpDir, "HEARTBEAT.md")
@@ -219,3 +203,177 @@ func TestHeartbeatFilePath(t *testing.T) {
 		t.Errorf("Expected HEARTBEAT.md at %s, but it doesn't exist", expectedPath)
 	}
 }
+
+func TestParseResult(t *testing.T) {
+	tests := []struct {
+		reply string
+		want  Result
+	}{
+		{`{"status": "ok"}`, Result{Status: StatusOK}},
+		{"HEARTBEAT_OK", Result{Status: StatusOK}},
+		{"", Result{Status: StatusOK}},
+		{`{"status": "alert", "message": "Disk is 95% full"}`, Result{Status: StatusAlert, Message: "Disk is 95% full"}},
+		{"All checked.\n```json\n{\"status\": \"OK\"}\n```", Result{Status: StatusOK}},
+		{"You have a meeting in 10 minutes.", Result{Status: StatusAlert, Message: "You have a meeting in 10 minutes."}},
+	}
+	for _, tt := range tests {
+		if got := ParseResult(tt.reply); got != tt.want {
+			t.Errorf("ParseResult(%q) = %+v, want %+v", tt.reply, got, tt.want)
+		}
+	}
+}
+
+func TestInQuietHours(t *testing.T) {
+	at := func(hm string) time.Time {
+		parsed, _ := time.Parse("15:04", hm)
+		return time.Date(2026, 3, 1, parsed.Hour(), parsed.Minute(), 0, 0, time.Local)
+	}
+	overnight := config.QuietHours{Start: "22:00", End: "07:00"}
+	daytime := config.QuietHours{Start: "09:00", End: "17:30"}
+
+	tests := []struct {
+		q    config.QuietHours
+		now  string
+		want bool
+	}{
+		{overnight, "23:15", true},
+		{overnight, "03:00", true},
+		{overnight, "07:00", false},
+		{overnight, "12:00", false},
+		{daytime, "17:29", true},
+		{daytime, "08:59", false},
+		{config.QuietHours{}, "03:00", false},
+	}
+	for _, tt := range tests {
+		if got := inQuietHours(tt.q, at(tt.now)); got != tt.want {
+			t.Errorf("inQuietHours(%+v, %s) = %v, want %v", tt.q, tt.now, got, tt.want)
+		}
+	}
+}
+
+func TestExecuteHeartbeat_TaskSchedules(t *testing.T) {
+	hs := NewHeartbeatService(t.TempDir(), config.HeartbeatConfig{
+		Enabled:    true,
+		Interval:   30,
+		QuietHours: config.QuietHours{Start: "22:00", End: "07:00"},
+		Tasks: []config.HeartbeatTask{
+			{Name: "fast", Prompt: "check fast", Interval: 10, AgentID: "ops"},
+			{Name: "slow", Prompt: "check slow"},
+			{Name: "always", Prompt: "check always", Interval: 60, QuietHours: &config.QuietHours{}},
+		},
+	})
+	hs.stopChan = make(chan struct{})
+
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.Local)
+	hs.now = func() time.Time { return now }
+
+	var runs []string
+	hs.SetHandler(func(agentID, prompt, channel, chatID string) (string, error) {
+		for _, name := range []string{"fast", "slow", "always"} {
+			if strings.Contains(prompt, "check "+name) {
+				runs = append(runs, agentID+"/"+name)
+			}
+		}
+		return `{"status": "ok"}`, nil
+	})
+
+	step := func(d time.Duration) []string {
+		now = now.Add(d)
+		runs = nil
+		hs.executeHeartbeat()
+		return runs
+	}
+
+	if got := strings.Join(step(0), ","); got != "ops/fast,/slow,/always" {
+		t.Errorf("first run = %s", got)
+	}
+	if got := strings.Join(step(10*time.Minute), ","); got != "ops/fast" {
+		t.Errorf("after 10 minutes = %s, want ops/fast", got)
+	}
+	if got := strings.Join(step(20*time.Minute), ","); got != "ops/fast,/slow" {
+		t.Errorf("after 30 minutes = %s, want ops/fast,/slow", got)
+	}
+
+	// At 23:00, only the task without quiet hours runs.
+	if got := strings.Join(step(10*time.Hour+30*time.Minute), ","); got != "/always" {
+		t.Errorf("in quiet hours = %s, want /always", got)
+	}
+	// Held tasks run once quiet hours are over.
+	if got := strings.Join(step(8*time.Hour), ","); got != "ops/fast,/slow,/always" {
+		t.Errorf("after quiet hours = %s", got)
+	}
+}
+
+func TestExecuteHeartbeat_AlertTargetsAndSuppression(t *testing.T) {
+	hs := NewHeartbeatService(t.TempDir(), config.HeartbeatConfig{
+		Enabled:                true,
+		SuppressRepeatsMinutes: 60,
+		Tasks: []config.HeartbeatTask{{
+			Name:     "disk",
+			Prompt:   "check the disk",
+			Interval: 5,
+			Targets:  []string{"telegram:42", "cli:direct", "discord:7"},
+		}},
+	})
+	hs.stopChan = make(chan struct{})
+	msgBus := bus.NewMessageBus()
+	hs.SetBus(msgBus)
+
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.Local)
+	hs.now = func() time.Time { return now }
+
+	reply := `{"status": "alert", "message": "Disk is 95% full"}`
+	var gotChannel, gotChatID string
+	hs.SetHandler(func(agentID, prompt, channel, chatID string) (string, error) {
+		gotChannel, gotChatID = channel, chatID
+		return reply, nil
+	})
+
+	sent := func() []string {
+		var out []string
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			msg, ok := msgBus.SubscribeOutbound(ctx)
+			cancel()
+			if !ok {
+				return out
+			}
+			out = append(out, msg.Channel+":"+msg.ChatID+" "+msg.Content)
+		}
+	}
+
+	hs.executeHeartbeat()
+	if gotChannel != "telegram" || gotChatID != "42" {
+		t.Errorf("task ran in %s:%s, want its first target", gotChannel, gotChatID)
+	}
+	want := "telegram:42 Disk is 95% full,discord:7 Disk is 95% full"
+	if got := strings.Join(sent(), ","); got != want {
+		t.Fatalf("sent %q, want %q", got, want)
+	}
+
+	// The same alert within the window is not sent again.
+	now = now.Add(10 * time.Minute)
+	hs.executeHeartbeat()
+	if got := sent(); len(got) != 0 {
+		t.Errorf("repeated alert sent: %v", got)
+	}
+
+	// A different alert is.
+	reply = `{"status": "alert", "message": "Disk is 99% full"}`
+	now = now.Add(10 * time.Minute)
+	hs.executeHeartbeat()
+	if got := sent(); len(got) != 2 {
+		t.Errorf("changed alert sent %d times, want 2", len(got))
+	}
+
+	// After an ok, the same alert is sent again.
+	reply = `{"status": "ok"}`
+	now = now.Add(10 * time.Minute)
+	hs.executeHeartbeat()
+	reply = `{"status": "alert", "message": "Disk is 99% full"}`
+	now = now.Add(10 * time.Minute)
+	hs.executeHeartbeat()
+	if got := sent(); len(got) != 2 {
+		t.Errorf("alert after recovery sent %d times, want 2", len(got))
+	}
+}
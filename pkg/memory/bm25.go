@@ -0,0 +1,119 @@
+package memory
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// BM25 parameters: k1 controls term frequency saturation, b how much long
+// entries are penalized.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// stopwords are common English words that say nothing about what an entry
+// is about.
+var stopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "but": true, "by": true, "do": true, "for": true, "from": true,
+	"has": true, "have": true, "i": true, "in": true, "is": true, "it": true,
+	"me": true, "my": true, "of": true, "on": true, "or": true, "so": true,
+	"that": true, "the": true, "this": true, "to": true, "was": true,
+	"what": true, "with": true, "you": true, "your": true,
+}
+
+// bm25 is a lexical index over entries.
+type bm25 struct {
+	docs   []map[string]int
+	lens   []int
+	avgLen float64
+	df     map[string]int
+}
+
+func newBM25(entries []Entry) *bm25 {
+	idx := &bm25{
+		docs: make([]map[string]int, len(entries)),
+		lens: make([]int, len(entries)),
+		df:   make(map[string]int),
+	}
+	total := 0
+	for i, e := range entries {
+		tf := make(map[string]int)
+		terms := tokenize(e.Section + " " + e.Text)
+		for _, term := range terms {
+			tf[term]++
+		}
+		for term := range tf {
+			idx.df[term]++
+		}
+		idx.docs[i] = tf
+		idx.lens[i] = len(terms)
+		total += len(terms)
+	}
+	if len(entries) > 0 {
+		idx.avgLen = float64(total) / float64(len(entries))
+	}
+	return idx
+}
+
+// rank returns the entries matching any of terms, best first.
+func (idx *bm25) rank(terms []string) []scored {
+	n := float64(len(idx.docs))
+	seen := make(map[string]bool, len(terms))
+	var ranking []scored
+	scores := make(map[int]float64)
+	for _, term := range terms {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		df := idx.df[term]
+		if df == 0 {
+			continue
+		}
+		idf := math.Log(1 + (n-float64(df)+0.5)/(float64(df)+0.5))
+		for i, tf := range idx.docs {
+			f := float64(tf[term])
+			if f == 0 {
+				continue
+			}
+			norm := 1 - bm25B + bm25B*float64(idx.lens[i])/idx.avgLen
+			scores[i] += idf * f * (bm25K1 + 1) / (f + bm25K1*norm)
+		}
+	}
+	for doc, score := range scores {
+		ranking = append(ranking, scored{doc: doc, score: score})
+	}
+	sortScored(ranking)
+	return ranking
+}
+
+// tokenize lowercases text and splits it into words. Han and kana
+// characters, which are not separated by spaces, become one term each.
+func tokenize(text string) []string {
+	var terms []string
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			if w := word.String(); !stopwords[w] {
+				terms = append(terms, w)
+			}
+			word.Reset()
+		}
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.Is(unicode.Han, r), unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r):
+			flush()
+			terms = append(terms, string(r))
+		case unicode.IsLetter(r), unicode.IsDigit(r):
+			word.WriteRune(r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return terms
+}
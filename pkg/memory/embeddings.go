@@ -0,0 +1,134 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+// embedBatchSize is how many texts go into one /embeddings request.
+const embedBatchSize = 64
+
+// Embedder turns texts into vectors for semantic search.
+type Embedder interface {
+	// Embed returns one vector per text, in order.
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	// Model names the embedding model; vectors from different models are
+	// not comparable.
+	Model() string
+}
+
+// OpenAIEmbedder calls an OpenAI-compatible /embeddings endpoint.
+type OpenAIEmbedder struct {
+	apiBase    string
+	apiKey     string
+	model      string
+	httpClient *http.Client
+}
+
+// NewEmbedder returns the embedder configured in cfg, or nil if embeddings
+// are disabled.
+func NewEmbedder(cfg config.EmbeddingsConfig) Embedder {
+	if !cfg.Enabled || cfg.Model == "" {
+		return nil
+	}
+	return NewOpenAIEmbedder(cfg.APIBase, cfg.APIKey, cfg.Model)
+}
+
+func NewOpenAIEmbedder(apiBase, apiKey, model string) *OpenAIEmbedder {
+	return &OpenAIEmbedder{
+		apiBase:    strings.TrimRight(apiBase, "/"),
+		apiKey:     apiKey,
+		model:      model,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Model implements Embedder.
+func (e *OpenAIEmbedder) Model() string {
+	return e.model
+}
+
+// Embed implements Embedder.
+func (e *OpenAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += embedBatchSize {
+		end := start + embedBatchSize
+		if end > len(texts) {
+			end = len(texts)
+		}
+		batch, err := e.embedBatch(ctx, texts[start:end])
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, batch...)
+	}
+	return vectors, nil
+}
+
+func (e *OpenAIEmbedder) embedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	if e.apiBase == "" {
+		return nil, fmt.Errorf("embeddings API base not configured")
+	}
+
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"model": e.model,
+		"input": texts,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", e.apiBase+"/embeddings", bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.apiKey)
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embeddings request failed:\n  Status: %d\n  Body:   %s", resp.StatusCode, string(body))
+	}
+
+	var parsed struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if len(parsed.Data) != len(texts) {
+		return nil, fmt.Errorf("embeddings response has %d vectors for %d inputs", len(parsed.Data), len(texts))
+	}
+
+	vectors := make([][]float32, len(texts))
+	for i, d := range parsed.Data {
+		idx := d.Index
+		if idx < 0 || idx >= len(texts) || vectors[idx] != nil {
+			idx = i
+		}
+		vectors[idx] = d.Embedding
+	}
+	return vectors, nil
+}
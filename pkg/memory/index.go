@@ -0,0 +1,366 @@
+// Package memory indexes an agent's memory entries so that only the ones
+// relevant to a conversation need to go into the prompt. Entries are always
+// searchable by BM25; with an Embedder they are also embedded and ranked by
+// similarity, and the two rankings are fused.
+package memory
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/secrets"
+)
+
+const (
+	// rrfK damps the weight of top ranks in reciprocal rank fusion.
+	rrfK = 60
+	// candidates is how many entries each ranking contributes to fusion.
+	candidates = 50
+	// minSimilarity is the cosine similarity below which an entry is not
+	// considered a semantic match.
+	minSimilarity = 0.2
+	// embedRetryDelay is how long to wait after a failed embeddings call
+	// before trying again.
+	embedRetryDelay = 5 * time.Minute
+)
+
+// Entry is one indexed piece of memory: a long-term memory item or a line
+// of the daily notes.
+type Entry struct {
+	ID      string `json:"id"`
+	Section string `json:"section"`
+	Text    string `json:"text"`
+	// Date is the day of a daily note, as YYYY-MM-DD.
+	Date   string    `json:"date,omitempty"`
+	Vector []float32 `json:"vector,omitempty"`
+}
+
+// NewEntry returns an entry whose ID is derived from its content, so the
+// same entry keeps its vector across rebuilds.
+func NewEntry(section, text, date string) Entry {
+	sum := sha256.Sum256([]byte(section + "\x00" + date + "\x00" + text))
+	return Entry{
+		ID:      hex.EncodeToString(sum[:8]),
+		Section: section,
+		Text:    text,
+		Date:    date,
+	}
+}
+
+// Result is an entry found by Search.
+type Result struct {
+	Entry
+	Score float64
+}
+
+// IndexPath returns where the memory index of a workspace is kept.
+func IndexPath(workspace string) string {
+	return filepath.Join(workspace, "state", "memory_index.json")
+}
+
+// indexFile is the on-disk form of an Index.
+type indexFile struct {
+	Model   string  `json:"model,omitempty"`
+	Entries []Entry `json:"entries"`
+}
+
+// Index is a searchable set of memory entries, persisted with their vectors
+// so that unchanged entries are not embedded again.
+type Index struct {
+	path     string
+	embedder Embedder
+
+	// updating serializes Update, which embeds without holding mu.
+	updating sync.Mutex
+
+	mu      sync.Mutex
+	model   string
+	entries []Entry
+	lexical *bm25
+	retryAt time.Time
+}
+
+// NewIndex opens the index at path. embedder may be nil for lexical search
+// only. A missing or unreadable index file starts an empty index.
+func NewIndex(path string, embedder Embedder) *Index {
+	x := &Index{path: path, embedder: embedder, lexical: newBM25(nil)}
+	data, err := secrets.ReadFile(path)
+	if err != nil {
+		return x
+	}
+	var f indexFile
+	if json.Unmarshal(data, &f) != nil {
+		return x
+	}
+	x.model = f.Model
+	x.entries = f.Entries
+	x.lexical = newBM25(f.Entries)
+	return x
+}
+
+// Len returns the number of indexed entries.
+func (x *Index) Len() int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return len(x.entries)
+}
+
+// Update makes entries the indexed set. Vectors are reused for entries
+// already indexed and computed for new ones. If embedding fails the entries
+// are still searchable lexically, and the error is returned. The embeddings
+// call is made without holding the index lock, so searches are not held up
+// by it.
+func (x *Index) Update(ctx context.Context, entries []Entry) error {
+	x.updating.Lock()
+	defer x.updating.Unlock()
+
+	model, missing, err := x.apply(entries)
+	if err != nil || len(missing) == 0 {
+		return err
+	}
+
+	texts := make([]string, len(missing))
+	for i, e := range missing {
+		texts[i] = embeddingText(e)
+	}
+	vectors, err := x.embedder.Embed(ctx, texts)
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if err != nil {
+		x.retryAt = time.Now().Add(embedRetryDelay)
+		return fmt.Errorf("embedding memory entries: %w", err)
+	}
+	if x.model != model {
+		return nil
+	}
+	embedded := make(map[string][]float32, len(missing))
+	for i, e := range missing {
+		if i < len(vectors) {
+			embedded[e.ID] = vectors[i]
+		}
+	}
+	// Searches may hold the old slice, so fill in a copy.
+	updated := append([]Entry(nil), x.entries...)
+	for i := range updated {
+		if updated[i].Vector == nil {
+			updated[i].Vector = embedded[updated[i].ID]
+		}
+	}
+	x.entries = updated
+	return x.save()
+}
+
+// apply makes entries the indexed set, reusing the vectors already known,
+// and returns the embedding model along with the entries that still need
+// embedding now.
+func (x *Index) apply(entries []Entry) (string, []Entry, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	model := ""
+	if x.embedder != nil {
+		model = x.embedder.Model()
+	}
+	if !sameEntries(x.entries, entries) || x.model != model {
+		previous := make(map[string][]float32, len(x.entries))
+		if x.model == model {
+			for _, e := range x.entries {
+				previous[e.ID] = e.Vector
+			}
+		}
+		updated := make([]Entry, len(entries))
+		for i, e := range entries {
+			e.Vector = previous[e.ID]
+			updated[i] = e
+		}
+		x.entries = updated
+		x.model = model
+		x.lexical = newBM25(updated)
+		if err := x.save(); err != nil {
+			return model, nil, err
+		}
+	}
+
+	if x.embedder == nil || time.Now().Before(x.retryAt) {
+		return model, nil, nil
+	}
+	var missing []Entry
+	for _, e := range x.entries {
+		if e.Vector == nil {
+			missing = append(missing, e)
+		}
+	}
+	return model, missing, nil
+}
+
+// Rebuild discards the index, including all vectors, and indexes entries
+// from scratch.
+func (x *Index) Rebuild(ctx context.Context, entries []Entry) error {
+	x.mu.Lock()
+	x.entries = nil
+	x.model = ""
+	x.retryAt = time.Time{}
+	x.mu.Unlock()
+	return x.Update(ctx, entries)
+}
+
+// save writes the index through a temporary file, encrypted when
+// encryption at rest is enabled. Callers must hold x.mu.
+func (x *Index) save() error {
+	data, err := json.Marshal(indexFile{Model: x.model, Entries: x.entries})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(x.path), 0755); err != nil {
+		return err
+	}
+	return secrets.WriteFile(x.path, data, 0644)
+}
+
+// Search returns up to k entries relevant to query, best first. Without an
+// embedder, or if embedding the query fails, entries are ranked by BM25
+// alone; a failure is returned alongside the lexical results. After a
+// failed embeddings call, searches stay lexical until the retry delay has
+// passed, so a dead endpoint does not slow down every search.
+func (x *Index) Search(ctx context.Context, query string, k int) ([]Result, error) {
+	x.mu.Lock()
+	entries, lexical := x.entries, x.lexical
+	useVectors := x.embedder != nil && x.model == x.embedder.Model() && !time.Now().Before(x.retryAt)
+	x.mu.Unlock()
+
+	if len(entries) == 0 || k <= 0 {
+		return nil, nil
+	}
+
+	lexicalRanking := lexical.rank(tokenize(query))
+	var semanticRanking []scored
+	var err error
+	if useVectors {
+		semanticRanking, err = x.semanticRank(ctx, entries, query)
+	}
+
+	var ranking []scored
+	if len(semanticRanking) == 0 {
+		ranking = lexicalRanking
+	} else {
+		ranking = fuse(lexicalRanking, semanticRanking)
+	}
+	if len(ranking) > k {
+		ranking = ranking[:k]
+	}
+
+	results := make([]Result, len(ranking))
+	for i, s := range ranking {
+		results[i] = Result{Entry: entries[s.doc], Score: s.score}
+		results[i].Vector = nil
+	}
+	return results, err
+}
+
+func (x *Index) semanticRank(ctx context.Context, entries []Entry, query string) ([]scored, error) {
+	vectors, err := x.embedder.Embed(ctx, []string{query})
+	if err != nil {
+		x.mu.Lock()
+		x.retryAt = time.Now().Add(embedRetryDelay)
+		x.mu.Unlock()
+		return nil, fmt.Errorf("embedding memory query: %w", err)
+	}
+	if len(vectors) != 1 {
+		return nil, nil
+	}
+	q := vectors[0]
+
+	var ranking []scored
+	for i, e := range entries {
+		if e.Vector == nil {
+			continue
+		}
+		if sim := cosine(q, e.Vector); sim >= minSimilarity {
+			ranking = append(ranking, scored{doc: i, score: sim})
+		}
+	}
+	sortScored(ranking)
+	return ranking, nil
+}
+
+// embeddingText is what gets embedded for an entry; the section gives short
+// entries some context.
+func embeddingText(e Entry) string {
+	if e.Section == "" {
+		return e.Text
+	}
+	return e.Section + ": " + e.Text
+}
+
+func sameEntries(a, b []Entry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ID != b[i].ID {
+			return false
+		}
+	}
+	return true
+}
+
+type scored struct {
+	doc   int
+	score float64
+}
+
+// sortScored orders s best first, breaking ties by document order.
+func sortScored(s []scored) {
+	sort.Slice(s, func(i, j int) bool {
+		if s[i].score != s[j].score {
+			return s[i].score > s[j].score
+		}
+		return s[i].doc < s[j].doc
+	})
+}
+
+// fuse combines rankings by reciprocal rank fusion, using the top
+// candidates of each.
+func fuse(rankings ...[]scored) []scored {
+	scores := make(map[int]float64)
+	for _, ranking := range rankings {
+		for rank, s := range ranking {
+			if rank >= candidates {
+				break
+			}
+			scores[s.doc] += 1 / float64(rrfK+rank+1)
+		}
+	}
+	fused := make([]scored, 0, len(scores))
+	for doc, score := range scores {
+		fused = append(fused, scored{doc: doc, score: score})
+	}
+	sortScored(fused)
+	return fused
+}
+
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
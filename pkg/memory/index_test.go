@@ -0,0 +1,265 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/secrets"
+)
+
+func testEntries() []Entry {
+	return []Entry{
+		NewEntry("Preferences", "Prefers metric units for weather reports", ""),
+		NewEntry("Preferences", "Likes green tea in the morning", ""),
+		NewEntry("Projects", "Building a weather station on a Raspberry Pi", ""),
+		NewEntry("Daily Notes", "Called the plumber about the kitchen sink", "2026-10-16"),
+	}
+}
+
+func TestIndex_LexicalSearch(t *testing.T) {
+	x := NewIndex(filepath.Join(t.TempDir(), "index.json"), nil)
+	if err := x.Update(context.Background(), testEntries()); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := x.Search(context.Background(), "what's the weather like?", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected the two weather entries, got %+v", results)
+	}
+	for _, r := range results {
+		if !strings.Contains(r.Text, "weather") {
+			t.Errorf("unexpected result %q", r.Text)
+		}
+	}
+
+	if results, _ := x.Search(context.Background(), "kitchen", 1); len(results) != 1 || results[0].Date != "2026-10-16" {
+		t.Errorf("expected the daily note, got %+v", results)
+	}
+	if results, _ := x.Search(context.Background(), "the and of", 5); len(results) != 0 {
+		t.Errorf("stopwords should not match, got %+v", results)
+	}
+}
+
+func TestIndex_EncryptedAtRest(t *testing.T) {
+	raw, _ := secrets.GenerateKey()
+	key, _ := secrets.NewKey(raw)
+	secrets.SetKeys(key)
+	t.Cleanup(func() { secrets.SetKeys(nil) })
+
+	path := filepath.Join(t.TempDir(), "index.json")
+	if err := NewIndex(path, nil).Update(context.Background(), testEntries()); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if !secrets.IsSealed(data) {
+		t.Fatalf("index is not encrypted:\n%s", data)
+	}
+	if n := NewIndex(path, nil).Len(); n != 4 {
+		t.Errorf("reopened index has %d entries, want 4", n)
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	got := strings.Join(tokenize("The user's cat is named Miso; 猫が好き"), ",")
+	want := "user,s,cat,named,miso,猫,が,好,き"
+	if got != want {
+		t.Errorf("tokenize = %q, want %q", got, want)
+	}
+}
+
+// fakeEmbedder maps texts to vectors by keyword, so "tea" and "drink" land
+// close together without sharing a word.
+type fakeEmbedder struct {
+	calls int
+	texts int
+	fail  bool
+}
+
+func (e *fakeEmbedder) Model() string { return "fake" }
+
+func (e *fakeEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	e.calls++
+	e.texts += len(texts)
+	if e.fail {
+		return nil, errors.New("unavailable")
+	}
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		lower := strings.ToLower(text)
+		v := []float32{0.01, 0.01, 0.01}
+		if strings.Contains(lower, "tea") || strings.Contains(lower, "drink") {
+			v[0] = 1
+		}
+		if strings.Contains(lower, "weather") || strings.Contains(lower, "forecast") {
+			v[1] = 1
+		}
+		if strings.Contains(lower, "plumber") {
+			v[2] = 1
+		}
+		vectors[i] = v
+	}
+	return vectors, nil
+}
+
+func TestIndex_SemanticSearchAndVectorReuse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.json")
+	embedder := &fakeEmbedder{}
+	x := NewIndex(path, embedder)
+	if err := x.Update(context.Background(), testEntries()); err != nil {
+		t.Fatal(err)
+	}
+	if embedder.texts != 4 {
+		t.Fatalf("expected all entries embedded, got %d", embedder.texts)
+	}
+
+	results, err := x.Search(context.Background(), "what should I drink?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !strings.Contains(results[0].Text, "green tea") {
+		t.Fatalf("expected a semantic match on tea, got %+v", results)
+	}
+
+	// Reopening and adding one entry embeds only the new one.
+	embedder = &fakeEmbedder{}
+	x = NewIndex(path, embedder)
+	entries := append(testEntries(), NewEntry("Preferences", "Drinks oat milk", ""))
+	if err := x.Update(context.Background(), entries); err != nil {
+		t.Fatal(err)
+	}
+	if embedder.texts != 1 {
+		t.Errorf("expected only the new entry to be embedded, got %d", embedder.texts)
+	}
+
+	// Rebuild embeds everything again.
+	if err := x.Rebuild(context.Background(), entries); err != nil {
+		t.Fatal(err)
+	}
+	if embedder.texts != 6 {
+		t.Errorf("expected rebuild to re-embed all entries, got %d total", embedder.texts)
+	}
+}
+
+func TestIndex_EmbeddingFailureFallsBackToLexical(t *testing.T) {
+	embedder := &fakeEmbedder{fail: true}
+	x := NewIndex(filepath.Join(t.TempDir(), "index.json"), embedder)
+	if err := x.Update(context.Background(), testEntries()); err == nil {
+		t.Fatal("expected the embedding error to be reported")
+	}
+
+	results, _ := x.Search(context.Background(), "weather", 5)
+	if len(results) != 2 {
+		t.Fatalf("expected lexical results, got %+v", results)
+	}
+
+	// Failed embeddings are not retried on every update.
+	calls := embedder.calls
+	x.Update(context.Background(), testEntries())
+	if embedder.calls != calls {
+		t.Errorf("embedding retried immediately after a failure")
+	}
+}
+
+func TestIndex_QueryEmbeddingFailureBacksOff(t *testing.T) {
+	embedder := &fakeEmbedder{}
+	x := NewIndex(filepath.Join(t.TempDir(), "index.json"), embedder)
+	if err := x.Update(context.Background(), testEntries()); err != nil {
+		t.Fatal(err)
+	}
+
+	embedder.fail = true
+	results, err := x.Search(context.Background(), "weather", 5)
+	if err == nil {
+		t.Fatal("expected the query embedding error to be reported")
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected lexical results, got %+v", results)
+	}
+
+	// Later searches stay lexical instead of waiting on the endpoint again.
+	calls := embedder.calls
+	results, err = x.Search(context.Background(), "weather", 5)
+	if err != nil || len(results) != 2 {
+		t.Fatalf("expected lexical results without error, got %+v, %v", results, err)
+	}
+	if embedder.calls != calls {
+		t.Errorf("query embedded again right after a failure")
+	}
+}
+
+// gatedEmbedder holds its first call until released.
+type gatedEmbedder struct {
+	fakeEmbedder
+	gated   atomic.Bool
+	started chan struct{}
+	release chan struct{}
+}
+
+func (e *gatedEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if e.gated.CompareAndSwap(false, true) {
+		close(e.started)
+		<-e.release
+	}
+	return e.fakeEmbedder.Embed(ctx, texts)
+}
+
+func TestIndex_SearchDoesNotWaitForUpdateEmbeddings(t *testing.T) {
+	embedder := &gatedEmbedder{started: make(chan struct{}), release: make(chan struct{})}
+	x := NewIndex(filepath.Join(t.TempDir(), "index.json"), embedder)
+
+	done := make(chan error, 1)
+	go func() { done <- x.Update(context.Background(), testEntries()) }()
+	<-embedder.started
+
+	results, _ := x.Search(context.Background(), "plumber", 5)
+	if len(results) != 1 {
+		t.Fatalf("expected a lexical result while entries are embedded, got %+v", results)
+	}
+
+	close(embedder.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if results, _ := x.Search(context.Background(), "what should I drink?", 1); len(results) != 1 || !strings.Contains(results[0].Text, "green tea") {
+		t.Fatalf("expected the vectors to be filled in after the update, got %+v", results)
+	}
+}
+
+func TestOpenAIEmbedder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/embeddings" || r.Header.Get("Authorization") != "Bearer key" {
+			t.Errorf("unexpected request %s with auth %q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		var req struct {
+			Model string   `json:"model"`
+			Input []string `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Model != "embed-small" || len(req.Input) != 2 {
+			t.Errorf("unexpected request body %+v", req)
+		}
+		// Out of order, as some servers return them.
+		w.Write([]byte(`{"data":[{"index":1,"embedding":[0,1]},{"index":0,"embedding":[1,0]}]}`))
+	}))
+	defer server.Close()
+
+	e := NewOpenAIEmbedder(server.URL+"/", "key", "embed-small")
+	vectors, err := e.Embed(context.Background(), []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vectors) != 2 || vectors[0][0] != 1 || vectors[1][1] != 1 {
+		t.Errorf("unexpected vectors %v", vectors)
+	}
+}
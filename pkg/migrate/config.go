@@ -231,6 +231,8 @@ func ConvertConfig(data map[string]interface{}) (*config.Config, []string, error
 		}
 	}
 
+	warnings = append(warnings, ConvertModelList(cfg)...)
+
 	return cfg, warnings, nil
 }
 
@@ -257,6 +259,12 @@ func MergeConfig(existing, incoming *config.Config) *config.Config {
 		existing.Providers.Gemini = incoming.Providers.Gemini
 	}
 
+	for _, entry := range incoming.ModelList {
+		if existing.FindModel(entry.Name) == nil {
+			existing.ModelList = append(existing.ModelList, entry)
+		}
+	}
+
 	if !existing.Channels.Telegram.Enabled && incoming.Channels.Telegram.Enabled {
 		existing.Channels.Telegram = incoming.Channels.Telegram
 	}
@@ -27,8 +27,11 @@ type Options struct {
 	WorkspaceOnly bool
 	Force         bool
 	Refresh       bool
-	OpenClawHome  string
-	PicoClawHome  string
+	// ModelList converts the providers block of the PicoClaw config to
+	// model_list instead of migrating from OpenClaw.
+	ModelList    bool
+	OpenClawHome string
+	PicoClawHome string
 }
 
 type Action struct {
@@ -67,6 +70,10 @@ func Run(opts Options) (*Result, error) {
 		return nil, err
 	}
 
+	if opts.ModelList {
+		return migrateModelList(opts, picoClawHome)
+	}
+
 	if _, err := os.Stat(openclawHome); os.IsNotExist(err) {
 		return nil, fmt.Errorf("OpenClaw installation not found at %s", openclawHome)
 	}
@@ -268,13 +275,7 @@ func PrintPlan(actions []Action, warnings []string) {
 		}
 	}
 
-	if len(warnings) > 0 {
-		fmt.Println()
-		fmt.Println("Warnings:")
-		for _, w := range warnings {
-			fmt.Printf("  - %s\n", w)
-		}
-	}
+	printWarnings(warnings)
 
 	fmt.Println()
 	fmt.Printf("%d files to copy, %d configs to convert, %d backups needed, %d skipped\n",
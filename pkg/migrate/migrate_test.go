@@ -177,6 +177,36 @@ func TestConvertConfig(t *testing.T) {
 		}
 	})
 
+	t.Run("model list from providers", func(t *testing.T) {
+		data := map[string]interface{}{
+			"agents": map[string]interface{}{
+				"defaults": map[string]interface{}{
+					"model": "groq/llama-3.3-70b",
+				},
+			},
+			"providers": map[string]interface{}{
+				"groq": map[string]interface{}{
+					"api_key": "gsk-test",
+				},
+			},
+		}
+
+		cfg, _, err := ConvertConfig(data)
+		if err != nil {
+			t.Fatalf("ConvertConfig: %v", err)
+		}
+		entry := cfg.FindModel("groq/llama-3.3-70b")
+		if entry == nil {
+			t.Fatalf("model_list has no entry for the default model: %+v", cfg.ModelList)
+		}
+		if entry.Protocol != config.ProtocolOpenAI || entry.APIBase != "https://api.groq.com/openai/v1" || entry.APIKey != "gsk-test" {
+			t.Errorf("entry = %+v", *entry)
+		}
+		if cfg.Providers.Groq.APIKey != "gsk-test" {
+			t.Error("providers block should be kept")
+		}
+	})
+
 	t.Run("unsupported provider warning", func(t *testing.T) {
 		data := map[string]interface{}{
 			"providers": map[string]interface{}{
@@ -365,6 +395,28 @@ func TestMergeConfig(t *testing.T) {
 		}
 	})
 
+	t.Run("adds missing model list entries", func(t *testing.T) {
+		existing := config.DefaultConfig()
+		existing.ModelList = []config.ModelEntry{{Name: "fast", Protocol: config.ProtocolOllama, Model: "qwen2.5:7b"}}
+
+		incoming := config.DefaultConfig()
+		incoming.ModelList = []config.ModelEntry{
+			{Name: "fast", Protocol: config.ProtocolGemini, Model: "gemini-2.5-flash"},
+			{Name: "smart", Protocol: config.ProtocolAnthropic, Model: "claude-sonnet-4-5"},
+		}
+
+		result := MergeConfig(existing, incoming)
+		if len(result.ModelList) != 2 {
+			t.Fatalf("ModelList = %+v, want 2 entries", result.ModelList)
+		}
+		if result.FindModel("fast").Protocol != config.ProtocolOllama {
+			t.Error("existing model_list entry should be preserved")
+		}
+		if result.FindModel("smart") == nil {
+			t.Error("new model_list entry should be added")
+		}
+	})
+
 	t.Run("preserves existing enabled channels", func(t *testing.T) {
 		existing := config.DefaultConfig()
 		existing.Channels.Telegram.Enabled = true
@@ -0,0 +1,90 @@
+package migrate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// ConvertModelList adds a model_list entry for every model cfg's agents
+// refer to through the providers block. Entries are named after the
+// references, so the rest of the config is left as is; so is the providers
+// block, which voice transcription and provider options still read.
+func ConvertModelList(cfg *config.Config) []string {
+	entries, warnings := providers.ModelListFromProviders(cfg)
+	cfg.ModelList = append(cfg.ModelList, entries...)
+	return warnings
+}
+
+// migrateModelList converts the providers block of the PicoClaw config in
+// place, keeping a backup of the old file.
+func migrateModelList(opts Options, picoClawHome string) (*Result, error) {
+	configPath := filepath.Join(picoClawHome, "config.json")
+	if _, err := os.Stat(configPath); err != nil {
+		return nil, fmt.Errorf("PicoClaw config not found at %s", configPath)
+	}
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("loading PicoClaw config: %w", err)
+	}
+
+	before := len(cfg.ModelList)
+	warnings := ConvertModelList(cfg)
+	added := cfg.ModelList[before:]
+
+	fmt.Println("Converting providers to model_list")
+	fmt.Printf("  Config: %s\n", configPath)
+	fmt.Println()
+
+	if len(added) == 0 {
+		printWarnings(warnings)
+		fmt.Println("No models to convert.")
+		return &Result{Warnings: warnings}, nil
+	}
+
+	fmt.Println("Planned entries:")
+	for _, e := range added {
+		fmt.Printf("  [model]   %s -> %s %s\n", e.Name, e.Protocol, e.Model)
+	}
+	printWarnings(warnings)
+	fmt.Println()
+
+	if opts.DryRun {
+		return &Result{Warnings: warnings}, nil
+	}
+	if !opts.Force {
+		if !Confirm() {
+			fmt.Println("Aborted.")
+			return &Result{Warnings: warnings}, nil
+		}
+		fmt.Println()
+	}
+
+	result := &Result{Warnings: warnings}
+	if err := backupFile(configPath); err != nil {
+		return nil, fmt.Errorf("backing up config: %w", err)
+	}
+	result.BackupsCreated++
+	fmt.Printf("  ✓ Backed up %s -> %s.bak\n", filepath.Base(configPath), filepath.Base(configPath))
+
+	if err := config.SaveConfig(configPath, cfg); err != nil {
+		return nil, fmt.Errorf("saving config: %w", err)
+	}
+	result.ConfigMigrated = true
+	fmt.Printf("  ✓ Added %d model_list entries to %s\n", len(added), configPath)
+	return result, nil
+}
+
+func printWarnings(warnings []string) {
+	if len(warnings) == 0 {
+		return
+	}
+	fmt.Println()
+	fmt.Println("Warnings:")
+	for _, w := range warnings {
+		fmt.Printf("  - %s\n", w)
+	}
+}
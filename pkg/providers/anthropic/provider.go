@@ -22,6 +22,10 @@ type ToolFunctionDefinition = protocoltypes.ToolFunctionDefinition
 
 const defaultBaseURL = "https://api.anthropic.com"
 
+// maxCacheBreakpoints is how many cache_control breakpoints a request may
+// carry.
+const maxCacheBreakpoints = 4
+
 type Provider struct {
 	client      *anthropic.Client
 	tokenSource func() (string, error)
@@ -44,6 +48,20 @@ func NewProviderWithBaseURL(token, apiBase string) *Provider {
 	}
 }
 
+// NewProviderWithAPIKey authenticates with an API key sent as x-api-key,
+// rather than with an OAuth token.
+func NewProviderWithAPIKey(apiKey, apiBase string) *Provider {
+	baseURL := normalizeBaseURL(apiBase)
+	client := anthropic.NewClient(
+		option.WithAPIKey(apiKey),
+		option.WithBaseURL(baseURL),
+	)
+	return &Provider{
+		client:  &client,
+		baseURL: baseURL,
+	}
+}
+
 func NewProviderWithClient(client *anthropic.Client) *Provider {
 	return &Provider{
 		client:  client,
@@ -62,13 +80,9 @@ func NewProviderWithTokenSourceAndBaseURL(token string, tokenSource func() (stri
 }
 
 func (p *Provider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
-	var opts []option.RequestOption
-	if p.tokenSource != nil {
-		tok, err := p.tokenSource()
-		if err != nil {
-			return nil, fmt.Errorf("refreshing token: %w", err)
-		}
-		opts = append(opts, option.WithAuthToken(tok))
+	opts, err := p.requestOptions()
+	if err != nil {
+		return nil, err
 	}
 
 	params, err := buildParams(messages, tools, model, options)
@@ -78,10 +92,60 @@ func (p *Provider) Chat(ctx context.Context, messages []Message, tools []ToolDef
 
 	resp, err := p.client.Messages.New(ctx, params, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("claude API call: %w", err)
+		return nil, protocoltypes.WrapContextLength(fmt.Errorf("claude API call: %w", err), err.Error())
 	}
 
-	return parseResponse(resp), nil
+	return structuredReply(parseResponse(resp), protocoltypes.ResponseFormatFrom(options)), nil
+}
+
+// ChatStream sends the request over the streaming Messages API, calling
+// onDelta with each text fragment, and returns the accumulated response.
+func (p *Provider) ChatStream(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}, onDelta func(string)) (*LLMResponse, error) {
+	opts, err := p.requestOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	params, err := buildParams(messages, tools, model, options)
+	if err != nil {
+		return nil, err
+	}
+
+	stream := p.client.Messages.NewStreaming(ctx, params, opts...)
+	defer stream.Close()
+
+	var message anthropic.Message
+	for stream.Next() {
+		event := stream.Current()
+		if err := message.Accumulate(event); err != nil {
+			return nil, fmt.Errorf("claude stream: %w", err)
+		}
+		if onDelta == nil {
+			continue
+		}
+		if delta, ok := event.AsAny().(anthropic.ContentBlockDeltaEvent); ok {
+			if text, ok := delta.Delta.AsAny().(anthropic.TextDelta); ok && text.Text != "" {
+				onDelta(text.Text)
+			}
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return nil, protocoltypes.WrapContextLength(fmt.Errorf("claude API call: %w", err), err.Error())
+	}
+
+	return structuredReply(parseResponse(&message), protocoltypes.ResponseFormatFrom(options)), nil
+}
+
+func (p *Provider) requestOptions() ([]option.RequestOption, error) {
+	var opts []option.RequestOption
+	if p.tokenSource != nil {
+		tok, err := p.tokenSource()
+		if err != nil {
+			return nil, fmt.Errorf("refreshing token: %w", err)
+		}
+		opts = append(opts, option.WithAuthToken(tok))
+	}
+	return opts, nil
 }
 
 func (p *Provider) GetDefaultModel() string {
@@ -95,16 +159,28 @@ func (p *Provider) BaseURL() string {
 func buildParams(messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (anthropic.MessageNewParams, error) {
 	var system []anthropic.TextBlockParam
 	var anthropicMessages []anthropic.MessageParam
+	var cached []int // indexes of anthropicMessages that end a cacheable prefix
 
 	for _, msg := range messages {
+		if msg.CacheBreakpoint && msg.Role != "system" {
+			cached = append(cached, len(anthropicMessages))
+		}
 		switch msg.Role {
 		case "system":
-			system = append(system, anthropic.TextBlockParam{Text: msg.Content})
+			block := anthropic.TextBlockParam{Text: msg.Content}
+			if msg.CacheBreakpoint {
+				block.CacheControl = anthropic.NewCacheControlEphemeralParam()
+			}
+			system = append(system, block)
 		case "user":
 			if msg.ToolCallID != "" {
 				anthropicMessages = append(anthropicMessages,
 					anthropic.NewUserMessage(anthropic.NewToolResultBlock(msg.ToolCallID, msg.Content, false)),
 				)
+			} else if len(msg.Parts) > 0 {
+				anthropicMessages = append(anthropicMessages,
+					anthropic.NewUserMessage(translateParts(msg.Parts)...),
+				)
 			} else {
 				anthropicMessages = append(anthropicMessages,
 					anthropic.NewUserMessage(anthropic.NewTextBlock(msg.Content)),
@@ -151,13 +227,95 @@ func buildParams(messages []Message, tools []ToolDefinition, model string, optio
 		params.Temperature = anthropic.Float(temp)
 	}
 
+	if topP, ok := options["top_p"].(float64); ok {
+		params.TopP = anthropic.Float(topP)
+	}
+
+	if stop, ok := options["stop"].([]string); ok && len(stop) > 0 {
+		params.StopSequences = stop
+	}
+
 	if len(tools) > 0 {
 		params.Tools = translateTools(tools)
 	}
 
+	if rf := protocoltypes.ResponseFormatFrom(options); rf != nil {
+		tool := responseTool(rf)
+		params.Tools = append(params.Tools, anthropic.ToolUnionParam{OfTool: &tool})
+		if len(tools) == 0 {
+			params.ToolChoice = anthropic.ToolChoiceParamOfTool(responseToolName)
+		} else {
+			// The model may still use the other tools first, but it cannot
+			// answer in free text.
+			params.ToolChoice = anthropic.ToolChoiceUnionParam{OfAny: &anthropic.ToolChoiceAnyParam{}}
+		}
+	}
+
+	setCacheBreakpoints(&params, cached)
+
 	return params, nil
 }
 
+// setCacheBreakpoints marks the prefixes of the request Anthropic should
+// cache: the tools, which change least, the system prompt and history
+// prefixes the caller marked, and the whole request, so the next tool loop
+// iteration can build on it. System blocks already carry their marks.
+func setCacheBreakpoints(params *anthropic.MessageNewParams, cached []int) {
+	budget := maxCacheBreakpoints
+	for _, block := range params.System {
+		if block.CacheControl.Type != "" {
+			budget--
+		}
+	}
+	if n := len(params.Tools); n > 0 && budget > 0 {
+		if tool := params.Tools[n-1].OfTool; tool != nil {
+			tool.CacheControl = anthropic.NewCacheControlEphemeralParam()
+			budget--
+		}
+	}
+
+	last := len(params.Messages) - 1
+	if last >= 0 && (len(cached) == 0 || cached[len(cached)-1] != last) {
+		cached = append(cached, last)
+	}
+	// The most recent prefixes are the most useful; older marks are
+	// dropped when there are too many.
+	if len(cached) > budget {
+		cached = cached[len(cached)-budget:]
+	}
+	for _, i := range cached {
+		if i < 0 || i > last {
+			continue
+		}
+		content := params.Messages[i].Content
+		if len(content) == 0 {
+			continue
+		}
+		if cc := content[len(content)-1].GetCacheControl(); cc != nil {
+			*cc = anthropic.NewCacheControlEphemeralParam()
+		}
+	}
+}
+
+func translateParts(parts []protocoltypes.ContentPart) []anthropic.ContentBlockParamUnion {
+	blocks := make([]anthropic.ContentBlockParamUnion, 0, len(parts))
+	for _, part := range parts {
+		switch part.Type {
+		case protocoltypes.ContentPartText:
+			if part.Text != "" {
+				blocks = append(blocks, anthropic.NewTextBlock(part.Text))
+			}
+		case protocoltypes.ContentPartImage:
+			if part.Data != "" {
+				blocks = append(blocks, anthropic.NewImageBlockBase64(part.MediaType, part.Data))
+			} else if part.URL != "" {
+				blocks = append(blocks, anthropic.NewImageBlock(anthropic.URLImageSourceParam{URL: part.URL}))
+			}
+		}
+	}
+	return blocks
+}
+
 func translateTools(tools []ToolDefinition) []anthropic.ToolUnionParam {
 	result := make([]anthropic.ToolUnionParam, 0, len(tools))
 	for _, t := range tools {
@@ -184,6 +342,84 @@ func translateTools(tools []ToolDefinition) []anthropic.ToolUnionParam {
 	return result
 }
 
+// responseToolName is the tool the model is made to call with its reply
+// when a response format is set; the tool input is the reply.
+const responseToolName = "structured_response"
+
+// responseTool describes the response format as a tool. Tool input must be
+// an object, so other schemas are wrapped in a "value" property.
+func responseTool(rf *protocoltypes.ResponseFormat) anthropic.ToolParam {
+	tool := anthropic.ToolParam{
+		Name:        responseToolName,
+		Description: anthropic.String(fmt.Sprintf("Give your final answer (%s) by calling this tool; its input is the answer.", rf.SchemaName())),
+	}
+	if rf.Schema["type"] != "object" {
+		tool.InputSchema = anthropic.ToolInputSchemaParam{
+			Properties: map[string]interface{}{"value": rf.Schema},
+			Required:   []string{"value"},
+		}
+		return tool
+	}
+	extra := make(map[string]interface{})
+	for k, v := range rf.Schema {
+		switch k {
+		case "type":
+		case "properties":
+			tool.InputSchema.Properties = v
+		case "required":
+			tool.InputSchema.Required = stringList(v)
+		default:
+			extra[k] = v
+		}
+	}
+	if len(extra) > 0 {
+		tool.InputSchema.ExtraFields = extra
+	}
+	return tool
+}
+
+// structuredReply turns the response tool call of resp into its content.
+func structuredReply(resp *LLMResponse, rf *protocoltypes.ResponseFormat) *LLMResponse {
+	if rf == nil {
+		return resp
+	}
+	calls := resp.ToolCalls[:0]
+	for _, tc := range resp.ToolCalls {
+		if tc.Name != responseToolName {
+			calls = append(calls, tc)
+			continue
+		}
+		var value interface{} = tc.Arguments
+		if rf.Schema["type"] != "object" {
+			value = tc.Arguments["value"]
+		}
+		if data, err := json.Marshal(value); err == nil {
+			resp.Content = string(data)
+		}
+	}
+	resp.ToolCalls = calls
+	if len(calls) == 0 && resp.FinishReason == "tool_calls" {
+		resp.FinishReason = "stop"
+	}
+	return resp
+}
+
+func stringList(v interface{}) []string {
+	switch list := v.(type) {
+	case []string:
+		return list
+	case []interface{}:
+		out := make([]string, 0, len(list))
+		for _, item := range list {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
 func parseResponse(resp *anthropic.Message) *LLMResponse {
 	var content string
 	var toolCalls []ToolCall
@@ -218,14 +454,18 @@ func parseResponse(resp *anthropic.Message) *LLMResponse {
 		finishReason = "stop"
 	}
 
+	// InputTokens only counts the input after the last cache breakpoint.
+	promptTokens := resp.Usage.InputTokens + resp.Usage.CacheReadInputTokens + resp.Usage.CacheCreationInputTokens
 	return &LLMResponse{
 		Content:      content,
 		ToolCalls:    toolCalls,
 		FinishReason: finishReason,
 		Usage: &UsageInfo{
-			PromptTokens:     int(resp.Usage.InputTokens),
+			PromptTokens:     int(promptTokens),
 			CompletionTokens: int(resp.Usage.OutputTokens),
-			TotalTokens:      int(resp.Usage.InputTokens + resp.Usage.OutputTokens),
+			TotalTokens:      int(promptTokens + resp.Usage.OutputTokens),
+			CachedTokens:     int(resp.Usage.CacheReadInputTokens),
+			CacheWriteTokens: int(resp.Usage.CacheCreationInputTokens),
 		},
 	}
 }
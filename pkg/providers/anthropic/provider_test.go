@@ -2,13 +2,16 @@ package anthropicprovider
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"sync/atomic"
 	"testing"
 
 	"github.com/anthropics/anthropic-sdk-go"
 	anthropicoption "github.com/anthropics/anthropic-sdk-go/option"
+	"github.com/sipeed/picoclaw/pkg/providers/protocoltypes"
 )
 
 func TestBuildParams_BasicMessage(t *testing.T) {
@@ -32,6 +35,60 @@ func TestBuildParams_BasicMessage(t *testing.T) {
 	}
 }
 
+func TestBuildParams_SamplingOptions(t *testing.T) {
+	messages := []Message{
+		{Role: "user", Content: "Hello"},
+	}
+	params, err := buildParams(messages, nil, "claude-sonnet-4-5-20250929", map[string]interface{}{
+		"top_p":            0.9,
+		"stop":             []string{"END"},
+		"reasoning_effort": "high",
+	})
+	if err != nil {
+		t.Fatalf("buildParams() error: %v", err)
+	}
+	if !params.TopP.Valid() || params.TopP.Value != 0.9 {
+		t.Errorf("TopP = %v, want 0.9", params.TopP)
+	}
+	if len(params.StopSequences) != 1 || params.StopSequences[0] != "END" {
+		t.Errorf("StopSequences = %v, want [END]", params.StopSequences)
+	}
+}
+
+func TestBuildParams_ImageParts(t *testing.T) {
+	messages := []Message{
+		{
+			Role:    "user",
+			Content: "What is this?",
+			Parts: []protocoltypes.ContentPart{
+				{Type: protocoltypes.ContentPartText, Text: "What is this?"},
+				{Type: protocoltypes.ContentPartImage, MediaType: "image/png", Data: "aGVsbG8="},
+				{Type: protocoltypes.ContentPartImage, URL: "https://example.com/cat.jpg"},
+			},
+		},
+	}
+	params, err := buildParams(messages, nil, "claude-sonnet-4-5-20250929", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("buildParams() error: %v", err)
+	}
+	blocks := params.Messages[0].Content
+	if len(blocks) != 3 {
+		t.Fatalf("len(Content) = %d, want 3", len(blocks))
+	}
+	if blocks[0].OfText == nil || blocks[0].OfText.Text != "What is this?" {
+		t.Errorf("Content[0] should be the text block")
+	}
+	if blocks[1].OfImage == nil || blocks[1].OfImage.Source.OfBase64 == nil {
+		t.Fatalf("Content[1] should be a base64 image block")
+	}
+	if got := string(blocks[1].OfImage.Source.OfBase64.MediaType); got != "image/png" {
+		t.Errorf("MediaType = %q, want %q", got, "image/png")
+	}
+	if blocks[2].OfImage == nil || blocks[2].OfImage.Source.OfURL == nil {
+		t.Fatalf("Content[2] should be a URL image block")
+	}
+}
+
 func TestBuildParams_SystemMessage(t *testing.T) {
 	messages := []Message{
 		{Role: "system", Content: "You are helpful"},
@@ -103,6 +160,67 @@ func TestBuildParams_WithTools(t *testing.T) {
 	}
 }
 
+func TestBuildParams_ResponseFormat(t *testing.T) {
+	rf := &protocoltypes.ResponseFormat{
+		Name: "heartbeat_result",
+		Schema: map[string]interface{}{
+			"type":                 "object",
+			"properties":           map[string]interface{}{"status": map[string]interface{}{"type": "string"}},
+			"required":             []string{"status"},
+			"additionalProperties": false,
+		},
+	}
+	opts := map[string]interface{}{protocoltypes.ResponseFormatOption: rf}
+
+	params, err := buildParams([]Message{{Role: "user", Content: "Hi"}}, nil, "claude-sonnet-4-5-20250929", opts)
+	if err != nil {
+		t.Fatalf("buildParams() error: %v", err)
+	}
+	if len(params.Tools) != 1 || params.Tools[0].OfTool.Name != responseToolName {
+		t.Fatalf("Tools = %+v, want the response tool", params.Tools)
+	}
+	schema := params.Tools[0].OfTool.InputSchema
+	if len(schema.Required) != 1 || schema.Required[0] != "status" || schema.ExtraFields["additionalProperties"] != false {
+		t.Errorf("InputSchema = %+v", schema)
+	}
+	if params.ToolChoice.OfTool == nil || params.ToolChoice.OfTool.Name != responseToolName {
+		t.Errorf("ToolChoice = %+v, want the response tool", params.ToolChoice)
+	}
+
+	tools := []ToolDefinition{{Type: "function", Function: ToolFunctionDefinition{Name: "exec"}}}
+	params, err = buildParams([]Message{{Role: "user", Content: "Hi"}}, tools, "claude-sonnet-4-5-20250929", opts)
+	if err != nil {
+		t.Fatalf("buildParams() error: %v", err)
+	}
+	if len(params.Tools) != 2 || params.ToolChoice.OfAny == nil {
+		t.Errorf("with tools: Tools = %d, ToolChoice = %+v, want 2 and any", len(params.Tools), params.ToolChoice)
+	}
+}
+
+func TestStructuredReply(t *testing.T) {
+	resp := structuredReply(&LLMResponse{
+		FinishReason: "tool_calls",
+		ToolCalls: []ToolCall{{
+			ID: "toolu_1", Name: responseToolName,
+			Arguments: map[string]interface{}{"status": "ok"},
+		}},
+	}, &protocoltypes.ResponseFormat{Schema: map[string]interface{}{"type": "object"}})
+	if resp.Content != `{"status":"ok"}` || len(resp.ToolCalls) != 0 || resp.FinishReason != "stop" {
+		t.Errorf("object reply = %+v", resp)
+	}
+
+	resp = structuredReply(&LLMResponse{
+		FinishReason: "tool_calls",
+		ToolCalls: []ToolCall{
+			{ID: "toolu_1", Name: "exec", Arguments: map[string]interface{}{}},
+			{ID: "toolu_2", Name: responseToolName, Arguments: map[string]interface{}{"value": []interface{}{"a"}}},
+		},
+	}, &protocoltypes.ResponseFormat{Schema: map[string]interface{}{"type": "array"}})
+	if resp.Content != `["a"]` || len(resp.ToolCalls) != 1 || resp.FinishReason != "tool_calls" {
+		t.Errorf("wrapped reply = %+v", resp)
+	}
+}
+
 func TestParseResponse_TextOnly(t *testing.T) {
 	resp := &anthropic.Message{
 		Content: []anthropic.ContentBlockUnion{},
@@ -193,6 +311,51 @@ func TestProvider_ChatRoundTrip(t *testing.T) {
 	}
 }
 
+func TestProvider_ChatStreamRoundTrip(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		events := []struct{ name, data string }{
+			{"message_start", `{"type":"message_start","message":{"id":"msg_test","type":"message","role":"assistant","model":"claude-sonnet-4-5-20250929","content":[],"stop_reason":null,"usage":{"input_tokens":15,"output_tokens":0}}}`},
+			{"content_block_start", `{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`},
+			{"content_block_delta", `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}`},
+			{"content_block_delta", `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":" there"}}`},
+			{"content_block_stop", `{"type":"content_block_stop","index":0}`},
+			{"message_delta", `{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":8}}`},
+			{"message_stop", `{"type":"message_stop"}`},
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, e := range events {
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.name, e.data)
+		}
+	}))
+	defer server.Close()
+
+	var deltas []string
+	provider := NewProviderWithClient(createAnthropicTestClient(server.URL, "test-token"))
+	messages := []Message{{Role: "user", Content: "Hello"}}
+	resp, err := provider.ChatStream(t.Context(), messages, nil, "claude-sonnet-4-5-20250929", nil, func(d string) {
+		deltas = append(deltas, d)
+	})
+	if err != nil {
+		t.Fatalf("ChatStream() error: %v", err)
+	}
+	if got := strings.Join(deltas, "|"); got != "Hello| there" {
+		t.Errorf("deltas = %q, want %q", got, "Hello| there")
+	}
+	if resp.Content != "Hello there" {
+		t.Errorf("Content = %q, want %q", resp.Content, "Hello there")
+	}
+	if resp.FinishReason != "stop" {
+		t.Errorf("FinishReason = %q, want %q", resp.FinishReason, "stop")
+	}
+	if resp.Usage.CompletionTokens != 8 {
+		t.Errorf("CompletionTokens = %d, want 8", resp.Usage.CompletionTokens)
+	}
+}
+
 func TestProvider_GetDefaultModel(t *testing.T) {
 	p := NewProvider("test-token")
 	if got := p.GetDefaultModel(); got != "claude-sonnet-4-5-20250929" {
@@ -263,3 +426,52 @@ func createAnthropicTestClient(baseURL, token string) *anthropic.Client {
 	)
 	return &c
 }
+
+func TestBuildParams_CacheBreakpoints(t *testing.T) {
+	tools := []ToolDefinition{
+		{Type: "function", Function: ToolFunctionDefinition{Name: "a", Parameters: map[string]interface{}{}}},
+		{Type: "function", Function: ToolFunctionDefinition{Name: "b", Parameters: map[string]interface{}{}}},
+	}
+	messages := []Message{
+		{Role: "system", Content: "You are helpful.", CacheBreakpoint: true},
+		{Role: "user", Content: "first"},
+		{Role: "assistant", Content: "one", CacheBreakpoint: true},
+		{Role: "user", Content: "second"},
+	}
+	params, err := buildParams(messages, tools, "claude-sonnet-4-5-20250929", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("buildParams() error: %v", err)
+	}
+
+	marked := func(cc anthropic.CacheControlEphemeralParam) bool { return cc.Type != "" }
+	if !marked(params.System[0].CacheControl) {
+		t.Error("system prompt not marked for caching")
+	}
+	if marked(params.Tools[0].OfTool.CacheControl) || !marked(params.Tools[1].OfTool.CacheControl) {
+		t.Error("expected only the last tool to be marked")
+	}
+	for i, want := range []bool{false, true, true} {
+		blocks := params.Messages[i].Content
+		if got := marked(*blocks[len(blocks)-1].GetCacheControl()); got != want {
+			t.Errorf("message %d marked = %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestParseResponse_CachedTokens(t *testing.T) {
+	resp := &anthropic.Message{
+		Usage: anthropic.Usage{
+			InputTokens:              10,
+			CacheReadInputTokens:     900,
+			CacheCreationInputTokens: 100,
+			OutputTokens:             20,
+		},
+	}
+	usage := parseResponse(resp).Usage
+	if usage.PromptTokens != 1010 || usage.CachedTokens != 900 || usage.CacheWriteTokens != 100 {
+		t.Errorf("usage = %+v, want 1010 prompt tokens of which 900 cached and 100 written", usage)
+	}
+	if usage.TotalTokens != 1030 {
+		t.Errorf("TotalTokens = %d, want 1030", usage.TotalTokens)
+	}
+}
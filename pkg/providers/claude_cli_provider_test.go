@@ -970,7 +970,10 @@ func TestFindMatchingBrace(t *testing.T) {
 		{`{unclosed`, 0, 0},      // no match returns pos
 		{`{}`, 0, 2},             // empty object
 		{`{{{}}}`, 0, 6},         // deeply nested
-		{`{"a":"b{c}d"}`, 0, 13}, // braces in strings (simplified matcher)
+		{`{"a":"b{c}d"}`, 0, 13}, // braces in strings
+		{`{"a":"}"} x`, 0, 9},    // closing brace in a string
+		{`{"a":"\"}"}`, 0, 11},   // escaped quote in a string
+		{`[1,[2],{}]`, 0, 10},    // arrays
 	}
 	for _, tt := range tests {
 		got := findMatchingBrace(tt.text, tt.pos)
@@ -23,6 +23,12 @@ func NewClaudeProviderWithBaseURL(token, apiBase string) *ClaudeProvider {
 	}
 }
 
+func NewClaudeProviderWithAPIKey(apiKey, apiBase string) *ClaudeProvider {
+	return &ClaudeProvider{
+		delegate: anthropicprovider.NewProviderWithAPIKey(apiKey, apiBase),
+	}
+}
+
 func NewClaudeProviderWithTokenSource(token string, tokenSource func() (string, error)) *ClaudeProvider {
 	return &ClaudeProvider{
 		delegate: anthropicprovider.NewProviderWithTokenSource(token, tokenSource),
@@ -47,6 +53,10 @@ func (p *ClaudeProvider) Chat(ctx context.Context, messages []Message, tools []T
 	return resp, nil
 }
 
+func (p *ClaudeProvider) ChatStream(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}, onDelta func(string)) (*LLMResponse, error) {
+	return p.delegate.ChatStream(ctx, messages, tools, model, options, onDelta)
+}
+
 func (p *ClaudeProvider) GetDefaultModel() string {
 	return p.delegate.GetDefaultModel()
 }
@@ -30,6 +30,8 @@ func (p *CodexCliProvider) Chat(ctx context.Context, messages []Message, tools [
 		return nil, fmt.Errorf("codex command not configured")
 	}
 
+	// The CLI cannot constrain its output; the format is asked for in words.
+	messages = withFormatInstruction(messages, options)
 	prompt := p.buildPrompt(messages, tools)
 
 	args := []string{
@@ -216,6 +218,7 @@ func (p *CodexCliProvider) parseJSONLEvents(output string) (*LLMResponse, error)
 					PromptTokens:     promptTokens,
 					CompletionTokens: event.Usage.OutputTokens,
 					TotalTokens:      promptTokens + event.Usage.OutputTokens,
+					CachedTokens:     event.Usage.CachedInputTokens,
 				}
 			}
 		case "error":
@@ -10,8 +10,10 @@ import (
 	"github.com/openai/openai-go/v3"
 	"github.com/openai/openai-go/v3/option"
 	"github.com/openai/openai-go/v3/responses"
+	"github.com/openai/openai-go/v3/shared"
 	"github.com/sipeed/picoclaw/pkg/auth"
 	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/providers/protocoltypes"
 )
 
 const codexDefaultModel = "gpt-5.2"
@@ -51,6 +53,16 @@ func NewCodexProviderWithTokenSource(token, accountID string, tokenSource func()
 }
 
 func (p *CodexProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	return p.chat(ctx, messages, tools, model, options, nil)
+}
+
+// ChatStream behaves like Chat but reports output text deltas to onDelta as
+// they arrive on the Responses event stream.
+func (p *CodexProvider) ChatStream(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}, onDelta func(string)) (*LLMResponse, error) {
+	return p.chat(ctx, messages, tools, model, options, onDelta)
+}
+
+func (p *CodexProvider) chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}, onDelta func(string)) (*LLMResponse, error) {
 	var opts []option.RequestOption
 	accountID := p.accountID
 	resolvedModel, fallbackReason := resolveCodexModel(model)
@@ -88,6 +100,10 @@ func (p *CodexProvider) Chat(ctx context.Context, messages []Message, tools []To
 	var resp *responses.Response
 	for stream.Next() {
 		evt := stream.Current()
+		if evt.Type == "response.output_text.delta" && onDelta != nil && evt.Delta != "" {
+			onDelta(evt.Delta)
+			continue
+		}
 		if evt.Type == "response.completed" || evt.Type == "response.failed" || evt.Type == "response.incomplete" {
 			evtResp := evt.Response
 			if evtResp.ID != "" {
@@ -106,8 +122,10 @@ func (p *CodexProvider) Chat(ctx context.Context, messages []Message, tools []To
 			"account_id_present": accountID != "",
 			"error":              err.Error(),
 		}
+		detail := err.Error()
 		var apiErr *openai.Error
 		if errors.As(err, &apiErr) {
+			detail = apiErr.Code + " " + apiErr.Message
 			fields["status_code"] = apiErr.StatusCode
 			fields["api_type"] = apiErr.Type
 			fields["api_code"] = apiErr.Code
@@ -121,7 +139,7 @@ func (p *CodexProvider) Chat(ctx context.Context, messages []Message, tools []To
 			}
 		}
 		logger.ErrorCF("provider.codex", "Codex API call failed", fields)
-		return nil, fmt.Errorf("codex API call: %w", err)
+		return nil, protocoltypes.WrapContextLength(fmt.Errorf("codex API call: %w", err), detail)
 	}
 	if resp == nil {
 		fields := map[string]interface{}{
@@ -268,10 +286,20 @@ func buildCodexParams(messages []Message, tools []ToolDefinition, model string,
 		params.Instructions = openai.Opt(defaultCodexInstructions)
 	}
 
+	if effort, ok := options["reasoning_effort"].(string); ok && effort != "" {
+		params.Reasoning = shared.ReasoningParam{Effort: shared.ReasoningEffort(effort)}
+	}
+
 	if len(tools) > 0 || enableWebSearch {
 		params.Tools = translateToolsForCodex(tools, enableWebSearch)
 	}
 
+	if rf := ResponseFormatFrom(options); rf != nil {
+		params.Text = responses.ResponseTextConfigParam{
+			Format: responses.ResponseFormatTextConfigParamOfJSONSchema(rf.SchemaName(), rf.Schema),
+		}
+	}
+
 	return params
 }
 
@@ -367,6 +395,7 @@ func parseCodexResponse(resp *responses.Response) *LLMResponse {
 			PromptTokens:     int(resp.Usage.InputTokens),
 			CompletionTokens: int(resp.Usage.OutputTokens),
 			TotalTokens:      int(resp.Usage.TotalTokens),
+			CachedTokens:     int(resp.Usage.InputTokensDetails.CachedTokens),
 		}
 	}
 
@@ -34,6 +34,15 @@ func TestBuildCodexParams_BasicMessage(t *testing.T) {
 	}
 }
 
+func TestBuildCodexParams_ReasoningEffort(t *testing.T) {
+	params := buildCodexParams([]Message{{Role: "user", Content: "Hi"}}, nil, "gpt-5.2", map[string]interface{}{
+		"reasoning_effort": "high",
+	}, false)
+	if params.Reasoning.Effort != "high" {
+		t.Errorf("Reasoning.Effort = %q, want %q", params.Reasoning.Effort, "high")
+	}
+}
+
 func TestBuildCodexParams_SystemAsInstructions(t *testing.T) {
 	messages := []Message{
 		{Role: "system", Content: "You are helpful"},
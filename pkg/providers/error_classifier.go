@@ -2,6 +2,7 @@ package providers
 
 import (
 	"context"
+	"errors"
 	"regexp"
 	"strings"
 )
@@ -68,6 +69,7 @@ var (
 		rxp(`\b403\b`),
 		substr("no credentials found"),
 		substr("no api key found"),
+		substr("api key not valid"),
 	}
 
 	formatPatterns = []errorPattern{
@@ -116,6 +118,30 @@ func ClassifyError(err error, provider, model string) *FailoverError {
 		}
 	}
 
+	// An oversized request fails the same way on every candidate; the caller
+	// shrinks it and retries instead.
+	if errors.Is(err, ErrContextLength) {
+		return &FailoverError{
+			Reason:   FailoverFormat,
+			Provider: provider,
+			Model:    model,
+			Wrapped:  err,
+		}
+	}
+
+	// Providers whose errors carry a structured reason classify themselves.
+	var reasoner interface{ FailoverReason() string }
+	if errors.As(err, &reasoner) {
+		if reason := FailoverReason(reasoner.FailoverReason()); reason != "" {
+			return &FailoverError{
+				Reason:   reason,
+				Provider: provider,
+				Model:    model,
+				Wrapped:  err,
+			}
+		}
+	}
+
 	msg := strings.ToLower(err.Error())
 
 	// Image dimension/size errors: non-retriable, non-fallback.
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/providers/gemini"
 )
 
 func TestClassifyError_Nil(t *testing.T) {
@@ -31,6 +33,20 @@ func TestClassifyError_ContextDeadlineExceeded(t *testing.T) {
 	}
 }
 
+func TestClassifyError_ContextLength(t *testing.T) {
+	err := fmt.Errorf("API request failed: status 429: %w", ErrContextLength)
+	result := ClassifyError(err, "openai", "gpt-4")
+	if result == nil {
+		t.Fatal("expected non-nil for context length error")
+	}
+	if result.Reason != FailoverFormat {
+		t.Errorf("reason = %q, want format", result.Reason)
+	}
+	if !errors.Is(result, ErrContextLength) {
+		t.Error("expected the classified error to wrap ErrContextLength")
+	}
+}
+
 func TestClassifyError_StatusCodes(t *testing.T) {
 	tests := []struct {
 		status int
@@ -240,6 +256,35 @@ func TestClassifyError_UnknownError(t *testing.T) {
 	}
 }
 
+func TestClassifyError_ProviderReason(t *testing.T) {
+	tests := []struct {
+		err    error
+		reason FailoverReason
+	}{
+		// Gemini reports a bad key as 400, which alone would read as format.
+		{&gemini.APIError{StatusCode: 400, Status: "INVALID_ARGUMENT", Message: "API key not valid. Please pass a valid API key."}, FailoverAuth},
+		{&gemini.APIError{StatusCode: 400, Status: "INVALID_ARGUMENT", Message: "Invalid JSON payload"}, FailoverFormat},
+		{&gemini.APIError{StatusCode: 400, Status: "FAILED_PRECONDITION", Message: "User location is not supported"}, FailoverBilling},
+		{fmt.Errorf("chat: %w", &gemini.APIError{StatusCode: 429, Status: "RESOURCE_EXHAUSTED", Message: "Quota exceeded"}), FailoverRateLimit},
+		{&gemini.APIError{StatusCode: 503, Status: "UNAVAILABLE", Message: "The model is overloaded"}, FailoverOverloaded},
+		// Without a known status the HTTP code decides.
+		{&gemini.APIError{StatusCode: 404, Status: "NOT_FOUND", Message: "models/nope is not found"}, ""},
+	}
+
+	for _, tt := range tests {
+		result := ClassifyError(tt.err, "gemini", "gemini-2.5-flash")
+		if tt.reason == "" {
+			if result != nil {
+				t.Errorf("%v: expected nil, got %q", tt.err, result.Reason)
+			}
+			continue
+		}
+		if result == nil || result.Reason != tt.reason {
+			t.Errorf("%v: got %+v, want reason %q", tt.err, result, tt.reason)
+		}
+	}
+}
+
 func TestClassifyError_ProviderModelPropagation(t *testing.T) {
 	err := errors.New("rate limit exceeded")
 	result := ClassifyError(err, "my-provider", "my-model")
@@ -6,6 +6,8 @@ import (
 
 	"github.com/sipeed/picoclaw/pkg/auth"
 	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/providers/gemini"
+	"github.com/sipeed/picoclaw/pkg/providers/ollama"
 )
 
 const defaultAnthropicAPIBase = "https://api.anthropic.com/v1"
@@ -22,9 +24,13 @@ const (
 	providerTypeClaudeCLI
 	providerTypeCodexCLI
 	providerTypeGitHubCopilot
+	providerTypeOllama
+	providerTypeGemini
+	providerTypeClaudeAPIKey
 )
 
 type providerSelection struct {
+	name            string
 	providerType    providerType
 	apiKey          string
 	apiBase         string
@@ -62,159 +68,200 @@ func createCodexAuthProvider(enableWebSearch bool) (LLMProvider, error) {
 	return p, nil
 }
 
-func resolveProviderSelection(cfg *config.Config) (providerSelection, error) {
-	model := cfg.Agents.Defaults.Model
-	providerName := strings.ToLower(cfg.Agents.Defaults.Provider)
-	lowerModel := strings.ToLower(model)
-
-	sel := providerSelection{
-		providerType: providerTypeHTTPCompat,
-		model:        model,
-	}
-
-	// First, prefer explicit provider configuration.
-	if providerName != "" {
-		switch providerName {
-		case "groq":
-			if cfg.Providers.Groq.APIKey != "" {
-				sel.apiKey = cfg.Providers.Groq.APIKey
-				sel.apiBase = cfg.Providers.Groq.APIBase
-				sel.proxy = cfg.Providers.Groq.Proxy
-				if sel.apiBase == "" {
-					sel.apiBase = "https://api.groq.com/openai/v1"
-				}
+// applyNamedProvider fills sel from the configuration of the provider called
+// name. It leaves sel untouched if that provider is not configured, and
+// reports true when sel is complete and needs no further validation.
+func applyNamedProvider(cfg *config.Config, name string, sel *providerSelection) bool {
+	sel.name = NormalizeProvider(name)
+	switch name {
+	case "groq":
+		if cfg.Providers.Groq.APIKey != "" {
+			sel.apiKey = cfg.Providers.Groq.APIKey
+			sel.apiBase = cfg.Providers.Groq.APIBase
+			sel.proxy = cfg.Providers.Groq.Proxy
+			if sel.apiBase == "" {
+				sel.apiBase = "https://api.groq.com/openai/v1"
 			}
-		case "openai", "gpt":
-			if cfg.Providers.OpenAI.APIKey != "" || cfg.Providers.OpenAI.AuthMethod != "" {
-				sel.enableWebSearch = cfg.Providers.OpenAI.WebSearch
-				if cfg.Providers.OpenAI.AuthMethod == "codex-cli" {
-					sel.providerType = providerTypeCodexCLIToken
-					return sel, nil
-				}
-				if cfg.Providers.OpenAI.AuthMethod == "oauth" || cfg.Providers.OpenAI.AuthMethod == "token" {
-					sel.providerType = providerTypeCodexAuth
-					return sel, nil
-				}
-				sel.apiKey = cfg.Providers.OpenAI.APIKey
-				sel.apiBase = cfg.Providers.OpenAI.APIBase
-				sel.proxy = cfg.Providers.OpenAI.Proxy
-				if sel.apiBase == "" {
-					sel.apiBase = "https://api.openai.com/v1"
-				}
+		}
+	case "openai", "gpt":
+		if cfg.Providers.OpenAI.APIKey != "" || cfg.Providers.OpenAI.AuthMethod != "" {
+			sel.enableWebSearch = cfg.Providers.OpenAI.WebSearch
+			if cfg.Providers.OpenAI.AuthMethod == "codex-cli" {
+				sel.providerType = providerTypeCodexCLIToken
+				return true
 			}
-		case "anthropic", "claude":
-			if cfg.Providers.Anthropic.APIKey != "" || cfg.Providers.Anthropic.AuthMethod != "" {
-				if cfg.Providers.Anthropic.AuthMethod == "oauth" || cfg.Providers.Anthropic.AuthMethod == "token" {
-					sel.apiBase = cfg.Providers.Anthropic.APIBase
-					if sel.apiBase == "" {
-						sel.apiBase = defaultAnthropicAPIBase
-					}
-					sel.providerType = providerTypeClaudeAuth
-					return sel, nil
-				}
-				sel.apiKey = cfg.Providers.Anthropic.APIKey
+			if cfg.Providers.OpenAI.AuthMethod == "oauth" || cfg.Providers.OpenAI.AuthMethod == "token" {
+				sel.providerType = providerTypeCodexAuth
+				return true
+			}
+			sel.apiKey = cfg.Providers.OpenAI.APIKey
+			sel.apiBase = cfg.Providers.OpenAI.APIBase
+			sel.proxy = cfg.Providers.OpenAI.Proxy
+			if sel.apiBase == "" {
+				sel.apiBase = "https://api.openai.com/v1"
+			}
+		}
+	case "anthropic", "claude":
+		if cfg.Providers.Anthropic.APIKey != "" || cfg.Providers.Anthropic.AuthMethod != "" {
+			if cfg.Providers.Anthropic.AuthMethod == "oauth" || cfg.Providers.Anthropic.AuthMethod == "token" {
 				sel.apiBase = cfg.Providers.Anthropic.APIBase
-				sel.proxy = cfg.Providers.Anthropic.Proxy
 				if sel.apiBase == "" {
 					sel.apiBase = defaultAnthropicAPIBase
 				}
+				sel.providerType = providerTypeClaudeAuth
+				return true
 			}
-		case "openrouter":
-			if cfg.Providers.OpenRouter.APIKey != "" {
-				sel.apiKey = cfg.Providers.OpenRouter.APIKey
-				sel.proxy = cfg.Providers.OpenRouter.Proxy
-				if cfg.Providers.OpenRouter.APIBase != "" {
-					sel.apiBase = cfg.Providers.OpenRouter.APIBase
-				} else {
-					sel.apiBase = "https://openrouter.ai/api/v1"
-				}
+			sel.apiKey = cfg.Providers.Anthropic.APIKey
+			sel.apiBase = cfg.Providers.Anthropic.APIBase
+			sel.proxy = cfg.Providers.Anthropic.Proxy
+			if sel.apiBase == "" {
+				sel.apiBase = defaultAnthropicAPIBase
 			}
-		case "zhipu", "glm":
-			if cfg.Providers.Zhipu.APIKey != "" {
-				sel.apiKey = cfg.Providers.Zhipu.APIKey
-				sel.apiBase = cfg.Providers.Zhipu.APIBase
-				sel.proxy = cfg.Providers.Zhipu.Proxy
-				if sel.apiBase == "" {
-					sel.apiBase = "https://open.bigmodel.cn/api/paas/v4"
-				}
+		}
+	case "openrouter":
+		if cfg.Providers.OpenRouter.APIKey != "" {
+			sel.apiKey = cfg.Providers.OpenRouter.APIKey
+			sel.proxy = cfg.Providers.OpenRouter.Proxy
+			if cfg.Providers.OpenRouter.APIBase != "" {
+				sel.apiBase = cfg.Providers.OpenRouter.APIBase
+			} else {
+				sel.apiBase = "https://openrouter.ai/api/v1"
 			}
-		case "gemini", "google":
-			if cfg.Providers.Gemini.APIKey != "" {
-				sel.apiKey = cfg.Providers.Gemini.APIKey
-				sel.apiBase = cfg.Providers.Gemini.APIBase
-				sel.proxy = cfg.Providers.Gemini.Proxy
-				if sel.apiBase == "" {
-					sel.apiBase = "https://generativelanguage.googleapis.com/v1beta"
-				}
+		}
+	case "zhipu", "glm":
+		if cfg.Providers.Zhipu.APIKey != "" {
+			sel.apiKey = cfg.Providers.Zhipu.APIKey
+			sel.apiBase = cfg.Providers.Zhipu.APIBase
+			sel.proxy = cfg.Providers.Zhipu.Proxy
+			if sel.apiBase == "" {
+				sel.apiBase = "https://open.bigmodel.cn/api/paas/v4"
+			}
+		}
+	case "gemini", "google":
+		if cfg.Providers.Gemini.APIKey != "" {
+			sel.apiKey = cfg.Providers.Gemini.APIKey
+			sel.apiBase = cfg.Providers.Gemini.APIBase
+			sel.proxy = cfg.Providers.Gemini.Proxy
+			if sel.apiBase == "" {
+				sel.apiBase = gemini.DefaultAPIBase
 			}
-		case "vllm":
-			if cfg.Providers.VLLM.APIBase != "" {
-				sel.apiKey = cfg.Providers.VLLM.APIKey
-				sel.apiBase = cfg.Providers.VLLM.APIBase
-				sel.proxy = cfg.Providers.VLLM.Proxy
+			// A base ending in /openai selects Gemini's OpenAI-compatible
+			// endpoint; anything else gets the native API.
+			if !strings.HasSuffix(strings.TrimRight(sel.apiBase, "/"), "/openai") {
+				sel.providerType = providerTypeGemini
 			}
-		case "shengsuanyun":
-			if cfg.Providers.ShengSuanYun.APIKey != "" {
-				sel.apiKey = cfg.Providers.ShengSuanYun.APIKey
-				sel.apiBase = cfg.Providers.ShengSuanYun.APIBase
-				sel.proxy = cfg.Providers.ShengSuanYun.Proxy
-				if sel.apiBase == "" {
-					sel.apiBase = "https://router.shengsuanyun.com/api/v1"
-				}
+		}
+	case "vllm":
+		if cfg.Providers.VLLM.APIBase != "" {
+			sel.apiKey = cfg.Providers.VLLM.APIKey
+			sel.apiBase = cfg.Providers.VLLM.APIBase
+			sel.proxy = cfg.Providers.VLLM.Proxy
+		}
+	case "shengsuanyun":
+		if cfg.Providers.ShengSuanYun.APIKey != "" {
+			sel.apiKey = cfg.Providers.ShengSuanYun.APIKey
+			sel.apiBase = cfg.Providers.ShengSuanYun.APIBase
+			sel.proxy = cfg.Providers.ShengSuanYun.Proxy
+			if sel.apiBase == "" {
+				sel.apiBase = "https://router.shengsuanyun.com/api/v1"
 			}
-		case "nvidia":
-			if cfg.Providers.Nvidia.APIKey != "" {
-				sel.apiKey = cfg.Providers.Nvidia.APIKey
-				sel.apiBase = cfg.Providers.Nvidia.APIBase
-				sel.proxy = cfg.Providers.Nvidia.Proxy
-				if sel.apiBase == "" {
-					sel.apiBase = "https://integrate.api.nvidia.com/v1"
-				}
+		}
+	case "nvidia":
+		if cfg.Providers.Nvidia.APIKey != "" {
+			sel.apiKey = cfg.Providers.Nvidia.APIKey
+			sel.apiBase = cfg.Providers.Nvidia.APIBase
+			sel.proxy = cfg.Providers.Nvidia.Proxy
+			if sel.apiBase == "" {
+				sel.apiBase = "https://integrate.api.nvidia.com/v1"
 			}
-		case "claude-cli", "claude-code", "claudecode":
-			workspace := cfg.WorkspacePath()
-			if workspace == "" {
-				workspace = "."
+		}
+	case "moonshot", "kimi":
+		if cfg.Providers.Moonshot.APIKey != "" {
+			sel.apiKey = cfg.Providers.Moonshot.APIKey
+			sel.apiBase = cfg.Providers.Moonshot.APIBase
+			sel.proxy = cfg.Providers.Moonshot.Proxy
+			if sel.apiBase == "" {
+				sel.apiBase = "https://api.moonshot.cn/v1"
 			}
-			sel.providerType = providerTypeClaudeCLI
-			sel.workspace = workspace
-			return sel, nil
-		case "codex-cli", "codex-code":
-			workspace := cfg.WorkspacePath()
-			if workspace == "" {
-				workspace = "."
+		}
+	case "ollama":
+		// A local Ollama server needs no key; the base alone configures it.
+		if cfg.Providers.Ollama.APIKey != "" || cfg.Providers.Ollama.APIBase != "" {
+			sel.providerType = providerTypeOllama
+			sel.apiKey = cfg.Providers.Ollama.APIKey
+			sel.apiBase = cfg.Providers.Ollama.APIBase
+			sel.proxy = cfg.Providers.Ollama.Proxy
+			if sel.apiBase == "" {
+				sel.apiBase = ollama.DefaultAPIBase
 			}
-			sel.providerType = providerTypeCodexCLI
-			sel.workspace = workspace
-			return sel, nil
-		case "deepseek":
-			if cfg.Providers.DeepSeek.APIKey != "" {
-				sel.apiKey = cfg.Providers.DeepSeek.APIKey
-				sel.apiBase = cfg.Providers.DeepSeek.APIBase
-				sel.proxy = cfg.Providers.DeepSeek.Proxy
-				if sel.apiBase == "" {
-					sel.apiBase = "https://api.deepseek.com/v1"
-				}
-				if model != "deepseek-chat" && model != "deepseek-reasoner" {
-					sel.model = "deepseek-chat"
-				}
+			return true
+		}
+	case "claude-cli", "claude-code", "claudecode":
+		workspace := cfg.WorkspacePath()
+		if workspace == "" {
+			workspace = "."
+		}
+		sel.providerType = providerTypeClaudeCLI
+		sel.workspace = workspace
+		return true
+	case "codex-cli", "codex-code":
+		workspace := cfg.WorkspacePath()
+		if workspace == "" {
+			workspace = "."
+		}
+		sel.providerType = providerTypeCodexCLI
+		sel.workspace = workspace
+		return true
+	case "deepseek":
+		if cfg.Providers.DeepSeek.APIKey != "" {
+			sel.apiKey = cfg.Providers.DeepSeek.APIKey
+			sel.apiBase = cfg.Providers.DeepSeek.APIBase
+			sel.proxy = cfg.Providers.DeepSeek.Proxy
+			if sel.apiBase == "" {
+				sel.apiBase = "https://api.deepseek.com/v1"
 			}
-		case "github_copilot", "copilot":
-			sel.providerType = providerTypeGitHubCopilot
-			if cfg.Providers.GitHubCopilot.APIBase != "" {
-				sel.apiBase = cfg.Providers.GitHubCopilot.APIBase
-			} else {
-				sel.apiBase = "localhost:4321"
+			if sel.model != "deepseek-chat" && sel.model != "deepseek-reasoner" {
+				sel.model = "deepseek-chat"
 			}
-			sel.connectMode = cfg.Providers.GitHubCopilot.ConnectMode
-			return sel, nil
 		}
+	case "github_copilot", "copilot":
+		sel.providerType = providerTypeGitHubCopilot
+		if cfg.Providers.GitHubCopilot.APIBase != "" {
+			sel.apiBase = cfg.Providers.GitHubCopilot.APIBase
+		} else {
+			sel.apiBase = "localhost:4321"
+		}
+		sel.connectMode = cfg.Providers.GitHubCopilot.ConnectMode
+		return true
+	}
+	return false
+}
+
+func resolveProviderSelection(cfg *config.Config) (providerSelection, error) {
+	model := cfg.Agents.Defaults.Model
+	providerName := strings.ToLower(cfg.Agents.Defaults.Provider)
+	lowerModel := strings.ToLower(model)
+
+	// A model_list entry names its protocol and credentials itself.
+	if entry := cfg.FindModel(model); entry != nil {
+		return selectionFromEntry(cfg, *entry)
+	}
+
+	sel := providerSelection{
+		providerType: providerTypeHTTPCompat,
+		model:        model,
+	}
+
+	// Otherwise, prefer explicit provider configuration.
+	if providerName != "" && applyNamedProvider(cfg, providerName, &sel) {
+		return sel, nil
 	}
 
 	// Fallback: infer provider from model and configured keys.
 	if sel.apiKey == "" && sel.apiBase == "" {
 		switch {
 		case (strings.Contains(lowerModel, "kimi") || strings.Contains(lowerModel, "moonshot") || strings.HasPrefix(model, "moonshot/")) && cfg.Providers.Moonshot.APIKey != "":
+			sel.name = "moonshot"
 			sel.apiKey = cfg.Providers.Moonshot.APIKey
 			sel.apiBase = cfg.Providers.Moonshot.APIBase
 			sel.proxy = cfg.Providers.Moonshot.Proxy
@@ -227,6 +274,7 @@ func resolveProviderSelection(cfg *config.Config) (providerSelection, error) {
 			strings.HasPrefix(model, "meta-llama/") ||
 			strings.HasPrefix(model, "deepseek/") ||
 			strings.HasPrefix(model, "google/"):
+			sel.name = "openrouter"
 			sel.apiKey = cfg.Providers.OpenRouter.APIKey
 			sel.proxy = cfg.Providers.OpenRouter.Proxy
 			if cfg.Providers.OpenRouter.APIBase != "" {
@@ -236,6 +284,7 @@ func resolveProviderSelection(cfg *config.Config) (providerSelection, error) {
 			}
 		case (strings.Contains(lowerModel, "claude") || strings.HasPrefix(model, "anthropic/")) &&
 			(cfg.Providers.Anthropic.APIKey != "" || cfg.Providers.Anthropic.AuthMethod != ""):
+			sel.name = "anthropic"
 			if cfg.Providers.Anthropic.AuthMethod == "oauth" || cfg.Providers.Anthropic.AuthMethod == "token" {
 				sel.apiBase = cfg.Providers.Anthropic.APIBase
 				if sel.apiBase == "" {
@@ -252,6 +301,7 @@ func resolveProviderSelection(cfg *config.Config) (providerSelection, error) {
 			}
 		case (strings.Contains(lowerModel, "gpt") || strings.HasPrefix(model, "openai/")) &&
 			(cfg.Providers.OpenAI.APIKey != "" || cfg.Providers.OpenAI.AuthMethod != ""):
+			sel.name = "openai"
 			sel.enableWebSearch = cfg.Providers.OpenAI.WebSearch
 			if cfg.Providers.OpenAI.AuthMethod == "codex-cli" {
 				sel.providerType = providerTypeCodexCLIToken
@@ -268,13 +318,9 @@ func resolveProviderSelection(cfg *config.Config) (providerSelection, error) {
 				sel.apiBase = "https://api.openai.com/v1"
 			}
 		case (strings.Contains(lowerModel, "gemini") || strings.HasPrefix(model, "google/")) && cfg.Providers.Gemini.APIKey != "":
-			sel.apiKey = cfg.Providers.Gemini.APIKey
-			sel.apiBase = cfg.Providers.Gemini.APIBase
-			sel.proxy = cfg.Providers.Gemini.Proxy
-			if sel.apiBase == "" {
-				sel.apiBase = "https://generativelanguage.googleapis.com/v1beta"
-			}
+			applyNamedProvider(cfg, "gemini", &sel)
 		case (strings.Contains(lowerModel, "glm") || strings.Contains(lowerModel, "zhipu") || strings.Contains(lowerModel, "zai")) && cfg.Providers.Zhipu.APIKey != "":
+			sel.name = "zhipu"
 			sel.apiKey = cfg.Providers.Zhipu.APIKey
 			sel.apiBase = cfg.Providers.Zhipu.APIBase
 			sel.proxy = cfg.Providers.Zhipu.Proxy
@@ -282,6 +328,7 @@ func resolveProviderSelection(cfg *config.Config) (providerSelection, error) {
 				sel.apiBase = "https://open.bigmodel.cn/api/paas/v4"
 			}
 		case (strings.Contains(lowerModel, "groq") || strings.HasPrefix(model, "groq/")) && cfg.Providers.Groq.APIKey != "":
+			sel.name = "groq"
 			sel.apiKey = cfg.Providers.Groq.APIKey
 			sel.apiBase = cfg.Providers.Groq.APIBase
 			sel.proxy = cfg.Providers.Groq.Proxy
@@ -289,25 +336,25 @@ func resolveProviderSelection(cfg *config.Config) (providerSelection, error) {
 				sel.apiBase = "https://api.groq.com/openai/v1"
 			}
 		case (strings.Contains(lowerModel, "nvidia") || strings.HasPrefix(model, "nvidia/")) && cfg.Providers.Nvidia.APIKey != "":
+			sel.name = "nvidia"
 			sel.apiKey = cfg.Providers.Nvidia.APIKey
 			sel.apiBase = cfg.Providers.Nvidia.APIBase
 			sel.proxy = cfg.Providers.Nvidia.Proxy
 			if sel.apiBase == "" {
 				sel.apiBase = "https://integrate.api.nvidia.com/v1"
 			}
-		case (strings.Contains(lowerModel, "ollama") || strings.HasPrefix(model, "ollama/")) && cfg.Providers.Ollama.APIKey != "":
-			sel.apiKey = cfg.Providers.Ollama.APIKey
-			sel.apiBase = cfg.Providers.Ollama.APIBase
-			sel.proxy = cfg.Providers.Ollama.Proxy
-			if sel.apiBase == "" {
-				sel.apiBase = "http://localhost:11434/v1"
-			}
+		case (strings.Contains(lowerModel, "ollama") || strings.HasPrefix(model, "ollama/")) &&
+			(cfg.Providers.Ollama.APIKey != "" || cfg.Providers.Ollama.APIBase != ""):
+			applyNamedProvider(cfg, "ollama", &sel)
+			return sel, nil
 		case cfg.Providers.VLLM.APIBase != "":
+			sel.name = "vllm"
 			sel.apiKey = cfg.Providers.VLLM.APIKey
 			sel.apiBase = cfg.Providers.VLLM.APIBase
 			sel.proxy = cfg.Providers.VLLM.Proxy
 		default:
 			if cfg.Providers.OpenRouter.APIKey != "" {
+				sel.name = "openrouter"
 				sel.apiKey = cfg.Providers.OpenRouter.APIKey
 				sel.proxy = cfg.Providers.OpenRouter.Proxy
 				if cfg.Providers.OpenRouter.APIBase != "" {
@@ -333,12 +380,16 @@ func resolveProviderSelection(cfg *config.Config) (providerSelection, error) {
 	return sel, nil
 }
 
+// CreateProvider creates the provider for the default agent model.
 func CreateProvider(cfg *config.Config) (LLMProvider, error) {
 	sel, err := resolveProviderSelection(cfg)
 	if err != nil {
 		return nil, err
 	}
+	return createFromSelection(sel)
+}
 
+func createFromSelection(sel providerSelection) (LLMProvider, error) {
 	switch sel.providerType {
 	case providerTypeClaudeAuth:
 		return createClaudeAuthProvider(sel.apiBase)
@@ -354,6 +405,12 @@ func CreateProvider(cfg *config.Config) (LLMProvider, error) {
 		return NewCodexCliProvider(sel.workspace), nil
 	case providerTypeGitHubCopilot:
 		return NewGitHubCopilotProvider(sel.apiBase, sel.connectMode, sel.model)
+	case providerTypeOllama:
+		return NewOllamaProvider(sel.apiKey, sel.apiBase, sel.proxy), nil
+	case providerTypeGemini:
+		return NewGeminiProvider(sel.apiKey, sel.apiBase, sel.proxy), nil
+	case providerTypeClaudeAPIKey:
+		return NewClaudeProviderWithAPIKey(sel.apiKey, sel.apiBase), nil
 	default:
 		return NewHTTPProvider(sel.apiKey, sel.apiBase, sel.proxy), nil
 	}
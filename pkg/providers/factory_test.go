@@ -67,6 +67,25 @@ func TestResolveProviderSelection(t *testing.T) {
 			wantAPIBase: "https://integrate.api.nvidia.com/v1",
 			wantProxy:   "http://127.0.0.1:7890",
 		},
+		{
+			name: "gemini model uses native gemini provider",
+			setup: func(cfg *config.Config) {
+				cfg.Agents.Defaults.Model = "gemini-2.5-flash"
+				cfg.Providers.Gemini.APIKey = "gemini-key"
+			},
+			wantType:    providerTypeGemini,
+			wantAPIBase: "https://generativelanguage.googleapis.com/v1beta",
+		},
+		{
+			name: "gemini openai-compatible base keeps http provider",
+			setup: func(cfg *config.Config) {
+				cfg.Agents.Defaults.Provider = "google"
+				cfg.Providers.Gemini.APIKey = "gemini-key"
+				cfg.Providers.Gemini.APIBase = "https://generativelanguage.googleapis.com/v1beta/openai/"
+			},
+			wantType:    providerTypeHTTPCompat,
+			wantAPIBase: "https://generativelanguage.googleapis.com/v1beta/openai/",
+		},
 		{
 			name: "openrouter model uses openrouter defaults",
 			setup: func(cfg *config.Config) {
@@ -132,8 +151,18 @@ func TestResolveProviderSelection(t *testing.T) {
 				cfg.Agents.Defaults.Model = "ollama/qwen2.5:14b"
 				cfg.Providers.Ollama.APIKey = "ollama-key"
 			},
-			wantType:    providerTypeHTTPCompat,
-			wantAPIBase: "http://localhost:11434/v1",
+			wantType:    providerTypeOllama,
+			wantAPIBase: "http://localhost:11434",
+		},
+		{
+			name: "explicit ollama provider needs no key",
+			setup: func(cfg *config.Config) {
+				cfg.Agents.Defaults.Provider = "ollama"
+				cfg.Agents.Defaults.Model = "llama3.2"
+				cfg.Providers.Ollama.APIBase = "http://pi.local:11434"
+			},
+			wantType:    providerTypeOllama,
+			wantAPIBase: "http://pi.local:11434",
 		},
 		{
 			name: "moonshot model keeps proxy and default base",
@@ -146,6 +175,60 @@ func TestResolveProviderSelection(t *testing.T) {
 			wantAPIBase: "https://api.moonshot.cn/v1",
 			wantProxy:   "http://127.0.0.1:7890",
 		},
+		{
+			name: "model_list entry overrides provider inference",
+			setup: func(cfg *config.Config) {
+				cfg.Agents.Defaults.Model = "fast"
+				cfg.Providers.OpenRouter.APIKey = "sk-or-test"
+				cfg.ModelList = []config.ModelEntry{
+					{Name: "fast", Protocol: "gemini", Model: "gemini-2.5-flash", APIKey: "gemini-key"},
+				}
+			},
+			wantType:    providerTypeGemini,
+			wantAPIBase: "https://generativelanguage.googleapis.com/v1beta",
+		},
+		{
+			name: "model_list openai entry on a local server needs no key",
+			setup: func(cfg *config.Config) {
+				cfg.Agents.Defaults.Model = "local"
+				cfg.ModelList = []config.ModelEntry{
+					{Name: "local", Protocol: "openai", Model: "qwen", APIBase: "http://localhost:8000/v1", Proxy: "http://127.0.0.1:7890"},
+				}
+			},
+			wantType:    providerTypeHTTPCompat,
+			wantAPIBase: "http://localhost:8000/v1",
+			wantProxy:   "http://127.0.0.1:7890",
+		},
+		{
+			name: "model_list anthropic entry reads key from environment",
+			setup: func(cfg *config.Config) {
+				t.Setenv("PICOCLAW_TEST_ANTHROPIC_KEY", "sk-ant")
+				cfg.Agents.Defaults.Model = "sonnet"
+				cfg.ModelList = []config.ModelEntry{
+					{Name: "sonnet", Protocol: "anthropic", Model: "claude-sonnet-4-5", Credentials: "env:PICOCLAW_TEST_ANTHROPIC_KEY"},
+				}
+			},
+			wantType:    providerTypeClaudeAPIKey,
+			wantAPIBase: "https://api.anthropic.com/v1",
+		},
+		{
+			name: "model_list entry with unset environment key fails",
+			setup: func(cfg *config.Config) {
+				cfg.Agents.Defaults.Model = "sonnet"
+				cfg.ModelList = []config.ModelEntry{
+					{Name: "sonnet", Protocol: "anthropic", Model: "claude-sonnet-4-5", Credentials: "env:PICOCLAW_TEST_UNSET_KEY"},
+				}
+			},
+			wantErrSubstr: "PICOCLAW_TEST_UNSET_KEY is not set",
+		},
+		{
+			name: "model_list entry with unknown protocol fails",
+			setup: func(cfg *config.Config) {
+				cfg.Agents.Defaults.Model = "odd"
+				cfg.ModelList = []config.ModelEntry{{Name: "odd", Protocol: "grpc", Model: "x"}}
+			},
+			wantErrSubstr: "unknown protocol",
+		},
 		{
 			name: "missing keys returns model config error",
 			setup: func(cfg *config.Config) {
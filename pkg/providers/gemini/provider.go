@@ -0,0 +1,483 @@
+// Package gemini talks to the Google Gemini API through generateContent,
+// with native function calling.
+package gemini
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/providers/protocoltypes"
+)
+
+type ToolCall = protocoltypes.ToolCall
+type LLMResponse = protocoltypes.LLMResponse
+type UsageInfo = protocoltypes.UsageInfo
+type Message = protocoltypes.Message
+type ToolDefinition = protocoltypes.ToolDefinition
+
+// DefaultAPIBase is the Gemini API endpoint.
+const DefaultAPIBase = "https://generativelanguage.googleapis.com/v1beta"
+
+type Provider struct {
+	apiKey     string
+	apiBase    string
+	httpClient *http.Client
+}
+
+func NewProvider(apiKey, apiBase, proxy string) *Provider {
+	apiBase = strings.TrimRight(apiBase, "/")
+	if apiBase == "" {
+		apiBase = DefaultAPIBase
+	}
+
+	client := &http.Client{Timeout: 120 * time.Second}
+	if proxy != "" {
+		parsed, err := url.Parse(proxy)
+		if err == nil {
+			client.Transport = &http.Transport{Proxy: http.ProxyURL(parsed)}
+		} else {
+			log.Printf("gemini: invalid proxy URL %q: %v", proxy, err)
+		}
+	}
+
+	return &Provider{
+		apiKey:     apiKey,
+		apiBase:    apiBase,
+		httpClient: client,
+	}
+}
+
+func (p *Provider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	resp, err := p.post(ctx, model, "generateContent", buildRequest(messages, tools, options))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out generateResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	var acc accumulator
+	acc.add(out, nil)
+	return p.response(&acc), nil
+}
+
+// ChatStream calls onDelta for every text fragment of the reply. Function
+// calls arrive whole and are collected as they come.
+func (p *Provider) ChatStream(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}, onDelta func(string)) (*LLMResponse, error) {
+	resp, err := p.post(ctx, model, "streamGenerateContent?alt=sse", buildRequest(messages, tools, options))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var acc accumulator
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		var chunk generateResponse
+		if err := json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &chunk); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal stream chunk: %w", err)
+		}
+		acc.add(chunk, onDelta)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read stream: %w", err)
+	}
+	return p.response(&acc), nil
+}
+
+func (p *Provider) GetDefaultModel() string {
+	return ""
+}
+
+// APIError is an error response of the Gemini API. Its status names the
+// kind of failure more reliably than the HTTP code: an invalid API key, for
+// one, is reported as 400.
+type APIError struct {
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API request failed:\n  Status: %d\n  Body:   %s: %s", e.StatusCode, e.Status, e.Message)
+}
+
+// FailoverReason classifies the error for model fallback, using the reason
+// names of providers.FailoverReason. It returns "" if the status says
+// nothing more than the HTTP code does.
+func (e *APIError) FailoverReason() string {
+	switch e.Status {
+	case "UNAUTHENTICATED", "PERMISSION_DENIED":
+		return "auth"
+	case "RESOURCE_EXHAUSTED":
+		return "rate_limit"
+	case "FAILED_PRECONDITION":
+		// Billing is not enabled, or the free tier is not offered where the
+		// request comes from.
+		return "billing"
+	case "UNAVAILABLE":
+		return "overloaded"
+	case "DEADLINE_EXCEEDED", "INTERNAL":
+		return "timeout"
+	case "INVALID_ARGUMENT":
+		if strings.Contains(strings.ToLower(e.Message), "api key") {
+			return "auth"
+		}
+		return "format"
+	}
+	return ""
+}
+
+func (p *Provider) post(ctx context.Context, model, method string, body interface{}) (*http.Response, error) {
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/models/%s:%s", p.apiBase, normalizeModel(model), method)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-goog-api-key", p.apiKey)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		apiErr := &APIError{StatusCode: resp.StatusCode, Message: string(data)}
+		var envelope struct {
+			Error struct {
+				Message string `json:"message"`
+				Status  string `json:"status"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(data, &envelope) == nil && envelope.Error.Message != "" {
+			apiErr.Status = envelope.Error.Status
+			apiErr.Message = envelope.Error.Message
+		}
+		return nil, protocoltypes.WrapContextLength(apiErr, apiErr.Message)
+	}
+	return resp, nil
+}
+
+// normalizeModel strips the provider prefix from a model name.
+func normalizeModel(model string) string {
+	for _, prefix := range []string{"gemini/", "google/", "models/"} {
+		if rest, ok := strings.CutPrefix(model, prefix); ok {
+			return rest
+		}
+	}
+	return model
+}
+
+type content struct {
+	Role  string `json:"role,omitempty"`
+	Parts []part `json:"parts"`
+}
+
+type part struct {
+	Text             string            `json:"text,omitempty"`
+	Thought          bool              `json:"thought,omitempty"`
+	InlineData       *inlineData       `json:"inlineData,omitempty"`
+	FunctionCall     *functionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *functionResponse `json:"functionResponse,omitempty"`
+	ThoughtSignature string            `json:"thoughtSignature,omitempty"`
+}
+
+type inlineData struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+type functionCall struct {
+	Name string                 `json:"name"`
+	Args map[string]interface{} `json:"args"`
+}
+
+type functionResponse struct {
+	Name     string                 `json:"name"`
+	Response map[string]interface{} `json:"response"`
+}
+
+type functionDeclaration struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+}
+
+type generateRequest struct {
+	SystemInstruction *content                 `json:"systemInstruction,omitempty"`
+	Contents          []content                `json:"contents"`
+	Tools             []map[string]interface{} `json:"tools,omitempty"`
+	GenerationConfig  map[string]interface{}   `json:"generationConfig,omitempty"`
+}
+
+type generateResponse struct {
+	Candidates []struct {
+		Content      content `json:"content"`
+		FinishReason string  `json:"finishReason"`
+	} `json:"candidates"`
+	PromptFeedback *struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+	UsageMetadata *struct {
+		PromptTokenCount        int `json:"promptTokenCount"`
+		CandidatesTokenCount    int `json:"candidatesTokenCount"`
+		ThoughtsTokenCount      int `json:"thoughtsTokenCount"`
+		CachedContentTokenCount int `json:"cachedContentTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+func buildRequest(messages []Message, tools []ToolDefinition, options map[string]interface{}) generateRequest {
+	system, contents := toContents(messages)
+	req := generateRequest{SystemInstruction: system, Contents: contents}
+
+	if len(tools) > 0 {
+		decls := make([]functionDeclaration, 0, len(tools))
+		for _, tool := range tools {
+			decl := functionDeclaration{
+				Name:        tool.Function.Name,
+				Description: tool.Function.Description,
+			}
+			if params := SanitizeSchema(tool.Function.Parameters); len(params) > 0 {
+				if props, _ := params["properties"].(map[string]interface{}); len(props) > 0 {
+					decl.Parameters = params
+				}
+			}
+			decls = append(decls, decl)
+		}
+		req.Tools = []map[string]interface{}{{"functionDeclarations": decls}}
+	}
+
+	config := map[string]interface{}{}
+	if maxTokens, ok := options["max_tokens"].(int); ok && maxTokens > 0 {
+		config["maxOutputTokens"] = maxTokens
+	}
+	if temperature, ok := options["temperature"].(float64); ok {
+		config["temperature"] = temperature
+	}
+	if topP, ok := options["top_p"].(float64); ok {
+		config["topP"] = topP
+	}
+	if stop, ok := options["stop"].([]string); ok && len(stop) > 0 {
+		config["stopSequences"] = stop
+	}
+	if rf := protocoltypes.ResponseFormatFrom(options); rf != nil {
+		if len(tools) == 0 {
+			config["responseMimeType"] = "application/json"
+			config["responseSchema"] = SanitizeSchema(rf.Schema)
+		} else {
+			// Gemini does not combine a response schema with function
+			// calling, so the schema is asked for in the instruction.
+			if req.SystemInstruction == nil {
+				req.SystemInstruction = &content{}
+			}
+			req.SystemInstruction.Parts = append(req.SystemInstruction.Parts, part{Text: rf.Instruction()})
+		}
+	}
+	if len(config) > 0 {
+		req.GenerationConfig = config
+	}
+	return req
+}
+
+// toContents converts messages to Gemini contents. System messages become
+// the system instruction, assistant messages "model" turns, and tool results
+// function responses in a "user" turn. Consecutive turns of the same role
+// are merged, so all results of one round of calls travel together.
+func toContents(messages []Message) (*content, []content) {
+	var system []part
+	var contents []content
+	toolNames := make(map[string]string)
+
+	appendTurn := func(role string, parts []part) {
+		if len(parts) == 0 {
+			return
+		}
+		if n := len(contents); n > 0 && contents[n-1].Role == role {
+			contents[n-1].Parts = append(contents[n-1].Parts, parts...)
+			return
+		}
+		contents = append(contents, content{Role: role, Parts: parts})
+	}
+
+	for _, msg := range messages {
+		switch msg.Role {
+		case "system":
+			if msg.Content != "" {
+				system = append(system, part{Text: msg.Content})
+			}
+
+		case "assistant":
+			var parts []part
+			if msg.Content != "" {
+				parts = append(parts, part{Text: msg.Content})
+			}
+			for _, tc := range msg.ToolCalls {
+				name, args := tc.Name, tc.Arguments
+				if tc.Function != nil {
+					if name == "" {
+						name = tc.Function.Name
+					}
+					if args == nil && tc.Function.Arguments != "" {
+						json.Unmarshal([]byte(tc.Function.Arguments), &args)
+					}
+				}
+				if args == nil {
+					args = map[string]interface{}{}
+				}
+				toolNames[tc.ID] = name
+				parts = append(parts, part{
+					FunctionCall:     &functionCall{Name: name, Args: args},
+					ThoughtSignature: tc.ThoughtSignature,
+				})
+			}
+			appendTurn("model", parts)
+
+		case "tool":
+			appendTurn("user", []part{{FunctionResponse: &functionResponse{
+				Name:     toolNames[msg.ToolCallID],
+				Response: map[string]interface{}{"result": msg.Content},
+			}}})
+
+		default:
+			appendTurn("user", userParts(msg))
+		}
+	}
+
+	if len(system) == 0 {
+		return nil, contents
+	}
+	return &content{Parts: system}, contents
+}
+
+func userParts(msg Message) []part {
+	if len(msg.Parts) == 0 {
+		if msg.Content == "" {
+			return nil
+		}
+		return []part{{Text: msg.Content}}
+	}
+	var parts []part
+	for _, p := range msg.Parts {
+		switch p.Type {
+		case protocoltypes.ContentPartText:
+			if p.Text != "" {
+				parts = append(parts, part{Text: p.Text})
+			}
+		case protocoltypes.ContentPartImage:
+			// Gemini only fetches files it hosts, so URL images are left out.
+			if p.Data != "" {
+				parts = append(parts, part{InlineData: &inlineData{MimeType: p.MediaType, Data: p.Data}})
+			}
+		}
+	}
+	return parts
+}
+
+// accumulator collects a response, whole or streamed in chunks.
+type accumulator struct {
+	text         strings.Builder
+	calls        []part
+	finishReason string
+	blocked      bool
+	usage        *UsageInfo
+}
+
+func (a *accumulator) add(resp generateResponse, onDelta func(string)) {
+	if resp.PromptFeedback != nil && resp.PromptFeedback.BlockReason != "" {
+		a.blocked = true
+	}
+	if u := resp.UsageMetadata; u != nil {
+		a.usage = &UsageInfo{
+			PromptTokens:     u.PromptTokenCount,
+			CompletionTokens: u.CandidatesTokenCount + u.ThoughtsTokenCount,
+			TotalTokens:      u.PromptTokenCount + u.CandidatesTokenCount + u.ThoughtsTokenCount,
+			CachedTokens:     u.CachedContentTokenCount,
+		}
+	}
+	if len(resp.Candidates) == 0 {
+		return
+	}
+	candidate := resp.Candidates[0]
+	for _, p := range candidate.Content.Parts {
+		switch {
+		case p.FunctionCall != nil:
+			a.calls = append(a.calls, p)
+		case p.Text != "" && !p.Thought:
+			a.text.WriteString(p.Text)
+			if onDelta != nil {
+				onDelta(p.Text)
+			}
+		}
+	}
+	if candidate.FinishReason != "" {
+		a.finishReason = candidate.FinishReason
+	}
+}
+
+func (p *Provider) response(a *accumulator) *LLMResponse {
+	toolCalls := make([]ToolCall, 0, len(a.calls))
+	for _, c := range a.calls {
+		args := c.FunctionCall.Args
+		if args == nil {
+			args = map[string]interface{}{}
+		}
+		toolCalls = append(toolCalls, ToolCall{
+			// Gemini does not number function calls.
+			ID:               protocoltypes.NewToolCallID(),
+			Name:             c.FunctionCall.Name,
+			Arguments:        args,
+			ThoughtSignature: c.ThoughtSignature,
+		})
+	}
+
+	return &LLMResponse{
+		Content:      a.text.String(),
+		ToolCalls:    toolCalls,
+		FinishReason: finishReason(a.finishReason, a.blocked, len(toolCalls) > 0),
+		Usage:        a.usage,
+	}
+}
+
+// finishReason maps a Gemini finish reason to the OpenAI-style names used
+// by LLMResponse.
+func finishReason(reason string, blocked, toolCalls bool) string {
+	if blocked {
+		return "content_filter"
+	}
+	switch reason {
+	case "MAX_TOKENS":
+		return "length"
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII", "IMAGE_SAFETY", "LANGUAGE":
+		return "content_filter"
+	case "MALFORMED_FUNCTION_CALL", "UNEXPECTED_TOOL_CALL":
+		return "error"
+	}
+	if toolCalls {
+		return "tool_calls"
+	}
+	return "stop"
+}
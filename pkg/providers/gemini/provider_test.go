@@ -0,0 +1,350 @@
+package gemini
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/providers/protocoltypes"
+)
+
+func TestProviderChat_FunctionCallsRoundTrip(t *testing.T) {
+	var requestBody map[string]interface{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/models/gemini-2.5-flash:generateContent" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("x-goog-api-key") != "test-key" {
+			t.Errorf("x-goog-api-key = %q", r.Header.Get("x-goog-api-key"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{
+			"candidates": [{
+				"content": {"role": "model", "parts": [
+					{"text": "thinking...", "thought": true},
+					{"functionCall": {"name": "get_weather", "args": {"city": "Lisbon"}}, "thoughtSignature": "sig-2"}
+				]},
+				"finishReason": "STOP"
+			}],
+			"usageMetadata": {"promptTokenCount": 40, "candidatesTokenCount": 5, "thoughtsTokenCount": 3, "cachedContentTokenCount": 32}
+		}`))
+	}))
+	defer server.Close()
+
+	p := NewProvider("test-key", server.URL, "")
+	messages := []Message{
+		{Role: "system", Content: "You are helpful."},
+		{Role: "user", Parts: []protocoltypes.ContentPart{
+			{Type: protocoltypes.ContentPartText, Text: "What is this?"},
+			{Type: protocoltypes.ContentPartImage, MediaType: "image/png", Data: "aGVsbG8="},
+		}},
+		{Role: "assistant", ToolCalls: []ToolCall{
+			{ID: "call_1", Type: "function", Function: &protocoltypes.FunctionCall{Name: "read_file", Arguments: `{"path":"a.txt"}`}, ThoughtSignature: "sig-1"},
+			{ID: "call_2", Name: "list_dir", Arguments: map[string]interface{}{"path": "."}},
+		}},
+		{Role: "tool", ToolCallID: "call_1", Content: "hello"},
+		{Role: "tool", ToolCallID: "call_2", Content: "a.txt"},
+	}
+	tools := []ToolDefinition{{
+		Type: "function",
+		Function: protocoltypes.ToolFunctionDefinition{
+			Name:        "get_weather",
+			Description: "Get the weather",
+			Parameters: map[string]interface{}{
+				"type":                 "object",
+				"additionalProperties": false,
+				"properties": map[string]interface{}{
+					"city": map[string]interface{}{"type": "string"},
+				},
+				"required": []string{"city"},
+			},
+		},
+	}, {
+		Type:     "function",
+		Function: protocoltypes.ToolFunctionDefinition{Name: "now", Parameters: map[string]interface{}{"type": "object"}},
+	}}
+	options := map[string]interface{}{"max_tokens": 512, "temperature": 0.2}
+
+	out, err := p.Chat(context.Background(), messages, tools, "gemini/gemini-2.5-flash", options)
+	if err != nil {
+		t.Fatalf("Chat() error: %v", err)
+	}
+
+	system := requestBody["systemInstruction"].(map[string]interface{})["parts"].([]interface{})[0].(map[string]interface{})
+	if system["text"] != "You are helpful." {
+		t.Errorf("systemInstruction = %v", requestBody["systemInstruction"])
+	}
+	wantConfig := map[string]interface{}{"maxOutputTokens": 512.0, "temperature": 0.2}
+	if !reflect.DeepEqual(requestBody["generationConfig"], wantConfig) {
+		t.Errorf("generationConfig = %v, want %v", requestBody["generationConfig"], wantConfig)
+	}
+
+	decls := requestBody["tools"].([]interface{})[0].(map[string]interface{})["functionDeclarations"].([]interface{})
+	weather := decls[0].(map[string]interface{})
+	wantParams := map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"city": map[string]interface{}{"type": "string"}},
+		"required":   []interface{}{"city"},
+	}
+	if !reflect.DeepEqual(weather["parameters"], wantParams) {
+		t.Errorf("parameters = %v, want %v", weather["parameters"], wantParams)
+	}
+	if _, ok := decls[1].(map[string]interface{})["parameters"]; ok {
+		t.Error("tool without properties should omit parameters")
+	}
+
+	contents := requestBody["contents"].([]interface{})
+	if len(contents) != 3 {
+		t.Fatalf("contents = %v, want user, model and merged tool results", contents)
+	}
+	userParts := contents[0].(map[string]interface{})["parts"].([]interface{})
+	if len(userParts) != 2 || userParts[1].(map[string]interface{})["inlineData"] == nil {
+		t.Errorf("user parts = %v", userParts)
+	}
+	model := contents[1].(map[string]interface{})
+	call := model["parts"].([]interface{})[0].(map[string]interface{})
+	if model["role"] != "model" || call["thoughtSignature"] != "sig-1" ||
+		!reflect.DeepEqual(call["functionCall"], map[string]interface{}{"name": "read_file", "args": map[string]interface{}{"path": "a.txt"}}) {
+		t.Errorf("model content = %v", model)
+	}
+	results := contents[2].(map[string]interface{})
+	if results["role"] != "user" || len(results["parts"].([]interface{})) != 2 {
+		t.Fatalf("tool results = %v", results)
+	}
+	second := results["parts"].([]interface{})[1].(map[string]interface{})["functionResponse"].(map[string]interface{})
+	if second["name"] != "list_dir" || !reflect.DeepEqual(second["response"], map[string]interface{}{"result": "a.txt"}) {
+		t.Errorf("functionResponse = %v", second)
+	}
+
+	if out.Content != "" {
+		t.Errorf("Content = %q, thought parts should be skipped", out.Content)
+	}
+	if len(out.ToolCalls) != 1 || out.ToolCalls[0].Name != "get_weather" || out.ToolCalls[0].Arguments["city"] != "Lisbon" {
+		t.Fatalf("ToolCalls = %+v", out.ToolCalls)
+	}
+	if out.ToolCalls[0].ID == "" || out.ToolCalls[0].ThoughtSignature != "sig-2" {
+		t.Errorf("ToolCall = %+v", out.ToolCalls[0])
+	}
+	if out.FinishReason != "tool_calls" {
+		t.Errorf("FinishReason = %q, want tool_calls", out.FinishReason)
+	}
+	if out.Usage.PromptTokens != 40 || out.Usage.CompletionTokens != 8 || out.Usage.TotalTokens != 48 || out.Usage.CachedTokens != 32 {
+		t.Errorf("Usage = %+v", out.Usage)
+	}
+}
+
+func TestProviderChatStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/models/gemini-2.5-pro:streamGenerateContent" || r.URL.Query().Get("alt") != "sse" {
+			t.Errorf("request = %s", r.URL)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range []string{
+			`{"candidates":[{"content":{"role":"model","parts":[{"text":"Hel"}]}}]}`,
+			`{"candidates":[{"content":{"role":"model","parts":[{"text":"lo"}]},"finishReason":"MAX_TOKENS"}],"usageMetadata":{"promptTokenCount":5,"candidatesTokenCount":2}}`,
+		} {
+			w.Write([]byte("data: " + chunk + "\r\n\r\n"))
+		}
+	}))
+	defer server.Close()
+
+	p := NewProvider("key", server.URL, "")
+	var deltas []string
+	out, err := p.ChatStream(context.Background(), []Message{{Role: "user", Content: "hi"}}, nil, "models/gemini-2.5-pro", nil, func(d string) {
+		deltas = append(deltas, d)
+	})
+	if err != nil {
+		t.Fatalf("ChatStream() error: %v", err)
+	}
+	if out.Content != "Hello" || strings.Join(deltas, "|") != "Hel|lo" {
+		t.Errorf("Content = %q, deltas = %v", out.Content, deltas)
+	}
+	if out.FinishReason != "length" || out.Usage.TotalTokens != 7 {
+		t.Errorf("FinishReason = %q, Usage = %+v", out.FinishReason, out.Usage)
+	}
+}
+
+func TestProviderChat_ToolCallIDsUniqueAcrossProviders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"exec","args":{}}}]},"finishReason":"STOP"}]}`))
+	}))
+	defer server.Close()
+
+	// A restart starts a new provider; its IDs must not repeat the old ones
+	// stored in session history.
+	seen := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		out, err := NewProvider("key", server.URL, "").Chat(context.Background(), []Message{{Role: "user", Content: "hi"}}, nil, "gemini-2.5-pro", nil)
+		if err != nil {
+			t.Fatalf("Chat() error: %v", err)
+		}
+		id := out.ToolCalls[0].ID
+		if !strings.HasPrefix(id, "call_") || seen[id] {
+			t.Errorf("tool call ID %q is not unique", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestProviderChat_Blocked(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"prompt blocked", `{"promptFeedback":{"blockReason":"SAFETY"}}`},
+		{"candidate blocked", `{"candidates":[{"content":{"parts":[]},"finishReason":"SAFETY"}]}`},
+		{"recitation", `{"candidates":[{"content":{"parts":[{"text":"partial"}]},"finishReason":"RECITATION"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			out, err := NewProvider("key", server.URL, "").Chat(context.Background(), []Message{{Role: "user", Content: "hi"}}, nil, "gemini-2.5-flash", nil)
+			if err != nil {
+				t.Fatalf("Chat() error: %v", err)
+			}
+			if out.FinishReason != "content_filter" {
+				t.Errorf("FinishReason = %q, want content_filter", out.FinishReason)
+			}
+		})
+	}
+}
+
+func TestProviderChat_APIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":{"code":400,"message":"API key not valid. Please pass a valid API key.","status":"INVALID_ARGUMENT"}}`))
+	}))
+	defer server.Close()
+
+	_, err := NewProvider("bad", server.URL, "").Chat(context.Background(), []Message{{Role: "user", Content: "hi"}}, nil, "gemini-2.5-flash", nil)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Chat() error = %v, want *APIError", err)
+	}
+	if apiErr.StatusCode != 400 || apiErr.Status != "INVALID_ARGUMENT" || apiErr.FailoverReason() != "auth" {
+		t.Errorf("APIError = %+v, reason %q", apiErr, apiErr.FailoverReason())
+	}
+	if !strings.Contains(err.Error(), "Status: 400") {
+		t.Errorf("Error() = %q", err.Error())
+	}
+}
+
+func TestBuildRequest_ResponseFormat(t *testing.T) {
+	opts := map[string]interface{}{protocoltypes.ResponseFormatOption: &protocoltypes.ResponseFormat{
+		Name:   "summary",
+		Schema: map[string]interface{}{"type": "object", "additionalProperties": false},
+	}}
+	messages := []Message{{Role: "user", Content: "hi"}}
+
+	req := buildRequest(messages, nil, opts)
+	if req.GenerationConfig["responseMimeType"] != "application/json" {
+		t.Errorf("responseMimeType = %v", req.GenerationConfig["responseMimeType"])
+	}
+	if !reflect.DeepEqual(req.GenerationConfig["responseSchema"], map[string]interface{}{"type": "object"}) {
+		t.Errorf("responseSchema = %v, want the sanitized schema", req.GenerationConfig["responseSchema"])
+	}
+
+	tools := []ToolDefinition{{Type: "function", Function: protocoltypes.ToolFunctionDefinition{Name: "exec"}}}
+	req = buildRequest(messages, tools, opts)
+	if _, ok := req.GenerationConfig["responseSchema"]; ok {
+		t.Error("responseSchema should not be sent with tools")
+	}
+	if req.SystemInstruction == nil || !strings.Contains(req.SystemInstruction.Parts[0].Text, "JSON schema") {
+		t.Errorf("SystemInstruction = %+v, want the schema instruction", req.SystemInstruction)
+	}
+}
+
+func TestSanitizeSchema(t *testing.T) {
+	in := map[string]interface{}{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"type":    "object",
+		"$defs": map[string]interface{}{
+			"unit": map[string]interface{}{"type": "string", "enum": []interface{}{"c", "f"}},
+		},
+		"properties": map[string]interface{}{
+			"unit":  map[string]interface{}{"$ref": "#/$defs/unit"},
+			"note":  map[string]interface{}{"type": []interface{}{"string", "null"}, "format": "email"},
+			"mode":  map[string]interface{}{"const": "fast"},
+			"level": map[string]interface{}{"type": "integer", "enum": []interface{}{1, 2}},
+			"when":  map[string]interface{}{"type": "string", "format": "date-time"},
+			"target": map[string]interface{}{"oneOf": []interface{}{
+				map[string]interface{}{"type": "string"},
+				map[string]interface{}{"type": "integer", "exclusiveMinimum": 0},
+			}},
+			"opts": map[string]interface{}{"allOf": []interface{}{
+				map[string]interface{}{"properties": map[string]interface{}{"a": map[string]interface{}{"type": "boolean"}}, "required": []interface{}{"a"}},
+				map[string]interface{}{"properties": map[string]interface{}{"b": map[string]interface{}{"type": "number"}}},
+			}},
+		},
+		"required": []interface{}{"unit", "missing"},
+	}
+
+	want := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"unit":  map[string]interface{}{"type": "string", "enum": []interface{}{"c", "f"}},
+			"note":  map[string]interface{}{"type": "string", "nullable": true},
+			"mode":  map[string]interface{}{"type": "string", "enum": []interface{}{"fast"}},
+			"level": map[string]interface{}{"type": "integer"},
+			"when":  map[string]interface{}{"type": "string", "format": "date-time"},
+			"target": map[string]interface{}{"anyOf": []interface{}{
+				map[string]interface{}{"type": "string"},
+				map[string]interface{}{"type": "integer"},
+			}},
+			"opts": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"a": map[string]interface{}{"type": "boolean"},
+					"b": map[string]interface{}{"type": "number"},
+				},
+				"required": []interface{}{"a"},
+			},
+		},
+		"required": []interface{}{"unit"},
+	}
+
+	got := SanitizeSchema(in)
+	if !reflect.DeepEqual(got, want) {
+		gotJSON, _ := json.MarshalIndent(got, "", "  ")
+		t.Errorf("SanitizeSchema() =\n%s", gotJSON)
+	}
+	if _, ok := in["properties"].(map[string]interface{})["unit"].(map[string]interface{})["$ref"]; !ok {
+		t.Error("SanitizeSchema modified its input")
+	}
+}
+
+func TestSanitizeSchema_RecursiveRef(t *testing.T) {
+	in := map[string]interface{}{
+		"type": "object",
+		"definitions": map[string]interface{}{
+			"node": map[string]interface{}{
+				"type":       "object",
+				"properties": map[string]interface{}{"child": map[string]interface{}{"$ref": "#/definitions/node"}},
+			},
+		},
+		"properties": map[string]interface{}{"root": map[string]interface{}{"$ref": "#/definitions/node"}},
+	}
+
+	out, err := json.Marshal(SanitizeSchema(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "$ref") || strings.Count(string(out), "child") != maxRefDepth {
+		t.Errorf("SanitizeSchema() = %s", out)
+	}
+}
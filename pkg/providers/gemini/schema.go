@@ -0,0 +1,244 @@
+package gemini
+
+import "strings"
+
+// schemaKeys are the JSON schema keywords Gemini accepts in function
+// parameters. Anything else makes the request fail with INVALID_ARGUMENT.
+var schemaKeys = map[string]bool{
+	"type":        true,
+	"format":      true,
+	"title":       true,
+	"description": true,
+	"nullable":    true,
+	"enum":        true,
+	"items":       true,
+	"minItems":    true,
+	"maxItems":    true,
+	"properties":  true,
+	"required":    true,
+	"minimum":     true,
+	"maximum":     true,
+	"minLength":   true,
+	"maxLength":   true,
+	"pattern":     true,
+	"anyOf":       true,
+	"default":     true,
+	"example":     true,
+}
+
+// maxRefDepth bounds how deep $ref is inlined, so recursive schemas end.
+const maxRefDepth = 4
+
+// SanitizeSchema rewrites a JSON schema into the subset Gemini accepts:
+// local $ref are inlined, allOf is merged, oneOf becomes anyOf, const an
+// enum, and a type list with "null" a nullable type. Unsupported keywords
+// are dropped. The input is not modified.
+func SanitizeSchema(schema map[string]interface{}) map[string]interface{} {
+	if schema == nil {
+		return nil
+	}
+	defs := map[string]interface{}{}
+	for _, key := range []string{"$defs", "definitions"} {
+		if d, ok := schema[key].(map[string]interface{}); ok {
+			for name, def := range d {
+				defs["#/"+key+"/"+name] = def
+			}
+		}
+	}
+	return sanitize(schema, defs, 0)
+}
+
+func sanitize(schema map[string]interface{}, defs map[string]interface{}, depth int) map[string]interface{} {
+	if ref, ok := schema["$ref"].(string); ok {
+		def, _ := defs[ref].(map[string]interface{})
+		if def == nil || depth >= maxRefDepth {
+			// Unresolvable or too deep: keep what the reference says about
+			// itself and accept any object.
+			out := map[string]interface{}{"type": "object"}
+			if desc, ok := schema["description"].(string); ok {
+				out["description"] = desc
+			}
+			return out
+		}
+		merged := copyMap(def)
+		for k, v := range schema {
+			if k != "$ref" {
+				merged[k] = v
+			}
+		}
+		return sanitize(merged, defs, depth+1)
+	}
+
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		merged := copyMap(schema)
+		delete(merged, "allOf")
+		for _, sub := range all {
+			if m, ok := sub.(map[string]interface{}); ok {
+				mergeSchema(merged, m)
+			}
+		}
+		return sanitize(merged, defs, depth)
+	}
+
+	out := map[string]interface{}{}
+	for key, value := range schema {
+		switch key {
+		case "type":
+			if t, nullable := schemaType(value); t != "" {
+				out["type"] = t
+				if nullable {
+					out["nullable"] = true
+				}
+			}
+		case "const":
+			out["enum"] = []interface{}{value}
+		case "oneOf", "anyOf":
+			if subs := sanitizeList(value, defs, depth); len(subs) > 0 {
+				out["anyOf"] = subs
+			}
+		case "items":
+			if m, ok := value.(map[string]interface{}); ok {
+				out["items"] = sanitize(m, defs, depth)
+			}
+		case "properties":
+			if props, ok := value.(map[string]interface{}); ok {
+				clean := make(map[string]interface{}, len(props))
+				for name, prop := range props {
+					if m, ok := prop.(map[string]interface{}); ok {
+						clean[name] = sanitize(m, defs, depth)
+					}
+				}
+				out["properties"] = clean
+			}
+		default:
+			if schemaKeys[key] {
+				out[key] = value
+			}
+		}
+	}
+
+	// A schema without a type but with properties is an object.
+	if _, ok := out["type"]; !ok {
+		if _, ok := out["properties"]; ok {
+			out["type"] = "object"
+		}
+	}
+
+	// Gemini only knows the enum and date-time string formats, and only
+	// string enums.
+	if format, ok := out["format"].(string); ok && out["type"] == "string" && format != "enum" && format != "date-time" {
+		delete(out, "format")
+	}
+	if enum, ok := out["enum"].([]interface{}); ok {
+		strs := make([]interface{}, 0, len(enum))
+		for _, v := range enum {
+			if s, ok := v.(string); ok {
+				strs = append(strs, s)
+			}
+		}
+		if len(strs) == len(enum) && len(strs) > 0 {
+			out["enum"] = strs
+			if _, ok := out["type"]; !ok {
+				out["type"] = "string"
+			}
+		} else {
+			delete(out, "enum")
+		}
+	}
+
+	// required may only name properties that exist.
+	if _, ok := out["required"]; ok {
+		props, _ := out["properties"].(map[string]interface{})
+		var kept []interface{}
+		for _, name := range stringList(out["required"]) {
+			if props[name] != nil {
+				kept = append(kept, name)
+			}
+		}
+		if len(kept) > 0 {
+			out["required"] = kept
+		} else {
+			delete(out, "required")
+		}
+	}
+
+	return out
+}
+
+func sanitizeList(value interface{}, defs map[string]interface{}, depth int) []interface{} {
+	list, _ := value.([]interface{})
+	out := make([]interface{}, 0, len(list))
+	for _, sub := range list {
+		if m, ok := sub.(map[string]interface{}); ok {
+			out = append(out, sanitize(m, defs, depth))
+		}
+	}
+	return out
+}
+
+// schemaType returns the single type Gemini expects for a JSON schema type,
+// which may be a list such as ["string", "null"].
+func schemaType(value interface{}) (string, bool) {
+	if t, ok := value.(string); ok {
+		return strings.ToLower(t), false
+	}
+	var typ string
+	nullable := false
+	for _, t := range stringList(value) {
+		if t == "null" {
+			nullable = true
+		} else if typ == "" {
+			typ = strings.ToLower(t)
+		}
+	}
+	return typ, nullable
+}
+
+// stringList returns the strings of a list decoded from JSON or built in Go.
+func stringList(value interface{}) []string {
+	switch v := value.(type) {
+	case []string:
+		return v
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// mergeSchema merges src into dst for allOf: properties and required lists
+// are combined, other keys are taken from src if dst lacks them.
+func mergeSchema(dst, src map[string]interface{}) {
+	for key, value := range src {
+		switch key {
+		case "properties":
+			props, _ := dst["properties"].(map[string]interface{})
+			props = copyMap(props)
+			if m, ok := value.(map[string]interface{}); ok {
+				for k, v := range m {
+					props[k] = v
+				}
+			}
+			dst["properties"] = props
+		case "required":
+			dst["required"] = append(append([]string{}, stringList(dst["required"])...), stringList(value)...)
+		default:
+			if _, ok := dst[key]; !ok {
+				dst[key] = value
+			}
+		}
+	}
+}
+
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
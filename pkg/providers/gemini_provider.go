@@ -0,0 +1,31 @@
+package providers
+
+import (
+	"context"
+
+	"github.com/sipeed/picoclaw/pkg/providers/gemini"
+)
+
+// GeminiProvider talks to Google Gemini through generateContent, with
+// native function calling.
+type GeminiProvider struct {
+	delegate *gemini.Provider
+}
+
+func NewGeminiProvider(apiKey, apiBase, proxy string) *GeminiProvider {
+	return &GeminiProvider{
+		delegate: gemini.NewProvider(apiKey, apiBase, proxy),
+	}
+}
+
+func (p *GeminiProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	return p.delegate.Chat(ctx, messages, tools, model, options)
+}
+
+func (p *GeminiProvider) ChatStream(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}, onDelta func(string)) (*LLMResponse, error) {
+	return p.delegate.ChatStream(ctx, messages, tools, model, options, onDelta)
+}
+
+func (p *GeminiProvider) GetDefaultModel() string {
+	return ""
+}
@@ -54,6 +54,7 @@ func (p *GitHubCopilotProvider) Chat(ctx context.Context, messages []Message, to
 		Role    string `json:"role"`
 		Content string `json:"content"`
 	}
+	messages = withFormatInstruction(messages, options)
 	out := make([]tempMessage, 0, len(messages))
 
 	for _, msg := range messages {
@@ -0,0 +1,268 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+// ValidateSchema checks a decoded JSON value against a JSON schema. It
+// covers the keywords tool and response schemas use: type, enum, const,
+// properties, required, additionalProperties, items, the length and range
+// bounds, anyOf, oneOf, allOf and local $refs. Unknown keywords are ignored.
+func ValidateSchema(schema map[string]interface{}, value interface{}) error {
+	// Schemas built in Go may hold []string and ints; the JSON form holds
+	// []interface{} and float64 only.
+	var root map[string]interface{}
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return fmt.Errorf("invalid schema: %w", err)
+	}
+	if err := json.Unmarshal(data, &root); err != nil {
+		return fmt.Errorf("invalid schema: %w", err)
+	}
+	v := schemaValidator{root: root}
+	return v.validate(root, value, "$", 0)
+}
+
+// maxSchemaDepth bounds $ref resolution in recursive schemas.
+const maxSchemaDepth = 32
+
+type schemaValidator struct {
+	root map[string]interface{}
+}
+
+func (v schemaValidator) validate(schema map[string]interface{}, value interface{}, path string, depth int) error {
+	if depth > maxSchemaDepth {
+		return nil
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		resolved, err := v.resolve(ref)
+		if err != nil {
+			return err
+		}
+		return v.validate(resolved, value, path, depth+1)
+	}
+
+	if t, ok := schema["type"]; ok {
+		if err := checkType(t, value, path); err != nil {
+			return err
+		}
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if jsonEqual(e, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: %s is not one of %s", path, encode(value), encode(enum))
+		}
+	}
+	if c, ok := schema["const"]; ok && !jsonEqual(c, value) {
+		return fmt.Errorf("%s: must be %s", path, encode(c))
+	}
+
+	for _, sub := range schemaList(schema["allOf"]) {
+		if err := v.validate(sub, value, path, depth+1); err != nil {
+			return err
+		}
+	}
+	if anyOf := schemaList(schema["anyOf"]); len(anyOf) > 0 {
+		var first error
+		for _, sub := range anyOf {
+			err := v.validate(sub, value, path, depth+1)
+			if err == nil {
+				first = nil
+				break
+			}
+			if first == nil {
+				first = err
+			}
+		}
+		if first != nil {
+			return first
+		}
+	}
+	if oneOf := schemaList(schema["oneOf"]); len(oneOf) > 0 {
+		matches := 0
+		var first error
+		for _, sub := range oneOf {
+			if err := v.validate(sub, value, path, depth+1); err != nil {
+				if first == nil {
+					first = err
+				}
+				continue
+			}
+			matches++
+		}
+		if matches == 0 {
+			return first
+		}
+		if matches > 1 {
+			return fmt.Errorf("%s: matches more than one of oneOf", path)
+		}
+	}
+
+	switch val := value.(type) {
+	case map[string]interface{}:
+		return v.validateObject(schema, val, path, depth)
+	case []interface{}:
+		if n, ok := number(schema["minItems"]); ok && float64(len(val)) < n {
+			return fmt.Errorf("%s: needs at least %v items", path, n)
+		}
+		if n, ok := number(schema["maxItems"]); ok && float64(len(val)) > n {
+			return fmt.Errorf("%s: allows at most %v items", path, n)
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range val {
+				if err := v.validate(items, item, fmt.Sprintf("%s[%d]", path, i), depth+1); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		length := float64(utf8.RuneCountInString(val))
+		if n, ok := number(schema["minLength"]); ok && length < n {
+			return fmt.Errorf("%s: must be at least %v characters", path, n)
+		}
+		if n, ok := number(schema["maxLength"]); ok && length > n {
+			return fmt.Errorf("%s: must be at most %v characters", path, n)
+		}
+	case float64:
+		if n, ok := number(schema["minimum"]); ok && val < n {
+			return fmt.Errorf("%s: must be at least %v", path, n)
+		}
+		if n, ok := number(schema["maximum"]); ok && val > n {
+			return fmt.Errorf("%s: must be at most %v", path, n)
+		}
+	}
+	return nil
+}
+
+func (v schemaValidator) validateObject(schema map[string]interface{}, obj map[string]interface{}, path string, depth int) error {
+	for _, name := range stringSlice(schema["required"]) {
+		if _, ok := obj[name]; !ok {
+			return fmt.Errorf("%s: missing required property %q", path, name)
+		}
+	}
+	props, _ := schema["properties"].(map[string]interface{})
+	for name, value := range obj {
+		if sub, ok := props[name].(map[string]interface{}); ok {
+			if err := v.validate(sub, value, path+"."+name, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		switch extra := schema["additionalProperties"].(type) {
+		case bool:
+			if !extra {
+				return fmt.Errorf("%s: unexpected property %q", path, name)
+			}
+		case map[string]interface{}:
+			if err := v.validate(extra, value, path+"."+name, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolve looks up a local reference such as "#/$defs/item".
+func (v schemaValidator) resolve(ref string) (map[string]interface{}, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("unsupported schema reference %q", ref)
+	}
+	node := v.root
+	for _, name := range strings.Split(strings.TrimPrefix(strings.TrimPrefix(ref, "#"), "/"), "/") {
+		if name == "" {
+			continue
+		}
+		next, ok := node[name].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unresolved schema reference %q", ref)
+		}
+		node = next
+	}
+	return node, nil
+}
+
+func checkType(t interface{}, value interface{}, path string) error {
+	types := stringSlice(t)
+	if s, ok := t.(string); ok {
+		types = []string{s}
+	}
+	if len(types) == 0 {
+		return nil
+	}
+	actual := jsonType(value)
+	for _, want := range types {
+		if want == actual || (want == "number" && actual == "integer") {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(types, " or "), actual)
+}
+
+func jsonType(value interface{}) string {
+	switch val := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if val == math.Trunc(val) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// jsonEqual compares two decoded JSON values.
+func jsonEqual(a, b interface{}) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+func encode(value interface{}) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+func number(v interface{}) (float64, bool) {
+	n, ok := v.(float64)
+	return n, ok
+}
+
+func stringSlice(v interface{}) []string {
+	list, _ := v.([]interface{})
+	out := make([]string, 0, len(list))
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func schemaList(v interface{}) []map[string]interface{} {
+	list, _ := v.([]interface{})
+	out := make([]map[string]interface{}, 0, len(list))
+	for _, item := range list {
+		if m, ok := item.(map[string]interface{}); ok {
+			out = append(out, m)
+		}
+	}
+	return out
+}
@@ -0,0 +1,243 @@
+package providers
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/providers/gemini"
+	"github.com/sipeed/picoclaw/pkg/providers/ollama"
+)
+
+const defaultOpenAIAPIBase = "https://api.openai.com/v1"
+
+// Credential references a model_list entry may use instead of an API key.
+const (
+	credentialsEnvPrefix = "env:"
+	credentialsAuth      = "auth"
+	credentialsCodexCLI  = "codex-cli"
+)
+
+// selectionFromEntry builds the provider selection for a model_list entry.
+// The selection is named after the entry.
+func selectionFromEntry(cfg *config.Config, e config.ModelEntry) (providerSelection, error) {
+	sel := providerSelection{
+		name:    e.Name,
+		model:   e.Model,
+		apiBase: e.APIBase,
+		proxy:   e.Proxy,
+	}
+	if strings.TrimSpace(e.Model) == "" {
+		return providerSelection{}, fmt.Errorf("model %q: no upstream model set", e.Name)
+	}
+
+	apiKey := e.APIKey
+	switch {
+	case strings.HasPrefix(e.Credentials, credentialsEnvPrefix):
+		name := strings.TrimPrefix(e.Credentials, credentialsEnvPrefix)
+		apiKey = os.Getenv(name)
+		if apiKey == "" {
+			return providerSelection{}, fmt.Errorf("model %q: environment variable %s is not set", e.Name, name)
+		}
+	case e.Credentials == credentialsAuth:
+		if e.Protocol != config.ProtocolOpenAI && e.Protocol != config.ProtocolAnthropic {
+			return providerSelection{}, fmt.Errorf("model %q: protocol %s has no stored login", e.Name, e.Protocol)
+		}
+	case e.Credentials == credentialsCodexCLI:
+		if e.Protocol != config.ProtocolOpenAI {
+			return providerSelection{}, fmt.Errorf("model %q: codex-cli credentials need the openai protocol", e.Name)
+		}
+	case e.Credentials != "":
+		return providerSelection{}, fmt.Errorf("model %q: unknown credentials %q", e.Name, e.Credentials)
+	}
+	sel.apiKey = apiKey
+
+	needsKey := false
+	switch e.Protocol {
+	case config.ProtocolOpenAI:
+		sel.enableWebSearch = e.WebSearch
+		switch e.Credentials {
+		case credentialsAuth:
+			sel.providerType = providerTypeCodexAuth
+		case credentialsCodexCLI:
+			sel.providerType = providerTypeCodexCLIToken
+		default:
+			sel.providerType = providerTypeHTTPCompat
+			if sel.apiBase == "" {
+				sel.apiBase = defaultOpenAIAPIBase
+			}
+			// Local OpenAI-compatible servers often need no key.
+			needsKey = sel.apiBase == defaultOpenAIAPIBase
+		}
+	case config.ProtocolAnthropic:
+		if sel.apiBase == "" {
+			sel.apiBase = defaultAnthropicAPIBase
+		}
+		if e.Credentials == credentialsAuth {
+			sel.providerType = providerTypeClaudeAuth
+		} else {
+			sel.providerType = providerTypeClaudeAPIKey
+			needsKey = true
+		}
+	case config.ProtocolOllama:
+		sel.providerType = providerTypeOllama
+		if sel.apiBase == "" {
+			sel.apiBase = ollama.DefaultAPIBase
+		}
+	case config.ProtocolGemini:
+		sel.providerType = providerTypeGemini
+		if sel.apiBase == "" {
+			sel.apiBase = gemini.DefaultAPIBase
+		}
+		needsKey = true
+	case config.ProtocolCodexCLI, config.ProtocolClaudeCLI:
+		sel.providerType = providerTypeCodexCLI
+		if e.Protocol == config.ProtocolClaudeCLI {
+			sel.providerType = providerTypeClaudeCLI
+		}
+		sel.workspace = cfg.WorkspacePath()
+		if sel.workspace == "" {
+			sel.workspace = "."
+		}
+	default:
+		return providerSelection{}, fmt.Errorf("model %q: unknown protocol %q", e.Name, e.Protocol)
+	}
+
+	if needsKey && sel.apiKey == "" {
+		return providerSelection{}, fmt.Errorf("model %q: no API key configured", e.Name)
+	}
+	return sel, nil
+}
+
+// ModelListFromProviders converts the providers block into model_list
+// entries, one for each model the agents refer to that is not listed yet.
+// Each entry is named after the reference it stands for, so agents,
+// fallbacks, subagents and budgets keep working unchanged and reach the same
+// provider and upstream model as before. References no provider is
+// configured for are skipped; the warnings name those served by a provider
+// model_list has no protocol for.
+func ModelListFromProviders(cfg *config.Config) ([]config.ModelEntry, []string) {
+	var entries []config.ModelEntry
+	var warnings []string
+
+	defaults := &cfg.Agents.Defaults
+	defaultSel, defaultErr := resolveProviderSelection(cfg)
+	seen := make(map[string]bool)
+
+	add := func(ref string) {
+		ref = strings.TrimSpace(ref)
+		if ref == "" || seen[ref] || cfg.FindModel(ref) != nil {
+			return
+		}
+		seen[ref] = true
+
+		// References resolve the way Registry does: a provider prefix that
+		// names a configured provider picks it, anything else goes to the
+		// default provider with the reference as the model name.
+		sel, model := defaultSel, ref
+		named := false
+		if ref != defaults.Model {
+			if parsed := ParseModelRef(ref, defaults.Provider); parsed != nil {
+				if s, ok := configuredProvider(cfg, canonicalProvider(parsed.Provider)); ok {
+					sel, model, named = s, parsed.Model, true
+				}
+			}
+		}
+		if !named && defaultErr != nil {
+			// No provider is configured for it; there is nothing to convert.
+			return
+		}
+
+		entry, ok := entryFromSelection(sel)
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("Model %q: provider %s has no model_list protocol, left under providers", ref, sel.name))
+			return
+		}
+		entry.Name = ref
+		entry.Model = model
+		entries = append(entries, entry)
+	}
+
+	addAll := func(m *config.AgentModelConfig) {
+		if m != nil {
+			add(m.Primary)
+			for _, fb := range m.Fallbacks {
+				add(fb)
+			}
+		}
+	}
+
+	add(defaults.Model)
+	for _, fb := range defaults.ModelFallbacks {
+		add(fb)
+	}
+	add(defaults.ImageModel)
+	for _, fb := range defaults.ImageModelFallbacks {
+		add(fb)
+	}
+	if defaults.Budget != nil {
+		add(defaults.Budget.DowngradeModel)
+	}
+	for i := range cfg.Agents.List {
+		ac := &cfg.Agents.List[i]
+		addAll(ac.Model)
+		if ac.Subagents != nil {
+			addAll(ac.Subagents.Model)
+		}
+		if ac.Budget != nil {
+			add(ac.Budget.DowngradeModel)
+		}
+	}
+
+	return entries, warnings
+}
+
+// configuredProvider returns the selection of the registry provider name if
+// it is configured.
+func configuredProvider(cfg *config.Config, name string) (providerSelection, bool) {
+	for _, p := range registryProviders {
+		if p != name {
+			continue
+		}
+		sel := providerSelection{providerType: providerTypeHTTPCompat}
+		if applyNamedProvider(cfg, name, &sel) || sel.apiBase != "" {
+			return sel, true
+		}
+	}
+	return providerSelection{}, false
+}
+
+// entryFromSelection is the model_list entry that reaches the provider sel
+// describes. It reports false for providers without a protocol.
+func entryFromSelection(sel providerSelection) (config.ModelEntry, bool) {
+	e := config.ModelEntry{APIBase: sel.apiBase, APIKey: sel.apiKey, Proxy: sel.proxy}
+	switch sel.providerType {
+	case providerTypeHTTPCompat:
+		e.Protocol = config.ProtocolOpenAI
+		if sel.name == "anthropic" {
+			// The native API replaces Anthropic's OpenAI-compatible endpoint.
+			e.Protocol = config.ProtocolAnthropic
+		}
+	case providerTypeClaudeAPIKey:
+		e.Protocol = config.ProtocolAnthropic
+	case providerTypeClaudeAuth:
+		e.Protocol = config.ProtocolAnthropic
+		e.Credentials = credentialsAuth
+	case providerTypeCodexAuth:
+		e = config.ModelEntry{Protocol: config.ProtocolOpenAI, Credentials: credentialsAuth, WebSearch: sel.enableWebSearch}
+	case providerTypeCodexCLIToken:
+		e = config.ModelEntry{Protocol: config.ProtocolOpenAI, Credentials: credentialsCodexCLI, WebSearch: sel.enableWebSearch}
+	case providerTypeOllama:
+		e.Protocol = config.ProtocolOllama
+	case providerTypeGemini:
+		e.Protocol = config.ProtocolGemini
+	case providerTypeClaudeCLI:
+		e = config.ModelEntry{Protocol: config.ProtocolClaudeCLI}
+	case providerTypeCodexCLI:
+		e = config.ModelEntry{Protocol: config.ProtocolCodexCLI}
+	default:
+		return config.ModelEntry{}, false
+	}
+	return e, true
+}
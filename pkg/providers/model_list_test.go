@@ -0,0 +1,70 @@
+package providers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func TestModelListFromProviders(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Agents.Defaults.Model = "openrouter/auto"
+	cfg.Agents.Defaults.ModelFallbacks = []string{"groq/llama-3.3-70b", "anthropic/claude-sonnet-4", "listed"}
+	cfg.Agents.Defaults.ImageModel = "gemini/gemini-2.5-flash"
+	cfg.Providers.OpenRouter.APIKey = "sk-or-test"
+	cfg.Providers.Groq.APIKey = "groq-key"
+	cfg.Providers.Gemini.APIKey = "gemini-key"
+	cfg.Providers.OpenAI.AuthMethod = "oauth"
+	cfg.Providers.OpenAI.WebSearch = true
+	cfg.Agents.List = []config.AgentConfig{{
+		ID:        "coder",
+		Model:     &config.AgentModelConfig{Primary: "openai/gpt-5"},
+		Subagents: &config.SubagentsConfig{Model: &config.AgentModelConfig{Primary: "copilot/gpt-4o"}},
+	}}
+	cfg.ModelList = []config.ModelEntry{{Name: "listed", Protocol: "ollama", Model: "llama3.2"}}
+
+	entries, warnings := ModelListFromProviders(cfg)
+
+	want := map[string]config.ModelEntry{
+		"openrouter/auto":    {Name: "openrouter/auto", Protocol: "openai", Model: "openrouter/auto", APIBase: "https://openrouter.ai/api/v1", APIKey: "sk-or-test"},
+		"groq/llama-3.3-70b": {Name: "groq/llama-3.3-70b", Protocol: "openai", Model: "llama-3.3-70b", APIBase: "https://api.groq.com/openai/v1", APIKey: "groq-key"},
+		// Anthropic is not configured, so the default provider serves it.
+		"anthropic/claude-sonnet-4": {Name: "anthropic/claude-sonnet-4", Protocol: "openai", Model: "anthropic/claude-sonnet-4", APIBase: "https://openrouter.ai/api/v1", APIKey: "sk-or-test"},
+		"gemini/gemini-2.5-flash":   {Name: "gemini/gemini-2.5-flash", Protocol: "gemini", Model: "gemini-2.5-flash", APIBase: "https://generativelanguage.googleapis.com/v1beta", APIKey: "gemini-key"},
+		"openai/gpt-5":              {Name: "openai/gpt-5", Protocol: "openai", Model: "gpt-5", Credentials: "auth", WebSearch: true},
+		// Copilot is not a registry provider: the default provider serves it.
+		"copilot/gpt-4o": {Name: "copilot/gpt-4o", Protocol: "openai", Model: "copilot/gpt-4o", APIBase: "https://openrouter.ai/api/v1", APIKey: "sk-or-test"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("ModelListFromProviders() = %+v, want %d entries", entries, len(want))
+	}
+	for _, e := range entries {
+		if e != want[e.Name] {
+			t.Errorf("entry %q = %+v, want %+v", e.Name, e, want[e.Name])
+		}
+	}
+	if len(warnings) != 0 {
+		t.Errorf("warnings = %v", warnings)
+	}
+
+	// The converted list resolves every reference as before.
+	cfg.ModelList = append(cfg.ModelList, entries...)
+	if sel, err := resolveProviderSelection(cfg); err != nil || sel.name != "openrouter/auto" || sel.apiBase != "https://openrouter.ai/api/v1" {
+		t.Errorf("resolveProviderSelection() = %+v, %v", sel, err)
+	}
+}
+
+func TestModelListFromProviders_NoProtocol(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Agents.Defaults.Provider = "copilot"
+	cfg.Agents.Defaults.Model = "gpt-4o"
+
+	entries, warnings := ModelListFromProviders(cfg)
+	if len(entries) != 0 {
+		t.Errorf("entries = %+v, want none", entries)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "no model_list protocol") {
+		t.Errorf("warnings = %v", warnings)
+	}
+}
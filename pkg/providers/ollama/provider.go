@@ -0,0 +1,441 @@
+// Package ollama talks to a local Ollama server through its native API:
+// /api/chat with tools, /api/tags to list installed models and /api/pull to
+// download missing ones.
+package ollama
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/providers/protocoltypes"
+)
+
+type ToolCall = protocoltypes.ToolCall
+type LLMResponse = protocoltypes.LLMResponse
+type UsageInfo = protocoltypes.UsageInfo
+type Message = protocoltypes.Message
+type ToolDefinition = protocoltypes.ToolDefinition
+
+// DefaultAPIBase is where Ollama listens by default.
+const DefaultAPIBase = "http://localhost:11434"
+
+type Provider struct {
+	apiKey     string
+	apiBase    string
+	httpClient *http.Client
+	// pullClient has no timeout: downloading a model can take a long time.
+	pullClient *http.Client
+}
+
+// NewProvider creates a provider for the Ollama server at apiBase. An
+// OpenAI-compatible base ending in /v1 is accepted and trimmed. apiKey is
+// only needed when the server sits behind an authenticating proxy.
+func NewProvider(apiKey, apiBase, proxy string) *Provider {
+	apiBase = strings.TrimRight(apiBase, "/")
+	apiBase = strings.TrimSuffix(apiBase, "/v1")
+	if apiBase == "" {
+		apiBase = DefaultAPIBase
+	}
+
+	// Local models can take a while to load and answer on small machines.
+	client := &http.Client{Timeout: 300 * time.Second}
+	pullClient := &http.Client{}
+	if proxy != "" {
+		parsed, err := url.Parse(proxy)
+		if err == nil {
+			transport := &http.Transport{Proxy: http.ProxyURL(parsed)}
+			client.Transport = transport
+			pullClient.Transport = transport
+		} else {
+			log.Printf("ollama: invalid proxy URL %q: %v", proxy, err)
+		}
+	}
+
+	return &Provider{
+		apiKey:     apiKey,
+		apiBase:    apiBase,
+		httpClient: client,
+		pullClient: pullClient,
+	}
+}
+
+func (p *Provider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	resp, err := p.post(ctx, p.httpClient, "/api/chat", p.buildRequest(messages, tools, model, options, false))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var chunk chatChunk
+	if err := json.NewDecoder(resp.Body).Decode(&chunk); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return p.response(chunk.Message.Content, chunk.Message.ToolCalls, chunk), nil
+}
+
+// ChatStream calls onDelta for every content fragment of the reply. Ollama
+// sends tool calls whole, so they are collected as they arrive.
+func (p *Provider) ChatStream(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}, onDelta func(string)) (*LLMResponse, error) {
+	resp, err := p.post(ctx, p.httpClient, "/api/chat", p.buildRequest(messages, tools, model, options, true))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var content strings.Builder
+	var toolCalls []wireToolCall
+	var last chatChunk
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var chunk chatChunk
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal stream chunk: %w", err)
+		}
+		if chunk.Error != "" {
+			return nil, fmt.Errorf("ollama: %s", chunk.Error)
+		}
+		if chunk.Message.Content != "" {
+			content.WriteString(chunk.Message.Content)
+			if onDelta != nil {
+				onDelta(chunk.Message.Content)
+			}
+		}
+		toolCalls = append(toolCalls, chunk.Message.ToolCalls...)
+		if chunk.Done {
+			last = chunk
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read stream: %w", err)
+	}
+
+	return p.response(content.String(), toolCalls, last), nil
+}
+
+func (p *Provider) GetDefaultModel() string {
+	return ""
+}
+
+// ListModels returns the names of the installed models.
+func (p *Provider) ListModels(ctx context.Context) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.apiBase+"/api/tags", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	p.authorize(req)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return nil, err
+	}
+
+	var tags struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal model list: %w", err)
+	}
+	names := make([]string, 0, len(tags.Models))
+	for _, m := range tags.Models {
+		names = append(names, m.Name)
+	}
+	return names, nil
+}
+
+// Pull downloads a model and returns once it is installed.
+func (p *Provider) Pull(ctx context.Context, model string) error {
+	resp, err := p.post(ctx, p.pullClient, "/api/pull", map[string]interface{}{
+		"model":  normalizeModel(model),
+		"stream": false,
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to unmarshal pull response: %w", err)
+	}
+	if result.Error != "" {
+		return fmt.Errorf("pulling %s: %s", model, result.Error)
+	}
+	return nil
+}
+
+// EnsureModels pulls the models that are not installed yet and returns the
+// ones it pulled.
+func (p *Provider) EnsureModels(ctx context.Context, models []string) ([]string, error) {
+	installed, err := p.ListModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	have := make(map[string]bool, len(installed))
+	for _, name := range installed {
+		have[withTag(name)] = true
+	}
+
+	var pulled []string
+	for _, model := range models {
+		name := withTag(normalizeModel(model))
+		if have[name] {
+			continue
+		}
+		if err := p.Pull(ctx, name); err != nil {
+			return pulled, err
+		}
+		have[name] = true
+		pulled = append(pulled, name)
+	}
+	return pulled, nil
+}
+
+// wireMessage is a message in the form /api/chat expects.
+type wireMessage struct {
+	Role      string         `json:"role"`
+	Content   string         `json:"content"`
+	Images    []string       `json:"images,omitempty"`
+	ToolCalls []wireToolCall `json:"tool_calls,omitempty"`
+	ToolName  string         `json:"tool_name,omitempty"`
+}
+
+type wireToolCall struct {
+	ID       string `json:"id,omitempty"`
+	Function struct {
+		Name      string                 `json:"name"`
+		Arguments map[string]interface{} `json:"arguments"`
+	} `json:"function"`
+}
+
+// chatChunk is a /api/chat response, or one line of a streamed one.
+type chatChunk struct {
+	Message struct {
+		Content   string         `json:"content"`
+		ToolCalls []wireToolCall `json:"tool_calls"`
+	} `json:"message"`
+	Done            bool   `json:"done"`
+	DoneReason      string `json:"done_reason"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+	Error           string `json:"error"`
+}
+
+func (p *Provider) buildRequest(messages []Message, tools []ToolDefinition, model string, options map[string]interface{}, stream bool) map[string]interface{} {
+	request := map[string]interface{}{
+		"model":    normalizeModel(model),
+		"messages": wireMessages(messages),
+		"stream":   stream,
+	}
+	if len(tools) > 0 {
+		request["tools"] = tools
+	}
+
+	modelOptions := map[string]interface{}{}
+	if maxTokens, ok := options["max_tokens"].(int); ok && maxTokens > 0 {
+		modelOptions["num_predict"] = maxTokens
+	}
+	if numCtx, ok := options["num_ctx"].(int); ok && numCtx > 0 {
+		modelOptions["num_ctx"] = numCtx
+	}
+	if temperature, ok := options["temperature"].(float64); ok {
+		modelOptions["temperature"] = temperature
+	}
+	if topP, ok := options["top_p"].(float64); ok {
+		modelOptions["top_p"] = topP
+	}
+	if stop, ok := options["stop"].([]string); ok && len(stop) > 0 {
+		modelOptions["stop"] = stop
+	}
+	if extra, ok := options["ollama_options"].(map[string]interface{}); ok {
+		for k, v := range extra {
+			modelOptions[k] = v
+		}
+	}
+	if len(modelOptions) > 0 {
+		request["options"] = modelOptions
+	}
+
+	if keepAlive, ok := options["keep_alive"].(string); ok && keepAlive != "" {
+		request["keep_alive"] = keepAlive
+	}
+	if rf := protocoltypes.ResponseFormatFrom(options); rf != nil {
+		// A format constrains every reply, tool calls included, so with
+		// tools the schema is only asked for.
+		if len(tools) > 0 {
+			request["messages"] = append(wireMessages(messages), wireMessage{Role: "system", Content: rf.Instruction()})
+		} else {
+			request["format"] = rf.Schema
+		}
+	}
+	return request
+}
+
+// wireMessages converts messages to the /api/chat form. Tool results carry
+// the name of the tool instead of a call ID, and images are sent as base64.
+func wireMessages(messages []Message) []wireMessage {
+	toolNames := make(map[string]string)
+	out := make([]wireMessage, 0, len(messages))
+	for _, msg := range messages {
+		wm := wireMessage{Role: msg.Role, Content: msg.Content}
+
+		if len(msg.Parts) > 0 {
+			var text []string
+			for _, part := range msg.Parts {
+				switch part.Type {
+				case protocoltypes.ContentPartText:
+					text = append(text, part.Text)
+				case protocoltypes.ContentPartImage:
+					if part.Data != "" {
+						wm.Images = append(wm.Images, part.Data)
+					}
+				}
+			}
+			wm.Content = strings.Join(text, "\n")
+		}
+
+		for _, tc := range msg.ToolCalls {
+			name, args := tc.Name, tc.Arguments
+			if tc.Function != nil {
+				if name == "" {
+					name = tc.Function.Name
+				}
+				if args == nil && tc.Function.Arguments != "" {
+					json.Unmarshal([]byte(tc.Function.Arguments), &args)
+				}
+			}
+			if args == nil {
+				args = map[string]interface{}{}
+			}
+			var call wireToolCall
+			call.Function.Name = name
+			call.Function.Arguments = args
+			wm.ToolCalls = append(wm.ToolCalls, call)
+			toolNames[tc.ID] = name
+		}
+
+		if msg.Role == "tool" {
+			wm.ToolName = toolNames[msg.ToolCallID]
+		}
+		out = append(out, wm)
+	}
+	return out
+}
+
+func (p *Provider) response(content string, calls []wireToolCall, final chatChunk) *LLMResponse {
+	toolCalls := make([]ToolCall, 0, len(calls))
+	for _, call := range calls {
+		id := call.ID
+		if id == "" {
+			// Older servers do not number tool calls.
+			id = protocoltypes.NewToolCallID()
+		}
+		args := call.Function.Arguments
+		if args == nil {
+			args = map[string]interface{}{}
+		}
+		toolCalls = append(toolCalls, ToolCall{
+			ID:        id,
+			Name:      call.Function.Name,
+			Arguments: args,
+		})
+	}
+
+	finishReason := final.DoneReason
+	if len(toolCalls) > 0 {
+		finishReason = "tool_calls"
+	} else if finishReason == "" {
+		finishReason = "stop"
+	}
+
+	return &LLMResponse{
+		Content:      content,
+		ToolCalls:    toolCalls,
+		FinishReason: finishReason,
+		Usage: &UsageInfo{
+			PromptTokens:     final.PromptEvalCount,
+			CompletionTokens: final.EvalCount,
+			TotalTokens:      final.PromptEvalCount + final.EvalCount,
+		},
+	}
+}
+
+func (p *Provider) post(ctx context.Context, client *http.Client, path string, body map[string]interface{}) (*http.Response, error) {
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.apiBase+path, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	p.authorize(req)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	if err := checkStatus(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (p *Provider) authorize(req *http.Request) {
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+}
+
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	body, _ := io.ReadAll(resp.Body)
+	return protocoltypes.WrapContextLength(
+		fmt.Errorf("API request failed:\n  Status: %d\n  Body:   %s", resp.StatusCode, string(body)),
+		string(body))
+}
+
+// normalizeModel strips the "ollama/" provider prefix.
+func normalizeModel(model string) string {
+	if rest, ok := strings.CutPrefix(model, "ollama/"); ok {
+		return rest
+	}
+	return model
+}
+
+// withTag adds the ":latest" tag Ollama assumes for untagged model names.
+func withTag(model string) string {
+	if strings.Contains(model, ":") {
+		return model
+	}
+	return model + ":latest"
+}
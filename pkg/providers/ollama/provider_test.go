@@ -0,0 +1,215 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/providers/protocoltypes"
+)
+
+func TestProviderChat_ToolCallsRoundTrip(t *testing.T) {
+	var requestBody map[string]interface{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": map[string]interface{}{
+				"role":    "assistant",
+				"content": "",
+				"tool_calls": []map[string]interface{}{
+					{"function": map[string]interface{}{
+						"name":      "get_weather",
+						"arguments": map[string]interface{}{"city": "Lisbon"},
+					}},
+				},
+			},
+			"done":              true,
+			"done_reason":       "stop",
+			"prompt_eval_count": 42,
+			"eval_count":        7,
+		})
+	}))
+	defer server.Close()
+
+	p := NewProvider("", server.URL+"/v1", "")
+	messages := []Message{
+		{Role: "system", Content: "You are helpful."},
+		{Role: "user", Parts: []protocoltypes.ContentPart{
+			{Type: protocoltypes.ContentPartText, Text: "What is this?"},
+			{Type: protocoltypes.ContentPartImage, MediaType: "image/png", Data: "aGVsbG8="},
+		}},
+		{Role: "assistant", ToolCalls: []ToolCall{{ID: "call_1", Name: "read_file", Arguments: map[string]interface{}{"path": "a.txt"}}}},
+		{Role: "tool", ToolCallID: "call_1", Content: "hello"},
+	}
+	tools := []ToolDefinition{{
+		Type: "function",
+		Function: protocoltypes.ToolFunctionDefinition{
+			Name:       "get_weather",
+			Parameters: map[string]interface{}{"type": "object"},
+		},
+	}}
+	options := map[string]interface{}{
+		"max_tokens":     512,
+		"temperature":    0.2,
+		"num_ctx":        8192,
+		"keep_alive":     "10m",
+		"ollama_options": map[string]interface{}{"num_gpu": 0},
+	}
+
+	out, err := p.Chat(context.Background(), messages, tools, "ollama/qwen2.5:7b", options)
+	if err != nil {
+		t.Fatalf("Chat() error: %v", err)
+	}
+
+	if requestBody["model"] != "qwen2.5:7b" || requestBody["stream"] != false || requestBody["keep_alive"] != "10m" {
+		t.Errorf("request = %v", requestBody)
+	}
+	wantOptions := map[string]interface{}{"num_predict": 512.0, "temperature": 0.2, "num_ctx": 8192.0, "num_gpu": 0.0}
+	if !reflect.DeepEqual(requestBody["options"], wantOptions) {
+		t.Errorf("options = %v, want %v", requestBody["options"], wantOptions)
+	}
+	if tools, _ := requestBody["tools"].([]interface{}); len(tools) != 1 {
+		t.Errorf("tools = %v", requestBody["tools"])
+	}
+
+	sent := requestBody["messages"].([]interface{})
+	user := sent[1].(map[string]interface{})
+	if user["content"] != "What is this?" || !reflect.DeepEqual(user["images"], []interface{}{"aGVsbG8="}) {
+		t.Errorf("user message = %v", user)
+	}
+	call := sent[2].(map[string]interface{})["tool_calls"].([]interface{})[0].(map[string]interface{})["function"].(map[string]interface{})
+	if call["name"] != "read_file" || !reflect.DeepEqual(call["arguments"], map[string]interface{}{"path": "a.txt"}) {
+		t.Errorf("assistant tool call = %v", call)
+	}
+	if result := sent[3].(map[string]interface{}); result["tool_name"] != "read_file" {
+		t.Errorf("tool result = %v, want tool_name read_file", result)
+	}
+
+	if len(out.ToolCalls) != 1 || out.ToolCalls[0].Name != "get_weather" || out.ToolCalls[0].Arguments["city"] != "Lisbon" {
+		t.Fatalf("ToolCalls = %+v", out.ToolCalls)
+	}
+	if id := out.ToolCalls[0].ID; !strings.HasPrefix(id, "call_") || id == "call_1" {
+		t.Errorf("tool call ID = %q, want a random call_ ID", id)
+	}
+	if out.FinishReason != "tool_calls" {
+		t.Errorf("FinishReason = %q, want tool_calls", out.FinishReason)
+	}
+	if out.Usage.PromptTokens != 42 || out.Usage.CompletionTokens != 7 || out.Usage.TotalTokens != 49 {
+		t.Errorf("Usage = %+v", out.Usage)
+	}
+}
+
+func TestProviderChatStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["stream"] != true {
+			t.Errorf("stream = %v, want true", body["stream"])
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		for _, line := range []string{
+			`{"message":{"role":"assistant","content":"Hel"},"done":false}`,
+			`{"message":{"role":"assistant","content":"lo"},"done":false}`,
+			`{"message":{"role":"assistant","content":""},"done":true,"done_reason":"length","prompt_eval_count":5,"eval_count":2}`,
+		} {
+			w.Write([]byte(line + "\n"))
+		}
+	}))
+	defer server.Close()
+
+	p := NewProvider("", server.URL, "")
+	var deltas []string
+	out, err := p.ChatStream(context.Background(), []Message{{Role: "user", Content: "hi"}}, nil, "llama3.2", nil, func(d string) {
+		deltas = append(deltas, d)
+	})
+	if err != nil {
+		t.Fatalf("ChatStream() error: %v", err)
+	}
+	if out.Content != "Hello" || strings.Join(deltas, "|") != "Hel|lo" {
+		t.Errorf("Content = %q, deltas = %v", out.Content, deltas)
+	}
+	if out.FinishReason != "length" || out.Usage.TotalTokens != 7 {
+		t.Errorf("FinishReason = %q, Usage = %+v", out.FinishReason, out.Usage)
+	}
+}
+
+func TestBuildRequest_ResponseFormat(t *testing.T) {
+	schema := map[string]interface{}{"type": "object"}
+	opts := map[string]interface{}{protocoltypes.ResponseFormatOption: &protocoltypes.ResponseFormat{Name: "summary", Schema: schema}}
+	messages := []Message{{Role: "user", Content: "hi"}}
+	p := NewProvider("", "http://localhost:11434", "")
+
+	req := p.buildRequest(messages, nil, "llama3.2", opts, false)
+	if !reflect.DeepEqual(req["format"], schema) {
+		t.Errorf("format = %v, want the schema", req["format"])
+	}
+
+	tools := []ToolDefinition{{Type: "function", Function: protocoltypes.ToolFunctionDefinition{Name: "exec"}}}
+	req = p.buildRequest(messages, tools, "llama3.2", opts, false)
+	if _, ok := req["format"]; ok {
+		t.Error("format should not be sent with tools")
+	}
+	wire := req["messages"].([]wireMessage)
+	if last := wire[len(wire)-1]; last.Role != "system" || !strings.Contains(last.Content, "JSON schema") {
+		t.Errorf("last message = %+v, want the schema instruction", last)
+	}
+}
+
+func TestProviderChat_ErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"model 'nope' not found"}`))
+	}))
+	defer server.Close()
+
+	p := NewProvider("", server.URL, "")
+	_, err := p.Chat(context.Background(), []Message{{Role: "user", Content: "hi"}}, nil, "nope", nil)
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("Chat() error = %v, want model not found", err)
+	}
+}
+
+func TestProviderEnsureModels(t *testing.T) {
+	var pulled []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			w.Write([]byte(`{"models":[{"name":"llama3.2:latest"},{"name":"qwen2.5:7b"}]}`))
+		case "/api/pull":
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			pulled = append(pulled, body["model"].(string))
+			w.Write([]byte(`{"status":"success"}`))
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	p := NewProvider("", server.URL, "")
+
+	models, err := p.ListModels(context.Background())
+	if err != nil || !reflect.DeepEqual(models, []string{"llama3.2:latest", "qwen2.5:7b"}) {
+		t.Fatalf("ListModels() = %v, %v", models, err)
+	}
+
+	got, err := p.EnsureModels(context.Background(), []string{"llama3.2", "ollama/qwen2.5:7b", "phi3", "phi3:latest"})
+	if err != nil {
+		t.Fatalf("EnsureModels() error: %v", err)
+	}
+	if !reflect.DeepEqual(got, []string{"phi3:latest"}) || !reflect.DeepEqual(pulled, []string{"phi3:latest"}) {
+		t.Errorf("EnsureModels() pulled %v (server saw %v), want only phi3:latest", got, pulled)
+	}
+}
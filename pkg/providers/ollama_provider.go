@@ -0,0 +1,39 @@
+package providers
+
+import (
+	"context"
+
+	"github.com/sipeed/picoclaw/pkg/providers/ollama"
+)
+
+// OllamaProvider talks to Ollama through its native API rather than the
+// OpenAI-compatible one, keeping tool calls, keep_alive and num_ctx.
+type OllamaProvider struct {
+	delegate *ollama.Provider
+}
+
+func NewOllamaProvider(apiKey, apiBase, proxy string) *OllamaProvider {
+	return &OllamaProvider{
+		delegate: ollama.NewProvider(apiKey, apiBase, proxy),
+	}
+}
+
+func (p *OllamaProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	return p.delegate.Chat(ctx, messages, tools, model, options)
+}
+
+func (p *OllamaProvider) ChatStream(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}, onDelta func(string)) (*LLMResponse, error) {
+	return p.delegate.ChatStream(ctx, messages, tools, model, options, onDelta)
+}
+
+func (p *OllamaProvider) GetDefaultModel() string {
+	return ""
+}
+
+func (p *OllamaProvider) ListModels(ctx context.Context) ([]string, error) {
+	return p.delegate.ListModels(ctx)
+}
+
+func (p *OllamaProvider) EnsureModels(ctx context.Context, models []string) ([]string, error) {
+	return p.delegate.EnsureModels(ctx, models)
+}
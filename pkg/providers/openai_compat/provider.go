@@ -57,11 +57,32 @@ func (p *Provider) Chat(ctx context.Context, messages []Message, tools []ToolDef
 		return nil, fmt.Errorf("API base not configured")
 	}
 
+	resp, err := p.post(ctx, p.buildRequestBody(messages, tools, model, options))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, protocoltypes.WrapContextLength(
+			fmt.Errorf("API request failed:\n  Status: %d\n  Body:   %s", resp.StatusCode, string(body)),
+			string(body))
+	}
+
+	return parseResponse(body)
+}
+
+func (p *Provider) buildRequestBody(messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) map[string]interface{} {
 	model = normalizeModel(model, p.apiBase)
 
 	requestBody := map[string]interface{}{
 		"model":    model,
-		"messages": messages,
+		"messages": wireMessages(messages),
 	}
 
 	if len(tools) > 0 {
@@ -88,6 +109,32 @@ func (p *Provider) Chat(ctx context.Context, messages []Message, tools []ToolDef
 		}
 	}
 
+	if topP, ok := asFloat(options["top_p"]); ok {
+		requestBody["top_p"] = topP
+	}
+
+	if stop, ok := options["stop"].([]string); ok && len(stop) > 0 {
+		requestBody["stop"] = stop
+	}
+
+	if effort, ok := options["reasoning_effort"].(string); ok && effort != "" {
+		requestBody["reasoning_effort"] = effort
+	}
+
+	if rf := protocoltypes.ResponseFormatFrom(options); rf != nil {
+		requestBody["response_format"] = map[string]interface{}{
+			"type": "json_schema",
+			"json_schema": map[string]interface{}{
+				"name":   rf.SchemaName(),
+				"schema": rf.Schema,
+			},
+		}
+	}
+
+	return requestBody
+}
+
+func (p *Provider) post(ctx context.Context, requestBody map[string]interface{}) (*http.Response, error) {
 	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
@@ -107,18 +154,7 @@ func (p *Provider) Chat(ctx context.Context, messages []Message, tools []ToolDef
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API request failed:\n  Status: %d\n  Body:   %s", resp.StatusCode, string(body))
-	}
-
-	return parseResponse(body)
+	return resp, nil
 }
 
 func parseResponse(body []byte) (*LLMResponse, error) {
@@ -137,7 +173,7 @@ func parseResponse(body []byte) (*LLMResponse, error) {
 			} `json:"message"`
 			FinishReason string `json:"finish_reason"`
 		} `json:"choices"`
-		Usage *UsageInfo `json:"usage"`
+		Usage *apiUsage `json:"usage"`
 	}
 
 	if err := json.Unmarshal(body, &apiResponse); err != nil {
@@ -159,12 +195,7 @@ func parseResponse(body []byte) (*LLMResponse, error) {
 
 		if tc.Function != nil {
 			name = tc.Function.Name
-			if tc.Function.Arguments != "" {
-				if err := json.Unmarshal([]byte(tc.Function.Arguments), &arguments); err != nil {
-					log.Printf("openai_compat: failed to decode tool call arguments for %q: %v", name, err)
-					arguments["raw"] = tc.Function.Arguments
-				}
-			}
+			arguments = decodeArguments(name, tc.Function.Arguments)
 		}
 
 		toolCalls = append(toolCalls, ToolCall{
@@ -178,10 +209,102 @@ func parseResponse(body []byte) (*LLMResponse, error) {
 		Content:      choice.Message.Content,
 		ToolCalls:    toolCalls,
 		FinishReason: choice.FinishReason,
-		Usage:        apiResponse.Usage,
+		Usage:        apiResponse.Usage.info(),
 	}, nil
 }
 
+// apiUsage is the usage object of a chat completion.
+type apiUsage struct {
+	PromptTokens        int `json:"prompt_tokens"`
+	CompletionTokens    int `json:"completion_tokens"`
+	TotalTokens         int `json:"total_tokens"`
+	PromptTokensDetails *struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+	// DeepSeek reports cache hits at the top level.
+	PromptCacheHitTokens int `json:"prompt_cache_hit_tokens"`
+}
+
+func (u *apiUsage) info() *UsageInfo {
+	if u == nil {
+		return nil
+	}
+	info := &UsageInfo{
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		TotalTokens:      u.TotalTokens,
+		CachedTokens:     u.PromptCacheHitTokens,
+	}
+	if u.PromptTokensDetails != nil && u.PromptTokensDetails.CachedTokens > 0 {
+		info.CachedTokens = u.PromptTokensDetails.CachedTokens
+	}
+	return info
+}
+
+// wireMessage is a chat message whose content may be a string or an array
+// of content parts.
+type wireMessage struct {
+	Role       string      `json:"role"`
+	Content    interface{} `json:"content"`
+	ToolCalls  []ToolCall  `json:"tool_calls,omitempty"`
+	ToolCallID string      `json:"tool_call_id,omitempty"`
+}
+
+// wireMessages converts messages with Parts into the OpenAI content array
+// form. Messages without Parts are sent unchanged.
+func wireMessages(messages []Message) []interface{} {
+	out := make([]interface{}, 0, len(messages))
+	for _, msg := range messages {
+		if len(msg.Parts) == 0 {
+			out = append(out, msg)
+			continue
+		}
+
+		content := make([]map[string]interface{}, 0, len(msg.Parts))
+		for _, part := range msg.Parts {
+			switch part.Type {
+			case protocoltypes.ContentPartText:
+				content = append(content, map[string]interface{}{
+					"type": "text",
+					"text": part.Text,
+				})
+			case protocoltypes.ContentPartImage:
+				url := part.URL
+				if part.Data != "" {
+					url = "data:" + part.MediaType + ";base64," + part.Data
+				}
+				if url == "" {
+					continue
+				}
+				content = append(content, map[string]interface{}{
+					"type":      "image_url",
+					"image_url": map[string]interface{}{"url": url},
+				})
+			}
+		}
+
+		out = append(out, wireMessage{
+			Role:       msg.Role,
+			Content:    content,
+			ToolCalls:  msg.ToolCalls,
+			ToolCallID: msg.ToolCallID,
+		})
+	}
+	return out
+}
+
+func decodeArguments(name, raw string) map[string]interface{} {
+	arguments := make(map[string]interface{})
+	if raw == "" {
+		return arguments
+	}
+	if err := json.Unmarshal([]byte(raw), &arguments); err != nil {
+		log.Printf("openai_compat: failed to decode tool call arguments for %q: %v", name, err)
+		arguments["raw"] = raw
+	}
+	return arguments
+}
+
 func normalizeModel(model, apiBase string) string {
 	idx := strings.Index(model, "/")
 	if idx == -1 {
@@ -2,10 +2,13 @@ package openai_compat
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/providers/protocoltypes"
 )
 
 func TestProviderChat_UsesMaxCompletionTokensForGLM(t *testing.T) {
@@ -47,6 +50,93 @@ func TestProviderChat_UsesMaxCompletionTokensForGLM(t *testing.T) {
 	}
 }
 
+func TestProviderChat_SendsSamplingOptions(t *testing.T) {
+	var requestBody map[string]interface{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp := map[string]interface{}{
+			"choices": []map[string]interface{}{
+				{
+					"message":       map[string]interface{}{"content": "ok"},
+					"finish_reason": "stop",
+				},
+			},
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	p := NewProvider("key", server.URL, "")
+	_, err := p.Chat(t.Context(), []Message{{Role: "user", Content: "hi"}}, nil, "gpt-4o", map[string]interface{}{
+		"top_p":            0.5,
+		"stop":             []string{"###"},
+		"reasoning_effort": "low",
+	})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+
+	if requestBody["top_p"] != 0.5 {
+		t.Errorf("top_p = %v, want 0.5", requestBody["top_p"])
+	}
+	stop, ok := requestBody["stop"].([]interface{})
+	if !ok || len(stop) != 1 || stop[0] != "###" {
+		t.Errorf("stop = %v, want [###]", requestBody["stop"])
+	}
+	if requestBody["reasoning_effort"] != "low" {
+		t.Errorf("reasoning_effort = %v, want low", requestBody["reasoning_effort"])
+	}
+	if _, ok := requestBody["temperature"]; ok {
+		t.Errorf("did not expect temperature when unset")
+	}
+}
+
+func TestProviderChat_SendsResponseFormat(t *testing.T) {
+	var requestBody map[string]interface{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"choices": []map[string]interface{}{
+				{"message": map[string]interface{}{"content": `{"ok":true}`}, "finish_reason": "stop"},
+			},
+		})
+	}))
+	defer server.Close()
+
+	p := NewProvider("key", server.URL, "")
+	_, err := p.Chat(t.Context(), []Message{{Role: "user", Content: "hi"}}, nil, "gpt-4o", map[string]interface{}{
+		protocoltypes.ResponseFormatOption: &protocoltypes.ResponseFormat{
+			Name:   "heartbeat result",
+			Schema: map[string]interface{}{"type": "object"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+
+	format, _ := requestBody["response_format"].(map[string]interface{})
+	if format["type"] != "json_schema" {
+		t.Fatalf("response_format = %v, want json_schema", requestBody["response_format"])
+	}
+	schema, _ := format["json_schema"].(map[string]interface{})
+	if schema["name"] != "heartbeatresult" {
+		t.Errorf("json_schema.name = %v, want heartbeatresult", schema["name"])
+	}
+	if inner, _ := schema["schema"].(map[string]interface{}); inner["type"] != "object" {
+		t.Errorf("json_schema.schema = %v", schema["schema"])
+	}
+}
+
 func TestProviderChat_ParsesToolCalls(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		resp := map[string]interface{}{
@@ -72,6 +162,9 @@ func TestProviderChat_ParsesToolCalls(t *testing.T) {
 				"prompt_tokens":     10,
 				"completion_tokens": 5,
 				"total_tokens":      15,
+				"prompt_tokens_details": map[string]interface{}{
+					"cached_tokens": 8,
+				},
 			},
 		}
 		w.Header().Set("Content-Type", "application/json")
@@ -93,6 +186,9 @@ func TestProviderChat_ParsesToolCalls(t *testing.T) {
 	if out.ToolCalls[0].Arguments["city"] != "SF" {
 		t.Fatalf("ToolCalls[0].Arguments[city] = %v, want SF", out.ToolCalls[0].Arguments["city"])
 	}
+	if out.Usage == nil || out.Usage.PromptTokens != 10 || out.Usage.CachedTokens != 8 {
+		t.Fatalf("Usage = %+v, want 10 prompt tokens of which 8 cached", out.Usage)
+	}
 }
 
 func TestProviderChat_HTTPError(t *testing.T) {
@@ -108,6 +204,32 @@ func TestProviderChat_HTTPError(t *testing.T) {
 	}
 }
 
+func TestProviderChat_ContextLengthError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"code":"context_length_exceeded","message":"This model's maximum context length is 8192 tokens."}}`, http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	p := NewProvider("key", server.URL, "")
+	_, err := p.Chat(t.Context(), []Message{{Role: "user", Content: "hi"}}, nil, "gpt-4o", nil)
+	if !errors.Is(err, protocoltypes.ErrContextLength) {
+		t.Fatalf("expected a context length error, got %v", err)
+	}
+}
+
+func TestProviderChat_OtherErrorsAreNotContextLength(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"code":"invalid_api_key","message":"Invalid token length"}}`, http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	p := NewProvider("key", server.URL, "")
+	_, err := p.Chat(t.Context(), []Message{{Role: "user", Content: "hi"}}, nil, "gpt-4o", nil)
+	if err == nil || errors.Is(err, protocoltypes.ErrContextLength) {
+		t.Fatalf("expected a non context length error, got %v", err)
+	}
+}
+
 func TestProviderChat_StripsMoonshotPrefixAndNormalizesKimiTemperature(t *testing.T) {
 	var requestBody map[string]interface{}
 
@@ -275,3 +397,58 @@ func TestNormalizeModel_UsesAPIBase(t *testing.T) {
 		t.Fatalf("normalizeModel(openrouter) = %q, want %q", got, "openrouter/auto")
 	}
 }
+
+func TestProviderChat_SendsImagePartsAsContentArray(t *testing.T) {
+	var requestBody map[string]interface{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp := map[string]interface{}{
+			"choices": []map[string]interface{}{
+				{
+					"message":       map[string]interface{}{"content": "a cat"},
+					"finish_reason": "stop",
+				},
+			},
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	p := NewProvider("key", server.URL, "")
+	messages := []Message{
+		{Role: "system", Content: "sys"},
+		{
+			Role:    "user",
+			Content: "what is this?",
+			Parts: []protocoltypes.ContentPart{
+				{Type: protocoltypes.ContentPartText, Text: "what is this?"},
+				{Type: protocoltypes.ContentPartImage, MediaType: "image/png", Data: "aGVsbG8="},
+			},
+		},
+	}
+	if _, err := p.Chat(t.Context(), messages, nil, "gpt-4o", nil); err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+
+	sent := requestBody["messages"].([]interface{})
+	if content, ok := sent[0].(map[string]interface{})["content"].(string); !ok || content != "sys" {
+		t.Fatalf("system message content = %v, want plain string", sent[0])
+	}
+	parts, ok := sent[1].(map[string]interface{})["content"].([]interface{})
+	if !ok || len(parts) != 2 {
+		t.Fatalf("user message content = %v, want 2 parts", sent[1])
+	}
+	image := parts[1].(map[string]interface{})
+	if image["type"] != "image_url" {
+		t.Fatalf("part type = %v, want image_url", image["type"])
+	}
+	url := image["image_url"].(map[string]interface{})["url"]
+	if url != "data:image/png;base64,aGVsbG8=" {
+		t.Fatalf("image url = %v", url)
+	}
+}
@@ -0,0 +1,153 @@
+package openai_compat
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/providers/protocoltypes"
+)
+
+// ChatStream sends a streaming chat completion request and calls onDelta for
+// every content fragment. Tool call fragments are merged by index and the
+// assembled response is returned once the server sends [DONE].
+func (p *Provider) ChatStream(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}, onDelta func(string)) (*LLMResponse, error) {
+	if p.apiBase == "" {
+		return nil, fmt.Errorf("API base not configured")
+	}
+
+	requestBody := p.buildRequestBody(messages, tools, model, options)
+	requestBody["stream"] = true
+	requestBody["stream_options"] = map[string]interface{}{"include_usage": true}
+
+	resp, err := p.post(ctx, requestBody)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, protocoltypes.WrapContextLength(
+			fmt.Errorf("API request failed:\n  Status: %d\n  Body:   %s", resp.StatusCode, string(body)),
+			string(body))
+	}
+
+	return parseStream(resp.Body, onDelta)
+}
+
+type streamToolCall struct {
+	id        string
+	name      string
+	arguments strings.Builder
+}
+
+func parseStream(r io.Reader, onDelta func(string)) (*LLMResponse, error) {
+	var content strings.Builder
+	var finishReason string
+	var usage *UsageInfo
+	calls := make(map[int]*streamToolCall)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content   string `json:"content"`
+					ToolCalls []struct {
+						Index    int    `json:"index"`
+						ID       string `json:"id"`
+						Function *struct {
+							Name      string `json:"name"`
+							Arguments string `json:"arguments"`
+						} `json:"function"`
+					} `json:"tool_calls"`
+				} `json:"delta"`
+				FinishReason *string `json:"finish_reason"`
+			} `json:"choices"`
+			Usage *apiUsage `json:"usage"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal stream chunk: %w", err)
+		}
+
+		if chunk.Usage != nil {
+			usage = chunk.Usage.info()
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+
+		choice := chunk.Choices[0]
+		if choice.Delta.Content != "" {
+			content.WriteString(choice.Delta.Content)
+			if onDelta != nil {
+				onDelta(choice.Delta.Content)
+			}
+		}
+		for _, tc := range choice.Delta.ToolCalls {
+			call, ok := calls[tc.Index]
+			if !ok {
+				call = &streamToolCall{}
+				calls[tc.Index] = call
+			}
+			if tc.ID != "" {
+				call.id = tc.ID
+			}
+			if tc.Function != nil {
+				if tc.Function.Name != "" {
+					call.name = tc.Function.Name
+				}
+				call.arguments.WriteString(tc.Function.Arguments)
+			}
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			finishReason = *choice.FinishReason
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read stream: %w", err)
+	}
+
+	indices := make([]int, 0, len(calls))
+	for idx := range calls {
+		indices = append(indices, idx)
+	}
+	sort.Ints(indices)
+
+	toolCalls := make([]ToolCall, 0, len(calls))
+	for _, idx := range indices {
+		call := calls[idx]
+		toolCalls = append(toolCalls, ToolCall{
+			ID:        call.id,
+			Name:      call.name,
+			Arguments: decodeArguments(call.name, call.arguments.String()),
+		})
+	}
+
+	if finishReason == "" {
+		finishReason = "stop"
+	}
+
+	return &LLMResponse{
+		Content:      content.String(),
+		ToolCalls:    toolCalls,
+		FinishReason: finishReason,
+		Usage:        usage,
+	}, nil
+}
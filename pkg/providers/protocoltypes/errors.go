@@ -0,0 +1,41 @@
+package protocoltypes
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrContextLength is wrapped by provider errors that reject a request for
+// not fitting the model's context window, so callers can shrink the request
+// and try again.
+var ErrContextLength = errors.New("request exceeds the model's context window")
+
+// contextLengthMarkers are the error codes and messages providers use for an
+// oversized request. They are specific enough not to match other errors that
+// merely mention tokens or lengths.
+var contextLengthMarkers = []string{
+	"context_length_exceeded",
+	"maximum context length",
+	"context window",
+	"prompt is too long",
+	"exceed max message tokens",
+	"exceeds the maximum number of tokens",
+	"reduce the length of the messages",
+}
+
+// WrapContextLength returns err wrapped with ErrContextLength when detail,
+// the provider's error code or message, reports an oversized request, and
+// err unchanged otherwise.
+func WrapContextLength(err error, detail string) error {
+	if err == nil {
+		return nil
+	}
+	detail = strings.ToLower(detail)
+	for _, marker := range contextLengthMarkers {
+		if strings.Contains(detail, marker) {
+			return fmt.Errorf("%w: %w", ErrContextLength, err)
+		}
+	}
+	return err
+}
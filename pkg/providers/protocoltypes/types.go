@@ -1,11 +1,33 @@
 package protocoltypes
 
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
 type ToolCall struct {
 	ID        string                 `json:"id"`
 	Type      string                 `json:"type,omitempty"`
 	Function  *FunctionCall          `json:"function,omitempty"`
 	Name      string                 `json:"name,omitempty"`
 	Arguments map[string]interface{} `json:"arguments,omitempty"`
+	// ThoughtSignature is an opaque token some providers attach to a tool
+	// call and expect back with it on the next request of the turn.
+	ThoughtSignature string `json:"-"`
+}
+
+// NewToolCallID returns an ID for a tool call from a provider that does not
+// assign one. IDs are random rather than counted, as they are stored in
+// session history and must stay unique across restarts.
+func NewToolCallID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("call_%d", time.Now().UnixNano())
+	}
+	return "call_" + hex.EncodeToString(b)
 }
 
 type FunctionCall struct {
@@ -24,6 +46,12 @@ type UsageInfo struct {
 	PromptTokens     int `json:"prompt_tokens"`
 	CompletionTokens int `json:"completion_tokens"`
 	TotalTokens      int `json:"total_tokens"`
+	// CachedTokens is the part of PromptTokens read from the provider's
+	// prompt cache.
+	CachedTokens int `json:"cached_tokens,omitempty"`
+	// CacheWriteTokens is the part of PromptTokens written to the prompt
+	// cache, which some providers bill above the normal input price.
+	CacheWriteTokens int `json:"cache_write_tokens,omitempty"`
 }
 
 type Message struct {
@@ -31,6 +59,42 @@ type Message struct {
 	Content    string     `json:"content"`
 	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
 	ToolCallID string     `json:"tool_call_id,omitempty"`
+	// Parts, when set, replaces Content with multi-part content such as text
+	// plus images. Providers translate it to their own wire format. It is not
+	// serialized, so images are never written into stored sessions.
+	Parts []ContentPart `json:"-"`
+	// Media lists the attachments a user message arrived with, as local
+	// paths or URLs, so the turn can be sent again with them. Providers
+	// ignore it and send Parts.
+	Media []string `json:"media,omitempty"`
+	// CacheBreakpoint marks the end of a request prefix that is expected to
+	// be sent again, for providers that cache prompts at explicit points.
+	CacheBreakpoint bool `json:"-"`
+}
+
+// ContentPart is one piece of a multi-part message.
+type ContentPart struct {
+	Type string `json:"type"` // "text" or "image"
+	Text string `json:"text,omitempty"`
+	// Image parts carry either base64 Data with its MediaType, or a URL.
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+const (
+	ContentPartText  = "text"
+	ContentPartImage = "image"
+)
+
+// HasImages reports whether the message carries at least one image part.
+func (m Message) HasImages() bool {
+	for _, p := range m.Parts {
+		if p.Type == ContentPartImage {
+			return true
+		}
+	}
+	return false
 }
 
 type ToolDefinition struct {
@@ -43,3 +107,53 @@ type ToolFunctionDefinition struct {
 	Description string                 `json:"description"`
 	Parameters  map[string]interface{} `json:"parameters"`
 }
+
+// ResponseFormatOption is the Chat option that carries a *ResponseFormat.
+const ResponseFormatOption = "response_format"
+
+// ResponseFormat asks for a reply that is a single JSON value matching
+// Schema, in place of free text. Providers constrain the output natively
+// where they can.
+type ResponseFormat struct {
+	// Name identifies the schema to the provider, e.g. "heartbeat_result".
+	Name   string                 `json:"name"`
+	Schema map[string]interface{} `json:"schema"`
+}
+
+// ResponseFormatFrom returns the response format set in options, or nil.
+func ResponseFormatFrom(options map[string]interface{}) *ResponseFormat {
+	switch rf := options[ResponseFormatOption].(type) {
+	case *ResponseFormat:
+		if rf != nil && rf.Schema != nil {
+			return rf
+		}
+	case ResponseFormat:
+		if rf.Schema != nil {
+			return &rf
+		}
+	}
+	return nil
+}
+
+// SchemaName returns the name the format is sent under, which providers
+// restrict to letters, digits, '_' and '-'.
+func (rf *ResponseFormat) SchemaName() string {
+	var b []byte
+	for i := 0; i < len(rf.Name) && len(b) < 64; i++ {
+		c := rf.Name[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-' {
+			b = append(b, c)
+		}
+	}
+	if len(b) == 0 {
+		return "response"
+	}
+	return string(b)
+}
+
+// Instruction asks for the format in words, for providers that cannot
+// constrain their output to a schema.
+func (rf *ResponseFormat) Instruction() string {
+	schema, _ := json.Marshal(rf.Schema)
+	return "Reply with only a JSON value matching this JSON schema, without code fences or any other text:\n" + string(schema)
+}
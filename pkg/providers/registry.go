@@ -0,0 +1,217 @@
+package providers
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+// registryProviders lists the providers the registry builds an instance for
+// when they are configured. GitHub Copilot is left out because creating it
+// connects to the Copilot CLI server; it is only used as the default provider.
+var registryProviders = []string{
+	"anthropic", "openai", "openrouter", "groq", "zhipu", "vllm", "gemini",
+	"nvidia", "ollama", "moonshot", "shengsuanyun", "deepseek",
+}
+
+// providerAliases maps alternative provider names to the name the registry
+// stores their instance under.
+var providerAliases = map[string]string{
+	"kimi":        "moonshot",
+	"claude-code": "claude-cli",
+	"claudecode":  "claude-cli",
+	"codex-code":  "codex-cli",
+	"copilot":     "github_copilot",
+}
+
+// Registry holds one LLMProvider per configured provider and per model_list
+// entry, so that fallback candidates naming different vendors are sent to
+// the right one.
+type Registry struct {
+	mu          sync.RWMutex
+	defaultName string
+	instances   map[string]LLMProvider
+	// models maps model_list names to their upstream model. Their instances
+	// are stored under the name as written; provider names are canonical.
+	models map[string]string
+}
+
+// NewRegistry builds an instance for every model_list entry and every
+// configured provider; an entry shadows a provider of the same name. The
+// default provider, created by CreateProvider for the default model, is
+// registered under its own name and serves names that are not configured.
+func NewRegistry(cfg *config.Config, defaultProvider LLMProvider) *Registry {
+	r := &Registry{
+		instances: make(map[string]LLMProvider),
+		models:    make(map[string]string),
+	}
+	if sel, err := resolveProviderSelection(cfg); err == nil {
+		r.defaultName = canonicalProvider(sel.name)
+		if entry := cfg.FindModel(cfg.Agents.Defaults.Model); entry != nil {
+			r.defaultName = entry.Name
+			r.models[entry.Name] = entry.Model
+		}
+	}
+	if defaultProvider != nil {
+		r.instances[r.defaultName] = defaultProvider
+	}
+
+	for _, entry := range cfg.ModelList {
+		if _, ok := r.instances[entry.Name]; ok {
+			continue
+		}
+		sel, err := selectionFromEntry(cfg, entry)
+		var p LLMProvider
+		if err == nil {
+			p, err = createFromSelection(sel)
+		}
+		if err != nil {
+			logger.WarnCF("provider", "Skipping model",
+				map[string]interface{}{"model": entry.Name, "error": err.Error()})
+			continue
+		}
+		r.instances[entry.Name] = p
+		r.models[entry.Name] = entry.Model
+	}
+
+	for _, name := range registryProviders {
+		if _, ok := r.instances[name]; ok {
+			continue
+		}
+		sel := providerSelection{providerType: providerTypeHTTPCompat}
+		if !applyNamedProvider(cfg, name, &sel) && sel.apiBase == "" {
+			continue
+		}
+		p, err := createFromSelection(sel)
+		if err != nil {
+			logger.WarnCF("provider", "Skipping provider",
+				map[string]interface{}{"provider": name, "error": err.Error()})
+			continue
+		}
+		r.instances[name] = p
+	}
+	return r
+}
+
+// Register adds or replaces the instance for a provider name.
+func (r *Registry) Register(name string, p LLMProvider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.instances[r.key(name)] = p
+}
+
+// Resolve returns the name of the provider that serves name: name itself if
+// it has an instance, otherwise the default provider.
+func (r *Registry) Resolve(name string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	name = r.key(name)
+	if _, ok := r.instances[name]; ok {
+		return name
+	}
+	return r.defaultName
+}
+
+// Has reports whether name has an instance of its own.
+func (r *Registry) Has(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.instances[r.key(name)]
+	return ok
+}
+
+// Model returns the candidate for the model_list entry called name.
+func (r *Registry) Model(name string) (FallbackCandidate, bool) {
+	name = strings.TrimSpace(name)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	model, ok := r.models[name]
+	if !ok {
+		return FallbackCandidate{}, false
+	}
+	return FallbackCandidate{Provider: name, Model: model}, true
+}
+
+// ResolveCandidates is ResolveCandidates bound to this registry: model_list
+// names become their entry, other references are parsed and bound to the
+// provider that will serve them.
+func (r *Registry) ResolveCandidates(cfg ModelConfig, defaultProvider string) []FallbackCandidate {
+	seen := make(map[string]bool)
+	var candidates []FallbackCandidate
+	for _, raw := range append([]string{cfg.Primary}, cfg.Fallbacks...) {
+		c, ok := r.Model(raw)
+		if !ok {
+			ref := ParseModelRef(raw, defaultProvider)
+			if ref == nil {
+				continue
+			}
+			c = FallbackCandidate{Provider: ref.Provider, Model: ref.Model}
+		}
+		key := ModelKey(c.Provider, c.Model)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if !ok {
+			c.Provider = r.Resolve(c.Provider)
+		}
+		candidates = append(candidates, c)
+	}
+	return candidates
+}
+
+// Get returns the instance that serves name. It returns nil only if the
+// registry has no default provider.
+func (r *Registry) Get(name string) LLMProvider {
+	name = r.Resolve(name)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.instances[name]
+}
+
+// DefaultName returns the name of the default provider.
+func (r *Registry) DefaultName() string {
+	return r.defaultName
+}
+
+// Names returns the names of the registered providers.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.instances))
+	for name := range r.instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BindCandidates rewrites each candidate's provider to the provider that
+// will serve it, so cooldowns are tracked per real provider.
+func (r *Registry) BindCandidates(candidates []FallbackCandidate) []FallbackCandidate {
+	bound := make([]FallbackCandidate, len(candidates))
+	for i, c := range candidates {
+		bound[i] = FallbackCandidate{Provider: r.Resolve(c.Provider), Model: c.Model}
+	}
+	return bound
+}
+
+// key returns the name name's instance is stored under: model_list names as
+// written, provider names in canonical form. r.mu must be held.
+func (r *Registry) key(name string) string {
+	if _, ok := r.models[name]; ok {
+		return name
+	}
+	return canonicalProvider(name)
+}
+
+func canonicalProvider(name string) string {
+	name = NormalizeProvider(name)
+	if alias, ok := providerAliases[name]; ok {
+		return alias
+	}
+	return name
+}
@@ -0,0 +1,127 @@
+package providers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+type stubProvider struct{}
+
+func (stubProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	return &LLMResponse{}, nil
+}
+
+func (stubProvider) GetDefaultModel() string { return "stub" }
+
+func TestRegistry_BuildsConfiguredProviders(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Agents.Defaults.Provider = "anthropic"
+	cfg.Providers.Anthropic.APIKey = "anthropic-key"
+	cfg.Providers.Groq.APIKey = "groq-key"
+	cfg.Providers.Moonshot.APIKey = "moonshot-key"
+
+	def := &stubProvider{}
+	r := NewRegistry(cfg, def)
+
+	if got := r.DefaultName(); got != "anthropic" {
+		t.Fatalf("DefaultName() = %q, want anthropic", got)
+	}
+	if r.Get("claude") != def {
+		t.Error("anthropic should be served by the default provider")
+	}
+	if _, ok := r.Get("groq").(*HTTPProvider); !ok {
+		t.Errorf("groq should have its own HTTP provider, got %T", r.Get("groq"))
+	}
+	if r.Get("groq") == r.Get("moonshot") {
+		t.Error("groq and moonshot should not share an instance")
+	}
+	if got := r.Resolve("kimi"); got != "moonshot" {
+		t.Errorf("Resolve(kimi) = %q, want moonshot", got)
+	}
+	if got := r.Resolve("openai"); got != "anthropic" {
+		t.Errorf("unconfigured provider should resolve to the default, got %q", got)
+	}
+	if r.Get("openai") != def {
+		t.Error("unconfigured provider should be served by the default provider")
+	}
+}
+
+func TestRegistry_BindCandidates(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Agents.Defaults.Model = "openrouter/auto"
+	cfg.Providers.OpenRouter.APIKey = "sk-or-test"
+	cfg.Providers.Groq.APIKey = "groq-key"
+
+	r := NewRegistry(cfg, &stubProvider{})
+	candidates := ResolveCandidates(ModelConfig{
+		Primary:   "groq/llama-3.3-70b",
+		Fallbacks: []string{"anthropic/claude-sonnet-4", "openrouter/auto"},
+	}, "")
+
+	got := r.BindCandidates(candidates)
+	want := []FallbackCandidate{
+		{Provider: "groq", Model: "llama-3.3-70b"},
+		{Provider: "openrouter", Model: "claude-sonnet-4"},
+		{Provider: "openrouter", Model: "auto"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("BindCandidates() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("candidate %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestRegistry_ModelList(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Agents.Defaults.Model = "smart"
+	cfg.Providers.Groq.APIKey = "groq-key"
+	cfg.ModelList = []config.ModelEntry{
+		{Name: "smart", Protocol: "anthropic", Model: "claude-sonnet-4-5", APIKey: "sk-ant"},
+		{Name: "local", Protocol: "ollama", Model: "qwen2.5:7b"},
+		// A model_list name shadows the provider of the same name.
+		{Name: "groq", Protocol: "gemini", Model: "gemini-2.5-flash", APIKey: "gemini-key"},
+		{Name: "broken", Protocol: "gemini", Model: "gemini-2.5-pro"},
+	}
+
+	def := &stubProvider{}
+	r := NewRegistry(cfg, def)
+
+	if got := r.DefaultName(); got != "smart" {
+		t.Fatalf("DefaultName() = %q, want smart", got)
+	}
+	if r.Get("smart") != def {
+		t.Error("the default model should be served by the default provider")
+	}
+	if _, ok := r.Get("local").(*OllamaProvider); !ok {
+		t.Errorf("local should have an Ollama provider, got %T", r.Get("local"))
+	}
+	if _, ok := r.Get("groq").(*GeminiProvider); !ok {
+		t.Errorf("groq entry should shadow the groq provider, got %T", r.Get("groq"))
+	}
+	if _, ok := r.Model("broken"); ok || r.Has("broken") {
+		t.Error("an entry that cannot be created should not be registered")
+	}
+
+	got := r.ResolveCandidates(ModelConfig{
+		Primary:   "smart",
+		Fallbacks: []string{"local", "openai/gpt-4o", "local"},
+	}, "")
+	want := []FallbackCandidate{
+		{Provider: "smart", Model: "claude-sonnet-4-5"},
+		{Provider: "local", Model: "qwen2.5:7b"},
+		{Provider: "smart", Model: "gpt-4o"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ResolveCandidates() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("candidate %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
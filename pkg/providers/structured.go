@@ -0,0 +1,140 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ParseStructured extracts the JSON value of a reply to a request with the
+// response format rf and checks it against the schema. Code fences and text
+// around a lone JSON object or array are tolerated.
+func ParseStructured(rf *ResponseFormat, content string) (json.RawMessage, error) {
+	text := strings.TrimSpace(content)
+	if strings.HasPrefix(text, "```") {
+		text = strings.TrimPrefix(text, "```")
+		text = strings.TrimPrefix(text, "json")
+		text = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(text), "```"))
+	}
+	if text == "" {
+		return nil, errors.New("reply is empty")
+	}
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		extracted, ok := extractJSON(text)
+		if !ok {
+			return nil, fmt.Errorf("reply is not JSON: %w", err)
+		}
+		if err := json.Unmarshal([]byte(extracted), &value); err != nil {
+			return nil, fmt.Errorf("reply is not JSON: %w", err)
+		}
+		text = extracted
+	}
+	if err := ValidateSchema(rf.Schema, value); err != nil {
+		return nil, err
+	}
+	return json.RawMessage(text), nil
+}
+
+// extractJSON returns the first JSON object or array in text.
+func extractJSON(text string) (string, bool) {
+	start := strings.IndexAny(text, "{[")
+	if start < 0 {
+		return "", false
+	}
+	end := findMatchingBrace(text, start)
+	if end == start {
+		return "", false
+	}
+	return text[start:end], true
+}
+
+// repairPrompt asks for a reply that failed validation again.
+func repairPrompt(err error) string {
+	return fmt.Sprintf("Your reply did not match the required JSON schema: %v. Reply again with only the corrected JSON.", err)
+}
+
+// ChatStructured asks p for a reply in the response format rf and returns
+// it with Content set to the validated JSON value. A reply that does not
+// match is sent back once with the problem for the model to correct. The
+// usage of both calls is added up.
+func ChatStructured(ctx context.Context, p LLMProvider, messages []Message, model string, options map[string]interface{}, rf *ResponseFormat) (*LLMResponse, error) {
+	opts := make(map[string]interface{}, len(options)+1)
+	for k, v := range options {
+		opts[k] = v
+	}
+	opts[ResponseFormatOption] = rf
+
+	resp, err := p.Chat(ctx, messages, nil, model, opts)
+	if err != nil {
+		return nil, err
+	}
+	value, verr := ParseStructured(rf, resp.Content)
+	if verr == nil {
+		resp.Content = string(value)
+		return resp, nil
+	}
+
+	retry := append(messages[:len(messages):len(messages)],
+		Message{Role: "assistant", Content: resp.Content},
+		Message{Role: "user", Content: repairPrompt(verr)},
+	)
+	repaired, err := p.Chat(ctx, retry, nil, model, opts)
+	if err != nil {
+		return nil, err
+	}
+	repaired.Usage = addUsage(resp.Usage, repaired.Usage)
+	value, verr = ParseStructured(rf, repaired.Content)
+	if verr != nil {
+		return repaired, fmt.Errorf("structured reply %s: %w", rf.SchemaName(), verr)
+	}
+	repaired.Content = string(value)
+	return repaired, nil
+}
+
+func addUsage(a, b *UsageInfo) *UsageInfo {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	return &UsageInfo{
+		PromptTokens:     a.PromptTokens + b.PromptTokens,
+		CompletionTokens: a.CompletionTokens + b.CompletionTokens,
+		TotalTokens:      a.TotalTokens + b.TotalTokens,
+		CachedTokens:     a.CachedTokens + b.CachedTokens,
+		CacheWriteTokens: a.CacheWriteTokens + b.CacheWriteTokens,
+	}
+}
+
+// RepairStructured checks the final reply of a tool loop run with the
+// response format rf. It returns the messages to continue the loop with
+// when the reply does not match and may still be repaired, or nil.
+func RepairStructured(rf *ResponseFormat, messages []Message, content string, repaired bool) []Message {
+	if rf == nil || repaired {
+		return nil
+	}
+	if _, err := ParseStructured(rf, content); err != nil {
+		return append(messages,
+			Message{Role: "assistant", Content: content},
+			Message{Role: "user", Content: repairPrompt(err)},
+		)
+	}
+	return nil
+}
+
+// withFormatInstruction adds the response format in options to messages as
+// a system instruction, for providers that cannot constrain their output.
+func withFormatInstruction(messages []Message, options map[string]interface{}) []Message {
+	rf := ResponseFormatFrom(options)
+	if rf == nil {
+		return messages
+	}
+	out := make([]Message, 0, len(messages)+1)
+	out = append(out, messages...)
+	return append(out, Message{Role: "system", Content: rf.Instruction()})
+}
@@ -0,0 +1,190 @@
+package providers
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+var itemsFormat = &ResponseFormat{
+	Name: "items",
+	Schema: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"items": map[string]interface{}{
+				"type":     "array",
+				"minItems": 1,
+				"items":    map[string]interface{}{"$ref": "#/$defs/item"},
+			},
+		},
+		"required":             []string{"items"},
+		"additionalProperties": false,
+		"$defs": map[string]interface{}{
+			"item": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"name":  map[string]interface{}{"type": "string", "minLength": 1},
+					"count": map[string]interface{}{"type": "integer", "minimum": 0},
+					"kind":  map[string]interface{}{"enum": []string{"file", "dir"}},
+				},
+				"required": []string{"name"},
+			},
+		},
+	},
+}
+
+func TestParseStructured(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		wantErr string
+	}{
+		{name: "plain", content: `{"items":[{"name":"a","count":2}]}`, want: `{"items":[{"name":"a","count":2}]}`},
+		{name: "code fence", content: "```json\n{\"items\":[{\"name\":\"a\"}]}\n```", want: `{"items":[{"name":"a"}]}`},
+		{name: "surrounding text", content: `Here you go: {"items":[{"name":"}"}]} done`, want: `{"items":[{"name":"}"}]}`},
+		{name: "empty", content: "  ", wantErr: "empty"},
+		{name: "not json", content: "no idea", wantErr: "not JSON"},
+		{name: "missing property", content: `{}`, wantErr: `missing required property "items"`},
+		{name: "extra property", content: `{"items":[{"name":"a"}],"x":1}`, wantErr: `unexpected property "x"`},
+		{name: "too few items", content: `{"items":[]}`, wantErr: "at least 1 items"},
+		{name: "wrong type", content: `{"items":[{"name":"a","count":1.5}]}`, wantErr: "$.items[0].count: expected integer, got number"},
+		{name: "below minimum", content: `{"items":[{"name":"a","count":-1}]}`, wantErr: "must be at least 0"},
+		{name: "enum", content: `{"items":[{"name":"a","kind":"link"}]}`, wantErr: `"link" is not one of ["file","dir"]`},
+		{name: "short string", content: `{"items":[{"name":""}]}`, wantErr: "at least 1 characters"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseStructured(itemsFormat, tt.content)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseStructured() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseStructured() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("ParseStructured() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateSchema_Combinators(t *testing.T) {
+	schema := map[string]interface{}{
+		"oneOf": []interface{}{
+			map[string]interface{}{"type": "string"},
+			map[string]interface{}{"type": []string{"integer", "null"}},
+		},
+	}
+	for _, value := range []interface{}{"a", float64(1), nil} {
+		if err := ValidateSchema(schema, value); err != nil {
+			t.Errorf("ValidateSchema(%v) error = %v", value, err)
+		}
+	}
+	if err := ValidateSchema(schema, true); err == nil {
+		t.Error("ValidateSchema(true) should fail")
+	}
+
+	anyOf := map[string]interface{}{"anyOf": []interface{}{
+		map[string]interface{}{"type": "number"},
+		map[string]interface{}{"type": "integer"},
+	}}
+	if err := ValidateSchema(anyOf, float64(2)); err != nil {
+		t.Errorf("anyOf matching two schemas: %v", err)
+	}
+	if err := ValidateSchema(map[string]interface{}{"oneOf": anyOf["anyOf"]}, float64(2)); err == nil {
+		t.Error("oneOf matching two schemas should fail")
+	}
+	if err := ValidateSchema(map[string]interface{}{"const": "x"}, "y"); err == nil {
+		t.Error("const mismatch should fail")
+	}
+}
+
+// structuredStub replies with the queued contents in turn.
+type structuredStub struct {
+	replies  []string
+	messages [][]Message
+	options  []map[string]interface{}
+}
+
+func (s *structuredStub) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	s.messages = append(s.messages, messages)
+	s.options = append(s.options, options)
+	content := s.replies[0]
+	s.replies = s.replies[1:]
+	return &LLMResponse{Content: content, Usage: &UsageInfo{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15}}, nil
+}
+
+func (s *structuredStub) GetDefaultModel() string {
+	return "stub"
+}
+
+func TestChatStructured_Repairs(t *testing.T) {
+	stub := &structuredStub{replies: []string{`{"items":[]}`, "```json\n{\"items\":[{\"name\":\"a\"}]}\n```"}}
+	messages := []Message{{Role: "user", Content: "list"}}
+
+	resp, err := ChatStructured(context.Background(), stub, messages, "m", map[string]interface{}{"max_tokens": 100}, itemsFormat)
+	if err != nil {
+		t.Fatalf("ChatStructured() error = %v", err)
+	}
+	if resp.Content != `{"items":[{"name":"a"}]}` {
+		t.Errorf("Content = %q", resp.Content)
+	}
+	if resp.Usage.TotalTokens != 30 {
+		t.Errorf("TotalTokens = %d, want both calls added up", resp.Usage.TotalTokens)
+	}
+	if ResponseFormatFrom(stub.options[0]) != itemsFormat || stub.options[0]["max_tokens"] != 100 {
+		t.Errorf("options = %v", stub.options[0])
+	}
+	retry := stub.messages[1]
+	if len(retry) != 3 || retry[1].Content != `{"items":[]}` || !strings.Contains(retry[2].Content, "at least 1 items") {
+		t.Errorf("repair messages = %+v", retry)
+	}
+	if len(messages) != 1 {
+		t.Errorf("caller's messages were modified: %+v", messages)
+	}
+}
+
+func TestChatStructured_FailsAfterRepair(t *testing.T) {
+	stub := &structuredStub{replies: []string{"no", "still no"}}
+	resp, err := ChatStructured(context.Background(), stub, []Message{{Role: "user", Content: "list"}}, "m", nil, itemsFormat)
+	if err == nil || !strings.Contains(err.Error(), "structured reply items") {
+		t.Fatalf("ChatStructured() error = %v", err)
+	}
+	if resp == nil || resp.Content != "still no" {
+		t.Errorf("resp = %+v, want the repaired reply", resp)
+	}
+}
+
+func TestRepairStructured(t *testing.T) {
+	messages := []Message{{Role: "user", Content: "list"}}
+	if next := RepairStructured(itemsFormat, messages, `{"items":[{"name":"a"}]}`, false); next != nil {
+		t.Errorf("valid reply: got %+v, want nil", next)
+	}
+	if next := RepairStructured(itemsFormat, messages, "no", true); next != nil {
+		t.Errorf("already repaired: got %+v, want nil", next)
+	}
+	if next := RepairStructured(nil, messages, "no", false); next != nil {
+		t.Errorf("no format: got %+v, want nil", next)
+	}
+	next := RepairStructured(itemsFormat, messages, "no", false)
+	if len(next) != 3 || next[1].Role != "assistant" || next[2].Role != "user" {
+		t.Errorf("repair messages = %+v", next)
+	}
+}
+
+func TestResponseFormat_SchemaName(t *testing.T) {
+	for name, want := range map[string]string{
+		"heartbeat_result": "heartbeat_result",
+		"my result!":       "myresult",
+		"":                 "response",
+	} {
+		rf := &ResponseFormat{Name: name}
+		if got := rf.SchemaName(); got != want {
+			t.Errorf("SchemaName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -70,3 +70,35 @@ func stripToolCallsFromText(text string) string {
 
 	return strings.TrimSpace(text[:start] + text[end:])
 }
+
+// findMatchingBrace returns the index after the bracket closing the one at
+// pos, or pos if it is not closed. Brackets inside JSON strings, such as
+// those in encoded tool arguments, are skipped.
+func findMatchingBrace(text string, pos int) int {
+	depth := 0
+	inString, escaped := false, false
+	for i := pos; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case inString:
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return pos
+}
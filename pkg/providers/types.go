@@ -12,14 +12,54 @@ type FunctionCall = protocoltypes.FunctionCall
 type LLMResponse = protocoltypes.LLMResponse
 type UsageInfo = protocoltypes.UsageInfo
 type Message = protocoltypes.Message
+type ContentPart = protocoltypes.ContentPart
 type ToolDefinition = protocoltypes.ToolDefinition
 type ToolFunctionDefinition = protocoltypes.ToolFunctionDefinition
+type ResponseFormat = protocoltypes.ResponseFormat
+
+// ResponseFormatOption is the Chat option that carries a *ResponseFormat.
+const ResponseFormatOption = protocoltypes.ResponseFormatOption
+
+// ResponseFormatFrom returns the response format set in options, or nil.
+func ResponseFormatFrom(options map[string]interface{}) *ResponseFormat {
+	return protocoltypes.ResponseFormatFrom(options)
+}
+
+// ErrContextLength is wrapped by provider errors that reject a request for
+// not fitting the model's context window.
+var ErrContextLength = protocoltypes.ErrContextLength
+
+const (
+	ContentPartText  = protocoltypes.ContentPartText
+	ContentPartImage = protocoltypes.ContentPartImage
+)
 
 type LLMProvider interface {
 	Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error)
 	GetDefaultModel() string
 }
 
+// StreamingProvider is implemented by providers that can deliver the reply
+// text incrementally. onDelta receives each new fragment in order; the
+// returned response is the same one Chat would have produced.
+type StreamingProvider interface {
+	LLMProvider
+	ChatStream(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}, onDelta func(delta string)) (*LLMResponse, error)
+}
+
+// ModelLister is implemented by providers that can list the models they
+// serve.
+type ModelLister interface {
+	ListModels(ctx context.Context) ([]string, error)
+}
+
+// ModelPuller is implemented by providers that can download models on
+// demand. EnsureModels downloads the models that are missing and returns
+// them.
+type ModelPuller interface {
+	EnsureModels(ctx context.Context, models []string) ([]string, error)
+}
+
 // FailoverReason classifies why an LLM request failed for fallback decisions.
 type FailoverReason string
 
@@ -0,0 +1,412 @@
+// Package retention periodically removes old sessions, trims long ones and
+// deletes downloaded media no session needs anymore, so a small disk does
+// not fill up over time.
+package retention
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/session"
+)
+
+const (
+	minIntervalMinutes     = 5
+	defaultIntervalMinutes = 60
+)
+
+// Target is the sessions of one agent.
+type Target struct {
+	AgentID  string
+	Sessions *session.SessionManager
+	// Busy reports whether a session is in use, by a run or background
+	// work; busy sessions are left alone. It may be nil.
+	Busy func(key string) bool
+	// Summarize folds a session's older messages into its summary before
+	// MaxMessages trims them, and reports whether it shortened the history.
+	// It may be nil, in which case the trimmed messages are lost.
+	Summarize func(key string) bool
+}
+
+func (t Target) busy(key string) bool {
+	return t.Busy != nil && t.Busy(key)
+}
+
+// Report is what one cleanup run did.
+type Report struct {
+	Archived     int
+	Deleted      int
+	Trimmed      int
+	MediaRemoved int
+	BytesFreed   int64
+	// Usage is the disk space sessions and media take after the run.
+	Usage int64
+}
+
+func (r Report) changed() bool {
+	return r.Archived+r.Deleted+r.Trimmed+r.MediaRemoved > 0
+}
+
+// Service applies the retention config to the sessions of its targets and
+// to the media directory.
+type Service struct {
+	cfg      config.RetentionConfig
+	targets  []Target
+	mediaDir string
+	interval time.Duration
+	now      func() time.Time
+	mu       sync.Mutex
+	running  sync.Mutex // held for the duration of a run
+	stopChan chan struct{}
+}
+
+// NewService creates a retention service. mediaDir may be empty to leave
+// media alone.
+func NewService(cfg config.RetentionConfig, targets []Target, mediaDir string) *Service {
+	intervalMinutes := cfg.IntervalMinutes
+	if intervalMinutes == 0 {
+		intervalMinutes = defaultIntervalMinutes
+	}
+	if intervalMinutes < minIntervalMinutes {
+		intervalMinutes = minIntervalMinutes
+	}
+	return &Service{
+		cfg:      cfg,
+		targets:  targets,
+		mediaDir: mediaDir,
+		interval: time.Duration(intervalMinutes) * time.Minute,
+		now:      time.Now,
+	}
+}
+
+// Start begins periodic cleanup.
+func (s *Service) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopChan != nil {
+		return nil
+	}
+	if !s.cfg.Enabled {
+		logger.InfoC("retention", "Retention service disabled")
+		return nil
+	}
+
+	s.stopChan = make(chan struct{})
+	go s.runLoop(s.stopChan)
+
+	logger.InfoCF("retention", "Retention service started", map[string]interface{}{
+		"interval_minutes": s.interval.Minutes(),
+	})
+	return nil
+}
+
+// Stop stops periodic cleanup.
+func (s *Service) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopChan == nil {
+		return
+	}
+	close(s.stopChan)
+	s.stopChan = nil
+}
+
+func (s *Service) runLoop(stopChan chan struct{}) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	// The first run waits a little so it does not compete with startup.
+	initial := time.NewTimer(time.Minute)
+	defer initial.Stop()
+
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-initial.C:
+			s.RunOnce()
+		case <-ticker.C:
+			s.RunOnce()
+		}
+	}
+}
+
+// RunOnce applies the retention config once and logs what it did.
+func (s *Service) RunOnce() Report {
+	s.running.Lock()
+	defer s.running.Unlock()
+
+	var report Report
+	for _, target := range s.targets {
+		s.applyPolicies(target, &report)
+	}
+	s.collectMedia(s.referencedMedia(true), &report)
+	s.enforceQuota(&report)
+
+	fields := map[string]interface{}{
+		"archived":      report.Archived,
+		"deleted":       report.Deleted,
+		"trimmed":       report.Trimmed,
+		"media_removed": report.MediaRemoved,
+		"bytes_freed":   report.BytesFreed,
+		"usage_bytes":   report.Usage,
+	}
+	if report.changed() {
+		logger.InfoCF("retention", "Cleanup finished", fields)
+	} else {
+		logger.DebugCF("retention", "Cleanup finished", fields)
+	}
+	return report
+}
+
+// policyFor returns the policy a session was last used under, or the
+// default policy.
+func (s *Service) policyFor(info session.Info) config.RetentionPolicy {
+	if info.Retention != nil {
+		return *info.Retention
+	}
+	return s.cfg.Policy
+}
+
+// applyPolicies archives or deletes idle sessions, deletes expired archives
+// and trims long histories.
+func (s *Service) applyPolicies(target Target, report *Report) {
+	infos, err := target.Sessions.List("")
+	if err != nil {
+		logger.WarnCF("retention", "Failed to list sessions", map[string]interface{}{
+			"agent_id": target.AgentID,
+			"error":    err.Error(),
+		})
+		return
+	}
+
+	now := s.now()
+	for _, info := range infos {
+		if target.busy(info.Key) {
+			continue
+		}
+		policy := s.policyFor(info)
+		idle := now.Sub(info.Updated)
+
+		if session.ArchiveID(info.Key) != "" {
+			if policy.ArchiveTTLHours > 0 && idle > hours(policy.ArchiveTTLHours) {
+				s.deleteSession(target, info.Key, report)
+			}
+			continue
+		}
+
+		if policy.IdleTTLHours > 0 && idle > hours(policy.IdleTTLHours) {
+			empty := info.Messages == 0 && info.Summary == ""
+			if empty || policy.IdleAction == "delete" {
+				s.deleteSession(target, info.Key, report)
+				continue
+			}
+			archiveKey, err := target.Sessions.Archive(info.Key)
+			if err != nil {
+				s.warn(target, info.Key, "Failed to archive idle session", err)
+				continue
+			}
+			if archiveKey != "" {
+				report.Archived++
+			}
+			continue
+		}
+
+		if policy.MaxMessages > 0 && info.Messages > policy.MaxMessages {
+			before := target.Sessions.Size(info.Key)
+			summarized := target.Summarize != nil && target.Summarize(info.Key)
+			if target.Sessions.TrimHistory(info.Key, policy.MaxMessages) == 0 && !summarized {
+				continue
+			}
+			if err := target.Sessions.Save(info.Key); err != nil {
+				s.warn(target, info.Key, "Failed to save trimmed session", err)
+				continue
+			}
+			report.Trimmed++
+			report.BytesFreed += before - target.Sessions.Size(info.Key)
+		}
+	}
+}
+
+// deleteSession deletes a session and reports whether it did.
+func (s *Service) deleteSession(target Target, key string, report *Report) bool {
+	size := target.Sessions.Size(key)
+	if err := target.Sessions.Delete(key); err != nil {
+		s.warn(target, key, "Failed to delete session", err)
+		return false
+	}
+	report.Deleted++
+	report.BytesFreed += size
+	return true
+}
+
+func (s *Service) warn(target Target, key, message string, err error) {
+	logger.WarnCF("retention", message, map[string]interface{}{
+		"agent_id":    target.AgentID,
+		"session_key": key,
+		"error":       err.Error(),
+	})
+}
+
+// referencedMedia returns the media paths some session still refers to,
+// counting archived sessions only if archives is set.
+func (s *Service) referencedMedia(archives bool) map[string]bool {
+	referenced := make(map[string]bool)
+	for _, target := range s.targets {
+		infos, err := target.Sessions.List("")
+		if err != nil {
+			continue
+		}
+		for _, info := range infos {
+			if !archives && session.ArchiveID(info.Key) != "" {
+				continue
+			}
+			for _, path := range info.Media {
+				referenced[filepath.Clean(path)] = true
+			}
+		}
+	}
+	return referenced
+}
+
+// mediaFile is a file in the media directory.
+type mediaFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func (s *Service) mediaFiles() []mediaFile {
+	if s.mediaDir == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(s.mediaDir)
+	if err != nil {
+		return nil
+	}
+	files := make([]mediaFile, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, mediaFile{
+			path:    filepath.Join(s.mediaDir, entry.Name()),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+	}
+	return files
+}
+
+// collectMedia deletes downloaded media that no session refers to once it
+// is older than the media TTL. The TTL also covers attachments downloaded
+// for a message that has not reached its session yet.
+func (s *Service) collectMedia(referenced map[string]bool, report *Report) {
+	if s.cfg.MediaTTLHours <= 0 {
+		return
+	}
+	cutoff := s.now().Add(-hours(s.cfg.MediaTTLHours))
+	for _, f := range s.mediaFiles() {
+		if referenced[f.path] || !f.modTime.Before(cutoff) {
+			continue
+		}
+		s.removeMedia(f, report)
+	}
+}
+
+func (s *Service) removeMedia(f mediaFile, report *Report) bool {
+	if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+		logger.WarnCF("retention", "Failed to remove media file", map[string]interface{}{
+			"path":  f.path,
+			"error": err.Error(),
+		})
+		return false
+	}
+	report.MediaRemoved++
+	report.BytesFreed += f.size
+	return true
+}
+
+// quotaCandidate is something that may be removed to get under the disk
+// quota: an archived session or a media file.
+type quotaCandidate struct {
+	target *Target
+	key    string
+	media  *mediaFile
+	size   int64
+	age    time.Time
+}
+
+// enforceQuota removes archived sessions and media, oldest first, until
+// sessions and media together fit in the disk quota. Current sessions and
+// the media they refer to are never removed for the quota.
+func (s *Service) enforceQuota(report *Report) {
+	live := s.referencedMedia(false)
+	var usage int64
+	var candidates []quotaCandidate
+	for i := range s.targets {
+		target := &s.targets[i]
+		infos, err := target.Sessions.List("")
+		if err != nil {
+			continue
+		}
+		for _, info := range infos {
+			size := target.Sessions.Size(info.Key)
+			usage += size
+			if session.ArchiveID(info.Key) != "" {
+				candidates = append(candidates, quotaCandidate{target: target, key: info.Key, size: size, age: info.Updated})
+			}
+		}
+	}
+	for _, f := range s.mediaFiles() {
+		f := f
+		usage += f.size
+		if live[f.path] {
+			continue
+		}
+		candidates = append(candidates, quotaCandidate{media: &f, size: f.size, age: f.modTime})
+	}
+
+	quota := int64(s.cfg.DiskQuotaMB) * 1024 * 1024
+	if quota > 0 && usage > quota {
+		sort.Slice(candidates, func(i, j int) bool {
+			return candidates[i].age.Before(candidates[j].age)
+		})
+		for _, c := range candidates {
+			if usage <= quota {
+				break
+			}
+			removed := false
+			if c.media != nil {
+				removed = s.removeMedia(*c.media, report)
+			} else {
+				removed = s.deleteSession(*c.target, c.key, report)
+			}
+			if removed {
+				usage -= c.size
+			}
+		}
+		if usage > quota {
+			logger.WarnCF("retention", "Sessions and media are still over the disk quota", map[string]interface{}{
+				"usage_bytes": usage,
+				"quota_bytes": quota,
+			})
+		}
+	}
+	report.Usage = usage
+}
+
+func hours(n int) time.Duration {
+	return time.Duration(n) * time.Hour
+}
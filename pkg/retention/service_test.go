@@ -0,0 +1,206 @@
+package retention
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/session"
+)
+
+func writeMedia(t *testing.T, dir, name string, size int, modTime time.Time) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestService_RunOnceAppliesPolicies(t *testing.T) {
+	sm := session.NewSessionManagerWithStore(session.NewJSONLStore(t.TempDir()), 0)
+	mediaDir := t.TempDir()
+	now := time.Now()
+
+	// Default policy: archived after a day idle.
+	sm.AddMessage("idle", "user", "hello")
+	sm.Save("idle")
+
+	// A channel policy that deletes after a day idle.
+	sm.AddMessage("deleted", "user", "hello")
+	sm.SetRetention("deleted", &config.RetentionPolicy{IdleTTLHours: 24, IdleAction: "delete"})
+	sm.Save("deleted")
+
+	// Recent enough to stay, but too long.
+	sm.SetRetention("long", &config.RetentionPolicy{IdleTTLHours: 72, MaxMessages: 4})
+	for i := 0; i < 3; i++ {
+		sm.AddMessage("long", "user", "question")
+		sm.AddFullMessage("long", providers.Message{Role: "assistant", ToolCalls: []providers.ToolCall{{ID: "call"}}})
+		sm.AddFullMessage("long", providers.Message{Role: "tool", ToolCallID: "call", Content: "result"})
+		sm.AddMessage("long", "assistant", "answer")
+	}
+	kept := writeMedia(t, mediaDir, "kept.jpg", 10, now.Add(-72*time.Hour))
+	sm.AddMedia("long", []string{kept})
+	sm.Save("long")
+
+	orphan := writeMedia(t, mediaDir, "orphan.jpg", 10, now.Add(-72*time.Hour))
+	fresh := writeMedia(t, mediaDir, "fresh.jpg", 10, now.Add(47*time.Hour))
+
+	svc := NewService(config.RetentionConfig{
+		Enabled:       true,
+		Policy:        config.RetentionPolicy{IdleTTLHours: 24, IdleAction: "archive"},
+		MediaTTLHours: 24,
+	}, []Target{{AgentID: "main", Sessions: sm}}, mediaDir)
+	svc.now = func() time.Time { return now.Add(48 * time.Hour) }
+
+	report := svc.RunOnce()
+	if report.Archived != 1 || report.Deleted != 1 || report.Trimmed != 1 || report.MediaRemoved != 1 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	if archives, _ := sm.Archives("idle"); len(archives) != 1 {
+		t.Fatalf("expected the idle session to be archived, got %+v", archives)
+	}
+	if sm.Get("deleted") != nil {
+		t.Fatal("expected the session under the delete policy to be gone")
+	}
+	history := sm.GetHistory("long")
+	if len(history) != 4 || history[0].Role != "user" {
+		t.Fatalf("expected the last exchange to be kept whole, got %+v", history)
+	}
+
+	for path, want := range map[string]bool{kept: true, orphan: false, fresh: true} {
+		if _, err := os.Stat(path); (err == nil) != want {
+			t.Errorf("%s: expected exists=%v, got err %v", filepath.Base(path), want, err)
+		}
+	}
+}
+
+func TestService_ArchiveTTL(t *testing.T) {
+	sm := session.NewSessionManagerWithStore(session.NewJSONLStore(t.TempDir()), 0)
+	sm.AddMessage("chat", "user", "hello")
+	archiveKey, err := sm.Archive("chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := NewService(config.RetentionConfig{
+		Policy: config.RetentionPolicy{ArchiveTTLHours: 24},
+	}, []Target{{Sessions: sm}}, "")
+	if report := svc.RunOnce(); report.Deleted != 0 {
+		t.Fatalf("expected a new archive to be kept, got %+v", report)
+	}
+	svc.now = func() time.Time { return time.Now().Add(25 * time.Hour) }
+	if report := svc.RunOnce(); report.Deleted != 1 || sm.Get(archiveKey) != nil {
+		t.Fatalf("expected the expired archive to be deleted, got %+v", report)
+	}
+}
+
+func TestService_DiskQuota(t *testing.T) {
+	sm := session.NewSessionManagerWithStore(session.NewJSONLStore(t.TempDir()), 0)
+	sm.AddMessage("chat", "user", strings.Repeat("x", 1000))
+	sm.Save("chat")
+
+	mediaDir := t.TempDir()
+	now := time.Now()
+	older := writeMedia(t, mediaDir, "older.bin", 800*1024, now.Add(-2*time.Hour))
+	newer := writeMedia(t, mediaDir, "newer.bin", 500*1024, now.Add(-time.Hour))
+
+	svc := NewService(config.RetentionConfig{DiskQuotaMB: 1}, []Target{{Sessions: sm}}, mediaDir)
+	report := svc.RunOnce()
+
+	if _, err := os.Stat(older); !os.IsNotExist(err) {
+		t.Fatal("expected the oldest media to go first")
+	}
+	if _, err := os.Stat(newer); err != nil {
+		t.Fatal("expected removal to stop once under quota")
+	}
+	if sm.Get("chat") == nil {
+		t.Fatal("current sessions must not be removed for the quota")
+	}
+	if report.MediaRemoved != 1 || report.Usage > 1024*1024 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+}
+
+func TestService_LeavesBusySessionsAlone(t *testing.T) {
+	sm := session.NewSessionManagerWithStore(session.NewJSONLStore(t.TempDir()), 0)
+	sm.AddMessage("busy", "user", "hello")
+	sm.Save("busy")
+
+	svc := NewService(config.RetentionConfig{
+		Policy: config.RetentionPolicy{IdleTTLHours: 1, IdleAction: "delete"},
+	}, []Target{{
+		Sessions: sm,
+		Busy:     func(key string) bool { return key == "busy" },
+	}}, "")
+	svc.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+
+	if report := svc.RunOnce(); report.Deleted != 0 || sm.Get("busy") == nil {
+		t.Fatalf("expected the busy session to be kept, got %+v", report)
+	}
+}
+
+func TestService_SummarizesBeforeTrimming(t *testing.T) {
+	sm := session.NewSessionManagerWithStore(session.NewJSONLStore(t.TempDir()), 0)
+	for i := 0; i < 4; i++ {
+		sm.AddMessage("long", "user", "question")
+		sm.AddMessage("long", "assistant", "answer")
+	}
+	sm.Save("long")
+
+	var summarized []string
+	svc := NewService(config.RetentionConfig{
+		Policy: config.RetentionPolicy{MaxMessages: 4},
+	}, []Target{{
+		Sessions: sm,
+		Summarize: func(key string) bool {
+			summarized = append(summarized, key)
+			sm.SetSummary(key, "asked and answered")
+			sm.TruncateHistory(key, 2)
+			return true
+		},
+	}}, "")
+
+	report := svc.RunOnce()
+	if len(summarized) != 1 || summarized[0] != "long" {
+		t.Fatalf("expected the long session to be summarized, got %v", summarized)
+	}
+	if report.Trimmed != 1 || len(sm.GetHistory("long")) != 2 || sm.GetSummary("long") == "" {
+		t.Fatalf("expected the summary to replace the older messages, got %+v", report)
+	}
+}
+
+func TestService_DiskQuotaKeepsLiveMedia(t *testing.T) {
+	sm := session.NewSessionManagerWithStore(session.NewJSONLStore(t.TempDir()), 0)
+	mediaDir := t.TempDir()
+	now := time.Now()
+	live := writeMedia(t, mediaDir, "live.bin", 800*1024, now.Add(-3*time.Hour))
+	archived := writeMedia(t, mediaDir, "archived.bin", 800*1024, now.Add(-2*time.Hour))
+
+	sm.AddMessage("old", "user", "photo")
+	sm.AddMedia("old", []string{archived})
+	if _, err := sm.Archive("old"); err != nil {
+		t.Fatal(err)
+	}
+	sm.AddMessage("chat", "user", "photo")
+	sm.AddMedia("chat", []string{live})
+	sm.Save("chat")
+
+	svc := NewService(config.RetentionConfig{DiskQuotaMB: 1}, []Target{{Sessions: sm}}, mediaDir)
+	svc.RunOnce()
+
+	if _, err := os.Stat(live); err != nil {
+		t.Fatal("media of a current session must not be removed for the quota")
+	}
+	if _, err := os.Stat(archived); !os.IsNotExist(err) {
+		t.Fatal("expected media only archives refer to to be removed")
+	}
+}
@@ -0,0 +1,126 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// KeyEnv is the environment variable that may hold the key or passphrase.
+const KeyEnv = "PICOCLAW_ENCRYPTION_KEY"
+
+// Argon2id parameters for passphrases, sized for small boards.
+const (
+	argonTime    = 3
+	argonMemory  = 32 * 1024 // KiB
+	argonThreads = 2
+	saltSize     = 16
+)
+
+// Source says where to look for the key. The key or passphrase is taken
+// from KeyEnv, then KeyFile, then Prompt.
+type Source struct {
+	KeyFile string
+	// SaltFile keeps the salt passphrases are stretched with. It is created
+	// on first use.
+	SaltFile string
+	// Prompt asks for a passphrase; nil means there is no one to ask.
+	Prompt func(prompt string) ([]byte, error)
+}
+
+// ErrNoKeySource is returned by LoadKey when no key is configured.
+var ErrNoKeySource = errors.New("encryption is enabled but no key is available: set " + KeyEnv + ", encryption.key_file, or run interactively to enter a passphrase")
+
+// LoadKey returns the key described by src and where it came from.
+func LoadKey(src Source) (*Key, string, error) {
+	if material := strings.TrimSpace(os.Getenv(KeyEnv)); material != "" {
+		key, err := KeyFromMaterial([]byte(material), src.SaltFile)
+		return key, KeyEnv, err
+	}
+	if src.KeyFile != "" {
+		data, err := os.ReadFile(src.KeyFile)
+		if err == nil {
+			key, err := KeyFromMaterial(data, src.SaltFile)
+			return key, src.KeyFile, err
+		}
+		if !os.IsNotExist(err) {
+			return nil, "", fmt.Errorf("reading key file: %w", err)
+		}
+	}
+	if src.Prompt != nil {
+		passphrase, err := src.Prompt("Encryption passphrase: ")
+		if err != nil {
+			return nil, "", err
+		}
+		key, err := KeyFromPassphrase(passphrase, src.SaltFile)
+		return key, "passphrase", err
+	}
+	return nil, "", ErrNoKeySource
+}
+
+// KeyFromMaterial reads a key written by EncodeKey, or treats material as a
+// passphrase if it is not one.
+func KeyFromMaterial(material []byte, saltFile string) (*Key, error) {
+	text := strings.TrimSpace(string(material))
+	if raw, err := hex.DecodeString(text); err == nil && len(raw) == KeySize {
+		return NewKey(raw)
+	}
+	if raw, err := base64.StdEncoding.DecodeString(text); err == nil && len(raw) == KeySize {
+		return NewKey(raw)
+	}
+	return KeyFromPassphrase([]byte(text), saltFile)
+}
+
+// KeyFromPassphrase stretches passphrase into a key with Argon2id.
+func KeyFromPassphrase(passphrase []byte, saltFile string) (*Key, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	if saltFile == "" {
+		return nil, errors.New("no salt file configured for the passphrase")
+	}
+	salt, err := loadSalt(saltFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewKey(argon2.IDKey(passphrase, salt, argonTime, argonMemory, argonThreads, KeySize))
+}
+
+// loadSalt reads the salt in path, creating it if there is none yet.
+func loadSalt(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		salt, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(salt) < saltSize {
+			return nil, fmt.Errorf("invalid salt file %s", path)
+		}
+		return salt, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	salt, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	salt = salt[:saltSize]
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(salt)+"\n"), 0600); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+// EncodeKey formats a raw key for a key file or KeyEnv.
+func EncodeKey(raw []byte) string {
+	return hex.EncodeToString(raw)
+}
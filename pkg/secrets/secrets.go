@@ -0,0 +1,268 @@
+// Package secrets encrypts data picoclaw keeps on disk: the auth store,
+// session files, the cron store and agent memory (MEMORY.md, daily notes,
+// the memory index and the handoff table). Data is sealed with XChaCha20-Poly1305
+// into text envelopes, one per file or, for append-only logs, one per line.
+// Data that is not in an envelope is read as plaintext, so files written
+// before encryption was enabled keep working and are encrypted when they are
+// next written.
+package secrets
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// KeySize is the size of an encryption key in bytes.
+const KeySize = chacha20poly1305.KeySize
+
+// envelopePrefix starts every sealed envelope, followed by the key ID, a
+// colon and the base64 nonce and ciphertext.
+const envelopePrefix = "picoclaw:enc:v1:"
+
+var (
+	// ErrNoKey is returned when reading encrypted data without a key.
+	ErrNoKey = errors.New("data is encrypted but no encryption key is configured")
+	// ErrUnknownKey is returned for data sealed with a key that is not
+	// loaded.
+	ErrUnknownKey = errors.New("data is encrypted with a different key")
+)
+
+// Key is an encryption key.
+type Key struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// NewKey returns a key for raw, which must be KeySize bytes.
+func NewKey(raw []byte) (*Key, error) {
+	if len(raw) != KeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", KeySize, len(raw))
+	}
+	aead, err := chacha20poly1305.NewX(raw)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(raw)
+	return &Key{id: hex.EncodeToString(sum[:4]), aead: aead}, nil
+}
+
+// GenerateKey returns KeySize random bytes.
+func GenerateKey() ([]byte, error) {
+	raw := make([]byte, KeySize)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// ID identifies the key in envelopes without revealing it.
+func (k *Key) ID() string {
+	return k.id
+}
+
+// Seal encrypts plaintext into an envelope.
+func (k *Key) Seal(plaintext []byte) []byte {
+	nonce := make([]byte, k.aead.NonceSize(), k.aead.NonceSize()+len(plaintext)+k.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		panic("secrets: reading random nonce: " + err.Error())
+	}
+	sealed := k.aead.Seal(nonce, nonce, plaintext, nil)
+
+	out := make([]byte, 0, len(envelopePrefix)+len(k.id)+1+base64.RawStdEncoding.EncodedLen(len(sealed)))
+	out = append(out, envelopePrefix...)
+	out = append(out, k.id...)
+	out = append(out, ':')
+	return base64.RawStdEncoding.AppendEncode(out, sealed)
+}
+
+// open decrypts the payload of an envelope sealed with k.
+func (k *Key) open(payload []byte) ([]byte, error) {
+	sealed, err := base64.RawStdEncoding.DecodeString(string(payload))
+	if err != nil {
+		return nil, fmt.Errorf("malformed encrypted data: %w", err)
+	}
+	if len(sealed) < k.aead.NonceSize() {
+		return nil, errors.New("malformed encrypted data: too short")
+	}
+	nonce, ciphertext := sealed[:k.aead.NonceSize()], sealed[k.aead.NonceSize():]
+	plaintext, err := k.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("encrypted data failed authentication")
+	}
+	return plaintext, nil
+}
+
+var (
+	mu sync.RWMutex
+	// current seals new data; nil leaves it in plaintext.
+	current *Key
+	// keys opens data, by key ID.
+	keys = map[string]*Key{}
+)
+
+// SetKeys makes key the key new data is sealed with, or turns encryption
+// off if key is nil. Data sealed with key or any of previous can be read.
+func SetKeys(key *Key, previous ...*Key) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = key
+	keys = map[string]*Key{}
+	for _, k := range append(previous, key) {
+		if k != nil {
+			keys[k.id] = k
+		}
+	}
+}
+
+// CurrentKey returns the key new data is sealed with, or nil.
+func CurrentKey() *Key {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// Enabled reports whether new data is encrypted.
+func Enabled() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current != nil
+}
+
+// IsSealed reports whether data is an envelope.
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte(envelopePrefix))
+}
+
+// IsCurrent reports whether data is stored the way it would be written now:
+// sealed with the current key, or plaintext if encryption is off.
+func IsCurrent(data []byte) bool {
+	mu.RLock()
+	key := current
+	mu.RUnlock()
+	if key == nil {
+		return !IsSealed(data)
+	}
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte(envelopePrefix+key.id+":"))
+}
+
+// Seal encrypts data with the current key, or returns it as is when
+// encryption is off.
+func Seal(data []byte) []byte {
+	mu.RLock()
+	key := current
+	mu.RUnlock()
+	if key == nil {
+		return data
+	}
+	return key.Seal(data)
+}
+
+// Open returns the plaintext of an envelope, or data itself if it is not
+// sealed.
+func Open(data []byte) ([]byte, error) {
+	trimmed := bytes.TrimSpace(data)
+	if !bytes.HasPrefix(trimmed, []byte(envelopePrefix)) {
+		return data, nil
+	}
+	rest := trimmed[len(envelopePrefix):]
+	i := bytes.IndexByte(rest, ':')
+	if i < 0 {
+		return nil, errors.New("malformed encrypted data: missing key ID")
+	}
+
+	mu.RLock()
+	key, ok := keys[string(rest[:i])]
+	enabled := len(keys) > 0
+	mu.RUnlock()
+	if !ok {
+		if !enabled {
+			return nil, ErrNoKey
+		}
+		return nil, ErrUnknownKey
+	}
+	return key.open(rest[i+1:])
+}
+
+// ReadFile reads a file written by WriteFile, or a plaintext file.
+func ReadFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := Open(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return plaintext, nil
+}
+
+// WriteFile seals data with the current key and writes it to path through a
+// temporary file, so a crash leaves either the old or the new content.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	if err := CheckOverwrite(path); err != nil {
+		return err
+	}
+	return writeRaw(path, Seal(data), perm)
+}
+
+// CheckOverwrite returns an error if path holds data sealed with a key that
+// is not loaded, so that a write never replaces data that could not be read.
+// Only the first line is checked.
+func CheckOverwrite(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	first, _ := bufio.NewReader(f).ReadBytes('\n')
+	if _, err := Open(first); errors.Is(err, ErrNoKey) || errors.Is(err, ErrUnknownKey) {
+		return fmt.Errorf("refusing to overwrite %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeRaw writes data to path through a temporary file.
+func writeRaw(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testKey(t *testing.T) *Key {
+	t.Helper()
+	raw, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	key, err := NewKey(raw)
+	if err != nil {
+		t.Fatalf("NewKey failed: %v", err)
+	}
+	return key
+}
+
+func useKeys(t *testing.T, key *Key, previous ...*Key) {
+	t.Helper()
+	SetKeys(key, previous...)
+	t.Cleanup(func() { SetKeys(nil) })
+}
+
+func TestSealAndOpen(t *testing.T) {
+	key := testKey(t)
+	useKeys(t, key)
+
+	sealed := Seal([]byte(`{"token":"secret"}`))
+	if !IsSealed(sealed) || bytes.Contains(sealed, []byte("secret")) {
+		t.Fatalf("data not sealed: %s", sealed)
+	}
+	plaintext, err := Open(sealed)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if string(plaintext) != `{"token":"secret"}` {
+		t.Errorf("Open = %s", plaintext)
+	}
+
+	// Plaintext is read as is.
+	plaintext, err = Open([]byte(`{"a":1}`))
+	if err != nil || string(plaintext) != `{"a":1}` {
+		t.Errorf("plaintext not passed through: %s, %v", plaintext, err)
+	}
+
+	SetKeys(nil)
+	if _, err := Open(sealed); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Open without key: got %v, want ErrNoKey", err)
+	}
+	SetKeys(testKey(t))
+	if _, err := Open(sealed); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Open with another key: got %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestOpen_RejectsTamperedData(t *testing.T) {
+	useKeys(t, testKey(t))
+	sealed := Seal([]byte("hello"))
+	sealed[len(sealed)-2] ^= 1
+	if _, err := Open(sealed); err == nil {
+		t.Fatal("expected tampered data to fail")
+	}
+}
+
+func TestReseal_RotatesFilesAndLogs(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "auth.json")
+	logPath := filepath.Join(dir, "chat.jsonl")
+	os.WriteFile(jsonPath, []byte(`{"a":1}`), 0600)
+	os.WriteFile(logPath, []byte("{\"n\":1}\n{\"n\":2}\n{\"n\":"), 0644)
+
+	oldKey := testKey(t)
+	useKeys(t, oldKey)
+	n, err := ResealDir(dir, ".json", ".jsonl")
+	if err != nil || n != 2 {
+		t.Fatalf("ResealDir = %d, %v; want 2 files", n, err)
+	}
+	data, _ := os.ReadFile(logPath)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected the torn line to be dropped, got %d lines", len(lines))
+	}
+	for _, line := range lines {
+		if !IsCurrent([]byte(line)) {
+			t.Fatalf("line not sealed with the key: %s", line)
+		}
+	}
+
+	// Nothing changes until the key does.
+	if n, _ := ResealDir(dir, ".json", ".jsonl"); n != 0 {
+		t.Fatalf("expected no rewrites, got %d", n)
+	}
+
+	newKey := testKey(t)
+	SetKeys(newKey, oldKey)
+	if n, err := ResealDir(dir, ".json", ".jsonl"); err != nil || n != 2 {
+		t.Fatalf("rotation rewrote %d files, %v; want 2", n, err)
+	}
+	SetKeys(newKey)
+	got, err := ReadFile(jsonPath)
+	if err != nil || string(got) != `{"a":1}` {
+		t.Fatalf("ReadFile after rotation = %s, %v", got, err)
+	}
+
+	// Turning encryption off decrypts the files again.
+	SetKeys(nil, newKey)
+	if n, err := ResealDir(dir, ".json", ".jsonl"); err != nil || n != 2 {
+		t.Fatalf("decryption rewrote %d files, %v; want 2", n, err)
+	}
+	data, _ = os.ReadFile(logPath)
+	if string(data) != "{\"n\":1}\n{\"n\":2}\n" {
+		t.Fatalf("unexpected plaintext log: %q", data)
+	}
+}
+
+func TestWriteFile_RefusesUnknownKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.json")
+	useKeys(t, testKey(t))
+	if err := WriteFile(path, []byte("{}"), 0600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	SetKeys(testKey(t))
+	if err := WriteFile(path, []byte("{}"), 0600); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("WriteFile over another key's data: got %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestKeyFromMaterial(t *testing.T) {
+	dir := t.TempDir()
+	saltFile := filepath.Join(dir, "encryption.salt")
+
+	raw, _ := GenerateKey()
+	fromHex, err := KeyFromMaterial([]byte(EncodeKey(raw)+"\n"), saltFile)
+	if err != nil {
+		t.Fatalf("hex key: %v", err)
+	}
+	direct, _ := NewKey(raw)
+	if fromHex.ID() != direct.ID() {
+		t.Errorf("hex key ID %s, want %s", fromHex.ID(), direct.ID())
+	}
+	if _, err := os.Stat(saltFile); !os.IsNotExist(err) {
+		t.Errorf("a raw key should not create a salt file")
+	}
+
+	first, err := KeyFromMaterial([]byte("correct horse"), saltFile)
+	if err != nil {
+		t.Fatalf("passphrase: %v", err)
+	}
+	second, err := KeyFromPassphrase([]byte("correct horse"), saltFile)
+	if err != nil {
+		t.Fatalf("passphrase: %v", err)
+	}
+	if first.ID() != second.ID() {
+		t.Errorf("the same passphrase and salt gave different keys")
+	}
+	other, _ := KeyFromPassphrase([]byte("battery staple"), saltFile)
+	if other.ID() == first.ID() {
+		t.Errorf("different passphrases gave the same key")
+	}
+}
@@ -0,0 +1,282 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// ArchiveSeparator joins a session key and an archive ID into the key the
+// archived session is stored under.
+const ArchiveSeparator = "#"
+
+// ErrNotFound is returned for a session that is neither loaded nor stored.
+var ErrNotFound = errors.New("session not found")
+
+// Info describes a session without its history.
+type Info struct {
+	Key      string
+	Messages int
+	Summary  string
+	// Preview is the first user message of the session.
+	Preview   string
+	Media     []string
+	Retention *config.RetentionPolicy
+	Created   time.Time
+	Updated   time.Time
+}
+
+// ArchiveID returns the archive ID of an archived session key, or "" if key
+// is not an archive.
+func ArchiveID(key string) string {
+	if i := strings.LastIndex(key, ArchiveSeparator); i >= 0 {
+		return key[i+len(ArchiveSeparator):]
+	}
+	return ""
+}
+
+func infoOf(s *Session) Info {
+	info := Info{
+		Key:       s.Key,
+		Messages:  len(s.Messages),
+		Summary:   s.Summary,
+		Media:     s.Media,
+		Retention: s.Retention,
+		Created:   s.Created,
+		Updated:   s.Updated,
+	}
+	for _, msg := range s.Messages {
+		if msg.Role == "user" {
+			info.Preview = msg.Content
+			break
+		}
+	}
+	return info
+}
+
+// List describes the loaded and stored sessions whose key starts with
+// prefix, most recently updated first. Stored sessions are read without
+// being loaded into memory.
+func (sm *SessionManager) List(prefix string) ([]Info, error) {
+	sm.mu.RLock()
+	infos := make([]Info, 0, len(sm.sessions))
+	seen := make(map[string]bool, len(sm.sessions))
+	for key, e := range sm.sessions {
+		if strings.HasPrefix(key, prefix) {
+			infos = append(infos, infoOf(e.session))
+		}
+		seen[key] = true
+	}
+	sm.mu.RUnlock()
+
+	if sm.store != nil {
+		keys, err := sm.store.Keys()
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if seen[key] || !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			s, err := sm.store.Load(key)
+			if err != nil || s == nil {
+				continue
+			}
+			s.Key = key
+			infos = append(infos, infoOf(s))
+		}
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		if !infos[i].Updated.Equal(infos[j].Updated) {
+			return infos[i].Updated.After(infos[j].Updated)
+		}
+		return infos[i].Key < infos[j].Key
+	})
+	return infos, nil
+}
+
+// Archives describes the archived sessions of key, most recent first.
+func (sm *SessionManager) Archives(key string) ([]Info, error) {
+	return sm.List(key + ArchiveSeparator)
+}
+
+// Get returns a copy of the session for key, or nil if there is none.
+func (sm *SessionManager) Get(key string) *Session {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	e := sm.lookup(key, false)
+	if e == nil {
+		return nil
+	}
+	return copySession(e.session, key)
+}
+
+func copySession(s *Session, key string) *Session {
+	c := *s
+	c.Key = key
+	c.Messages = make([]providers.Message, len(s.Messages))
+	copy(c.Messages, s.Messages)
+	c.Media = append([]string(nil), s.Media...)
+	if s.Retention != nil {
+		policy := *s.Retention
+		c.Retention = &policy
+	}
+	return &c
+}
+
+// Archive moves the history and summary of key into a new archived session
+// and leaves key empty. It returns the archived session's key, or "" if
+// there was nothing to archive.
+func (sm *SessionManager) Archive(key string) (string, error) {
+	sm.mu.Lock()
+	e := sm.lookup(key, false)
+	if e == nil || (len(e.session.Messages) == 0 && e.session.Summary == "") {
+		sm.mu.Unlock()
+		return "", nil
+	}
+
+	archiveKey := sm.unusedKey(key + ArchiveSeparator + time.Now().Format("20060102-150405"))
+	archived := &entry{session: copySession(e.session, archiveKey), dirty: true}
+	archived.elem = sm.lru.PushFront(archiveKey)
+	sm.sessions[archiveKey] = archived
+
+	now := time.Now()
+	e.session.Messages = []providers.Message{}
+	e.session.Summary = ""
+	e.session.Media = nil
+	e.session.Created = now
+	e.session.Updated = now
+	e.markRewritten()
+	sm.mu.Unlock()
+
+	if err := sm.Save(archiveKey); err != nil {
+		return "", fmt.Errorf("saving archive %s: %w", archiveKey, err)
+	}
+	if err := sm.Save(key); err != nil {
+		return archiveKey, err
+	}
+	return archiveKey, nil
+}
+
+// Fork copies the session for key to a new archived session named id, or
+// a timestamped branch name if id is empty, and returns its key. key itself
+// is left as it is. It returns "" if the session is empty.
+func (sm *SessionManager) Fork(key, id string) (string, error) {
+	sm.mu.Lock()
+	e := sm.lookup(key, false)
+	if e == nil || (len(e.session.Messages) == 0 && e.session.Summary == "") {
+		sm.mu.Unlock()
+		return "", nil
+	}
+	if id == "" {
+		id = "branch-" + time.Now().Format("20060102-150405")
+	}
+	forkKey := sm.unusedKey(key + ArchiveSeparator + id)
+	fork := &entry{session: copySession(e.session, forkKey), dirty: true}
+	fork.session.Updated = time.Now()
+	fork.elem = sm.lru.PushFront(forkKey)
+	sm.sessions[forkKey] = fork
+	sm.mu.Unlock()
+
+	if err := sm.Save(forkKey); err != nil {
+		return "", fmt.Errorf("saving branch %s: %w", forkKey, err)
+	}
+	return forkKey, nil
+}
+
+// unusedKey returns key, or key with a numeric suffix if key is taken.
+// Callers must hold sm.mu.
+func (sm *SessionManager) unusedKey(key string) string {
+	candidate := key
+	for n := 2; sm.exists(candidate); n++ {
+		candidate = fmt.Sprintf("%s-%d", key, n)
+	}
+	return candidate
+}
+
+func (sm *SessionManager) exists(key string) bool {
+	if _, ok := sm.sessions[key]; ok {
+		return true
+	}
+	if sm.store == nil {
+		return false
+	}
+	s, err := sm.store.Load(key)
+	return err == nil && s != nil
+}
+
+// Restore makes the archived session archiveKey the current session of key
+// again. The current session is archived first if it has any history; its
+// archive key is returned.
+func (sm *SessionManager) Restore(key, archiveKey string) (string, error) {
+	sm.mu.Lock()
+	a := sm.lookup(archiveKey, false)
+	if a == nil {
+		sm.mu.Unlock()
+		return "", ErrNotFound
+	}
+	restored := copySession(a.session, key)
+	sm.mu.Unlock()
+
+	previous, err := sm.Archive(key)
+	if err != nil {
+		return "", err
+	}
+
+	sm.mu.Lock()
+	e := sm.lookup(key, true)
+	e.session.Messages = restored.Messages
+	e.session.Summary = restored.Summary
+	e.session.Media = restored.Media
+	e.session.Created = restored.Created
+	e.session.Updated = time.Now()
+	e.markRewritten()
+	sm.mu.Unlock()
+
+	if err := sm.Save(key); err != nil {
+		return previous, err
+	}
+	return previous, sm.Delete(archiveKey)
+}
+
+// Delete removes the session for key from memory and from the store.
+func (sm *SessionManager) Delete(key string) error {
+	sm.mu.Lock()
+	if e, ok := sm.sessions[key]; ok {
+		sm.lru.Remove(e.elem)
+		delete(sm.sessions, key)
+	}
+	sm.mu.Unlock()
+
+	if sm.store == nil {
+		return nil
+	}
+	return sm.store.Delete(key)
+}
+
+// Prune deletes the sessions with the given key prefix that were last
+// updated before cutoff, and returns their keys.
+func (sm *SessionManager) Prune(prefix string, cutoff time.Time) ([]string, error) {
+	infos, err := sm.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	var deleted []string
+	for _, info := range infos {
+		if !info.Updated.Before(cutoff) {
+			continue
+		}
+		if err := sm.Delete(info.Key); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, info.Key)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,147 @@
+package session
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionManager_ArchiveAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	sm := NewSessionManagerWithStore(NewJSONLStore(dir), 0)
+
+	sm.AddMessage("chat", "user", "first conversation")
+	sm.SetSummary("chat", "talked about the first thing")
+	if err := sm.Save("chat"); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	archiveKey, err := sm.Archive("chat")
+	if err != nil {
+		t.Fatalf("Archive failed: %v", err)
+	}
+	if !strings.HasPrefix(archiveKey, "chat"+ArchiveSeparator) {
+		t.Fatalf("unexpected archive key %q", archiveKey)
+	}
+	if got := sm.GetHistory("chat"); len(got) != 0 {
+		t.Fatalf("expected empty session after archiving, got %+v", got)
+	}
+	if got := sm.GetSummary("chat"); got != "" {
+		t.Fatalf("expected summary to be cleared, got %q", got)
+	}
+
+	// A fresh manager sees the archive through the store.
+	sm = NewSessionManagerWithStore(NewJSONLStore(dir), 0)
+	archives, err := sm.Archives("chat")
+	if err != nil {
+		t.Fatalf("Archives failed: %v", err)
+	}
+	if len(archives) != 1 || archives[0].Key != archiveKey || archives[0].Messages != 1 ||
+		archives[0].Preview != "first conversation" || archives[0].Summary != "talked about the first thing" {
+		t.Fatalf("unexpected archives: %+v", archives)
+	}
+
+	sm.AddMessage("chat", "user", "second conversation")
+	previous, err := sm.Restore("chat", archiveKey)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if previous == "" || previous == archiveKey {
+		t.Fatalf("expected the second conversation to be archived under a new key, got %q", previous)
+	}
+	history := sm.GetHistory("chat")
+	if len(history) != 1 || history[0].Content != "first conversation" {
+		t.Fatalf("unexpected restored history: %+v", history)
+	}
+
+	archives, err = sm.Archives("chat")
+	if err != nil {
+		t.Fatalf("Archives failed: %v", err)
+	}
+	if len(archives) != 1 || archives[0].Key != previous || archives[0].Preview != "second conversation" {
+		t.Fatalf("restored archive should be gone and the left one listed: %+v", archives)
+	}
+
+	if _, err := sm.Restore("chat", archiveKey); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound for a consumed archive, got %v", err)
+	}
+}
+
+func TestSessionManager_ArchiveEmptySession(t *testing.T) {
+	sm := NewSessionManagerWithStore(NewJSONLStore(t.TempDir()), 0)
+	sm.GetOrCreate("chat")
+
+	archiveKey, err := sm.Archive("chat")
+	if err != nil || archiveKey != "" {
+		t.Fatalf("expected nothing to archive, got %q, %v", archiveKey, err)
+	}
+}
+
+func TestSessionManager_Fork(t *testing.T) {
+	sm := NewSessionManagerWithStore(NewJSONLStore(t.TempDir()), 0)
+	if forkKey, err := sm.Fork("chat", ""); err != nil || forkKey != "" {
+		t.Fatalf("expected nothing to fork, got %q, %v", forkKey, err)
+	}
+
+	sm.AddMessage("chat", "user", "plan the trip")
+	forkKey, err := sm.Fork("chat", "trip")
+	if err != nil {
+		t.Fatalf("Fork failed: %v", err)
+	}
+	if forkKey != "chat#trip" {
+		t.Fatalf("unexpected fork key %q", forkKey)
+	}
+	if again, _ := sm.Fork("chat", "trip"); again != "chat#trip-2" {
+		t.Fatalf("expected a suffixed key for a taken name, got %q", again)
+	}
+
+	sm.AddMessage("chat", "user", "actually, plan the budget")
+	if n := len(sm.GetHistory("chat")); n != 2 {
+		t.Fatalf("expected the original session to keep going, got %d messages", n)
+	}
+	fork := sm.Get(forkKey)
+	if fork == nil || len(fork.Messages) != 1 || fork.Messages[0].Content != "plan the trip" {
+		t.Fatalf("unexpected fork: %+v", fork)
+	}
+}
+
+func TestSessionManager_DeleteAndPrune(t *testing.T) {
+	dir := t.TempDir()
+	sm := NewSessionManagerWithStore(NewJSONLStore(dir), 0)
+
+	for _, key := range []string{"old", "new"} {
+		sm.AddMessage(key, "user", "hello")
+		if err := sm.Save(key); err != nil {
+			t.Fatalf("Save(%q) failed: %v", key, err)
+		}
+	}
+
+	deleted, err := sm.Prune("", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if len(deleted) != 2 {
+		t.Fatalf("expected both sessions pruned, got %v", deleted)
+	}
+	infos, err := NewSessionManagerWithStore(NewJSONLStore(dir), 0).List("")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(infos) != 0 {
+		t.Fatalf("expected no stored sessions after prune, got %+v", infos)
+	}
+
+	sm.AddMessage("kept", "user", "hello")
+	if err := sm.Save("kept"); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if deleted, _ := sm.Prune("", time.Now().Add(-time.Hour)); len(deleted) != 0 {
+		t.Fatalf("recent session should not be pruned, got %v", deleted)
+	}
+	if err := sm.Delete("kept"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if sm.Get("kept") != nil {
+		t.Fatal("deleted session is still readable")
+	}
+}
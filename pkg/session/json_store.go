@@ -0,0 +1,116 @@
+package session
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/secrets"
+)
+
+// JSONStore keeps each session in one indented JSON file, rewritten on
+// every save and sealed as a whole when encryption is on.
+type JSONStore struct {
+	dir string
+}
+
+func NewJSONStore(dir string) *JSONStore {
+	return &JSONStore{dir: dir}
+}
+
+func (s *JSONStore) path(key string) (string, error) {
+	filename, err := sessionFilename(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.dir, filename+".json"), nil
+}
+
+// Load implements Store.
+func (s *JSONStore) Load(key string) (*Session, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return readJSONSession(path)
+}
+
+func readJSONSession(path string) (*Session, error) {
+	data, err := secrets.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Save implements Store. The whole session is written every time.
+func (s *JSONStore) Save(session *Session, persisted int, rewrite bool) error {
+	path, err := s.path(session.Key)
+	if err != nil {
+		return err
+	}
+	if session.Messages == nil {
+		session.Messages = []providers.Message{}
+	}
+	data, err := json.MarshalIndent(session, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	if err := secrets.CheckOverwrite(path); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.dir, path, secrets.Seal(data))
+}
+
+// Keys implements Store.
+func (s *JSONStore) Keys() ([]string, error) {
+	files, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		session, err := readJSONSession(filepath.Join(s.dir, file.Name()))
+		if err != nil || session == nil {
+			continue
+		}
+		keys = append(keys, session.Key)
+	}
+	return keys, nil
+}
+
+// Delete implements Store.
+func (s *JSONStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	return removeIfExists(path)
+}
+
+// Size returns the size of the stored session for key.
+func (s *JSONStore) Size(key string) (int64, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+	return fileSize(path)
+}
@@ -0,0 +1,359 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/secrets"
+)
+
+// compactSlack is how many stale records a log may carry beyond its live
+// size before it is compacted.
+const compactSlack = 32
+
+const (
+	recordMeta    = "meta"
+	recordMessage = "message"
+)
+
+// logRecord is one line of a session log.
+type logRecord struct {
+	Type    string             `json:"type"`
+	Time    time.Time          `json:"time"`
+	Key     string             `json:"key,omitempty"`
+	Created *time.Time         `json:"created,omitempty"`
+	Summary string             `json:"summary,omitempty"`
+	Message *providers.Message `json:"message,omitempty"`
+	// Media and Retention belong to meta records.
+	Media     []string                `json:"media,omitempty"`
+	Retention *config.RetentionPolicy `json:"retention,omitempty"`
+}
+
+// logState is what the store knows about a session log on disk.
+type logState struct {
+	records int
+	// meta is the last meta record written, without its time.
+	meta string
+	// stale is set when some records are not stored the way they would be
+	// written now, e.g. plaintext after encryption was turned on.
+	stale bool
+}
+
+// JSONLStore keeps each session in an append-only log: a meta record with
+// the key, creation time, summary, media and retention policy, then one
+// record per message. A save appends only the new messages, plus a meta
+// record if any of the rest changed.
+// A log is compacted into a fresh file when its history was rewritten or it
+// has grown to more than twice its live size.
+//
+// Sessions saved by JSONStore are read when no log exists yet and replaced by
+// a log on their next save.
+type JSONLStore struct {
+	dir  string
+	mu   sync.Mutex
+	logs map[string]*logState
+}
+
+func NewJSONLStore(dir string) *JSONLStore {
+	return &JSONLStore{dir: dir, logs: make(map[string]*logState)}
+}
+
+func (s *JSONLStore) paths(key string) (logPath, legacyPath string, err error) {
+	filename, err := sessionFilename(key)
+	if err != nil {
+		return "", "", err
+	}
+	return filepath.Join(s.dir, filename+".jsonl"), filepath.Join(s.dir, filename+".json"), nil
+}
+
+// Load implements Store.
+func (s *JSONLStore) Load(key string) (*Session, error) {
+	logPath, legacyPath, err := s.paths(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(logPath)
+	if os.IsNotExist(err) {
+		return readJSONSession(legacyPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	session, records, stale, err := readLog(f, false)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.logs[key] = &logState{records: records, meta: metaFingerprint(session), stale: stale}
+	s.mu.Unlock()
+	return session, nil
+}
+
+// readLog replays a session log and reports whether any record needs to be
+// rewritten to match the current encryption setting. With metaOnly it stops
+// after the first record. A torn last line, left by a crash during an
+// append, is ignored.
+func readLog(r io.Reader, metaOnly bool) (*Session, int, bool, error) {
+	session := &Session{Messages: []providers.Message{}}
+	records := 0
+	stale := false
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			if !secrets.IsCurrent(line) {
+				stale = true
+			}
+			plaintext, openErr := secrets.Open(line)
+			if errors.Is(openErr, secrets.ErrNoKey) || errors.Is(openErr, secrets.ErrUnknownKey) {
+				return nil, 0, false, openErr
+			}
+			var rec logRecord
+			if jsonErr := json.Unmarshal(plaintext, &rec); openErr == nil && jsonErr == nil {
+				records++
+				switch rec.Type {
+				case recordMeta:
+					if rec.Key != "" {
+						session.Key = rec.Key
+					}
+					if rec.Created != nil {
+						session.Created = *rec.Created
+					}
+					session.Summary = rec.Summary
+					session.Media = rec.Media
+					session.Retention = rec.Retention
+				case recordMessage:
+					if rec.Message != nil {
+						session.Messages = append(session.Messages, *rec.Message)
+					}
+				}
+				if rec.Time.After(session.Updated) {
+					session.Updated = rec.Time
+				}
+				if metaOnly {
+					break
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, 0, false, err
+		}
+	}
+	return session, records, stale, nil
+}
+
+// Save implements Store.
+func (s *JSONLStore) Save(session *Session, persisted int, rewrite bool) error {
+	logPath, legacyPath, err := s.paths(session.Key)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, known := s.logs[session.Key]
+	live := len(session.Messages) + 1
+	if !known {
+		if err := secrets.CheckOverwrite(logPath); err != nil {
+			return err
+		}
+	}
+	if rewrite || !known || state.stale || persisted > len(session.Messages) || state.records > 2*live+compactSlack {
+		return s.compact(session, logPath, legacyPath)
+	}
+
+	var w recordWriter
+	added := 0
+	for i := persisted; i < len(session.Messages); i++ {
+		msg := session.Messages[i]
+		if err := w.write(logRecord{Type: recordMessage, Time: session.Updated, Message: &msg}); err != nil {
+			return err
+		}
+		added++
+	}
+	meta := metaFingerprint(session)
+	if meta != state.meta {
+		if err := w.write(metaRecord(session)); err != nil {
+			return err
+		}
+		added++
+	}
+	if added == 0 {
+		return nil
+	}
+
+	f, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(w.buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	state.records += added
+	state.meta = meta
+	return nil
+}
+
+// compact replaces the log with one holding only the live state.
+func (s *JSONLStore) compact(session *Session, logPath, legacyPath string) error {
+	var w recordWriter
+	if err := w.write(metaRecord(session)); err != nil {
+		return err
+	}
+	for i := range session.Messages {
+		if err := w.write(logRecord{Type: recordMessage, Time: session.Updated, Message: &session.Messages[i]}); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.dir, logPath, w.buf.Bytes()); err != nil {
+		return err
+	}
+	_ = os.Remove(legacyPath)
+
+	s.logs[session.Key] = &logState{records: len(session.Messages) + 1, meta: metaFingerprint(session)}
+	return nil
+}
+
+// recordWriter encodes log records one per line, each sealed on its own
+// when encryption is on so the log stays append-only.
+type recordWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *recordWriter) write(rec logRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	w.buf.Write(secrets.Seal(data))
+	w.buf.WriteByte('\n')
+	return nil
+}
+
+func metaRecord(session *Session) logRecord {
+	created := session.Created
+	return logRecord{
+		Type:      recordMeta,
+		Time:      session.Updated,
+		Key:       session.Key,
+		Created:   &created,
+		Summary:   session.Summary,
+		Media:     session.Media,
+		Retention: session.Retention,
+	}
+}
+
+// metaFingerprint identifies the content of the meta record for session.
+func metaFingerprint(session *Session) string {
+	rec := metaRecord(session)
+	rec.Time = time.Time{}
+	data, _ := json.Marshal(rec)
+	return string(data)
+}
+
+// Keys implements Store.
+func (s *JSONLStore) Keys() ([]string, error) {
+	files, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var keys []string
+	var legacy []string
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		path := filepath.Join(s.dir, file.Name())
+		switch filepath.Ext(file.Name()) {
+		case ".jsonl":
+			f, err := os.Open(path)
+			if err != nil {
+				continue
+			}
+			session, _, _, err := readLog(f, true)
+			f.Close()
+			if err != nil || session.Key == "" || seen[session.Key] {
+				continue
+			}
+			seen[session.Key] = true
+			keys = append(keys, session.Key)
+		case ".json":
+			legacy = append(legacy, path)
+		}
+	}
+	for _, path := range legacy {
+		session, err := readJSONSession(path)
+		if err != nil || session == nil || seen[session.Key] {
+			continue
+		}
+		seen[session.Key] = true
+		keys = append(keys, session.Key)
+	}
+	return keys, nil
+}
+
+// Delete implements Store. A legacy JSON file for key is removed as well.
+func (s *JSONLStore) Delete(key string) error {
+	logPath, legacyPath, err := s.paths(key)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.logs, key)
+	if err := removeIfExists(logPath); err != nil {
+		return err
+	}
+	return removeIfExists(legacyPath)
+}
+
+// Size returns the size of the log for key, plus a legacy JSON file if one
+// is left.
+func (s *JSONLStore) Size(key string) (int64, error) {
+	logPath, legacyPath, err := s.paths(key)
+	if err != nil {
+		return 0, err
+	}
+	logSize, err := fileSize(logPath)
+	if err != nil {
+		return 0, err
+	}
+	legacySize, err := fileSize(legacyPath)
+	return logSize + legacySize, err
+}
@@ -0,0 +1,176 @@
+package session
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/secrets"
+)
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading %s: %v", path, err)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+func TestJSONLStore_AppendsAndCompacts(t *testing.T) {
+	dir := t.TempDir()
+	sm := NewSessionManagerWithStore(NewJSONLStore(dir), 0)
+	key := "telegram:42"
+	logPath := filepath.Join(dir, "telegram_42.jsonl")
+
+	sm.AddMessage(key, "user", "hello")
+	sm.AddMessage(key, "assistant", "hi")
+	if err := sm.Save(key); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if got := countLines(t, logPath); got != 3 {
+		t.Fatalf("expected meta + 2 messages, got %d lines", got)
+	}
+
+	sm.AddMessage(key, "user", "again")
+	sm.SetSummary(key, "greetings")
+	if err := sm.Save(key); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if got := countLines(t, logPath); got != 5 {
+		t.Fatalf("expected one message and one meta record appended, got %d lines", got)
+	}
+
+	// Saving without changes writes nothing.
+	if err := sm.Save(key); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if got := countLines(t, logPath); got != 5 {
+		t.Fatalf("expected no new records, got %d lines", got)
+	}
+
+	sm.TruncateHistory(key, 1)
+	if err := sm.Save(key); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if got := countLines(t, logPath); got != 2 {
+		t.Fatalf("expected the log to be compacted to meta + 1 message, got %d lines", got)
+	}
+
+	reloaded := NewSessionManagerWithStore(NewJSONLStore(dir), 0)
+	history := reloaded.GetHistory(key)
+	if len(history) != 1 || history[0].Content != "again" {
+		t.Fatalf("unexpected history after reload: %+v", history)
+	}
+	if got := reloaded.GetSummary(key); got != "greetings" {
+		t.Errorf("summary = %q, want %q", got, "greetings")
+	}
+}
+
+func TestJSONLStore_IgnoresTornLastLine(t *testing.T) {
+	dir := t.TempDir()
+	sm := NewSessionManagerWithStore(NewJSONLStore(dir), 0)
+	key := "telegram:42"
+	sm.AddMessage(key, "user", "hello")
+	if err := sm.Save(key); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, "telegram_42.jsonl"), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"type":"message","message":{"role":"us`)
+	f.Close()
+
+	history := NewSessionManagerWithStore(NewJSONLStore(dir), 0).GetHistory(key)
+	if len(history) != 1 || history[0].Content != "hello" {
+		t.Fatalf("unexpected history: %+v", history)
+	}
+}
+
+func TestJSONLStore_MigratesJSONSessions(t *testing.T) {
+	dir := t.TempDir()
+	legacy := NewSessionManager(dir)
+	key := "discord:7"
+	legacy.AddMessage(key, "user", "from the old format")
+	if err := legacy.Save(key); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	store := NewJSONLStore(dir)
+	keys, err := store.Keys()
+	if err != nil || len(keys) != 1 || keys[0] != key {
+		t.Fatalf("Keys() = %v, %v; want [%s]", keys, err, key)
+	}
+
+	sm := NewSessionManagerWithStore(store, 0)
+	sm.AddMessage(key, "user", "in the new format")
+	if err := sm.Save(key); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "discord_7.json")); !os.IsNotExist(err) {
+		t.Error("the JSON file should be replaced by the log")
+	}
+
+	history := NewSessionManagerWithStore(NewJSONLStore(dir), 0).GetHistory(key)
+	if len(history) != 2 || history[0].Content != "from the old format" {
+		t.Fatalf("unexpected history after migration: %+v", history)
+	}
+}
+
+func TestJSONLStore_PersistsMediaAndRetention(t *testing.T) {
+	dir := t.TempDir()
+	sm := NewSessionManagerWithStore(NewJSONLStore(dir), 0)
+	sm.AddMessage("chat", "user", "look at this")
+	sm.Save("chat")
+
+	policy := &config.RetentionPolicy{IdleTTLHours: 24, IdleAction: "delete"}
+	sm.AddMedia("chat", []string{"/tmp/picoclaw_media/a.jpg"})
+	sm.SetRetention("chat", policy)
+	if err := sm.Save("chat"); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loaded, err := NewJSONLStore(dir).Load("chat")
+	if err != nil || loaded == nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(loaded.Media) != 1 || loaded.Media[0] != "/tmp/picoclaw_media/a.jpg" {
+		t.Fatalf("media not persisted: %+v", loaded.Media)
+	}
+	if loaded.Retention == nil || *loaded.Retention != *policy {
+		t.Fatalf("retention not persisted: %+v", loaded.Retention)
+	}
+}
+
+func TestJSONLStore_EncryptsPlaintextLog(t *testing.T) {
+	dir := t.TempDir()
+	sm := NewSessionManagerWithStore(NewJSONLStore(dir), 0)
+	sm.AddMessage("chat", "user", "my plaintext secret")
+	if err := sm.Save("chat"); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	raw, _ := secrets.GenerateKey()
+	key, _ := secrets.NewKey(raw)
+	secrets.SetKeys(key)
+	t.Cleanup(func() { secrets.SetKeys(nil) })
+
+	sm = NewSessionManagerWithStore(NewJSONLStore(dir), 0)
+	sm.AddMessage("chat", "assistant", "noted")
+	if err := sm.Save("chat"); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(dir, "chat.jsonl"))
+	if bytes.Contains(data, []byte("secret")) {
+		t.Fatalf("plaintext left in the log after saving with a key:\n%s", data)
+	}
+	history := NewSessionManagerWithStore(NewJSONLStore(dir), 0).GetHistory("chat")
+	if len(history) != 2 || history[0].Content != "my plaintext secret" {
+		t.Fatalf("unexpected history after reload: %+v", history)
+	}
+}
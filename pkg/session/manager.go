@@ -1,13 +1,14 @@
 package session
 
 import (
-	"encoding/json"
+	"container/list"
+	"errors"
 	"os"
-	"path/filepath"
-	"strings"
 	"sync"
 	"time"
 
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
 	"github.com/sipeed/picoclaw/pkg/providers"
 )
 
@@ -17,46 +18,134 @@ type Session struct {
 	Summary  string              `json:"summary,omitempty"`
 	Created  time.Time           `json:"created"`
 	Updated  time.Time           `json:"updated"`
+	// Media lists the local attachments received in the session, so media
+	// cleanup can tell which downloads are still in use.
+	Media []string `json:"media,omitempty"`
+	// Retention is the policy the session was last used under, or nil for
+	// the default policy.
+	Retention *config.RetentionPolicy `json:"retention,omitempty"`
 }
 
+// entry is a session held in memory and what is left to persist of it.
+type entry struct {
+	session *Session
+	// persisted is how many messages the store already holds.
+	persisted int
+	// rewrite is set when the stored history must be replaced rather than
+	// appended to; generation counts such changes.
+	rewrite    bool
+	generation int
+	// meta counts changes to the summary, media and retention policy.
+	meta   int
+	dirty  bool
+	elem   *list.Element
+	saveMu sync.Mutex
+}
+
+// SessionManager keeps sessions in memory while they are in use. Sessions
+// are loaded from the store on first access, and once more than maxLoaded
+// are in memory the least recently used ones without unsaved changes are
+// dropped.
 type SessionManager struct {
-	sessions map[string]*Session
-	mu       sync.RWMutex
-	storage  string
+	sessions  map[string]*entry
+	lru       *list.List // of session keys, most recently used first
+	mu        sync.RWMutex
+	store     Store
+	maxLoaded int
 }
 
+// NewSessionManager returns a manager that keeps sessions as JSON files in
+// storage, or only in memory if storage is empty.
 func NewSessionManager(storage string) *SessionManager {
-	sm := &SessionManager{
-		sessions: make(map[string]*Session),
-		storage:  storage,
-	}
-
+	var store Store
 	if storage != "" {
 		os.MkdirAll(storage, 0755)
-		sm.loadSessions()
+		store = NewJSONStore(storage)
 	}
+	return NewSessionManagerWithStore(store, 0)
+}
 
-	return sm
+// NewSessionManagerWithStore returns a manager backed by store, which may be
+// nil for memory-only sessions. maxLoaded caps the sessions kept in memory;
+// zero means no cap.
+func NewSessionManagerWithStore(store Store, maxLoaded int) *SessionManager {
+	return &SessionManager{
+		sessions:  make(map[string]*entry),
+		lru:       list.New(),
+		store:     store,
+		maxLoaded: maxLoaded,
+	}
 }
 
-func (sm *SessionManager) GetOrCreate(key string) *Session {
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
+// lookup returns the entry for key, loading it from the store if needed.
+// With create, a missing session is created. Callers must hold sm.mu.
+func (sm *SessionManager) lookup(key string, create bool) *entry {
+	if e, ok := sm.sessions[key]; ok {
+		sm.lru.MoveToFront(e.elem)
+		return e
+	}
 
-	session, ok := sm.sessions[key]
-	if ok {
-		return session
+	var session *Session
+	if sm.store != nil {
+		loaded, err := sm.store.Load(key)
+		if err != nil && !errors.Is(err, os.ErrInvalid) {
+			logger.WarnCF("session", "Failed to load session",
+				map[string]interface{}{
+					"session_key": key,
+					"error":       err.Error(),
+				})
+		}
+		session = loaded
 	}
 
-	session = &Session{
-		Key:      key,
-		Messages: []providers.Message{},
-		Created:  time.Now(),
-		Updated:  time.Now(),
+	e := &entry{session: session}
+	if session != nil {
+		session.Key = key
+		if session.Messages == nil {
+			session.Messages = []providers.Message{}
+		}
+		e.persisted = len(session.Messages)
+	} else {
+		if !create {
+			return nil
+		}
+		now := time.Now()
+		e.session = &Session{
+			Key:      key,
+			Messages: []providers.Message{},
+			Created:  now,
+			Updated:  now,
+		}
+		e.dirty = true
+	}
+
+	e.elem = sm.lru.PushFront(key)
+	sm.sessions[key] = e
+	sm.evict()
+	return e
+}
+
+// evict drops the least recently used sessions without unsaved changes
+// until at most maxLoaded remain. Callers must hold sm.mu.
+func (sm *SessionManager) evict() {
+	if sm.maxLoaded <= 0 || sm.store == nil {
+		return
+	}
+	for el := sm.lru.Back(); el != nil && len(sm.sessions) > sm.maxLoaded; {
+		prev := el.Prev()
+		key := el.Value.(string)
+		if e := sm.sessions[key]; e != nil && !e.dirty {
+			sm.lru.Remove(el)
+			delete(sm.sessions, key)
+		}
+		el = prev
 	}
-	sm.sessions[key] = session
+}
 
-	return session
+func (sm *SessionManager) GetOrCreate(key string) *Session {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.lookup(key, true).session
 }
 
 func (sm *SessionManager) AddMessage(sessionKey, role, content string) {
@@ -72,196 +161,177 @@ func (sm *SessionManager) AddFullMessage(sessionKey string, msg providers.Messag
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	session, ok := sm.sessions[sessionKey]
-	if !ok {
-		session = &Session{
-			Key:      sessionKey,
-			Messages: []providers.Message{},
-			Created:  time.Now(),
-		}
-		sm.sessions[sessionKey] = session
-	}
-
-	session.Messages = append(session.Messages, msg)
-	session.Updated = time.Now()
+	e := sm.lookup(sessionKey, true)
+	e.session.Messages = append(e.session.Messages, msg)
+	e.session.Updated = time.Now()
+	e.dirty = true
 }
 
 func (sm *SessionManager) GetHistory(key string) []providers.Message {
-	sm.mu.RLock()
-	defer sm.mu.RUnlock()
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
 
-	session, ok := sm.sessions[key]
-	if !ok {
+	e := sm.lookup(key, false)
+	if e == nil {
 		return []providers.Message{}
 	}
 
-	history := make([]providers.Message, len(session.Messages))
-	copy(history, session.Messages)
+	history := make([]providers.Message, len(e.session.Messages))
+	copy(history, e.session.Messages)
 	return history
 }
 
 func (sm *SessionManager) GetSummary(key string) string {
-	sm.mu.RLock()
-	defer sm.mu.RUnlock()
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
 
-	session, ok := sm.sessions[key]
-	if !ok {
+	e := sm.lookup(key, false)
+	if e == nil {
 		return ""
 	}
-	return session.Summary
+	return e.session.Summary
 }
 
 func (sm *SessionManager) SetSummary(key string, summary string) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	session, ok := sm.sessions[key]
-	if ok {
-		session.Summary = summary
-		session.Updated = time.Now()
+	if e := sm.lookup(key, false); e != nil {
+		e.session.Summary = summary
+		e.session.Updated = time.Now()
+		e.metaChanged()
 	}
 }
 
-func (sm *SessionManager) TruncateHistory(key string, keepLast int) {
+// AddMedia records local attachments received in the session.
+func (sm *SessionManager) AddMedia(key string, paths []string) {
+	if len(paths) == 0 {
+		return
+	}
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	session, ok := sm.sessions[key]
-	if !ok {
-		return
+	e := sm.lookup(key, true)
+	added := false
+	for _, path := range paths {
+		if !containsString(e.session.Media, path) {
+			e.session.Media = append(e.session.Media, path)
+			added = true
+		}
 	}
-
-	if keepLast <= 0 {
-		session.Messages = []providers.Message{}
-		session.Updated = time.Now()
-		return
+	if added {
+		e.metaChanged()
 	}
+}
 
-	if len(session.Messages) <= keepLast {
+// SetRetention records the retention policy the session is used under;
+// nil means the default policy.
+func (sm *SessionManager) SetRetention(key string, policy *config.RetentionPolicy) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	e := sm.lookup(key, true)
+	if samePolicy(e.session.Retention, policy) {
 		return
 	}
-
-	session.Messages = session.Messages[len(session.Messages)-keepLast:]
-	session.Updated = time.Now()
+	if policy != nil {
+		p := *policy
+		policy = &p
+	}
+	e.session.Retention = policy
+	e.metaChanged()
 }
 
-// sanitizeFilename converts a session key into a cross-platform safe filename.
-// Session keys use "channel:chatID" (e.g. "telegram:123456") but ':' is the
-// volume separator on Windows, so filepath.Base would misinterpret the key.
-// We replace it with '_'. The original key is preserved inside the JSON file,
-// so loadSessions still maps back to the right in-memory key.
-func sanitizeFilename(key string) string {
-	return strings.ReplaceAll(key, ":", "_")
+func samePolicy(a, b *config.RetentionPolicy) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
 }
 
-func (sm *SessionManager) Save(key string) error {
-	if sm.storage == "" {
-		return nil
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
 	}
+	return false
+}
 
-	filename := sanitizeFilename(key)
-
-	// filepath.IsLocal rejects empty names, "..", absolute paths, and
-	// OS-reserved device names (NUL, COM1 … on Windows).
-	// The extra checks reject "." and any directory separators so that
-	// the session file is always written directly inside sm.storage.
-	if filename == "." || !filepath.IsLocal(filename) || strings.ContainsAny(filename, `/\`) {
-		return os.ErrInvalid
-	}
+func (sm *SessionManager) TruncateHistory(key string, keepLast int) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
 
-	// Snapshot under read lock, then perform slow file I/O after unlock.
-	sm.mu.RLock()
-	stored, ok := sm.sessions[key]
-	if !ok {
-		sm.mu.RUnlock()
-		return nil
+	e := sm.lookup(key, false)
+	if e == nil {
+		return
 	}
 
-	snapshot := Session{
-		Key:     stored.Key,
-		Summary: stored.Summary,
-		Created: stored.Created,
-		Updated: stored.Updated,
-	}
-	if len(stored.Messages) > 0 {
-		snapshot.Messages = make([]providers.Message, len(stored.Messages))
-		copy(snapshot.Messages, stored.Messages)
+	if keepLast <= 0 {
+		e.session.Messages = []providers.Message{}
+	} else if len(e.session.Messages) <= keepLast {
+		return
 	} else {
-		snapshot.Messages = []providers.Message{}
-	}
-	sm.mu.RUnlock()
-
-	data, err := json.MarshalIndent(snapshot, "", "  ")
-	if err != nil {
-		return err
+		e.session.Messages = e.session.Messages[len(e.session.Messages)-keepLast:]
 	}
+	e.session.Updated = time.Now()
+	e.markRewritten()
+}
 
-	sessionPath := filepath.Join(sm.storage, filename+".json")
-	tmpFile, err := os.CreateTemp(sm.storage, "session-*.tmp")
-	if err != nil {
-		return err
-	}
+// metaChanged records a change to the session's summary, media or
+// retention policy.
+func (e *entry) metaChanged() {
+	e.meta++
+	e.dirty = true
+}
 
-	tmpPath := tmpFile.Name()
-	cleanup := true
-	defer func() {
-		if cleanup {
-			_ = os.Remove(tmpPath)
-		}
-	}()
+// markRewritten records that the stored history no longer matches.
+func (e *entry) markRewritten() {
+	e.rewrite = true
+	e.generation++
+	e.persisted = 0
+	e.dirty = true
+}
 
-	if _, err := tmpFile.Write(data); err != nil {
-		_ = tmpFile.Close()
-		return err
-	}
-	if err := tmpFile.Chmod(0644); err != nil {
-		_ = tmpFile.Close()
-		return err
-	}
-	if err := tmpFile.Sync(); err != nil {
-		_ = tmpFile.Close()
-		return err
-	}
-	if err := tmpFile.Close(); err != nil {
-		return err
+// Save persists the changes to the session since it was last saved.
+func (sm *SessionManager) Save(key string) error {
+	if sm.store == nil {
+		return nil
 	}
-
-	if err := os.Rename(tmpPath, sessionPath); err != nil {
+	if _, err := sessionFilename(key); err != nil {
 		return err
 	}
-	cleanup = false
-	return nil
-}
 
-func (sm *SessionManager) loadSessions() error {
-	files, err := os.ReadDir(sm.storage)
-	if err != nil {
-		return err
+	sm.mu.RLock()
+	e, ok := sm.sessions[key]
+	sm.mu.RUnlock()
+	if !ok {
+		return nil
 	}
 
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
-
-		if filepath.Ext(file.Name()) != ".json" {
-			continue
-		}
+	// One save per session at a time, so appends land in order.
+	e.saveMu.Lock()
+	defer e.saveMu.Unlock()
 
-		sessionPath := filepath.Join(sm.storage, file.Name())
-		data, err := os.ReadFile(sessionPath)
-		if err != nil {
-			continue
-		}
-
-		var session Session
-		if err := json.Unmarshal(data, &session); err != nil {
-			continue
-		}
+	// Snapshot under read lock, then perform slow file I/O after unlock.
+	sm.mu.RLock()
+	stored := e.session
+	snapshot := copySession(stored, stored.Key)
+	persisted, rewrite, generation, meta := e.persisted, e.rewrite, e.generation, e.meta
+	sm.mu.RUnlock()
 
-		sm.sessions[session.Key] = &session
+	if err := sm.store.Save(snapshot, persisted, rewrite); err != nil {
+		return err
 	}
 
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if e.generation == generation {
+		e.rewrite = false
+		e.persisted = len(snapshot.Messages)
+		e.dirty = len(e.session.Messages) != e.persisted || e.meta != meta
+	}
+	sm.evict()
 	return nil
 }
 
@@ -270,13 +340,13 @@ func (sm *SessionManager) SetHistory(key string, history []providers.Message) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	session, ok := sm.sessions[key]
-	if ok {
+	if e := sm.lookup(key, false); e != nil {
 		// Create a deep copy to strictly isolate internal state
 		// from the caller's slice.
 		msgs := make([]providers.Message, len(history))
 		copy(msgs, history)
-		session.Messages = msgs
-		session.Updated = time.Now()
+		e.session.Messages = msgs
+		e.session.Updated = time.Now()
+		e.markRewritten()
 	}
 }
@@ -72,3 +72,40 @@ func TestSave_RejectsPathTraversal(t *testing.T) {
 		}
 	}
 }
+
+func TestSessionManager_EvictsIdleSessions(t *testing.T) {
+	dir := t.TempDir()
+	sm := NewSessionManagerWithStore(NewJSONLStore(dir), 2)
+
+	for _, key := range []string{"a", "b", "c"} {
+		sm.AddMessage(key, "user", "message for "+key)
+		if err := sm.Save(key); err != nil {
+			t.Fatalf("Save(%q) failed: %v", key, err)
+		}
+	}
+	if len(sm.sessions) != 2 {
+		t.Fatalf("expected 2 sessions in memory, got %d", len(sm.sessions))
+	}
+	if _, ok := sm.sessions["a"]; ok {
+		t.Error("least recently used session should have been evicted")
+	}
+
+	// Evicted sessions are loaded again on access.
+	history := sm.GetHistory("a")
+	if len(history) != 1 || history[0].Content != "message for a" {
+		t.Fatalf("unexpected history for evicted session: %+v", history)
+	}
+}
+
+func TestSessionManager_KeepsUnsavedSessions(t *testing.T) {
+	sm := NewSessionManagerWithStore(NewJSONLStore(t.TempDir()), 1)
+
+	sm.AddMessage("a", "user", "unsaved")
+	sm.AddMessage("b", "user", "also unsaved")
+	if len(sm.sessions) != 2 {
+		t.Fatalf("sessions with unsaved changes must stay in memory, got %d", len(sm.sessions))
+	}
+	if got := sm.GetHistory("a"); len(got) != 1 {
+		t.Fatalf("unsaved history lost: %+v", got)
+	}
+}
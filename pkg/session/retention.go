@@ -0,0 +1,60 @@
+package session
+
+import (
+	"os"
+	"time"
+)
+
+// sizer is implemented by stores that can tell how much disk space a
+// session takes.
+type sizer interface {
+	Size(key string) (int64, error)
+}
+
+// Size returns the bytes the stored session for key takes on disk, or 0 if
+// it is not stored or the store cannot tell.
+func (sm *SessionManager) Size(key string) int64 {
+	s, ok := sm.store.(sizer)
+	if !ok {
+		return 0
+	}
+	size, err := s.Size(key)
+	if err != nil {
+		return 0
+	}
+	return size
+}
+
+// TrimHistory drops the oldest messages of key so that at most max remain,
+// and returns how many were dropped. The kept history starts at a user
+// message, so tool results are never separated from their call.
+func (sm *SessionManager) TrimHistory(key string, max int) int {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	e := sm.lookup(key, false)
+	if e == nil || max <= 0 || len(e.session.Messages) <= max {
+		return 0
+	}
+	messages := e.session.Messages
+	start := len(messages) - max
+	for start < len(messages) && messages[start].Role != "user" {
+		start++
+	}
+	e.session.Messages = append(messages[:0:0], messages[start:]...)
+	e.session.Updated = time.Now()
+	e.markRewritten()
+	return start
+}
+
+// fileSize returns the size of path, or 0 if it does not exist.
+func fileSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
@@ -0,0 +1,109 @@
+package session
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Store persists sessions for a SessionManager.
+type Store interface {
+	// Load returns the stored session for key, or nil if there is none.
+	Load(key string) (*Session, error)
+	// Save persists s. The first persisted messages of s were stored by an
+	// earlier Save; rewrite is set when the stored history is no longer a
+	// prefix of s.Messages, e.g. after it was truncated.
+	Save(s *Session, persisted int, rewrite bool) error
+	// Keys returns the keys of all stored sessions.
+	Keys() ([]string, error)
+	// Delete removes the stored session for key. Deleting a session that
+	// is not stored is not an error.
+	Delete(key string) error
+}
+
+// Storage backends selectable in config.
+const (
+	StorageJSON  = "json"
+	StorageJSONL = "jsonl"
+)
+
+// NewStore returns the store for a storage backend name, defaulting to the
+// append-only JSONL store.
+func NewStore(kind, dir string) Store {
+	if kind == StorageJSON {
+		return NewJSONStore(dir)
+	}
+	return NewJSONLStore(dir)
+}
+
+// sanitizeFilename converts a session key into a cross-platform safe filename.
+// Session keys use "channel:chatID" (e.g. "telegram:123456") but ':' is the
+// volume separator on Windows, so filepath.Base would misinterpret the key.
+// We replace it with '_'. The original key is preserved inside the file, so
+// stores still map back to the right key.
+func sanitizeFilename(key string) string {
+	return strings.ReplaceAll(key, ":", "_")
+}
+
+// sessionFilename returns the base filename for key, or os.ErrInvalid if the
+// key would not name a file directly inside the storage directory.
+func sessionFilename(key string) (string, error) {
+	filename := sanitizeFilename(key)
+
+	// filepath.IsLocal rejects empty names, "..", absolute paths, and
+	// OS-reserved device names (NUL, COM1 … on Windows).
+	// The extra checks reject "." and any directory separators so that
+	// the session file is always written directly inside the directory.
+	if filename == "." || !filepath.IsLocal(filename) || strings.ContainsAny(filename, `/\`) {
+		return "", os.ErrInvalid
+	}
+	return filename, nil
+}
+
+// writeFileAtomic writes data to path through a synced temporary file, so a
+// crash leaves either the old or the new content.
+func writeFileAtomic(dir, path string, data []byte) error {
+	tmpFile, err := os.CreateTemp(dir, "session-*.tmp")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := tmpFile.Name()
+	cleanup := true
+	defer func() {
+		if cleanup {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err := tmpFile.Write(data); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Chmod(0644); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	cleanup = false
+	return nil
+}
+
+// removeIfExists removes path, ignoring a file that is already gone.
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
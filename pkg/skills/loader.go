@@ -225,6 +225,28 @@ func (sl *SkillsLoader) LoadSkillsForContext(skillNames []string) string {
 	return strings.Join(parts, "\n\n---\n\n")
 }
 
+// SourceFiles returns the skill directories and the SKILL.md files in them
+// that ListSkills reads, so callers can tell when the skills have changed.
+func (sl *SkillsLoader) SourceFiles() []string {
+	var files []string
+	for _, root := range []string{sl.workspaceSkills, sl.globalSkills, sl.builtinSkills} {
+		if root == "" {
+			continue
+		}
+		files = append(files, root)
+		dirs, err := os.ReadDir(root)
+		if err != nil {
+			continue
+		}
+		for _, dir := range dirs {
+			if dir.IsDir() {
+				files = append(files, filepath.Join(root, dir.Name(), "SKILL.md"))
+			}
+		}
+	}
+	return files
+}
+
 func (sl *SkillsLoader) BuildSkillsSummary() string {
 	allSkills := sl.ListSkills()
 	if len(allSkills) == 0 {
@@ -0,0 +1,115 @@
+// Package tokenizer counts tokens for LLM requests. Exact tokenizers can be
+// registered per model family; models without one fall back to a
+// conservative heuristic that rather overcounts, since a request counted too
+// small is rejected by the provider.
+package tokenizer
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Tokenizer counts the tokens a model would use for text.
+type Tokenizer interface {
+	Count(text string) int
+}
+
+// Heuristic approximates a BPE tokenizer without a vocabulary. Runs of
+// ASCII text cost CharsPerToken characters per token; other scripts, where
+// BPE vocabularies rarely merge characters, cost about a token per rune.
+type Heuristic struct {
+	CharsPerToken float64
+}
+
+// Count implements Tokenizer.
+func (h Heuristic) Count(text string) int {
+	if text == "" {
+		return 0
+	}
+	charsPerToken := h.CharsPerToken
+	if charsPerToken <= 0 {
+		charsPerToken = 2.5
+	}
+
+	ascii, other := 0, 0
+	for _, r := range text {
+		switch {
+		case r < unicode.MaxASCII:
+			ascii++
+		case unicode.Is(unicode.Han, r), unicode.Is(unicode.Hiragana, r),
+			unicode.Is(unicode.Katakana, r), unicode.Is(unicode.Hangul, r):
+			other++
+		default:
+			// Other non-ASCII letters (accents, Cyrillic, ...) usually take
+			// about two bytes of a token each.
+			ascii += 2
+		}
+	}
+	return int(float64(ascii)/charsPerToken+0.5) + other
+}
+
+// Fallback is used for models whose family has no registered tokenizer.
+// Its 2.5 characters per token is below what BPE vocabularies reach on
+// English prose, so code, JSON and other languages are not undercounted.
+var Fallback Tokenizer = Heuristic{CharsPerToken: 2.5}
+
+type family struct {
+	name      string
+	prefixes  []string
+	tokenizer Tokenizer
+}
+
+var (
+	mu sync.RWMutex
+	// families lists the known model families. None has a tokenizer until
+	// one is registered, so they all use Fallback.
+	families = []family{
+		{name: "openai", prefixes: []string{"gpt-", "o1", "o3", "o4", "codex", "chatgpt"}},
+		{name: "anthropic", prefixes: []string{"claude"}},
+		{name: "gemini", prefixes: []string{"gemini", "gemma"}},
+		{name: "llama", prefixes: []string{"llama", "meta-llama"}},
+		{name: "qwen", prefixes: []string{"qwen"}},
+		{name: "deepseek", prefixes: []string{"deepseek"}},
+		{name: "glm", prefixes: []string{"glm", "chatglm"}},
+		{name: "kimi", prefixes: []string{"kimi", "moonshot"}},
+	}
+)
+
+// Register sets the tokenizer for a model family. Known families are openai, anthropic, gemini, llama, qwen,
+// deepseek, glm and kimi. Registering an unknown family requires prefixes,
+// the lowercase model-name prefixes that select it.
+func Register(name string, t Tokenizer, prefixes ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+	for i := range families {
+		if families[i].name == name {
+			families[i].tokenizer = t
+			if len(prefixes) > 0 {
+				families[i].prefixes = prefixes
+			}
+			return
+		}
+	}
+	families = append(families, family{name: name, prefixes: prefixes, tokenizer: t})
+}
+
+// ForModel returns the tokenizer for a model name such as "gpt-4o" or
+// "openrouter/anthropic/claude-sonnet-4". Provider prefixes are ignored.
+func ForModel(model string) Tokenizer {
+	model = strings.ToLower(model)
+	if idx := strings.LastIndex(model, "/"); idx >= 0 {
+		model = model[idx+1:]
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, f := range families {
+		for _, prefix := range f.prefixes {
+			if strings.HasPrefix(model, prefix) && f.tokenizer != nil {
+				return f.tokenizer
+			}
+		}
+	}
+	return Fallback
+}
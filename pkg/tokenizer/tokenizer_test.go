@@ -0,0 +1,42 @@
+package tokenizer
+
+import "testing"
+
+func TestHeuristic_Count(t *testing.T) {
+	h := Heuristic{CharsPerToken: 4}
+
+	if got := h.Count(""); got != 0 {
+		t.Errorf("Count(\"\") = %d, want 0", got)
+	}
+	if got := h.Count("abcdefgh"); got != 2 {
+		t.Errorf("Count(ascii) = %d, want 2", got)
+	}
+	// CJK characters are counted about one token each.
+	if got := h.Count("你好世界"); got != 4 {
+		t.Errorf("Count(cjk) = %d, want 4", got)
+	}
+}
+
+type fixedTokenizer int
+
+func (f fixedTokenizer) Count(string) int { return int(f) }
+
+func TestForModel(t *testing.T) {
+	if got := ForModel("openrouter/anthropic/claude-sonnet-4"); got != Fallback {
+		t.Errorf("ForModel(claude) = %#v, want Fallback until one is registered", got)
+	}
+	if got := ForModel("some-unknown-model"); got != Fallback {
+		t.Errorf("ForModel(unknown) = %#v, want Fallback", got)
+	}
+
+	Register("mistral", fixedTokenizer(7), "mistral", "mixtral")
+	if got := ForModel("mixtral-8x7b").Count("anything"); got != 7 {
+		t.Errorf("registered tokenizer Count() = %d, want 7", got)
+	}
+
+	Register("openai", fixedTokenizer(3))
+	defer Register("openai", nil)
+	if got := ForModel("gpt-4o").Count("anything"); got != 3 {
+		t.Errorf("registered openai tokenizer Count() = %d, want 3", got)
+	}
+}
@@ -0,0 +1,107 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+// ApprovalRequest describes a tool call that needs the user's approval.
+type ApprovalRequest struct {
+	Tool    string
+	Args    map[string]interface{}
+	Channel string
+	ChatID  string
+}
+
+// Approver asks the user whether a tool call may run. RequestApproval blocks
+// until the user decides, the request times out or ctx is cancelled, and
+// returns nil only if the call was approved.
+type Approver interface {
+	RequestApproval(ctx context.Context, req ApprovalRequest) error
+}
+
+// ApprovalPolicy decides which tool calls need approval.
+type ApprovalPolicy struct {
+	rules []approvalRule
+}
+
+type approvalRule struct {
+	tool      string
+	args      map[string]*regexp.Regexp
+	otherChat bool
+}
+
+// NewApprovalPolicy compiles rules into a policy. A rule with an invalid
+// pattern is kept without its argument patterns, so that every call to its
+// tool needs approval, and the first such error is returned with the policy.
+func NewApprovalPolicy(rules []config.ApprovalRule) (*ApprovalPolicy, error) {
+	p := &ApprovalPolicy{}
+	var firstErr error
+	for _, r := range rules {
+		rule := approvalRule{tool: r.Tool, otherChat: r.OtherChat}
+		if len(r.Args) > 0 {
+			rule.args = make(map[string]*regexp.Regexp, len(r.Args))
+			for name, pattern := range r.Args {
+				re, err := regexp.Compile(pattern)
+				if err != nil {
+					if firstErr == nil {
+						firstErr = fmt.Errorf("approval rule for %s: argument %s: %w", r.Tool, name, err)
+					}
+					rule.args, rule.otherChat = nil, false
+					break
+				}
+				rule.args[name] = re
+			}
+		}
+		p.rules = append(p.rules, rule)
+	}
+	return p, firstErr
+}
+
+// Requires reports whether req matches one of the policy's rules.
+func (p *ApprovalPolicy) Requires(req ApprovalRequest) bool {
+	if p == nil {
+		return false
+	}
+	for _, rule := range p.rules {
+		if rule.matches(req) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r approvalRule) matches(req ApprovalRequest) bool {
+	if r.tool != req.Tool {
+		return false
+	}
+	for name, re := range r.args {
+		if !re.MatchString(argString(req.Args[name])) {
+			return false
+		}
+	}
+	if r.otherChat {
+		channel, _ := req.Args["channel"].(string)
+		chatID, _ := req.Args["chat_id"].(string)
+		sameChannel := channel == "" || channel == req.Channel
+		sameChat := chatID == "" || chatID == req.ChatID
+		if sameChannel && sameChat {
+			return false
+		}
+	}
+	return true
+}
+
+func argString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,108 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func TestApprovalPolicy_Requires(t *testing.T) {
+	policy, err := NewApprovalPolicy(config.DefaultConfig().Tools.Approval.Rules)
+	if err != nil {
+		t.Fatalf("NewApprovalPolicy() error: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		req  ApprovalRequest
+		want bool
+	}{
+		{"exec", ApprovalRequest{Tool: "exec", Args: map[string]interface{}{"command": "ls"}}, true},
+		{"read_file", ApprovalRequest{Tool: "read_file"}, false},
+		{"i2c write", ApprovalRequest{Tool: "i2c", Args: map[string]interface{}{"action": "write"}}, true},
+		{"i2c scan", ApprovalRequest{Tool: "i2c", Args: map[string]interface{}{"action": "scan"}}, false},
+		{"spi transfer", ApprovalRequest{Tool: "spi", Args: map[string]interface{}{"action": "transfer"}}, true},
+		{"message to current chat", ApprovalRequest{
+			Tool: "message", Channel: "telegram", ChatID: "1",
+			Args: map[string]interface{}{"content": "hi"},
+		}, false},
+		{"message to same chat by id", ApprovalRequest{
+			Tool: "message", Channel: "telegram", ChatID: "1",
+			Args: map[string]interface{}{"channel": "telegram", "chat_id": "1"},
+		}, false},
+		{"message to another chat", ApprovalRequest{
+			Tool: "message", Channel: "telegram", ChatID: "1",
+			Args: map[string]interface{}{"chat_id": "2"},
+		}, true},
+		{"message to another channel", ApprovalRequest{
+			Tool: "message", Channel: "telegram", ChatID: "1",
+			Args: map[string]interface{}{"channel": "discord", "chat_id": "1"},
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.Requires(tt.req); got != tt.want {
+				t.Errorf("Requires() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApprovalPolicy_InvalidPatternFailsClosed(t *testing.T) {
+	policy, err := NewApprovalPolicy([]config.ApprovalRule{
+		{Tool: "i2c", Args: map[string]string{"action": "(write"}},
+	})
+	if err == nil {
+		t.Fatal("expected an error for the invalid pattern")
+	}
+	if !policy.Requires(ApprovalRequest{Tool: "i2c", Args: map[string]interface{}{"action": "scan"}}) {
+		t.Error("a rule with an invalid pattern should match every call to its tool")
+	}
+}
+
+type stubApprover struct {
+	err   error
+	calls int
+}
+
+func (a *stubApprover) RequestApproval(ctx context.Context, req ApprovalRequest) error {
+	a.calls++
+	return a.err
+}
+
+type countingTool struct {
+	calls int
+}
+
+func (t *countingTool) Name() string                       { return "guarded" }
+func (t *countingTool) Description() string                { return "counts calls" }
+func (t *countingTool) Parameters() map[string]interface{} { return map[string]interface{}{} }
+func (t *countingTool) Execute(ctx context.Context, args map[string]interface{}) *ToolResult {
+	t.calls++
+	return SilentResult("ran")
+}
+
+func TestToolRegistry_ApprovalGatesExecution(t *testing.T) {
+	policy, _ := NewApprovalPolicy([]config.ApprovalRule{{Tool: "guarded"}})
+	tool := &countingTool{}
+	r := NewToolRegistry()
+	r.Register(tool)
+
+	approver := &stubApprover{err: errors.New("denied")}
+	r.SetApproval(policy, approver)
+	result := r.ExecuteWithContext(context.Background(), "guarded", nil, "telegram", "1", nil)
+	if !result.IsError || tool.calls != 0 {
+		t.Fatalf("denied call should not run: result=%+v calls=%d", result, tool.calls)
+	}
+
+	approver.err = nil
+	result = r.ExecuteWithContext(context.Background(), "guarded", nil, "telegram", "1", nil)
+	if result.IsError || tool.calls != 1 {
+		t.Fatalf("approved call should run: result=%+v calls=%d", result, tool.calls)
+	}
+	if approver.calls != 2 {
+		t.Errorf("expected 2 approval requests, got %d", approver.calls)
+	}
+}
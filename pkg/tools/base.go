@@ -17,6 +17,14 @@ type ContextualTool interface {
 	SetContext(channel, chatID string)
 }
 
+// ConcurrentTool is an optional interface for tools that may run at the same
+// time as other calls from the same LLM turn. Tools that do not implement it,
+// or return false, always run on their own.
+type ConcurrentTool interface {
+	Tool
+	ConcurrencySafe() bool
+}
+
 // AsyncCallback is a function type that async tools use to notify completion.
 // When an async tool finishes its work, it calls this callback with the result.
 //
@@ -0,0 +1,141 @@
+package tools
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// Tools are shared between concurrently running sessions, so anything that
+// belongs to a single run (the originating chat, the async callback, what
+// the run has already sent) travels in the context passed to Execute rather
+// than in fields on the tool.
+
+type toolContextKey struct{}
+type asyncCallbackKey struct{}
+type roundKey struct{}
+type backgroundKey struct{}
+type usageKey struct{}
+
+type toolContext struct {
+	channel string
+	chatID  string
+}
+
+// WithToolContext returns a context that tells tools which channel and chat
+// the current run belongs to.
+func WithToolContext(ctx context.Context, channel, chatID string) context.Context {
+	return context.WithValue(ctx, toolContextKey{}, toolContext{channel: channel, chatID: chatID})
+}
+
+// ToolContextFrom returns the channel and chat ID stored by WithToolContext.
+func ToolContextFrom(ctx context.Context) (channel, chatID string, ok bool) {
+	tc, ok := ctx.Value(toolContextKey{}).(toolContext)
+	if !ok {
+		return "", "", false
+	}
+	return tc.channel, tc.chatID, true
+}
+
+// WithAsyncCallback returns a context carrying the completion callback for
+// async tools started during the current run.
+func WithAsyncCallback(ctx context.Context, cb AsyncCallback) context.Context {
+	return context.WithValue(ctx, asyncCallbackKey{}, cb)
+}
+
+// AsyncCallbackFrom returns the callback stored by WithAsyncCallback, or nil.
+func AsyncCallbackFrom(ctx context.Context) AsyncCallback {
+	cb, _ := ctx.Value(asyncCallbackKey{}).(AsyncCallback)
+	return cb
+}
+
+// UsageAccount charges the LLM calls that tools make on behalf of a run,
+// such as subagent runs, to the session the run belongs to.
+type UsageAccount struct {
+	// Record adds the token usage of one call to the usage ledger.
+	Record func(model string, usage *providers.UsageInfo)
+	// Budget is checked before each call. It returns the model to downgrade
+	// to, "" to keep the configured model, or an error when the call must be
+	// refused.
+	Budget func() (model string, err error)
+}
+
+// WithUsageAccount returns a context carrying the account LLM calls made by
+// tools during the current run are charged to.
+func WithUsageAccount(ctx context.Context, account UsageAccount) context.Context {
+	return context.WithValue(ctx, usageKey{}, account)
+}
+
+// UsageAccountFrom returns the account stored by WithUsageAccount, or the
+// zero account, which records nothing and never refuses a call.
+func UsageAccountFrom(ctx context.Context) UsageAccount {
+	account, _ := ctx.Value(usageKey{}).(UsageAccount)
+	return account
+}
+
+type background struct {
+	ctx  context.Context
+	hold func() (release func())
+}
+
+// WithBackground returns a context carrying bg, the context that work
+// outliving the current tool call (such as async subagents) should run
+// under. The agent cancels it when the user stops the session. hold is
+// called when such work starts and the func it returns when the work is
+// done, so the agent knows the session still has work running.
+func WithBackground(ctx, bg context.Context, hold func() (release func())) context.Context {
+	return context.WithValue(ctx, backgroundKey{}, background{ctx: bg, hold: hold})
+}
+
+// BackgroundFrom returns a context with the values of ctx that is cancelled
+// with the context stored by WithBackground instead of with ctx, and a func
+// the caller must call once its background work is done. It returns ctx
+// itself if no background context was set.
+func BackgroundFrom(ctx context.Context) (context.Context, func()) {
+	if bg, ok := ctx.Value(backgroundKey{}).(background); ok {
+		return backgroundContext{Context: bg.ctx, values: ctx}, bg.hold()
+	}
+	return ctx, func() {}
+}
+
+// backgroundContext takes cancellation from the embedded context and values
+// from another.
+type backgroundContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c backgroundContext) Value(key any) any {
+	return c.values.Value(key)
+}
+
+// Round records what tools did during one processing run.
+type Round struct {
+	messageSent atomic.Bool
+}
+
+// WithRound starts a new processing round and returns it alongside the
+// derived context.
+func WithRound(ctx context.Context) (context.Context, *Round) {
+	r := &Round{}
+	return context.WithValue(ctx, roundKey{}, r), r
+}
+
+// RoundFrom returns the round stored by WithRound, or nil.
+func RoundFrom(ctx context.Context) *Round {
+	r, _ := ctx.Value(roundKey{}).(*Round)
+	return r
+}
+
+// MessageSent reports whether the message tool delivered a message to the
+// user during this round.
+func (r *Round) MessageSent() bool {
+	return r != nil && r.messageSent.Load()
+}
+
+func (r *Round) markMessageSent() {
+	if r != nil {
+		r.messageSent.Store(true)
+	}
+}
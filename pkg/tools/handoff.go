@@ -0,0 +1,63 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+)
+
+// HandoffFunc hands the conversation of the current run over to
+// targetAgentID, or back to the agent it was handed over from if
+// targetAgentID is empty, and returns a note for the LLM.
+type HandoffFunc func(ctx context.Context, targetAgentID, summary string) (string, error)
+
+// HandoffTool lets an agent pass the live conversation with a user to
+// another agent, such as a specialist.
+type HandoffTool struct {
+	handoff HandoffFunc
+}
+
+func NewHandoffTool(handoff HandoffFunc) *HandoffTool {
+	return &HandoffTool{handoff: handoff}
+}
+
+func (t *HandoffTool) Name() string {
+	return "handoff"
+}
+
+func (t *HandoffTool) Description() string {
+	return "Hand the conversation with the user over to another agent that is better suited to it. The user's next messages go to that agent until it hands the conversation back or the handoff expires. Leave agent_id empty to hand the conversation back to the agent it came from. Tell the user about the handoff in your reply."
+}
+
+func (t *HandoffTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"agent_id": map[string]interface{}{
+				"type":        "string",
+				"description": "Agent to hand the conversation to; empty to hand it back",
+			},
+			"summary": map[string]interface{}{
+				"type":        "string",
+				"description": "What the receiving agent needs to know: what the user wants, what has been done and what is still open",
+			},
+		},
+		"required": []string{"summary"},
+	}
+}
+
+func (t *HandoffTool) Execute(ctx context.Context, args map[string]interface{}) *ToolResult {
+	summary, _ := args["summary"].(string)
+	if summary == "" {
+		return ErrorResult("summary is required")
+	}
+	agentID, _ := args["agent_id"].(string)
+
+	if t.handoff == nil {
+		return ErrorResult("handoff not configured")
+	}
+	note, err := t.handoff(ctx, agentID, summary)
+	if err != nil {
+		return ErrorResult(fmt.Sprintf("handoff failed: %v", err))
+	}
+	return NewToolResult(note)
+}
@@ -0,0 +1,227 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// DefaultMemorySection is where remembered facts go when no section is given.
+const DefaultMemorySection = "Important Notes"
+
+// MemoryBackend is the agent memory the memory tools work on: long-term
+// entries grouped by section, and a daily notes file. source names the
+// channel and peer the entry came from.
+type MemoryBackend interface {
+	// Remember adds text to a section of long-term memory. It returns the
+	// section already holding the same entry, if any, instead of adding it
+	// twice.
+	Remember(section, text, source string) (duplicateIn string, err error)
+	// Forget removes the long-term entries containing match, in section or
+	// in all sections if section is empty, and returns them.
+	Forget(section, match string) ([]string, error)
+	// Note appends a timestamped entry to today's daily notes.
+	Note(text, source string) error
+	// Recall returns the long-term entries and the daily notes of the last
+	// days days that contain every word of query, or all long-term memory
+	// if query is empty.
+	Recall(section, query string, days int) (string, error)
+}
+
+// memorySource describes where a memory entry came from.
+func memorySource(ctx context.Context) string {
+	channel, chatID, ok := ToolContextFrom(ctx)
+	if !ok || channel == "" {
+		return ""
+	}
+	if chatID == "" {
+		return channel
+	}
+	return channel + ":" + chatID
+}
+
+// RememberTool stores facts in long-term memory or today's notes.
+type RememberTool struct {
+	memory MemoryBackend
+}
+
+func NewRememberTool(memory MemoryBackend) *RememberTool {
+	return &RememberTool{memory: memory}
+}
+
+func (t *RememberTool) Name() string {
+	return "remember"
+}
+
+func (t *RememberTool) Description() string {
+	return "Save a fact to memory. Long-term memory is organized in sections (e.g. \"User Information\", \"Preferences\", \"Important Notes\") and the entries relevant to a conversation are shown to you automatically; use it for durable facts. Use daily notes for things that only matter for the next few days. Use this instead of editing memory files directly."
+}
+
+func (t *RememberTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"content": map[string]interface{}{
+				"type":        "string",
+				"description": "The fact to remember, as one short self-contained sentence",
+			},
+			"section": map[string]interface{}{
+				"type":        "string",
+				"description": "Long-term memory section (default: " + DefaultMemorySection + "). A new section is created if needed.",
+			},
+			"target": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{"long_term", "daily"},
+				"description": "Where to save it (default: long_term)",
+			},
+		},
+		"required": []string{"content"},
+	}
+}
+
+func (t *RememberTool) Execute(ctx context.Context, args map[string]interface{}) *ToolResult {
+	content, _ := args["content"].(string)
+	content = strings.Join(strings.Fields(content), " ")
+	if content == "" {
+		return ErrorResult("content is required")
+	}
+	target, _ := args["target"].(string)
+	source := memorySource(ctx)
+
+	switch target {
+	case "", "long_term":
+		section, _ := args["section"].(string)
+		section = strings.TrimSpace(section)
+		if section == "" {
+			section = DefaultMemorySection
+		}
+		duplicateIn, err := t.memory.Remember(section, content, source)
+		if err != nil {
+			return ErrorResult(fmt.Sprintf("failed to save memory: %v", err))
+		}
+		if duplicateIn != "" {
+			return SilentResult(fmt.Sprintf("Already remembered under %q", duplicateIn))
+		}
+		return SilentResult(fmt.Sprintf("Remembered under %q", section))
+	case "daily":
+		if err := t.memory.Note(content, source); err != nil {
+			return ErrorResult(fmt.Sprintf("failed to save note: %v", err))
+		}
+		return SilentResult("Added to today's notes")
+	default:
+		return ErrorResult(fmt.Sprintf("unknown target %q", target))
+	}
+}
+
+// ForgetTool removes entries from long-term memory.
+type ForgetTool struct {
+	memory MemoryBackend
+}
+
+func NewForgetTool(memory MemoryBackend) *ForgetTool {
+	return &ForgetTool{memory: memory}
+}
+
+func (t *ForgetTool) Name() string {
+	return "forget"
+}
+
+func (t *ForgetTool) Description() string {
+	return "Remove entries from long-term memory. Every entry containing the given text (case-insensitive) is removed, so be specific; use recall first if unsure."
+}
+
+func (t *ForgetTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"match": map[string]interface{}{
+				"type":        "string",
+				"description": "Text the entries to remove contain",
+			},
+			"section": map[string]interface{}{
+				"type":        "string",
+				"description": "Only remove entries from this section",
+			},
+		},
+		"required": []string{"match"},
+	}
+}
+
+func (t *ForgetTool) Execute(ctx context.Context, args map[string]interface{}) *ToolResult {
+	match, _ := args["match"].(string)
+	match = strings.TrimSpace(match)
+	if match == "" {
+		return ErrorResult("match is required")
+	}
+	section, _ := args["section"].(string)
+
+	removed, err := t.memory.Forget(strings.TrimSpace(section), match)
+	if err != nil {
+		return ErrorResult(fmt.Sprintf("failed to update memory: %v", err))
+	}
+	if len(removed) == 0 {
+		return SilentResult(fmt.Sprintf("No memory entries contain %q", match))
+	}
+	return SilentResult("Removed from memory:\n- " + strings.Join(removed, "\n- "))
+}
+
+// RecallTool searches memory.
+type RecallTool struct {
+	memory MemoryBackend
+}
+
+func NewRecallTool(memory MemoryBackend) *RecallTool {
+	return &RecallTool{memory: memory}
+}
+
+func (t *RecallTool) Name() string {
+	return "recall"
+}
+
+func (t *RecallTool) Description() string {
+	return "Search long-term memory and recent daily notes. Returns the entries containing every word of the query, or all long-term memory when the query is empty."
+}
+
+func (t *RecallTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"query": map[string]interface{}{
+				"type":        "string",
+				"description": "Words to look for",
+			},
+			"section": map[string]interface{}{
+				"type":        "string",
+				"description": "Only search this long-term memory section",
+			},
+			"days": map[string]interface{}{
+				"type":        "integer",
+				"description": "How many days of daily notes to search (default: 7)",
+			},
+		},
+	}
+}
+
+// ConcurrencySafe reports that recall only reads memory. remember and
+// forget write it, so they run one at a time.
+func (t *RecallTool) ConcurrencySafe() bool {
+	return true
+}
+
+func (t *RecallTool) Execute(ctx context.Context, args map[string]interface{}) *ToolResult {
+	query, _ := args["query"].(string)
+	section, _ := args["section"].(string)
+	days := 7
+	if d, ok := args["days"].(float64); ok && d >= 0 {
+		days = int(d)
+	}
+
+	found, err := t.memory.Recall(strings.TrimSpace(section), strings.TrimSpace(query), days)
+	if err != nil {
+		return ErrorResult(fmt.Sprintf("failed to read memory: %v", err))
+	}
+	if found == "" {
+		return SilentResult("Nothing found in memory")
+	}
+	return SilentResult(found)
+}
@@ -0,0 +1,91 @@
+package tools
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+type fakeMemory struct {
+	section, text, source string
+	notes                 []string
+}
+
+func (m *fakeMemory) Remember(section, text, source string) (string, error) {
+	if m.text == text {
+		return m.section, nil
+	}
+	m.section, m.text, m.source = section, text, source
+	return "", nil
+}
+
+func (m *fakeMemory) Forget(section, match string) ([]string, error) {
+	if m.text != "" && strings.Contains(m.text, match) {
+		removed := m.text
+		m.text = ""
+		return []string{removed}, nil
+	}
+	return nil, nil
+}
+
+func (m *fakeMemory) Note(text, source string) error {
+	m.notes = append(m.notes, text+" ("+source+")")
+	return nil
+}
+
+func (m *fakeMemory) Recall(section, query string, days int) (string, error) {
+	return "", nil
+}
+
+func TestRememberTool_RecordsSource(t *testing.T) {
+	mem := &fakeMemory{}
+	tool := NewRememberTool(mem)
+	ctx := WithToolContext(context.Background(), "telegram", "42")
+
+	result := tool.Execute(ctx, map[string]interface{}{"content": "  likes   tea "})
+	if result.IsError || mem.section != DefaultMemorySection || mem.text != "likes tea" || mem.source != "telegram:42" {
+		t.Fatalf("unexpected remember: %+v, %+v", result, mem)
+	}
+
+	result = tool.Execute(ctx, map[string]interface{}{"content": "likes tea", "section": "Preferences"})
+	if !strings.Contains(result.ForLLM, "Already remembered") {
+		t.Errorf("expected duplicate to be reported, got %q", result.ForLLM)
+	}
+
+	tool.Execute(ctx, map[string]interface{}{"content": "call back", "target": "daily"})
+	if len(mem.notes) != 1 || mem.notes[0] != "call back (telegram:42)" {
+		t.Errorf("unexpected notes: %v", mem.notes)
+	}
+
+	if result := tool.Execute(ctx, map[string]interface{}{"content": ""}); !result.IsError {
+		t.Error("expected error for empty content")
+	}
+}
+
+func TestForgetTool_ReportsRemoved(t *testing.T) {
+	mem := &fakeMemory{text: "likes tea"}
+	tool := NewForgetTool(mem)
+
+	result := tool.Execute(context.Background(), map[string]interface{}{"match": "coffee"})
+	if !strings.Contains(result.ForLLM, "No memory entries") {
+		t.Errorf("unexpected result: %q", result.ForLLM)
+	}
+	result = tool.Execute(context.Background(), map[string]interface{}{"match": "tea"})
+	if !strings.Contains(result.ForLLM, "Removed from memory") || !strings.Contains(result.ForLLM, "likes tea") {
+		t.Errorf("unexpected result: %q", result.ForLLM)
+	}
+}
+
+func TestMemoryTools_OnlyRecallRunsConcurrently(t *testing.T) {
+	mem := &fakeMemory{}
+	r := NewToolRegistry()
+	r.Register(NewRememberTool(mem))
+	r.Register(NewForgetTool(mem))
+	r.Register(NewRecallTool(mem))
+
+	for name, want := range map[string]bool{"remember": false, "forget": false, "recall": true} {
+		if got := r.IsConcurrencySafe(name); got != want {
+			t.Errorf("IsConcurrencySafe(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
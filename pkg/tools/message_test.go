@@ -257,3 +257,30 @@ func TestMessageTool_Parameters(t *testing.T) {
 		t.Error("Expected chat_id type to be 'string'")
 	}
 }
+
+func TestMessageTool_Execute_UsesRunContext(t *testing.T) {
+	tool := NewMessageTool()
+	tool.SetContext("default-channel", "default-chat-id")
+
+	var sentChannel, sentChatID string
+	tool.SetSendCallback(func(channel, chatID, content string) error {
+		sentChannel = channel
+		sentChatID = chatID
+		return nil
+	})
+
+	ctx, round := WithRound(WithToolContext(context.Background(), "run-channel", "run-chat-id"))
+	tool.Execute(ctx, map[string]interface{}{"content": "hi"})
+
+	if sentChannel != "run-channel" || sentChatID != "run-chat-id" {
+		t.Errorf("Expected run-channel:run-chat-id, got %s:%s", sentChannel, sentChatID)
+	}
+	if !round.MessageSent() {
+		t.Error("Expected round to record the sent message")
+	}
+
+	_, other := WithRound(context.Background())
+	if other.MessageSent() {
+		t.Error("Expected a fresh round to start unsent")
+	}
+}
@@ -3,6 +3,7 @@ package tools
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -11,8 +12,10 @@ import (
 )
 
 type ToolRegistry struct {
-	tools map[string]Tool
-	mu    sync.RWMutex
+	tools    map[string]Tool
+	mu       sync.RWMutex
+	policy   *ApprovalPolicy
+	approver Approver
 }
 
 func NewToolRegistry() *ToolRegistry {
@@ -27,6 +30,14 @@ func (r *ToolRegistry) Register(tool Tool) {
 	r.tools[tool.Name()] = tool
 }
 
+// SetApproval makes calls matching policy wait for approver before they run.
+func (r *ToolRegistry) SetApproval(policy *ApprovalPolicy, approver Approver) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.policy = policy
+	r.approver = approver
+}
+
 func (r *ToolRegistry) Get(name string) (Tool, bool) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -39,8 +50,7 @@ func (r *ToolRegistry) Execute(ctx context.Context, name string, args map[string
 }
 
 // ExecuteWithContext executes a tool with channel/chatID context and optional async callback.
-// If the tool implements AsyncTool and a non-nil callback is provided,
-// the callback will be set on the tool before execution.
+// Both are passed to the tool through ctx (see ToolContextFrom and AsyncCallbackFrom).
 func (r *ToolRegistry) ExecuteWithContext(ctx context.Context, name string, args map[string]interface{}, channel, chatID string, asyncCallback AsyncCallback) *ToolResult {
 	logger.InfoCF("tool", "Tool execution started",
 		map[string]interface{}{
@@ -57,14 +67,31 @@ func (r *ToolRegistry) ExecuteWithContext(ctx context.Context, name string, args
 		return ErrorResult(fmt.Sprintf("tool %q not found", name)).WithError(fmt.Errorf("tool not found"))
 	}
 
-	// If tool implements ContextualTool, set context
-	if contextualTool, ok := tool.(ContextualTool); ok && channel != "" && chatID != "" {
-		contextualTool.SetContext(channel, chatID)
+	// Channel, chat and callback go into ctx rather than onto the tool, since
+	// the same tool instance may be executing for several sessions at once.
+	if channel != "" && chatID != "" {
+		ctx = WithToolContext(ctx, channel, chatID)
+	}
+
+	r.mu.RLock()
+	policy, approver := r.policy, r.approver
+	r.mu.RUnlock()
+	if approver != nil {
+		req := ApprovalRequest{Tool: name, Args: args, Channel: channel, ChatID: chatID}
+		if policy.Requires(req) {
+			if err := approver.RequestApproval(ctx, req); err != nil {
+				logger.WarnCF("tool", "Tool call not approved",
+					map[string]interface{}{
+						"tool":  name,
+						"error": err.Error(),
+					})
+				return ErrorResult(fmt.Sprintf("tool %q was not run: %v", name, err)).WithError(err)
+			}
+		}
 	}
 
-	// If tool implements AsyncTool and callback is provided, set callback
-	if asyncTool, ok := tool.(AsyncTool); ok && asyncCallback != nil {
-		asyncTool.SetCallback(asyncCallback)
+	if _, ok := tool.(AsyncTool); ok && asyncCallback != nil {
+		ctx = WithAsyncCallback(ctx, asyncCallback)
 		logger.DebugCF("tool", "Async callback injected",
 			map[string]interface{}{
 				"tool": name,
@@ -101,25 +128,106 @@ func (r *ToolRegistry) ExecuteWithContext(ctx context.Context, name string, args
 	return result
 }
 
+// IsConcurrencySafe reports whether the named tool may run alongside other
+// calls from the same turn.
+func (r *ToolRegistry) IsConcurrencySafe(name string) bool {
+	tool, ok := r.Get(name)
+	if !ok {
+		return false
+	}
+	ct, ok := tool.(ConcurrentTool)
+	return ok && ct.ConcurrencySafe()
+}
+
+// ExecuteToolCalls runs the tool calls from one LLM turn and returns their
+// results in call order. When parallel is set, each run of consecutive calls
+// to concurrency-safe tools executes at once; all other calls run alone.
+// Calls share ctx but not fate: an error, timeout or panic in one call is
+// reported in its own result and does not stop the others.
+func (r *ToolRegistry) ExecuteToolCalls(ctx context.Context, calls []providers.ToolCall, channel, chatID string, parallel bool, callbackFor func(tc providers.ToolCall) AsyncCallback) []*ToolResult {
+	results := make([]*ToolResult, len(calls))
+	run := func(i int) {
+		defer func() {
+			if p := recover(); p != nil {
+				logger.ErrorCF("tool", "Tool panicked",
+					map[string]interface{}{
+						"tool":  calls[i].Name,
+						"panic": fmt.Sprint(p),
+					})
+				results[i] = ErrorResult(fmt.Sprintf("tool %q panicked: %v", calls[i].Name, p)).
+					WithError(fmt.Errorf("panic: %v", p))
+			}
+		}()
+		var cb AsyncCallback
+		if callbackFor != nil {
+			cb = callbackFor(calls[i])
+		}
+		results[i] = r.ExecuteWithContext(ctx, calls[i].Name, calls[i].Arguments, channel, chatID, cb)
+	}
+
+	for i := 0; i < len(calls); {
+		j := i + 1
+		if parallel && r.IsConcurrencySafe(calls[i].Name) {
+			for j < len(calls) && r.IsConcurrencySafe(calls[j].Name) {
+				j++
+			}
+		}
+
+		if j-i == 1 {
+			run(i)
+		} else {
+			var wg sync.WaitGroup
+			for k := i; k < j; k++ {
+				wg.Add(1)
+				go func(k int) {
+					defer wg.Done()
+					run(k)
+				}(k)
+			}
+			wg.Wait()
+		}
+		i = j
+	}
+
+	return results
+}
+
+// sorted returns the registered tools ordered by name, so that requests
+// built from them are identical from one call to the next and providers
+// can cache them. Callers must hold r.mu.
+func (r *ToolRegistry) sorted() []Tool {
+	names := make([]string, 0, len(r.tools))
+	for name := range r.tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sorted := make([]Tool, len(names))
+	for i, name := range names {
+		sorted[i] = r.tools[name]
+	}
+	return sorted
+}
+
 func (r *ToolRegistry) GetDefinitions() []map[string]interface{} {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	definitions := make([]map[string]interface{}, 0, len(r.tools))
-	for _, tool := range r.tools {
+	for _, tool := range r.sorted() {
 		definitions = append(definitions, ToolToSchema(tool))
 	}
 	return definitions
 }
 
 // ToProviderDefs converts tool definitions to provider-compatible format.
-// This is the format expected by LLM provider APIs.
+// This is the format expected by LLM provider APIs. Tools are ordered by
+// name.
 func (r *ToolRegistry) ToProviderDefs() []providers.ToolDefinition {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	definitions := make([]providers.ToolDefinition, 0, len(r.tools))
-	for _, tool := range r.tools {
+	for _, tool := range r.sorted() {
 		schema := ToolToSchema(tool)
 
 		// Safely extract nested values with type checks
@@ -144,7 +252,7 @@ func (r *ToolRegistry) ToProviderDefs() []providers.ToolDefinition {
 	return definitions
 }
 
-// List returns a list of all registered tool names.
+// List returns the names of all registered tools in order.
 func (r *ToolRegistry) List() []string {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -153,6 +261,7 @@ func (r *ToolRegistry) List() []string {
 	for name := range r.tools {
 		names = append(names, name)
 	}
+	sort.Strings(names)
 	return names
 }
 
@@ -170,7 +279,7 @@ func (r *ToolRegistry) GetSummaries() []string {
 	defer r.mu.RUnlock()
 
 	summaries := make([]string, 0, len(r.tools))
-	for _, tool := range r.tools {
+	for _, tool := range r.sorted() {
 		summaries = append(summaries, fmt.Sprintf("- `%s` - %s", tool.Name(), tool.Description()))
 	}
 	return summaries
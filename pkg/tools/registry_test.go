@@ -0,0 +1,128 @@
+package tools
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// sleepTool sleeps for the requested number of milliseconds and echoes its name.
+type sleepTool struct {
+	name    string
+	safe    bool
+	running *atomic.Int32
+	peak    *atomic.Int32
+}
+
+func (t *sleepTool) Name() string                       { return t.name }
+func (t *sleepTool) Description() string                { return "sleeps" }
+func (t *sleepTool) Parameters() map[string]interface{} { return map[string]interface{}{} }
+func (t *sleepTool) ConcurrencySafe() bool              { return t.safe }
+
+func (t *sleepTool) Execute(ctx context.Context, args map[string]interface{}) *ToolResult {
+	n := t.running.Add(1)
+	for {
+		p := t.peak.Load()
+		if n <= p || t.peak.CompareAndSwap(p, n) {
+			break
+		}
+	}
+	defer t.running.Add(-1)
+
+	if args["panic"] == true {
+		panic("boom")
+	}
+	ms, _ := args["ms"].(float64)
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	id, _ := args["id"].(string)
+	return NewToolResult(id)
+}
+
+func newSleepRegistry(safe bool) (*ToolRegistry, *atomic.Int32) {
+	var running, peak atomic.Int32
+	r := NewToolRegistry()
+	r.Register(&sleepTool{name: "sleep", safe: safe, running: &running, peak: &peak})
+	return r, &peak
+}
+
+func sleepCalls(ms ...float64) []providers.ToolCall {
+	calls := make([]providers.ToolCall, len(ms))
+	for i, d := range ms {
+		calls[i] = providers.ToolCall{
+			ID:        string(rune('a' + i)),
+			Name:      "sleep",
+			Arguments: map[string]interface{}{"ms": d, "id": string(rune('a' + i))},
+		}
+	}
+	return calls
+}
+
+func TestExecuteToolCalls_ParallelKeepsOrder(t *testing.T) {
+	r, peak := newSleepRegistry(true)
+
+	results := r.ExecuteToolCalls(context.Background(), sleepCalls(30, 10, 20), "", "", true, nil)
+
+	for i, want := range []string{"a", "b", "c"} {
+		if results[i].ForLLM != want {
+			t.Errorf("results[%d] = %q, want %q", i, results[i].ForLLM, want)
+		}
+	}
+	if peak.Load() < 2 {
+		t.Errorf("expected calls to overlap, peak concurrency = %d", peak.Load())
+	}
+}
+
+func TestExecuteToolCalls_UnsafeToolsRunSequentially(t *testing.T) {
+	r, peak := newSleepRegistry(false)
+
+	r.ExecuteToolCalls(context.Background(), sleepCalls(10, 10, 10), "", "", true, nil)
+
+	if peak.Load() != 1 {
+		t.Errorf("expected sequential execution, peak concurrency = %d", peak.Load())
+	}
+}
+
+func TestExecuteToolCalls_SequentialWhenDisabled(t *testing.T) {
+	r, peak := newSleepRegistry(true)
+
+	r.ExecuteToolCalls(context.Background(), sleepCalls(10, 10, 10), "", "", false, nil)
+
+	if peak.Load() != 1 {
+		t.Errorf("expected sequential execution, peak concurrency = %d", peak.Load())
+	}
+}
+
+func TestExecuteToolCalls_PanicDoesNotAffectOthers(t *testing.T) {
+	r, _ := newSleepRegistry(true)
+	calls := sleepCalls(10, 10, 10)
+	calls[1].Arguments["panic"] = true
+
+	results := r.ExecuteToolCalls(context.Background(), calls, "", "", true, nil)
+
+	if !results[1].IsError {
+		t.Errorf("expected panicking call to return an error result")
+	}
+	if results[0].ForLLM != "a" || results[2].ForLLM != "c" {
+		t.Errorf("expected other calls to succeed, got %q and %q", results[0].ForLLM, results[2].ForLLM)
+	}
+}
+
+func TestToProviderDefs_SortedByName(t *testing.T) {
+	var running, peak atomic.Int32
+	r := NewToolRegistry()
+	for _, name := range []string{"write", "exec", "read", "alpha", "message"} {
+		r.Register(&sleepTool{name: name, running: &running, peak: &peak})
+	}
+	want := []string{"alpha", "exec", "message", "read", "write"}
+	for i := 0; i < 5; i++ {
+		defs := r.ToProviderDefs()
+		for j, def := range defs {
+			if def.Function.Name != want[j] {
+				t.Fatalf("tool %d = %s, want %s", j, def.Function.Name, want[j])
+			}
+		}
+	}
+}
@@ -84,8 +84,17 @@ func (t *SpawnTool) Execute(ctx context.Context, args map[string]interface{}) *T
 		return ErrorResult("Subagent manager not configured")
 	}
 
+	originChannel, originChatID := t.originChannel, t.originChatID
+	if channel, chatID, ok := ToolContextFrom(ctx); ok {
+		originChannel, originChatID = channel, chatID
+	}
+	callback := t.callback
+	if cb := AsyncCallbackFrom(ctx); cb != nil {
+		callback = cb
+	}
+
 	// Pass callback to manager for async completion notification
-	result, err := t.manager.Spawn(ctx, task, label, agentID, t.originChannel, t.originChatID, t.callback)
+	result, err := t.manager.Spawn(ctx, task, label, agentID, originChannel, originChatID, callback)
 	if err != nil {
 		return ErrorResult(fmt.Sprintf("failed to spawn subagent: %v", err))
 	}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/sipeed/picoclaw/pkg/bus"
 	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/utils"
 )
 
 type SubagentTask struct {
@@ -31,6 +32,7 @@ type SubagentManager struct {
 	workspace     string
 	tools         *ToolRegistry
 	maxIterations int
+	llmOptions    map[string]any
 	nextID        int
 }
 
@@ -55,6 +57,14 @@ func (sm *SubagentManager) SetTools(tools *ToolRegistry) {
 	sm.tools = tools
 }
 
+// SetLLMOptions sets the generation options sent with each subagent LLM call.
+// If not set, RunToolLoop's defaults are used.
+func (sm *SubagentManager) SetLLMOptions(opts map[string]any) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.llmOptions = opts
+}
+
 // RegisterTool registers a tool for subagent execution.
 func (sm *SubagentManager) RegisterTool(tool Tool) {
 	sm.mu.Lock()
@@ -82,7 +92,13 @@ func (sm *SubagentManager) Spawn(ctx context.Context, task, label, agentID, orig
 	sm.tasks[taskID] = subagentTask
 
 	// Start task in background with context cancellation support
-	go sm.runTask(ctx, subagentTask, callback)
+	// The task outlives this tool call, so it runs under the session's
+	// background context rather than the current run's.
+	bg, done := BackgroundFrom(ctx)
+	go func() {
+		defer done()
+		sm.runTask(bg, subagentTask, callback)
+	}()
 
 	if label != "" {
 		return fmt.Sprintf("Spawned subagent '%s' for task: %s", label, task), nil
@@ -125,17 +141,18 @@ After completing the task, provide a clear summary of what was done.`
 	sm.mu.RLock()
 	tools := sm.tools
 	maxIter := sm.maxIterations
+	llmOpts := sm.llmOptions
 	sm.mu.RUnlock()
 
+	account := UsageAccountFrom(ctx)
 	loopResult, err := RunToolLoop(ctx, ToolLoopConfig{
 		Provider:      sm.provider,
 		Model:         sm.defaultModel,
 		Tools:         tools,
 		MaxIterations: maxIter,
-		LLMOptions: map[string]any{
-			"max_tokens":  4096,
-			"temperature": 0.7,
-		},
+		LLMOptions:    llmOpts,
+		OnUsage:       account.Record,
+		Budget:        account.Budget,
 	}, messages, task.OriginChannel, task.OriginChatID)
 
 	sm.mu.Lock()
@@ -244,6 +261,10 @@ func (t *SubagentTool) Parameters() map[string]interface{} {
 				"type":        "string",
 				"description": "Optional short label for the task (for display)",
 			},
+			"output_schema": map[string]interface{}{
+				"type":        "object",
+				"description": "Optional JSON schema the result must match; the result is then returned as JSON",
+			},
 		},
 		"required": []string{"task"},
 	}
@@ -262,6 +283,11 @@ func (t *SubagentTool) Execute(ctx context.Context, args map[string]interface{})
 
 	label, _ := args["label"].(string)
 
+	var format *providers.ResponseFormat
+	if schema, ok := args["output_schema"].(map[string]interface{}); ok && len(schema) > 0 {
+		format = &providers.ResponseFormat{Name: "subagent_result", Schema: schema}
+	}
+
 	if t.manager == nil {
 		return ErrorResult("Subagent manager not configured").WithError(fmt.Errorf("manager is nil"))
 	}
@@ -278,35 +304,47 @@ func (t *SubagentTool) Execute(ctx context.Context, args map[string]interface{})
 		},
 	}
 
+	originChannel, originChatID := t.originChannel, t.originChatID
+	if channel, chatID, ok := ToolContextFrom(ctx); ok {
+		originChannel, originChatID = channel, chatID
+	}
+
 	// Use RunToolLoop to execute with tools (same as async SpawnTool)
 	sm := t.manager
 	sm.mu.RLock()
 	tools := sm.tools
 	maxIter := sm.maxIterations
+	llmOpts := sm.llmOptions
 	sm.mu.RUnlock()
 
+	account := UsageAccountFrom(ctx)
 	loopResult, err := RunToolLoop(ctx, ToolLoopConfig{
-		Provider:      sm.provider,
-		Model:         sm.defaultModel,
-		Tools:         tools,
-		MaxIterations: maxIter,
-		LLMOptions: map[string]any{
-			"max_tokens":  4096,
-			"temperature": 0.7,
-		},
-	}, messages, t.originChannel, t.originChatID)
+		Provider:       sm.provider,
+		Model:          sm.defaultModel,
+		Tools:          tools,
+		MaxIterations:  maxIter,
+		LLMOptions:     llmOpts,
+		ResponseFormat: format,
+		OnUsage:        account.Record,
+		Budget:         account.Budget,
+	}, messages, originChannel, originChatID)
 
 	if err != nil {
 		return ErrorResult(fmt.Sprintf("Subagent execution failed: %v", err)).WithError(err)
 	}
 
-	// ForUser: Brief summary for user (truncated if too long)
-	userContent := loopResult.Content
-	maxUserLen := 500
-	if len(userContent) > maxUserLen {
-		userContent = userContent[:maxUserLen] + "..."
+	if format != nil {
+		value, err := providers.ParseStructured(format, loopResult.Content)
+		if err != nil {
+			return ErrorResult(fmt.Sprintf("Subagent result does not match output_schema: %v\nResult: %s",
+				err, loopResult.Content)).WithError(err)
+		}
+		loopResult.Content = string(value)
 	}
 
+	// ForUser: Brief summary for user (truncated if too long)
+	userContent := utils.Truncate(loopResult.Content, 500)
+
 	// ForLLM: Full execution details
 	labelStr := label
 	if labelStr == "" {
@@ -313,3 +313,72 @@ func TestSubagentTool_ForUserTruncation(t *testing.T) {
 		t.Error("ForLLM should contain reference to original task")
 	}
 }
+
+// structuredMockProvider replies with prose first and with JSON once it is
+// asked to correct its reply.
+type structuredMockProvider struct {
+	calls   int
+	formats []*providers.ResponseFormat
+}
+
+func (m *structuredMockProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, options map[string]interface{}) (*providers.LLMResponse, error) {
+	m.calls++
+	m.formats = append(m.formats, providers.ResponseFormatFrom(options))
+	if strings.Contains(messages[len(messages)-1].Content, "did not match") {
+		return &providers.LLMResponse{Content: "```json\n{\"count\": 3}\n```"}, nil
+	}
+	return &providers.LLMResponse{Content: "There are three."}, nil
+}
+
+func (m *structuredMockProvider) GetDefaultModel() string {
+	return "test-model"
+}
+
+// TestSubagentTool_Execute_OutputSchema verifies typed results are requested,
+// repaired once and returned as JSON
+func TestSubagentTool_Execute_OutputSchema(t *testing.T) {
+	provider := &structuredMockProvider{}
+	manager := NewSubagentManager(provider, "test-model", "/tmp/test", nil)
+	tool := NewSubagentTool(manager)
+
+	result := tool.Execute(context.Background(), map[string]interface{}{
+		"task": "Count the files",
+		"output_schema": map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"count": map[string]interface{}{"type": "integer"}},
+			"required":   []interface{}{"count"},
+		},
+	})
+
+	if result.IsError {
+		t.Fatalf("Expected success, got error: %s", result.ForLLM)
+	}
+	if provider.calls != 2 {
+		t.Errorf("Expected one repair call, got %d calls", provider.calls)
+	}
+	if provider.formats[0] == nil || provider.formats[0].Name != "subagent_result" {
+		t.Errorf("Expected the output schema as response format, got %v", provider.formats[0])
+	}
+	if !strings.Contains(result.ForLLM, `Result: {"count": 3}`) {
+		t.Errorf("ForLLM should contain the JSON result, got: %s", result.ForLLM)
+	}
+}
+
+// TestSubagentTool_Execute_OutputSchemaMismatch verifies a result that still
+// does not match after the repair is reported as an error
+func TestSubagentTool_Execute_OutputSchemaMismatch(t *testing.T) {
+	manager := NewSubagentManager(&MockLLMProvider{}, "test-model", "/tmp/test", nil)
+	tool := NewSubagentTool(manager)
+
+	result := tool.Execute(context.Background(), map[string]interface{}{
+		"task":          "Count the files",
+		"output_schema": map[string]interface{}{"type": "object"},
+	})
+
+	if !result.IsError {
+		t.Fatal("Expected an error for a result that is not JSON")
+	}
+	if !strings.Contains(result.ForLLM, "does not match output_schema") {
+		t.Errorf("Error should mention output_schema, got: %s", result.ForLLM)
+	}
+}
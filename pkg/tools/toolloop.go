@@ -23,6 +23,16 @@ type ToolLoopConfig struct {
 	Tools         *ToolRegistry
 	MaxIterations int
 	LLMOptions    map[string]any
+	// ResponseFormat, when set, asks for a final reply that is JSON matching
+	// its schema. A reply that does not match is sent back once for the
+	// model to correct.
+	ResponseFormat *providers.ResponseFormat
+	// OnUsage, when set, is called with the token usage of each LLM call.
+	OnUsage func(model string, usage *providers.UsageInfo)
+	// Budget, when set, is checked before each LLM call. It returns the
+	// model to use instead of Model, "" to keep Model, or an error that ends
+	// the loop.
+	Budget func() (model string, err error)
 }
 
 // ToolLoopResult contains the result of running the tool loop.
@@ -36,6 +46,7 @@ type ToolLoopResult struct {
 func RunToolLoop(ctx context.Context, config ToolLoopConfig, messages []providers.Message, channel, chatID string) (*ToolLoopResult, error) {
 	iteration := 0
 	var finalContent string
+	repaired := false
 
 	for iteration < config.MaxIterations {
 		iteration++
@@ -60,9 +71,27 @@ func RunToolLoop(ctx context.Context, config ToolLoopConfig, messages []provider
 				"temperature": 0.7,
 			}
 		}
+		if config.ResponseFormat != nil {
+			opts := make(map[string]any, len(llmOpts)+1)
+			for k, v := range llmOpts {
+				opts[k] = v
+			}
+			opts[providers.ResponseFormatOption] = config.ResponseFormat
+			llmOpts = opts
+		}
 
-		// 3. Call LLM
-		response, err := config.Provider.Chat(ctx, messages, providerToolDefs, config.Model, llmOpts)
+		// 3. Check the budget, then call LLM
+		model := config.Model
+		if config.Budget != nil {
+			downgrade, err := config.Budget()
+			if err != nil {
+				return nil, err
+			}
+			if downgrade != "" {
+				model = downgrade
+			}
+		}
+		response, err := config.Provider.Chat(ctx, messages, providerToolDefs, model, llmOpts)
 		if err != nil {
 			logger.ErrorCF("toolloop", "LLM call failed",
 				map[string]any{
@@ -71,9 +100,22 @@ func RunToolLoop(ctx context.Context, config ToolLoopConfig, messages []provider
 				})
 			return nil, fmt.Errorf("LLM call failed: %w", err)
 		}
+		if config.OnUsage != nil && response.Usage != nil {
+			config.OnUsage(model, response.Usage)
+		}
 
 		// 4. If no tool calls, we're done
 		if len(response.ToolCalls) == 0 {
+			if next := providers.RepairStructured(config.ResponseFormat, messages, response.Content, repaired); next != nil {
+				logger.WarnCF("toolloop", "Reply does not match the response format, asking again",
+					map[string]any{
+						"format":    config.ResponseFormat.SchemaName(),
+						"iteration": iteration,
+					})
+				messages = next
+				repaired = true
+				continue
+			}
 			finalContent = response.Content
 			logger.InfoCF("toolloop", "LLM response without tool calls (direct answer)",
 				map[string]any{
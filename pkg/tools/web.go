@@ -291,6 +291,11 @@ func (t *WebSearchTool) Name() string {
 	return "web_search"
 }
 
+// ConcurrencySafe reports that searches are independent of each other.
+func (t *WebSearchTool) ConcurrencySafe() bool {
+	return true
+}
+
 func (t *WebSearchTool) Description() string {
 	return "Search the web for current information. Returns titles, URLs, and snippets from search results."
 }
@@ -355,6 +360,11 @@ func (t *WebFetchTool) Name() string {
 	return "web_fetch"
 }
 
+// ConcurrencySafe reports that fetches are independent of each other.
+func (t *WebFetchTool) ConcurrencySafe() bool {
+	return true
+}
+
 func (t *WebFetchTool) Description() string {
 	return "Fetch a URL and extract readable content (HTML to text). Use this to get weather info, news, articles, or any web content."
 }
@@ -0,0 +1,350 @@
+package usage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+// ErrBudgetExceeded is returned by CheckBudget when an agent has spent its
+// daily or monthly budget.
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+// Record is one LLM call in the ledger.
+type Record struct {
+	Time             time.Time `json:"time"`
+	AgentID          string    `json:"agent_id"`
+	SessionKey       string    `json:"session_key,omitempty"`
+	Model            string    `json:"model"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	TotalTokens      int       `json:"total_tokens"`
+	// CachedTokens is the part of PromptTokens read from a prompt cache.
+	CachedTokens int     `json:"cached_tokens,omitempty"`
+	CostUSD      float64 `json:"cost_usd"`
+}
+
+// Day returns the local calendar day of the record, e.g. "2026-02-14".
+func (r Record) Day() string {
+	return r.Time.Local().Format("2006-01-02")
+}
+
+// Totals aggregates a set of records.
+type Totals struct {
+	Calls            int
+	PromptTokens     int
+	CompletionTokens int
+	TotalTokens      int
+	CachedTokens     int
+	CostUSD          float64
+}
+
+func (t *Totals) add(r Record) {
+	t.Calls++
+	t.PromptTokens += r.PromptTokens
+	t.CompletionTokens += r.CompletionTokens
+	t.TotalTokens += r.TotalTokens
+	t.CachedTokens += r.CachedTokens
+	t.CostUSD += r.CostUSD
+}
+
+// Ledger appends usage records to a JSONL file in the workspace and keeps
+// running per-agent spend for the current day and month so budget checks do
+// not have to re-read the file. The first record of a new month moves the
+// file aside as usage-2006-01.jsonl, so reading a month's usage only reads
+// that month's records.
+type Ledger struct {
+	path    string
+	prices  map[string]config.ModelPrice
+	mu      sync.Mutex
+	daily   map[string]float64 // "agent|2006-01-02" -> USD
+	monthly map[string]float64 // "agent|2006-01" -> USD
+}
+
+// LedgerPath returns the location of the ledger file for a workspace.
+func LedgerPath(workspace string) string {
+	return filepath.Join(workspace, "state", "usage.jsonl")
+}
+
+// NewLedger opens the ledger at path, loading this month's spend.
+func NewLedger(path string, prices map[string]config.ModelPrice) *Ledger {
+	l := &Ledger{
+		path:    path,
+		prices:  prices,
+		daily:   make(map[string]float64),
+		monthly: make(map[string]float64),
+	}
+
+	monthStart := StartOfMonth(time.Now())
+	records, _ := readRecords(path, func(r Record) bool { return !r.Time.Before(monthStart) })
+	for _, r := range records {
+		l.track(r)
+	}
+	return l
+}
+
+// Cost estimates the USD cost of a call from the price table. Models are
+// looked up by full name first, then without a "provider/" prefix. Unpriced
+// models cost nothing.
+func (l *Ledger) Cost(model string, promptTokens, completionTokens int) float64 {
+	price, ok := l.price(model)
+	if !ok {
+		return 0
+	}
+	return (float64(promptTokens)*price.Input + float64(completionTokens)*price.Output) / 1e6
+}
+
+// cost prices r, charging its cached prompt tokens at the cached input
+// price if the model has one.
+func (l *Ledger) cost(r Record) float64 {
+	cost := l.Cost(r.Model, r.PromptTokens, r.CompletionTokens)
+	if price, ok := l.price(r.Model); ok && price.CachedInput > 0 && r.CachedTokens > 0 {
+		cost -= float64(r.CachedTokens) * (price.Input - price.CachedInput) / 1e6
+	}
+	return cost
+}
+
+func (l *Ledger) price(model string) (config.ModelPrice, bool) {
+	price, ok := l.prices[model]
+	if !ok {
+		if idx := strings.LastIndex(model, "/"); idx >= 0 {
+			price, ok = l.prices[model[idx+1:]]
+		}
+	}
+	return price, ok
+}
+
+// Record prices r, appends it to the ledger file and updates running spend.
+func (l *Ledger) Record(r Record) error {
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	if r.TotalTokens == 0 {
+		r.TotalTokens = r.PromptTokens + r.CompletionTokens
+	}
+	r.CostUSD = l.cost(r)
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to marshal usage record: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.track(r)
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return fmt.Errorf("failed to create usage directory: %w", err)
+	}
+	if err := l.rotate(r.Time); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open usage ledger: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write usage record: %w", err)
+	}
+	return nil
+}
+
+// rotate moves the ledger file aside when its last record was written in an
+// earlier month than now. Must be called with the lock held.
+func (l *Ledger) rotate(now time.Time) error {
+	info, err := os.Stat(l.path)
+	if err != nil {
+		return nil
+	}
+	month := info.ModTime().Local().Format("2006-01")
+	if month >= now.Local().Format("2006-01") {
+		return nil
+	}
+	archive := l.archivePath(month)
+	if _, err := os.Stat(archive); err != nil {
+		if err := os.Rename(l.path, archive); err != nil {
+			return fmt.Errorf("failed to rotate usage ledger: %w", err)
+		}
+		return nil
+	}
+
+	// The month was already moved aside once, e.g. after the clock was set
+	// back; add these records to it rather than overwriting it.
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		return fmt.Errorf("failed to rotate usage ledger: %w", err)
+	}
+	f, err := os.OpenFile(archive, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to rotate usage ledger: %w", err)
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to rotate usage ledger: %w", err)
+	}
+	return os.Remove(l.path)
+}
+
+// archivePath returns where the records of month ("2006-01") are kept once
+// the ledger has moved on.
+func (l *Ledger) archivePath(month string) string {
+	return strings.TrimSuffix(l.path, ".jsonl") + "-" + month + ".jsonl"
+}
+
+// files returns the ledger files that may hold records at or after since,
+// oldest first.
+func (l *Ledger) files(since time.Time) []string {
+	prefix := strings.TrimSuffix(l.path, ".jsonl") + "-"
+	archives, _ := filepath.Glob(prefix + "*.jsonl")
+	sort.Strings(archives)
+
+	first := since.Local().Format("2006-01")
+	var files []string
+	for _, archive := range archives {
+		month := strings.TrimSuffix(strings.TrimPrefix(archive, prefix), ".jsonl")
+		if month >= first {
+			files = append(files, archive)
+		}
+	}
+	return append(files, l.path)
+}
+
+// Spent returns the agent's spend for the day and month containing now.
+func (l *Ledger) Spent(agentID string, now time.Time) (day, month float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now = now.Local()
+	return l.daily[agentID+"|"+now.Format("2006-01-02")], l.monthly[agentID+"|"+now.Format("2006-01")]
+}
+
+// CheckBudget returns an error wrapping ErrBudgetExceeded if the agent has
+// reached either limit of budget.
+func (l *Ledger) CheckBudget(agentID string, budget config.BudgetConfig, now time.Time) error {
+	day, month := l.Spent(agentID, now)
+	if budget.DailyUSD > 0 && day >= budget.DailyUSD {
+		return fmt.Errorf("%w: agent %s spent $%.4f of its $%.2f daily budget", ErrBudgetExceeded, agentID, day, budget.DailyUSD)
+	}
+	if budget.MonthlyUSD > 0 && month >= budget.MonthlyUSD {
+		return fmt.Errorf("%w: agent %s spent $%.4f of its $%.2f monthly budget", ErrBudgetExceeded, agentID, month, budget.MonthlyUSD)
+	}
+	return nil
+}
+
+// Query returns the ledger records at or after since that match filter.
+// A nil filter matches every record.
+func (l *Ledger) Query(since time.Time, filter func(Record) bool) ([]Record, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var records []Record
+	for _, path := range l.files(since) {
+		found, err := readRecords(path, func(r Record) bool {
+			return !r.Time.Before(since) && (filter == nil || filter(r))
+		})
+		records = append(records, found...)
+		if err != nil {
+			return records, err
+		}
+	}
+	return records, nil
+}
+
+// track adds r to the running spend. Must be called with the lock held, or
+// before the ledger is shared.
+func (l *Ledger) track(r Record) {
+	t := r.Time.Local()
+	l.daily[r.AgentID+"|"+t.Format("2006-01-02")] += r.CostUSD
+	l.monthly[r.AgentID+"|"+t.Format("2006-01")] += r.CostUSD
+}
+
+// Sum totals records.
+func Sum(records []Record) Totals {
+	var t Totals
+	for _, r := range records {
+		t.add(r)
+	}
+	return t
+}
+
+// Group is the totals for one key of GroupBy.
+type Group struct {
+	Key string
+	Totals
+}
+
+// GroupBy totals records by key, sorted by key.
+func GroupBy(records []Record, key func(Record) string) []Group {
+	byKey := make(map[string]*Totals)
+	for _, r := range records {
+		k := key(r)
+		t, ok := byKey[k]
+		if !ok {
+			t = &Totals{}
+			byKey[k] = t
+		}
+		t.add(r)
+	}
+
+	groups := make([]Group, 0, len(byKey))
+	for k, t := range byKey {
+		groups = append(groups, Group{Key: k, Totals: *t})
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Key < groups[j].Key })
+	return groups
+}
+
+// StartOfDay returns local midnight of the day containing t.
+func StartOfDay(t time.Time) time.Time {
+	t = t.Local()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
+
+// StartOfMonth returns local midnight of the first day of t's month.
+func StartOfMonth(t time.Time) time.Time {
+	t = t.Local()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.Local)
+}
+
+func readRecords(path string, keep func(Record) bool) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to open usage ledger: %w", err)
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			// Skip a line torn by a crash mid-write rather than losing the ledger.
+			continue
+		}
+		if keep(r) {
+			records = append(records, r)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return records, fmt.Errorf("failed to read usage ledger: %w", err)
+	}
+	return records, nil
+}
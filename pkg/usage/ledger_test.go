@@ -0,0 +1,119 @@
+package usage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+var testPrices = map[string]config.ModelPrice{
+	"gpt-4o-mini": {Input: 1, Output: 2},
+	"claude":      {Input: 3, Output: 15, CachedInput: 0.3},
+}
+
+func TestLedger_CostStripsProviderPrefix(t *testing.T) {
+	l := NewLedger(filepath.Join(t.TempDir(), "usage.jsonl"), testPrices)
+
+	if got := l.Cost("openai/gpt-4o-mini", 1_000_000, 500_000); got != 2 {
+		t.Errorf("Cost() = %v, want 2", got)
+	}
+	if got := l.Cost("unknown-model", 1_000_000, 1_000_000); got != 0 {
+		t.Errorf("Cost() of unpriced model = %v, want 0", got)
+	}
+}
+
+func TestLedger_CachedInputDiscount(t *testing.T) {
+	l := NewLedger(filepath.Join(t.TempDir(), "usage.jsonl"), testPrices)
+
+	if err := l.Record(Record{AgentID: "main", Model: "claude", PromptTokens: 1_000_000, CachedTokens: 900_000}); err != nil {
+		t.Fatalf("Record() error: %v", err)
+	}
+	// 100k uncached tokens at $3/M plus 900k cached at $0.30/M.
+	if day, _ := l.Spent("main", time.Now()); day < 0.569 || day > 0.571 {
+		t.Errorf("Spent() = %v, want 0.57", day)
+	}
+}
+
+func TestLedger_RecordPersistsAndReloads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "usage.jsonl")
+	l := NewLedger(path, testPrices)
+
+	for _, agentID := range []string{"main", "main", "helper"} {
+		err := l.Record(Record{AgentID: agentID, SessionKey: "s1", Model: "gpt-4o-mini", PromptTokens: 100_000, CompletionTokens: 50_000})
+		if err != nil {
+			t.Fatalf("Record() error: %v", err)
+		}
+	}
+
+	reloaded := NewLedger(path, testPrices)
+	day, month := reloaded.Spent("main", time.Now())
+	if day < 0.399 || day > 0.401 || month != day {
+		t.Errorf("Spent() = (%v, %v), want (0.4, 0.4)", day, month)
+	}
+
+	records, err := reloaded.Query(StartOfDay(time.Now()), nil)
+	if err != nil {
+		t.Fatalf("Query() error: %v", err)
+	}
+	if len(records) != 3 || records[0].TotalTokens != 150_000 {
+		t.Fatalf("Query() = %+v, want 3 records of 150000 tokens", records)
+	}
+
+	groups := GroupBy(records, func(r Record) string { return r.AgentID })
+	if len(groups) != 2 || groups[0].Key != "helper" || groups[1].Calls != 2 {
+		t.Errorf("GroupBy() = %+v", groups)
+	}
+}
+
+func TestLedger_CheckBudget(t *testing.T) {
+	l := NewLedger(filepath.Join(t.TempDir(), "usage.jsonl"), testPrices)
+	budget := config.BudgetConfig{DailyUSD: 0.5}
+
+	if err := l.CheckBudget("main", budget, time.Now()); err != nil {
+		t.Fatalf("CheckBudget() before spending = %v", err)
+	}
+
+	l.Record(Record{AgentID: "main", Model: "gpt-4o-mini", PromptTokens: 500_000})
+
+	if err := l.CheckBudget("main", budget, time.Now()); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("CheckBudget() = %v, want ErrBudgetExceeded", err)
+	}
+	if err := l.CheckBudget("other", budget, time.Now()); err != nil {
+		t.Errorf("CheckBudget() for another agent = %v, want nil", err)
+	}
+	if err := l.CheckBudget("main", budget, time.Now().AddDate(0, 0, 1)); err != nil {
+		t.Errorf("CheckBudget() the next day = %v, want nil", err)
+	}
+}
+
+func TestLedger_RotatesMonthly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "usage.jsonl")
+	l := NewLedger(path, testPrices)
+
+	lastMonth := StartOfMonth(time.Now()).AddDate(0, 0, -1)
+	if err := l.Record(Record{Time: lastMonth, AgentID: "main", Model: "gpt-4o-mini", PromptTokens: 10}); err != nil {
+		t.Fatalf("Record() error: %v", err)
+	}
+	os.Chtimes(path, lastMonth, lastMonth)
+	if err := l.Record(Record{AgentID: "main", Model: "gpt-4o-mini", PromptTokens: 20}); err != nil {
+		t.Fatalf("Record() error: %v", err)
+	}
+
+	archive := filepath.Join(filepath.Dir(path), "usage-"+lastMonth.Format("2006-01")+".jsonl")
+	if _, err := os.Stat(archive); err != nil {
+		t.Fatalf("last month's records were not moved aside: %v", err)
+	}
+
+	records, err := l.Query(StartOfMonth(time.Now()), nil)
+	if err != nil || len(records) != 1 || records[0].PromptTokens != 20 {
+		t.Fatalf("Query(this month) = %+v, %v, want only this month's record", records, err)
+	}
+	records, err = l.Query(StartOfMonth(lastMonth), nil)
+	if err != nil || len(records) != 2 || records[0].PromptTokens != 10 {
+		t.Fatalf("Query(last month) = %+v, %v, want both records oldest first", records, err)
+	}
+}
@@ -53,6 +53,11 @@ type DownloadOptions struct {
 	LoggerPrefix string
 }
 
+// MediaDir returns the directory downloaded attachments are saved in.
+func MediaDir() string {
+	return filepath.Join(os.TempDir(), "picoclaw_media")
+}
+
 // DownloadFile downloads a file from URL to a local temp directory.
 // Returns the local file path or empty string on error.
 func DownloadFile(url, filename string, opts DownloadOptions) string {
@@ -64,7 +69,7 @@ func DownloadFile(url, filename string, opts DownloadOptions) string {
 		opts.LoggerPrefix = "utils"
 	}
 
-	mediaDir := filepath.Join(os.TempDir(), "picoclaw_media")
+	mediaDir := MediaDir()
 	if err := os.MkdirAll(mediaDir, 0700); err != nil {
 		logger.ErrorCF(opts.LoggerPrefix, "Failed to create media directory", map[string]interface{}{
 			"error": err.Error(),